@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Machine readable error codes returned in ErrorResponse.Code.
+const (
+	ErrorCodeConfigNotFound   = "config_not_found"
+	ErrorCodeOverrideNotFound = "override_not_found"
+	ErrorCodeRevisionNotFound = "revision_not_found"
+	ErrorCodeSegmentNotFound  = "segment_not_found"
+	ErrorCodeScheduleNotFound = "schedule_not_found"
+	ErrorCodeMalformedRequest = "malformed_request"
+	ErrorCodeMissingField     = "missing_field"
+	ErrorCodeInvalidParameter = "invalid_parameter"
+	ErrorCodeInvalidValue     = "invalid_value"
+	ErrorCodeTypeConflict     = "type_conflict"
+	ErrorCodeSegmentInUse     = "segment_in_use"
+	ErrorCodeInternal         = "internal_error"
+)
+
+var ErrConfigNotFound = errors.New("config not found")
+
+var ErrSegmentNotFound = errors.New("segment not found")
+
+// ApiError is an error the caller can act on. Handlers return it, possibly
+// wrapped, and CatchErrors turns it into Status with an ErrorResponse body.
+type ApiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *ApiError) Error() string {
+	return e.Message
+}
+
+func NewApiError(status int, code string, message string) *ApiError {
+	return &ApiError{
+		Status:  status,
+		Code:    code,
+		Message: message,
+	}
+}
+
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// GetApiError finds the ApiError describing err. Store errors that aren't
+// already ApiErrors are mapped here, anything else is an internal error.
+func GetApiError(err error) *ApiError {
+	var apiErr *ApiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	if errors.Is(err, ErrConfigNotFound) {
+		return NewApiError(http.StatusNotFound, ErrorCodeConfigNotFound, "config not found")
+	}
+	if errors.Is(err, ErrSegmentNotFound) {
+		return NewApiError(http.StatusNotFound, ErrorCodeSegmentNotFound, "segment not found")
+	}
+	return NewApiError(http.StatusInternalServerError, ErrorCodeInternal, "Internal Server Error")
+}
+
+func WriteError(w http.ResponseWriter, apiErr *ApiError) {
+	body, err := json.Marshal(ErrorResponse{
+		Code:    apiErr.Code,
+		Message: apiErr.Message,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error marshalling error response: %+v\n", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	w.Write(body)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// Upper bound on the configs evaluated by a single batch request.
+const maxBatchSize = 1000
+
+// GetServiceValues evaluates many configs in one service against the same
+// attributes.
+func (h *Handlers) GetServiceValues(r *http.Request) (*HttpResponse, error) {
+	service := mux.Vars(r)["service"]
+	var requestBody GetServiceValuesRequest
+	err := DecodeRequestBody(r, &requestBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode request body")
+	}
+
+	paths := make([]ConfigPath, 0, len(requestBody.Names))
+	for _, name := range requestBody.Names {
+		paths = append(paths, ConfigPath{Service: service, Name: name})
+	}
+	return h.evaluateBatch(r, paths, requestBody.Attributes)
+}
+
+// GetValues evaluates configs from any number of services against the same
+// attributes.
+func (h *Handlers) GetValues(r *http.Request) (*HttpResponse, error) {
+	var requestBody GetValuesRequest
+	err := DecodeRequestBody(r, &requestBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode request body")
+	}
+	return h.evaluateBatch(r, requestBody.Configs, requestBody.Attributes)
+}
+
+// evaluateBatch returns the values of paths in the order they were asked for.
+func (h *Handlers) evaluateBatch(r *http.Request, paths []ConfigPath, attributes map[string]string) (*HttpResponse, error) {
+	format, err := h.GetValueFormat(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get value format from request")
+	}
+	if len(paths) == 0 {
+		return nil, NewApiError(http.StatusBadRequest, ErrorCodeMissingField, "at least one config is required")
+	}
+	if len(paths) > maxBatchSize {
+		return nil, NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Sprintf("at most %d configs can be evaluated at once", maxBatchSize))
+	}
+
+	response := GetValuesResponse{
+		Values: make([]ConfigValueResult, 0, len(paths)),
+	}
+	for _, path := range paths {
+		if path.Service == "" || path.Name == "" {
+			return nil, NewApiError(http.StatusBadRequest, ErrorCodeMissingField, "config name and service are required")
+		}
+		result := ConfigValueResult{
+			ConfigPath: path,
+		}
+		result.GetConfigValueResponse, err = h.EvaluateConfig(&path, attributes, format)
+		if errors.Is(err, ErrConfigNotFound) {
+			apiErr := GetApiError(err)
+			result.Error = &ErrorResponse{
+				Code:    apiErr.Code,
+				Message: apiErr.Message,
+			}
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to evaluate config")
+		}
+		response.Values = append(response.Values, result)
+	}
+
+	respBytes, err := json.Marshal(response)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal response")
+	}
+	return &HttpResponse{
+		Status: http.StatusOK,
+		Data:   respBytes,
+	}, nil
+}
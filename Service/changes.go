@@ -0,0 +1,697 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Every change to configs, overrides and segments goes through the methods
+// in this file so each one is validated the same way and recorded in the
+// config's history, whether it came from a request or from inside the
+// service. Each change also moves the config on to its next revision.
+
+// SaveConfig creates config or replaces the existing config at its path,
+// clearing its overrides.
+func (h *Handlers) SaveConfig(actor string, config *Config) error {
+	err := ValidateConfig(config)
+	if err != nil {
+		return err
+	}
+
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	return h.saveConfig(actor, config)
+}
+
+// ValidateConfig checks the values of a config match its type.
+func ValidateConfig(config *Config) error {
+	err := ValidateConfigValue(config.Type, config.DefaultValue)
+	if err != nil {
+		return NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, "invalid default value: "+err.Error())
+	}
+	err = ValidateRules(config.Type, config.Rules)
+	if err != nil {
+		return err
+	}
+	return ValidateRollouts(config.Type, config.Rollouts)
+}
+
+func (h *Handlers) saveConfig(actor string, config *Config) error {
+	existing, found, err := h.findConfig(&config.ConfigPath)
+	if err != nil {
+		return err
+	}
+	var existingOverrides []Override
+	if found {
+		existingOverrides, err = h.ConfigDb.GetOverrides(&config.ConfigPath)
+		if err != nil {
+			return errors.Wrap(err, "failed to get overrides from db")
+		}
+	}
+	config.Revision, err = h.nextRevision(&config.ConfigPath, &existing, found)
+	if err != nil {
+		return err
+	}
+
+	err = h.ConfigDb.AddConfig(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to add config to db")
+	}
+
+	entry := HistoryEntry{
+		ConfigPath: config.ConfigPath,
+		Revision:   config.Revision,
+		Action:     HistoryActionCreateConfig,
+		Type:       config.Type,
+		NewConfig:  config,
+	}
+	if found {
+		entry.Action = HistoryActionUpdateConfig
+		entry.OldConfig = &existing
+		entry.OldOverrides = existingOverrides
+	}
+	return h.recordHistory(actor, entry)
+}
+
+// UpdateConfig replaces the existing config at its path keeping its
+// overrides, or creates config when there is none.
+func (h *Handlers) UpdateConfig(actor string, config *Config) error {
+	err := ValidateConfig(config)
+	if err != nil {
+		return err
+	}
+
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	return h.updateConfig(actor, config)
+}
+
+func (h *Handlers) updateConfig(actor string, config *Config) error {
+	existing, found, err := h.findConfig(&config.ConfigPath)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return h.saveConfig(actor, config)
+	}
+	err = h.checkTypeChange(&existing, config)
+	if err != nil {
+		return err
+	}
+	config.Revision = existing.Revision + 1
+
+	err = h.ConfigDb.UpdateConfig(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to update config in db")
+	}
+
+	return h.recordHistory(actor, HistoryEntry{
+		ConfigPath: config.ConfigPath,
+		Revision:   config.Revision,
+		Action:     HistoryActionUpdateConfig,
+		Type:       config.Type,
+		OldConfig:  &existing,
+		NewConfig:  config,
+	})
+}
+
+// checkTypeChange rejects changing the type of an existing config when any
+// of the overrides it keeps can't be read as the new type.
+func (h *Handlers) checkTypeChange(existing *Config, config *Config) error {
+	if existing.Type == config.Type {
+		return nil
+	}
+
+	overrides, err := h.ConfigDb.GetOverrides(&config.ConfigPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to get overrides from db")
+	}
+	for _, override := range overrides {
+		err = ValidateConfigValue(config.Type, override.Value)
+		if err != nil {
+			return NewApiError(http.StatusConflict, ErrorCodeTypeConflict, fmt.Sprintf(
+				"cannot change type from %s to %s, override %s: %s",
+				existing.Type, config.Type, GetOverridePathStr(&override.OverrideKey), err.Error()))
+		}
+	}
+	return nil
+}
+
+// RemoveConfig deletes a config along with its overrides. Removing a config
+// that doesn't exist does nothing.
+func (h *Handlers) RemoveConfig(actor string, path *ConfigPath) error {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	return h.removeConfig(actor, path)
+}
+
+func (h *Handlers) removeConfig(actor string, path *ConfigPath) error {
+	existing, found, err := h.findConfig(path)
+	if err != nil || !found {
+		return err
+	}
+	overrides, err := h.ConfigDb.GetOverrides(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to get overrides from db")
+	}
+
+	err = h.ConfigDb.DeleteConfig(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete config from db")
+	}
+
+	return h.recordHistory(actor, HistoryEntry{
+		ConfigPath:   *path,
+		Revision:     existing.Revision + 1,
+		Action:       HistoryActionDeleteConfig,
+		Type:         existing.Type,
+		OldConfig:    &existing,
+		OldOverrides: overrides,
+	})
+}
+
+// SaveOverride creates override or replaces the existing one for the same
+// entity.
+func (h *Handlers) SaveOverride(actor string, path *ConfigPath, override *Override) error {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	return h.saveOverride(actor, path, override)
+}
+
+// checkOverride validates override against its config, which it returns.
+func (h *Handlers) checkOverride(path *ConfigPath, override *Override) (Config, error) {
+	config, err := h.ConfigDb.GetConfig(path)
+	if err != nil {
+		return Config{}, errors.Wrap(err, "failed to get config from db")
+	}
+	err = ValidateConfigValue(config.Type, override.Value)
+	if err != nil {
+		return Config{}, NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, "invalid override value: "+err.Error())
+	}
+	if override.EntityType == SegmentEntityType {
+		_, err = h.ConfigDb.GetSegment(override.EntityId)
+		if err != nil {
+			return Config{}, errors.Wrap(err, "failed to get segment from db")
+		}
+	}
+	return config, nil
+}
+
+func (h *Handlers) saveOverride(actor string, path *ConfigPath, override *Override) error {
+	config, err := h.checkOverride(path, override)
+	if err != nil {
+		return err
+	}
+	existing, found, err := h.ConfigDb.GetOverride(path, &override.OverrideKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to get override from db")
+	}
+
+	err = h.ConfigDb.AddOverride(path, override)
+	if err != nil {
+		return errors.Wrap(err, "failed to add override to db")
+	}
+	err = h.bumpRevision(&config)
+	if err != nil {
+		return err
+	}
+
+	entry := HistoryEntry{
+		ConfigPath:  *path,
+		Revision:    config.Revision,
+		Action:      HistoryActionCreateOverride,
+		Type:        config.Type,
+		OverrideKey: &override.OverrideKey,
+		NewOverride: override,
+	}
+	if found {
+		entry.Action = HistoryActionUpdateOverride
+		entry.OldOverride = &existing
+	}
+	return h.recordHistory(actor, entry)
+}
+
+// RemoveOverride deletes an override. Removing an override that doesn't
+// exist does nothing.
+func (h *Handlers) RemoveOverride(actor string, path *ConfigPath, overrideKey *OverrideKey) error {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	return h.removeOverride(actor, path, overrideKey)
+}
+
+func (h *Handlers) removeOverride(actor string, path *ConfigPath, overrideKey *OverrideKey) error {
+	config, found, err := h.findConfig(path)
+	if err != nil || !found {
+		return err
+	}
+	existing, found, err := h.ConfigDb.GetOverride(path, overrideKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to get override from db")
+	}
+	if !found {
+		return nil
+	}
+
+	err = h.ConfigDb.DeleteOverride(path, overrideKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete override from db")
+	}
+	err = h.bumpRevision(&config)
+	if err != nil {
+		return err
+	}
+
+	return h.recordHistory(actor, HistoryEntry{
+		ConfigPath:  *path,
+		Revision:    config.Revision,
+		Action:      HistoryActionDeleteOverride,
+		Type:        config.Type,
+		OverrideKey: overrideKey,
+		OldOverride: &existing,
+	})
+}
+
+// SaveSegment creates segment or replaces the existing one with the same
+// name. Replacing a segment changes the value of every config with an
+// override targeting it, each of them moves on to a new revision recording
+// the change so watchers and pollers pick it up.
+func (h *Handlers) SaveSegment(actor string, segment *Segment) error {
+	err := ValidateSegment(segment)
+	if err != nil {
+		return err
+	}
+
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	return h.saveSegment(actor, segment)
+}
+
+func (h *Handlers) saveSegment(actor string, segment *Segment) error {
+	existing, err := h.ConfigDb.GetSegment(segment.Name)
+	found := err == nil
+	if err != nil && !errors.Is(err, ErrSegmentNotFound) {
+		return errors.Wrap(err, "failed to get segment from db")
+	}
+	segment.Revision = existing.Revision + 1
+	err = h.ConfigDb.AddSegment(segment)
+	if err != nil {
+		return errors.Wrap(err, "failed to add segment to db")
+	}
+	if !found {
+		// Overrides can't target a segment before it exists.
+		return nil
+	}
+
+	paths, err := h.segmentUsages(segment.Name)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		config, err := h.ConfigDb.GetConfig(&path)
+		if err != nil {
+			return errors.Wrap(err, "failed to get config from db")
+		}
+		err = h.bumpRevision(&config)
+		if err != nil {
+			return err
+		}
+		err = h.recordHistory(actor, HistoryEntry{
+			ConfigPath: path,
+			Revision:   config.Revision,
+			Action:     HistoryActionUpdateSegment,
+			Type:       config.Type,
+			OldSegment: &existing,
+			NewSegment: segment,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveSegment deletes a segment. A segment still targeted by overrides
+// can't be removed, removing one that doesn't exist does nothing.
+func (h *Handlers) RemoveSegment(name string) error {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+
+	paths, err := h.segmentUsages(name)
+	if err != nil {
+		return err
+	}
+	if len(paths) > 0 {
+		used := make([]string, 0, len(paths))
+		for _, path := range paths {
+			used = append(used, GetConfigPathStr(&path))
+		}
+		return NewApiError(http.StatusConflict, ErrorCodeSegmentInUse, fmt.Sprintf(
+			"segment %s is used by overrides of %s", name, strings.Join(used, ", ")))
+	}
+	err = h.ConfigDb.DeleteSegment(name)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete segment from db")
+	}
+	return nil
+}
+
+// ScheduleChange validates change against the current state and stores it
+// for the Scheduler, or makes it right away when its ApplyAt isn't after
+// now. It returns the change left pending, which is the revert of change
+// when it was made right away with a RevertAt, or nil.
+func (h *Handlers) ScheduleChange(change *ScheduledChange, now time.Time) (*ScheduledChange, error) {
+	err := ValidateScheduledChange(change, now)
+	if err != nil {
+		return nil, err
+	}
+
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+
+	if change.Override != nil {
+		config, err := h.checkOverride(&change.ConfigPath, change.Override)
+		if err != nil {
+			return nil, err
+		}
+		change.Type = config.Type
+	}
+	if change.Config != nil {
+		existing, found, err := h.findConfig(&change.ConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			err = h.checkTypeChange(&existing, change.Config)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !change.ApplyAt.After(now) {
+		return h.applyScheduledChange(change, now)
+	}
+	err = h.ConfigDb.AddScheduledChange(change)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to add scheduled change to db")
+	}
+	return change, nil
+}
+
+// CancelScheduledChange deletes a change that hasn't been applied yet.
+func (h *Handlers) CancelScheduledChange(id string) error {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+
+	found, err := h.ConfigDb.DeleteScheduledChange(id)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete scheduled change from db")
+	}
+	if !found {
+		return NewApiError(http.StatusNotFound, ErrorCodeScheduleNotFound, "scheduled change not found")
+	}
+	return nil
+}
+
+// ApplyDueChanges makes every scheduled change due at now, earliest first,
+// and returns how many were made. A change that can no longer be made, such
+// as an override of a config deleted since it was scheduled, is dropped.
+func (h *Handlers) ApplyDueChanges(now time.Time) (int, error) {
+	changes, err := h.ConfigDb.GetScheduledChanges()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get scheduled changes from db")
+	}
+	sortScheduledChanges(changes)
+
+	applied := 0
+	for i := range changes {
+		if changes[i].ApplyAt.After(now) {
+			break
+		}
+		made, err := h.applyDueChange(&changes[i], now)
+		if err != nil {
+			return applied, err
+		}
+		if made {
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+func (h *Handlers) applyDueChange(change *ScheduledChange, now time.Time) (bool, error) {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+
+	claimed, err := h.ConfigDb.DeleteScheduledChange(change.Id)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to delete scheduled change from db")
+	}
+	if !claimed {
+		// Cancelled, or applied by another service sharing the store.
+		return false, nil
+	}
+	_, err = h.applyScheduledChange(change, now)
+	if err == nil {
+		return true, nil
+	}
+	if GetApiError(err).Status >= http.StatusInternalServerError {
+		// Keep the change so the next pass retries it.
+		restoreErr := h.ConfigDb.AddScheduledChange(change)
+		if restoreErr != nil {
+			return false, errors.Wrap(restoreErr, "failed to restore scheduled change in db")
+		}
+		return false, err
+	}
+	log.Printf("Dropped scheduled %s of %s: %v", change.Action, GetConfigPathStr(&change.ConfigPath), err)
+	return false, nil
+}
+
+// applyScheduledChange makes change and, when it has a RevertAt, stores the
+// change undoing it, which it returns. Callers must hold writeMu.
+func (h *Handlers) applyScheduledChange(change *ScheduledChange, now time.Time) (*ScheduledChange, error) {
+	var revert *ScheduledChange
+	if change.RevertAt != nil {
+		var err error
+		revert, err = h.planRevert(change, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	path := &change.ConfigPath
+	switch change.Action {
+	case ScheduledActionSetConfig:
+		// The config's overrides stay, so its revert only has to put the
+		// config itself back.
+		config := *change.Config
+		err = ValidateConfig(&config)
+		if err == nil {
+			err = h.updateConfig(change.Actor, &config)
+		}
+	case ScheduledActionDeleteConfig:
+		err = h.removeConfig(change.Actor, path)
+	case ScheduledActionSetOverride:
+		override := *change.Override
+		err = h.saveOverride(change.Actor, path, &override)
+	case ScheduledActionDeleteOverride:
+		err = h.removeOverride(change.Actor, path, &change.Override.OverrideKey)
+	default:
+		err = NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, fmt.Sprintf("unknown scheduled action %q", change.Action))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if revert == nil {
+		return nil, nil
+	}
+	err = h.ConfigDb.AddScheduledChange(revert)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to add scheduled change to db")
+	}
+	return revert, nil
+}
+
+// planRevert builds the change restoring what change is about to replace,
+// deleting the config or override when change creates it.
+func (h *Handlers) planRevert(change *ScheduledChange, now time.Time) (*ScheduledChange, error) {
+	revert := &ScheduledChange{
+		Id:         NewScheduledChangeId(),
+		ConfigPath: change.ConfigPath,
+		Type:       change.Type,
+		ApplyAt:    *change.RevertAt,
+		RevertOf:   change.Id,
+		Actor:      change.Actor,
+		CreatedAt:  now,
+	}
+	switch change.Action {
+	case ScheduledActionSetConfig:
+		existing, found, err := h.findConfig(&change.ConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		revert.Action = ScheduledActionDeleteConfig
+		if found {
+			revert.Action = ScheduledActionSetConfig
+			revert.Type = existing.Type
+			revert.Config = &existing
+		}
+	case ScheduledActionSetOverride:
+		existing, found, err := h.ConfigDb.GetOverride(&change.ConfigPath, &change.Override.OverrideKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get override from db")
+		}
+		revert.Action = ScheduledActionDeleteOverride
+		revert.Override = &Override{OverrideKey: change.Override.OverrideKey}
+		if found {
+			revert.Action = ScheduledActionSetOverride
+			revert.Override = &existing
+		}
+	default:
+		return nil, nil
+	}
+	return revert, nil
+}
+
+// RollbackConfig restores a config and its overrides to how they were at
+// revision. The rollback is recorded as a new revision rather than
+// discarding the ones after it, so it can itself be rolled back.
+func (h *Handlers) RollbackConfig(actor string, path *ConfigPath, revision int64) (Config, []Override, error) {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+
+	history, err := h.ConfigDb.GetHistory(path)
+	if err != nil {
+		return Config{}, nil, errors.Wrap(err, "failed to get history from db")
+	}
+	target, overrides, err := GetConfigAtRevision(history, revision)
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	err = h.restoreConfig(actor, HistoryEntry{
+		Action:           HistoryActionRollback,
+		RollbackRevision: revision,
+	}, &target, overrides)
+	if err != nil {
+		return Config{}, nil, err
+	}
+	return target, overrides, nil
+}
+
+// ImportConfigs loads document into the store, see planImport for how mode
+// and service decide what changes. Nothing is changed when any part of the
+// document is invalid or dryRun is set, the report lists what was or would
+// be changed.
+func (h *Handlers) ImportConfigs(actor string, document *ExportDocument, mode string, service string, dryRun bool) (ImportReport, error) {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+
+	plan, err := h.planImport(document, mode, service)
+	plan.Report.DryRun = dryRun
+	if err != nil || dryRun {
+		return plan.Report, err
+	}
+	for i := range plan.Segments {
+		err = h.saveSegment(actor, &plan.Segments[i])
+		if err != nil {
+			return ImportReport{}, err
+		}
+	}
+	for i := range plan.Restores {
+		restore := &plan.Restores[i]
+		err = h.restoreConfig(actor, HistoryEntry{Action: HistoryActionImport}, &restore.Config, restore.Overrides)
+		if err != nil {
+			return ImportReport{}, err
+		}
+	}
+	for i := range plan.Deletes {
+		err = h.removeConfig(actor, &plan.Deletes[i])
+		if err != nil {
+			return ImportReport{}, err
+		}
+	}
+	return plan.Report, nil
+}
+
+// restoreConfig replaces target's config and whole override set in one
+// revision, recording entry with the before and after state filled in.
+func (h *Handlers) restoreConfig(actor string, entry HistoryEntry, target *Config, overrides []Override) error {
+	path := &target.ConfigPath
+	existing, found, err := h.findConfig(path)
+	if err != nil {
+		return err
+	}
+	var existingOverrides []Override
+	if found {
+		existingOverrides, err = h.ConfigDb.GetOverrides(path)
+		if err != nil {
+			return errors.Wrap(err, "failed to get overrides from db")
+		}
+	}
+	target.Revision, err = h.nextRevision(path, &existing, found)
+	if err != nil {
+		return err
+	}
+
+	err = h.ConfigDb.RestoreConfig(target, overrides)
+	if err != nil {
+		return errors.Wrap(err, "failed to restore config in db")
+	}
+
+	entry.ConfigPath = *path
+	entry.Revision = target.Revision
+	entry.Type = target.Type
+	entry.NewConfig = target
+	entry.OldOverrides = existingOverrides
+	entry.NewOverrides = overrides
+	if found {
+		entry.OldConfig = &existing
+	}
+	return h.recordHistory(actor, entry)
+}
+
+// nextRevision finds the revision the next change to a config gets. A
+// deleted config carries on from its last recorded revision so revisions
+// never repeat.
+func (h *Handlers) nextRevision(path *ConfigPath, existing *Config, found bool) (int64, error) {
+	if found {
+		return existing.Revision + 1, nil
+	}
+	history, err := h.ConfigDb.GetHistory(path)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get history from db")
+	}
+	if len(history) == 0 {
+		return 1, nil
+	}
+	return history[len(history)-1].Revision + 1, nil
+}
+
+// bumpRevision moves config on to its next revision after one of its
+// overrides changed.
+func (h *Handlers) bumpRevision(config *Config) error {
+	config.Revision++
+	err := h.ConfigDb.UpdateConfig(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to update config in db")
+	}
+	return nil
+}
+
+func (h *Handlers) findConfig(path *ConfigPath) (Config, bool, error) {
+	config, err := h.ConfigDb.GetConfig(path)
+	if errors.Is(err, ErrConfigNotFound) {
+		return Config{}, false, nil
+	}
+	if err != nil {
+		return Config{}, false, errors.Wrap(err, "failed to get config from db")
+	}
+	return config, true, nil
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"Service/evaluator"
+)
+
+// configCache holds the last snapshot loaded from the service for each
+// service.
+type configCache struct {
+	mu        sync.RWMutex
+	snapshots map[string]*evaluator.Snapshot
+}
+
+func newConfigCache() *configCache {
+	return &configCache{
+		snapshots: make(map[string]*evaluator.Snapshot),
+	}
+}
+
+func (cache *configCache) replace(snapshots map[string]*evaluator.Snapshot) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.snapshots = snapshots
+}
+
+// get returns the cached snapshot of service, or false until one has been
+// loaded.
+func (cache *configCache) get(service string) (*evaluator.Snapshot, bool) {
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+
+	snapshot, found := cache.snapshots[service]
+	return snapshot, found
+}
+
+// evaluateSnapshot resolves a value from a cached snapshot, the same way
+// the service does.
+func evaluateSnapshot(snapshot *evaluator.Snapshot, name string, attributes map[string]string) (Value, bool) {
+	// The service deletes expired overrides a little after they expire, the
+	// snapshot keeps their expiry so they are skipped until then.
+	result, err := snapshot.Evaluate(name, attributes)
+	if err != nil {
+		return Value{}, false
+	}
+	return Value{
+		Type:            result.Type,
+		Value:           result.Value,
+		MatchedOverride: (*OverrideKey)(result.MatchedOverride),
+		MatchedRule:     (*RuleMatch)(result.MatchedRule),
+		MatchedRollout:  (*RolloutMatch)(result.MatchedRollout),
+	}, true
+}
+
+// Refresh reloads the cache with a snapshot of every service in
+// Options.Services, or of every service with configs when it is empty. The
+// cache is left untouched when loading fails.
+func (c *Client) Refresh(ctx context.Context) error {
+	services := c.options.Services
+	if len(services) == 0 {
+		configs, err := c.ListConfigs(ctx)
+		if err != nil {
+			return err
+		}
+		for _, config := range configs {
+			if !slices.Contains(services, config.Service) {
+				services = append(services, config.Service)
+			}
+		}
+	}
+
+	loaded := make(map[string]*evaluator.Snapshot, len(services))
+	for _, service := range services {
+		snapshot, err := c.GetSnapshot(ctx, service)
+		if err != nil {
+			return err
+		}
+		loaded[service] = snapshot
+	}
+	c.cache.replace(loaded)
+	return nil
+}
+
+// Start loads the cache and keeps refreshing it every RefreshInterval until
+// Close is called. Failed refreshes are retried on the next interval.
+func (c *Client) Start() {
+	c.closeMu.Lock()
+	defer c.closeMu.Unlock()
+
+	if c.stop != nil {
+		return
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	c.stop = stop
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(c.options.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			c.Refresh(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the background refresh started by Start.
+func (c *Client) Close() {
+	c.closeMu.Lock()
+	defer c.closeMu.Unlock()
+
+	if c.stop == nil {
+		return
+	}
+	c.stop()
+	<-c.done
+	c.stop = nil
+}
+
+// lookup evaluates a config against the cached snapshot of its service once
+// Start or Refresh has loaded it, and on the service before that or for
+// services that aren't cached.
+func (c *Client) lookup(ctx context.Context, service string, name string, attributes map[string]string) (Value, bool) {
+	if snapshot, found := c.cache.get(service); found {
+		return evaluateSnapshot(snapshot, name, attributes)
+	}
+	value, err := c.GetValue(ctx, ConfigPath{Service: service, Name: name}, attributes)
+	if err != nil {
+		return Value{}, false
+	}
+	return value, true
+}
+
+// The typed getters return a config's value for a set of entity attributes.
+// They never fail, defaultValue is returned when the config doesn't exist,
+// has a different type or can't be found anywhere.
+
+func (c *Client) GetBool(ctx context.Context, service string, name string, attributes map[string]string, defaultValue bool) bool {
+	value, found := c.lookup(ctx, service, name, attributes)
+	if !found || value.Type != ConfigTypeBool {
+		return defaultValue
+	}
+	boolValue, err := strconv.ParseBool(value.Value)
+	if err != nil {
+		return defaultValue
+	}
+	return boolValue
+}
+
+func (c *Client) GetLong(ctx context.Context, service string, name string, attributes map[string]string, defaultValue int64) int64 {
+	value, found := c.lookup(ctx, service, name, attributes)
+	if !found || value.Type != ConfigTypeLong {
+		return defaultValue
+	}
+	longValue, err := strconv.ParseInt(value.Value, 10, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return longValue
+}
+
+func (c *Client) GetFloat(ctx context.Context, service string, name string, attributes map[string]string, defaultValue float64) float64 {
+	value, found := c.lookup(ctx, service, name, attributes)
+	if !found || value.Type != ConfigTypeFloat {
+		return defaultValue
+	}
+	floatValue, err := strconv.ParseFloat(value.Value, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return floatValue
+}
+
+func (c *Client) GetString(ctx context.Context, service string, name string, attributes map[string]string, defaultValue string) string {
+	value, found := c.lookup(ctx, service, name, attributes)
+	if !found || value.Type != ConfigTypeStr {
+		return defaultValue
+	}
+	return value.Value
+}
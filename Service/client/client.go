@@ -0,0 +1,417 @@
+// Package client talks to ConfigService. It wraps every endpoint and adds
+// typed getters that, once Start has loaded the cache, evaluate locally and
+// keep serving the last known values while the service is unreachable.
+//
+//	c := client.New(client.Options{BaseURL: "http://configs:8080"})
+//	c.Start()
+//	defer c.Close()
+//	enabled := c.GetBool(ctx, "service1", "newCheckout", map[string]string{"user": "123"}, false)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"Service/evaluator"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultRefreshInterval = 30 * time.Second
+	defaultTimeout         = 10 * time.Second
+)
+
+type Options struct {
+	// BaseURL is where the service is listening, such as
+	// http://localhost:8080.
+	BaseURL string
+	// HTTPClient sends requests, http.DefaultClient when nil.
+	HTTPClient *http.Client
+	// Timeout bounds each request, 10 seconds when zero. Poll gets it on
+	// top of the time it waits for a change, Watch streams aren't bound by
+	// it.
+	Timeout time.Duration
+	// Actor is sent with every change and recorded in the config history.
+	Actor string
+
+	// Services limits the local cache to these services, every service is
+	// cached when empty.
+	Services []string
+	// RefreshInterval is how often Start reloads the cache, 30 seconds when
+	// zero.
+	RefreshInterval time.Duration
+}
+
+// Client is safe for concurrent use.
+type Client struct {
+	options    Options
+	httpClient *http.Client
+
+	cache *configCache
+
+	closeMu sync.Mutex
+	stop    context.CancelFunc
+	done    chan struct{}
+}
+
+func New(options Options) *Client {
+	httpClient := options.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if options.RefreshInterval <= 0 {
+		options.RefreshInterval = defaultRefreshInterval
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTimeout
+	}
+	return &Client{
+		options:    options,
+		httpClient: httpClient,
+		cache:      newConfigCache(),
+	}
+}
+
+// Error is an error response from the service.
+type Error struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("config service returned %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsNotFound reports whether err is the service saying a config or override
+// doesn't exist.
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+func configPathURL(path *ConfigPath) string {
+	return "/configs/" + url.PathEscape(path.Service) + "/" + url.PathEscape(path.Name)
+}
+
+func overrideURL(path *ConfigPath, key *OverrideKey) string {
+	return configPathURL(path) + "/overrides/" + url.PathEscape(key.EntityType) + "/" + url.PathEscape(key.EntityId)
+}
+
+func (c *Client) newRequest(ctx context.Context, method string, path string, query url.Values, body any) (*http.Request, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("valueFormat", "string")
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal request body")
+		}
+		bodyReader = bytes.NewReader(bodyBytes)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.options.BaseURL+path+"?"+query.Encode(), bodyReader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.options.Actor != "" {
+		req.Header.Set("X-Actor", c.options.Actor)
+	}
+	return req, nil
+}
+
+// do sends a request and decodes the JSON response into response, which
+// may be nil. Error responses are returned as *Error.
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body any, response any) error {
+	return c.doWithin(ctx, c.options.Timeout, method, path, query, body, response)
+}
+
+// doWithin is do giving up after timeout.
+func (c *Client) doWithin(ctx context.Context, timeout time.Duration, method string, path string, query url.Values, body any, response any) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := c.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to send %s %s", method, path)
+	}
+	defer res.Body.Close()
+
+	respBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read response body")
+	}
+	if res.StatusCode != http.StatusOK {
+		apiErr := &Error{StatusCode: res.StatusCode}
+		err = json.Unmarshal(respBytes, apiErr)
+		if err != nil {
+			apiErr.Message = string(respBytes)
+		}
+		return apiErr
+	}
+	if response == nil {
+		return nil
+	}
+	err = json.Unmarshal(respBytes, response)
+	if err != nil {
+		return errors.Wrap(err, "failed to unmarshal response body")
+	}
+	return nil
+}
+
+func (c *Client) ListConfigs(ctx context.Context) ([]Config, error) {
+	var response configsResponse
+	err := c.do(ctx, "GET", "/configs", nil, nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Configs, nil
+}
+
+func (c *Client) GetConfig(ctx context.Context, path ConfigPath) (Config, error) {
+	var response configResponse
+	err := c.do(ctx, "GET", configPathURL(&path), nil, nil, &response)
+	if err != nil {
+		return Config{}, err
+	}
+	return response.Config, nil
+}
+
+// SetConfig creates config or replaces the existing config at its path,
+// clearing its overrides.
+func (c *Client) SetConfig(ctx context.Context, config Config) error {
+	return c.do(ctx, "POST", "/configs", nil, configRequest{Config: config}, nil)
+}
+
+// UpdateConfig replaces the config at its path keeping its overrides, or
+// creates it when there is none.
+func (c *Client) UpdateConfig(ctx context.Context, config Config) error {
+	return c.do(ctx, "PUT", configPathURL(&config.ConfigPath), nil, configRequest{Config: config}, nil)
+}
+
+// DeleteConfig deletes a config along with its overrides.
+func (c *Client) DeleteConfig(ctx context.Context, path ConfigPath) error {
+	return c.do(ctx, "DELETE", configPathURL(&path), nil, nil, nil)
+}
+
+func (c *Client) ListOverrides(ctx context.Context, path ConfigPath) ([]Override, error) {
+	var response overridesResponse
+	err := c.do(ctx, "GET", configPathURL(&path)+"/overrides", nil, nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Overrides, nil
+}
+
+func (c *Client) GetOverride(ctx context.Context, path ConfigPath, key OverrideKey) (Override, error) {
+	var response overrideResponse
+	err := c.do(ctx, "GET", overrideURL(&path, &key), nil, nil, &response)
+	if err != nil {
+		return Override{}, err
+	}
+	return response.Override, nil
+}
+
+// SetOverride creates override or replaces the existing one for the same
+// entity.
+func (c *Client) SetOverride(ctx context.Context, path ConfigPath, override Override) error {
+	return c.do(ctx, "POST", configPathURL(&path)+"/overrides", nil, overrideRequest{Override: override}, nil)
+}
+
+func (c *Client) DeleteOverride(ctx context.Context, path ConfigPath, key OverrideKey) error {
+	return c.do(ctx, "DELETE", overrideURL(&path, &key), nil, nil, nil)
+}
+
+func (c *Client) ListSegments(ctx context.Context) ([]Segment, error) {
+	var response segmentsResponse
+	err := c.do(ctx, "GET", "/segments", nil, nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Segments, nil
+}
+
+// GetSegment returns a segment and the configs with overrides targeting it.
+func (c *Client) GetSegment(ctx context.Context, name string) (Segment, []ConfigPath, error) {
+	var response segmentResponse
+	err := c.do(ctx, "GET", "/segments/"+url.PathEscape(name), nil, nil, &response)
+	if err != nil {
+		return Segment{}, nil, err
+	}
+	return response.Segment, response.UsedBy, nil
+}
+
+// SetSegment creates segment or replaces the existing one with the same
+// name.
+func (c *Client) SetSegment(ctx context.Context, segment Segment) error {
+	return c.do(ctx, "POST", "/segments", nil, segmentRequest{Segment: segment}, nil)
+}
+
+// DeleteSegment deletes a segment, it fails while overrides target it.
+func (c *Client) DeleteSegment(ctx context.Context, name string) error {
+	return c.do(ctx, "DELETE", "/segments/"+url.PathEscape(name), nil, nil, nil)
+}
+
+// ScheduleConfig sets config at the time given by schedule. It returns the
+// change left pending: the write itself, or its revert when the write was
+// made now, or nil when there is neither.
+func (c *Client) ScheduleConfig(ctx context.Context, config Config, schedule Schedule) (*ScheduledChange, error) {
+	var response writeResponse
+	err := c.do(ctx, "POST", "/configs", nil, configRequest{Config: config, Schedule: &schedule}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Scheduled, nil
+}
+
+// ScheduleOverride sets override at the time given by schedule, like
+// ScheduleConfig.
+func (c *Client) ScheduleOverride(ctx context.Context, path ConfigPath, override Override, schedule Schedule) (*ScheduledChange, error) {
+	var response writeResponse
+	err := c.do(ctx, "POST", configPathURL(&path)+"/overrides", nil, overrideRequest{Override: override, Schedule: &schedule}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Scheduled, nil
+}
+
+// ListScheduledChanges lists the pending changes earliest first, only those
+// to service's configs when service isn't empty.
+func (c *Client) ListScheduledChanges(ctx context.Context, service string) ([]ScheduledChange, error) {
+	query := url.Values{}
+	if service != "" {
+		query.Set("service", service)
+	}
+	var response scheduledChangesResponse
+	err := c.do(ctx, "GET", "/schedules", query, nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Changes, nil
+}
+
+func (c *Client) CancelScheduledChange(ctx context.Context, id string) error {
+	return c.do(ctx, "DELETE", "/schedules/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// GetValue asks the service for a config's value. Unlike the typed getters
+// it never reads the cache.
+func (c *Client) GetValue(ctx context.Context, path ConfigPath, attributes map[string]string) (Value, error) {
+	var response Value
+	err := c.do(ctx, "POST", configPathURL(&path)+"/value", nil, valueRequest{Attributes: attributes}, &response)
+	if err != nil {
+		return Value{}, err
+	}
+	return response, nil
+}
+
+// ExplainValue is GetValue with the service's explanation of how it
+// resolved the value.
+func (c *Client) ExplainValue(ctx context.Context, path ConfigPath, attributes map[string]string) (Value, error) {
+	query := url.Values{}
+	query.Set("explain", "true")
+	var response Value
+	err := c.do(ctx, "POST", configPathURL(&path)+"/value", query, valueRequest{Attributes: attributes}, &response)
+	if err != nil {
+		return Value{}, err
+	}
+	return response, nil
+}
+
+// GetServiceValues evaluates several configs of one service in one request.
+func (c *Client) GetServiceValues(ctx context.Context, service string, names []string, attributes map[string]string) ([]ValueResult, error) {
+	var response valuesResponse
+	request := serviceValuesRequest{
+		Attributes: attributes,
+		Names:      names,
+	}
+	err := c.do(ctx, "POST", "/services/"+url.PathEscape(service)+"/values", nil, request, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Values, nil
+}
+
+// GetValues evaluates configs from any number of services in one request.
+func (c *Client) GetValues(ctx context.Context, paths []ConfigPath, attributes map[string]string) ([]ValueResult, error) {
+	var response valuesResponse
+	request := valuesRequest{
+		Attributes: attributes,
+		Configs:    paths,
+	}
+	err := c.do(ctx, "POST", "/values", nil, request, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Values, nil
+}
+
+// GetHistory lists the changes to a config oldest first, limit keeps only
+// the most recent ones when positive.
+func (c *Client) GetHistory(ctx context.Context, path ConfigPath, limit int) ([]HistoryEntry, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var response historyResponse
+	err := c.do(ctx, "GET", configPathURL(&path)+"/history", query, nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.History, nil
+}
+
+// Rollback restores a config and its overrides to an earlier revision.
+func (c *Client) Rollback(ctx context.Context, path ConfigPath, revision int64) (RollbackResult, error) {
+	var response RollbackResult
+	err := c.do(ctx, "POST", configPathURL(&path)+"/rollback", nil, rollbackRequest{Revision: revision}, &response)
+	if err != nil {
+		return RollbackResult{}, err
+	}
+	return response, nil
+}
+
+// Poll waits up to timeout for a config in service to change after
+// revision. Pass an empty revision to get every config in the service.
+func (c *Client) Poll(ctx context.Context, service string, revision string, timeout time.Duration) (PollResult, error) {
+	query := url.Values{}
+	query.Set("timeout", strconv.Itoa(int(timeout/time.Second)))
+	if revision != "" {
+		query.Set("revision", revision)
+	}
+	var response PollResult
+	err := c.doWithin(ctx, timeout+c.options.Timeout, "GET", "/services/"+url.PathEscape(service)+"/poll", query, nil, &response)
+	if err != nil {
+		return PollResult{}, err
+	}
+	return response, nil
+}
+
+// GetSnapshot downloads every config of a service with its overrides, to
+// evaluate locally with Snapshot.Evaluate.
+func (c *Client) GetSnapshot(ctx context.Context, service string) (*evaluator.Snapshot, error) {
+	var response json.RawMessage
+	err := c.do(ctx, "GET", "/services/"+url.PathEscape(service)+"/snapshot", nil, nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return evaluator.Parse(response)
+}
@@ -0,0 +1,326 @@
+package client
+
+import (
+	"time"
+
+	"Service/evaluator"
+)
+
+// The client always asks for values in the service's string format, so
+// every value below is the stored string and the typed getters parse it
+// according to the config type.
+
+const (
+	ConfigTypeBool  = "bool"
+	ConfigTypeStr   = "str"
+	ConfigTypeLong  = "long"
+	ConfigTypeFloat = "float"
+)
+
+type ConfigPath struct {
+	Service string `json:"service"`
+	Name    string `json:"name"`
+}
+
+type Config struct {
+	ConfigPath
+	Type         string    `json:"type"`
+	DefaultValue string    `json:"defaultValue"`
+	Priority     []string  `json:"priority,omitempty"`
+	Rules        []Rule    `json:"rules,omitempty"`
+	Rollouts     []Rollout `json:"rollouts,omitempty"`
+	// Revision is assigned by the service and ignored on writes.
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// Rule gives Value to requests that no override matched whose attributes
+// satisfy Condition, the highest priority matching rule wins.
+type Rule struct {
+	Name      string    `json:"name,omitempty"`
+	Priority  int       `json:"priority"`
+	Condition Condition `json:"condition"`
+	Value     string    `json:"value"`
+}
+
+// Condition is the evaluator's, see evaluator.Condition for the operators.
+type Condition = evaluator.Condition
+
+// RuleMatch identifies the rule that produced a value by its index in the
+// config's rules.
+type RuleMatch struct {
+	Index int    `json:"index"`
+	Name  string `json:"name,omitempty"`
+}
+
+// Rollout gives Value to Percentage of the entities of EntityType that no
+// override or rule matched.
+type Rollout struct {
+	EntityType string  `json:"entityType"`
+	Percentage float64 `json:"percentage"`
+	Value      string  `json:"value"`
+}
+
+// RolloutMatch identifies the rollout that produced a value by its index in
+// the config's rollouts.
+type RolloutMatch struct {
+	Index      int    `json:"index"`
+	EntityType string `json:"entityType"`
+	EntityId   string `json:"entityId"`
+	Bucket     int    `json:"bucket"`
+}
+
+// SegmentEntityType is the entity type of overrides that target a segment
+// by name.
+const SegmentEntityType = evaluator.SegmentEntityType
+
+// Segment is a named group of entities that overrides can target. A request
+// is in it when its EntityType attribute is one of EntityIds, or when its
+// attributes satisfy Condition.
+type Segment struct {
+	Name       string     `json:"name"`
+	EntityType string     `json:"entityType,omitempty"`
+	EntityIds  []string   `json:"entityIds,omitempty"`
+	Condition  *Condition `json:"condition,omitempty"`
+	// Revision is assigned by the service and ignored on writes.
+	Revision int64 `json:"revision,omitempty"`
+}
+
+type OverrideKey struct {
+	EntityType string `json:"entityType"`
+	EntityId   string `json:"entityId"`
+}
+
+type Override struct {
+	OverrideKey
+	Value string `json:"value"`
+	// ExpiresAt is when the override stops applying, it never expires when
+	// nil.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// TTL is only sent on writes, it sets ExpiresAt that many seconds after
+	// the override is made instead.
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// Value is a config value resolved for a set of entity attributes.
+type Value struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	// MatchedOverride is the override that produced Value, or nil when the
+	// default value was used.
+	MatchedOverride *OverrideKey `json:"matchedOverride,omitempty"`
+	// MatchedRule is set instead when a rule produced Value.
+	MatchedRule *RuleMatch `json:"matchedRule,omitempty"`
+	// MatchedRollout is set instead when a rollout produced Value.
+	MatchedRollout *RolloutMatch `json:"matchedRollout,omitempty"`
+	// Explanation is only set by ExplainValue.
+	Explanation *Explanation `json:"explanation,omitempty"`
+}
+
+// Sources of a value, from the highest precedence down.
+const (
+	ValueSourceOverride = "override"
+	ValueSourceSegment  = "segment"
+	ValueSourceRule     = "rule"
+	ValueSourceRollout  = "rollout"
+	ValueSourceDefault  = "default"
+)
+
+// Explanation is everything the service considered while resolving a
+// value, including what matched but lost to something of higher
+// precedence.
+type Explanation struct {
+	DefaultValue string   `json:"defaultValue"`
+	Priority     []string `json:"priority"`
+	// Overrides lists the entity overrides in the order they are tried,
+	// then the config's segment overrides by segment name.
+	Overrides []OverrideCandidate `json:"overrides"`
+	// Rules lists the config's rules in the order they are tried.
+	Rules    []RuleCandidate    `json:"rules"`
+	Rollouts []RolloutCandidate `json:"rollouts"`
+	// Source is one of the ValueSource constants, Decision says in words why
+	// it won.
+	Source   string `json:"source"`
+	Decision string `json:"decision"`
+}
+
+// OverrideCandidate is an override key checked for a request. Found is set
+// when the config has an override for it, Matched when that override
+// applies.
+type OverrideCandidate struct {
+	OverrideKey
+	Found     bool       `json:"found"`
+	Value     string     `json:"value,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Matched   bool       `json:"matched"`
+}
+
+type RuleCandidate struct {
+	Index    int    `json:"index"`
+	Name     string `json:"name,omitempty"`
+	Priority int    `json:"priority"`
+	Value    string `json:"value"`
+	Matched  bool   `json:"matched"`
+}
+
+// RolloutCandidate is one of a config's rollouts. EntityId and Bucket are
+// set when the request has an entity of EntityType.
+type RolloutCandidate struct {
+	Index      int     `json:"index"`
+	EntityType string  `json:"entityType"`
+	Percentage float64 `json:"percentage"`
+	Value      string  `json:"value"`
+	EntityId   string  `json:"entityId,omitempty"`
+	Bucket     *int    `json:"bucket,omitempty"`
+	Matched    bool    `json:"matched"`
+}
+
+// ValueResult is one config's value from a batch, Error is set instead when
+// the config couldn't be evaluated.
+type ValueResult struct {
+	ConfigPath
+	Value
+	Error *Error `json:"error,omitempty"`
+}
+
+type HistoryEntry struct {
+	ConfigPath
+	Revision  int64     `json:"revision"`
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Type      string    `json:"type"`
+
+	OldConfig        *Config      `json:"oldConfig,omitempty"`
+	NewConfig        *Config      `json:"newConfig,omitempty"`
+	OverrideKey      *OverrideKey `json:"overrideKey,omitempty"`
+	OldOverride      *Override    `json:"oldOverride,omitempty"`
+	NewOverride      *Override    `json:"newOverride,omitempty"`
+	OldOverrides     []Override   `json:"oldOverrides,omitempty"`
+	NewOverrides     []Override   `json:"newOverrides,omitempty"`
+	RollbackRevision int64        `json:"rollbackRevision,omitempty"`
+	OldSegment       *Segment     `json:"oldSegment,omitempty"`
+	NewSegment       *Segment     `json:"newSegment,omitempty"`
+}
+
+// Scheduled change actions.
+const (
+	ScheduledActionSetConfig      = "setConfig"
+	ScheduledActionDeleteConfig   = "deleteConfig"
+	ScheduledActionSetOverride    = "setOverride"
+	ScheduledActionDeleteOverride = "deleteOverride"
+)
+
+// Schedule is when a config or override write is made. A zero ApplyAt
+// makes it now, RevertAt undoes it later when set.
+type Schedule struct {
+	ApplyAt  *time.Time `json:"applyAt,omitempty"`
+	RevertAt *time.Time `json:"revertAt,omitempty"`
+}
+
+// ScheduledChange is a write the service will make at ApplyAt. Config is set
+// for config actions, Override for override actions.
+type ScheduledChange struct {
+	Id string `json:"id"`
+	ConfigPath
+	Action   string     `json:"action"`
+	Type     string     `json:"type"`
+	Config   *Config    `json:"config,omitempty"`
+	Override *Override  `json:"override,omitempty"`
+	ApplyAt  time.Time  `json:"applyAt"`
+	RevertAt *time.Time `json:"revertAt,omitempty"`
+	// RevertOf is the id of the change this one undoes.
+	RevertOf  string    `json:"revertOf,omitempty"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// PollResult is the response to a long-poll for a service's changes.
+type PollResult struct {
+	// Revision is the cursor to pass to the next poll.
+	Revision string   `json:"revision"`
+	Configs  []Config `json:"configs"`
+	// Deleted lists the names of configs removed since the last poll.
+	Deleted []string `json:"deleted"`
+	// Reset is set when Configs holds every config in the service rather
+	// than only the changed ones.
+	Reset bool `json:"reset"`
+}
+
+type configRequest struct {
+	Config   Config    `json:"config"`
+	Schedule *Schedule `json:"schedule,omitempty"`
+}
+
+type writeResponse struct {
+	Scheduled *ScheduledChange `json:"scheduled"`
+}
+
+type configResponse struct {
+	Config Config `json:"config"`
+}
+
+type configsResponse struct {
+	Configs []Config `json:"configs"`
+}
+
+type overrideRequest struct {
+	Override Override  `json:"override"`
+	Schedule *Schedule `json:"schedule,omitempty"`
+}
+
+type overrideResponse struct {
+	Override Override `json:"override"`
+}
+
+type overridesResponse struct {
+	Overrides []Override `json:"overrides"`
+}
+
+type segmentRequest struct {
+	Segment Segment `json:"segment"`
+}
+
+type segmentResponse struct {
+	Segment Segment      `json:"segment"`
+	UsedBy  []ConfigPath `json:"usedBy"`
+}
+
+type segmentsResponse struct {
+	Segments []Segment `json:"segments"`
+}
+
+type scheduledChangesResponse struct {
+	Changes []ScheduledChange `json:"changes"`
+}
+
+type valueRequest struct {
+	Attributes map[string]string `json:"attributes"`
+}
+
+type serviceValuesRequest struct {
+	Attributes map[string]string `json:"attributes"`
+	Names      []string          `json:"names"`
+}
+
+type valuesRequest struct {
+	Attributes map[string]string `json:"attributes"`
+	Configs    []ConfigPath      `json:"configs"`
+}
+
+type valuesResponse struct {
+	Values []ValueResult `json:"values"`
+}
+
+type historyResponse struct {
+	History []HistoryEntry `json:"history"`
+}
+
+type rollbackRequest struct {
+	Revision int64 `json:"revision"`
+}
+
+// RollbackResult is the config and overrides restored by a rollback.
+type RollbackResult struct {
+	Config    Config     `json:"config"`
+	Overrides []Override `json:"overrides"`
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	EventChange = "change"
+	EventReset  = "reset"
+)
+
+type WatchOptions struct {
+	// Services and Configs limit the changes received, every change is
+	// received when both are empty.
+	Services []string
+	Configs  []ConfigPath
+	// LastEventId resumes a watch after the event with this id. Ids from
+	// before the service restarted get a reset event.
+	LastEventId *string
+}
+
+// Event is a change streamed by Watch. Change events carry the history
+// entry for the change. A reset event means changes were missed, the
+// watcher should reload what it is watching.
+type Event struct {
+	Id    string
+	Type  string
+	Entry *HistoryEntry
+}
+
+// Watch streams changes to handle until ctx is cancelled, the stream ends
+// or handle returns an error. Callers resuming after an error should pass
+// the id of the last event they handled as WatchOptions.LastEventId.
+func (c *Client) Watch(ctx context.Context, options WatchOptions, handle func(Event) error) error {
+	query := url.Values{}
+	for _, service := range options.Services {
+		query.Add("service", service)
+	}
+	for _, config := range options.Configs {
+		query.Add("config", config.Service+"/"+config.Name)
+	}
+	req, err := c.newRequest(ctx, "GET", "/watch", query, nil)
+	if err != nil {
+		return err
+	}
+	if options.LastEventId != nil {
+		req.Header.Set("Last-Event-ID", *options.LastEventId)
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to open watch")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		apiErr := &Error{StatusCode: res.StatusCode}
+		json.NewDecoder(res.Body).Decode(apiErr)
+		return apiErr
+	}
+
+	reader := bufio.NewReader(res.Body)
+	var event Event
+	var data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return errors.Wrap(err, "failed to read watch")
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line != "" {
+			field, value, _ := strings.Cut(line, ": ")
+			switch field {
+			case "id":
+				event.Id = value
+			case "event":
+				event.Type = value
+			case "data":
+				data = value
+			}
+			continue
+		}
+
+		// A blank line ends an event, comments leave nothing to dispatch.
+		if event.Type == EventChange {
+			var entry HistoryEntry
+			err = json.Unmarshal([]byte(data), &entry)
+			if err != nil {
+				return errors.Wrap(err, "failed to unmarshal watch event")
+			}
+			event.Entry = &entry
+		}
+		if event.Type != "" {
+			err = handle(event)
+			if err != nil {
+				return err
+			}
+		}
+		event = Event{}
+		data = ""
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"Service/client"
+
+	"github.com/pkg/errors"
+)
+
+func TestClientEndpoints(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+	ctx := context.Background()
+	c := client.New(client.Options{BaseURL: subject.URL, Actor: "alice"})
+
+	path := client.ConfigPath{Service: "service1", Name: "config1"}
+	err := c.SetConfig(ctx, client.Config{ConfigPath: path, Type: "long", DefaultValue: "9007199254740993"})
+	if err != nil {
+		t.Fatalf("Failed to set config: %v", err)
+	}
+	key := client.OverrideKey{EntityType: "user", EntityId: "123"}
+	err = c.SetOverride(ctx, path, client.Override{OverrideKey: key, Value: "2"})
+	if err != nil {
+		t.Fatalf("Failed to set override: %v", err)
+	}
+
+	config, err := c.GetConfig(ctx, path)
+	if err != nil {
+		t.Fatalf("Failed to get config: %v", err)
+	}
+	if config.DefaultValue != "9007199254740993" || config.Revision != 2 {
+		t.Errorf("Expected full precision default at revision 2, but got %v", config)
+	}
+	configs, err := c.ListConfigs(ctx)
+	if err != nil || len(configs) != 1 {
+		t.Errorf("Expected 1 config, but got %v, error: %v", configs, err)
+	}
+	override, err := c.GetOverride(ctx, path, key)
+	if err != nil || override.Value != "2" {
+		t.Errorf("Expected override value 2, but got %v, error: %v", override, err)
+	}
+
+	value, err := c.GetValue(ctx, path, map[string]string{"user": "123"})
+	if err != nil {
+		t.Fatalf("Failed to get value: %v", err)
+	}
+	if value.Value != "2" || value.MatchedOverride == nil || *value.MatchedOverride != key {
+		t.Errorf("Expected the user override, but got %v", value)
+	}
+	explained, err := c.ExplainValue(ctx, path, map[string]string{"user": "123", "group": "a"})
+	if err != nil {
+		t.Fatalf("Failed to explain value: %v", err)
+	}
+	if explained.Explanation == nil || explained.Explanation.Source != client.ValueSourceOverride ||
+		explained.Explanation.DefaultValue != "9007199254740993" || len(explained.Explanation.Overrides) != 2 {
+		t.Errorf("Expected the user override explained, but got %v", explained.Explanation)
+	}
+	values, err := c.GetServiceValues(ctx, "service1", []string{"config1", "missing"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to get values: %v", err)
+	}
+	if len(values) != 2 || values[0].Value.Value != "9007199254740993" ||
+		values[1].Error == nil || values[1].Error.Code != ErrorCodeConfigNotFound {
+		t.Errorf("Expected a value and a missing config, but got %v", values)
+	}
+
+	err = c.DeleteOverride(ctx, path, key)
+	if err != nil {
+		t.Fatalf("Failed to delete override: %v", err)
+	}
+	overrides, err := c.ListOverrides(ctx, path)
+	if err != nil || len(overrides) != 0 {
+		t.Errorf("Expected no overrides, but got %v, error: %v", overrides, err)
+	}
+
+	restored, err := c.Rollback(ctx, path, 2)
+	if err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+	if len(restored.Overrides) != 1 || restored.Config.Revision != 4 {
+		t.Errorf("Expected override restored at revision 4, but got %v", restored)
+	}
+	history, err := c.GetHistory(ctx, path, 0)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(history) != 4 || history[0].Actor != "alice" || history[3].Action != HistoryActionRollback {
+		t.Errorf("Expected 4 history entries ending in a rollback, but got %v", history)
+	}
+
+	err = c.DeleteConfig(ctx, path)
+	if err != nil {
+		t.Fatalf("Failed to delete config: %v", err)
+	}
+	_, err = c.GetConfig(ctx, path)
+	if !client.IsNotFound(err) {
+		t.Errorf("Expected config to be gone, but got %v", err)
+	}
+	err = c.SetConfig(ctx, client.Config{ConfigPath: path, Type: "bool", DefaultValue: "maybe"})
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != ErrorCodeInvalidValue {
+		t.Errorf("Expected invalid value error, but got %v", err)
+	}
+
+	err = c.SetSegment(ctx, client.Segment{Name: "staff", Condition: &client.Condition{Attribute: "email", Operator: "matches", Values: []string{"@example\\.com$"}}})
+	if err != nil {
+		t.Fatalf("Failed to set segment: %v", err)
+	}
+	segment, usedBy, err := c.GetSegment(ctx, "staff")
+	if err != nil || segment.Revision != 1 || segment.Condition == nil || len(usedBy) != 0 {
+		t.Errorf("Expected the unused segment at revision 1, but got %v used by %v, error: %v", segment, usedBy, err)
+	}
+	segments, err := c.ListSegments(ctx)
+	if err != nil || len(segments) != 1 {
+		t.Errorf("Expected 1 segment, but got %v, error: %v", segments, err)
+	}
+	err = c.DeleteSegment(ctx, "staff")
+	if err != nil {
+		t.Fatalf("Failed to delete segment: %v", err)
+	}
+	_, _, err = c.GetSegment(ctx, "staff")
+	if !client.IsNotFound(err) {
+		t.Errorf("Expected segment to be gone, but got %v", err)
+	}
+
+	applyAt := time.Now().Add(time.Hour)
+	pending, err := c.ScheduleConfig(ctx, client.Config{ConfigPath: path, Type: "bool", DefaultValue: "true"}, client.Schedule{ApplyAt: &applyAt})
+	if err != nil {
+		t.Fatalf("Failed to schedule config: %v", err)
+	}
+	if pending == nil || pending.Action != client.ScheduledActionSetConfig || pending.Actor != "alice" || pending.Config.DefaultValue != "true" {
+		t.Errorf("Expected the config to be set later, but got %v", pending)
+	}
+	err = c.SetConfig(ctx, client.Config{ConfigPath: path, Type: "bool", DefaultValue: "false"})
+	if err != nil {
+		t.Fatalf("Failed to set config: %v", err)
+	}
+	temporary := client.OverrideKey{EntityType: "user", EntityId: "456"}
+	err = c.SetOverride(ctx, path, client.Override{OverrideKey: temporary, Value: "true", TTL: 60})
+	if err != nil {
+		t.Fatalf("Failed to set override: %v", err)
+	}
+	override, err = c.GetOverride(ctx, path, temporary)
+	if err != nil || override.ExpiresAt == nil || override.ExpiresAt.Before(time.Now()) {
+		t.Errorf("Expected the override to expire in a minute, but got %v, error: %v", override, err)
+	}
+
+	revertAt := time.Now().Add(time.Hour)
+	revert, err := c.ScheduleOverride(ctx, path, client.Override{OverrideKey: key, Value: "true"}, client.Schedule{RevertAt: &revertAt})
+	if err != nil {
+		t.Fatalf("Failed to schedule override: %v", err)
+	}
+	if revert == nil || revert.Action != client.ScheduledActionDeleteOverride || revert.RevertOf == "" {
+		t.Errorf("Expected the override to be deleted later, but got %v", revert)
+	}
+	changes, err := c.ListScheduledChanges(ctx, "service1")
+	if err != nil || len(changes) != 2 {
+		t.Errorf("Expected 2 pending changes, but got %v, error: %v", changes, err)
+	}
+	err = c.CancelScheduledChange(ctx, pending.Id)
+	if err != nil {
+		t.Fatalf("Failed to cancel scheduled change: %v", err)
+	}
+	err = c.CancelScheduledChange(ctx, pending.Id)
+	if !client.IsNotFound(err) {
+		t.Errorf("Expected the change to be gone, but got %v", err)
+	}
+}
+
+func TestClientTypedGetters(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	ctx := context.Background()
+	c := client.New(client.Options{BaseURL: subject.URL})
+
+	configs := []Config{
+		{ConfigPath: ConfigPath{Service: "service1", Name: "bool"}, Type: "bool", DefaultValue: "false"},
+		{ConfigPath: ConfigPath{Service: "service1", Name: "long"}, Type: "long", DefaultValue: "42"},
+		{ConfigPath: ConfigPath{Service: "service1", Name: "float"}, Type: "float", DefaultValue: "1.5"},
+		{ConfigPath: ConfigPath{Service: "service1", Name: "str"}, Type: "str", DefaultValue: "hello",
+			Rules: []Rule{{Priority: 1, Condition: Condition{Attribute: "appVersion", Operator: "semverGte", Values: []string{"2.0"}}, Value: "hi"}}},
+		{ConfigPath: ConfigPath{Service: "service1", Name: "launch"}, Type: "bool", DefaultValue: "false",
+			Rollouts: []Rollout{{EntityType: "user", Percentage: 30, Value: "true"}}},
+	}
+	for _, config := range configs {
+		app.ConfigDb.AddConfig(&config)
+	}
+	app.ConfigDb.AddOverride(&configs[0].ConfigPath, &Override{OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"}, Value: "true"})
+	app.ConfigDb.AddSegment(&Segment{Name: "staff", EntityType: "user", EntityIds: []string{"7"}})
+	app.ConfigDb.AddOverride(&configs[1].ConfigPath, &Override{OverrideKey: OverrideKey{EntityType: SegmentEntityType, EntityId: "staff"}, Value: "7"})
+	expired := time.Now().Add(-time.Minute)
+	app.ConfigDb.AddOverride(&configs[1].ConfigPath, &Override{OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"}, Value: "1", ExpiresAt: &expired})
+	user := map[string]string{"user": "123"}
+
+	check := func(when string) {
+		if value := c.GetBool(ctx, "service1", "bool", user, false); value != true {
+			t.Errorf("Expected bool override true %s, but got %v", when, value)
+		}
+		if value := c.GetBool(ctx, "service1", "bool", nil, true); value != false {
+			t.Errorf("Expected bool default false %s, but got %v", when, value)
+		}
+		if value := c.GetLong(ctx, "service1", "long", user, 0); value != 42 {
+			t.Errorf("Expected long 42 past the expired override %s, but got %v", when, value)
+		}
+		if value := c.GetLong(ctx, "service1", "long", map[string]string{"user": "7"}, 0); value != 7 {
+			t.Errorf("Expected long segment override 7 %s, but got %v", when, value)
+		}
+		if value := c.GetFloat(ctx, "service1", "float", user, 0); value != 1.5 {
+			t.Errorf("Expected float 1.5 %s, but got %v", when, value)
+		}
+		if value := c.GetString(ctx, "service1", "str", user, ""); value != "hello" {
+			t.Errorf("Expected str hello %s, but got %v", when, value)
+		}
+		if value := c.GetString(ctx, "service1", "str", map[string]string{"appVersion": "2.1.0"}, ""); value != "hi" {
+			t.Errorf("Expected str rule hi %s, but got %v", when, value)
+		}
+		if value := c.GetLong(ctx, "service1", "bool", user, 7); value != 7 {
+			t.Errorf("Expected caller default for the wrong type %s, but got %v", when, value)
+		}
+		if value := c.GetBool(ctx, "service1", "launch", map[string]string{"user": "5"}, false); value != true {
+			t.Errorf("Expected user 5 in the rollout %s, but got %v", when, value)
+		}
+		if value := c.GetBool(ctx, "service1", "launch", map[string]string{"user": "2"}, true); value != false {
+			t.Errorf("Expected user 2 outside the rollout %s, but got %v", when, value)
+		}
+		if value := c.GetString(ctx, "service1", "missing", user, "fallback"); value != "fallback" {
+			t.Errorf("Expected caller default for a missing config %s, but got %v", when, value)
+		}
+	}
+	check("from the service")
+
+	c.Start()
+	defer c.Close()
+	// Start loads the cache before its first wait, Refresh waits for it
+	// here so the test doesn't race the background load.
+	err := c.Refresh(ctx)
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+	subject.Close()
+	check("with the service down")
+}
+
+func TestClientServesFromCache(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+	ctx := context.Background()
+	c := client.New(client.Options{BaseURL: subject.URL, Services: []string{"service1"}})
+
+	configPath := ConfigPath{Service: "service1", Name: "config1"}
+	app.ConfigDb.AddConfig(&Config{ConfigPath: configPath, Type: "long", DefaultValue: "1"})
+	otherPath := ConfigPath{Service: "service2", Name: "config1"}
+	app.ConfigDb.AddConfig(&Config{ConfigPath: otherPath, Type: "long", DefaultValue: "1"})
+	err := c.Refresh(ctx)
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+
+	app.ConfigDb.UpdateConfig(&Config{ConfigPath: configPath, Type: "long", DefaultValue: "2"})
+	app.ConfigDb.UpdateConfig(&Config{ConfigPath: otherPath, Type: "long", DefaultValue: "2"})
+	if value := c.GetLong(ctx, "service1", "config1", nil, 0); value != 1 {
+		t.Errorf("Expected the cached value 1, but got %v", value)
+	}
+	if value := c.GetLong(ctx, "service2", "config1", nil, 0); value != 2 {
+		t.Errorf("Expected the uncached service to be evaluated on the service, but got %v", value)
+	}
+
+	err = c.Refresh(ctx)
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+	if value := c.GetLong(ctx, "service1", "config1", nil, 0); value != 2 {
+		t.Errorf("Expected the refreshed value 2, but got %v", value)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	subject := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer subject.Close()
+	defer close(release)
+	c := client.New(client.Options{BaseURL: subject.URL, Timeout: 50 * time.Millisecond})
+
+	start := time.Now()
+	_, err := c.ListConfigs(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the request to time out, but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the request to give up after its timeout, but it took %v", elapsed)
+	}
+}
+
+func TestClientWatch(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	t.Cleanup(subject.Close)
+	c := client.New(client.Options{BaseURL: subject.URL})
+
+	lastEventId := FormatEventId(app.Handlers.Events.Epoch(), 0)
+	app.Handlers.SaveConfig("alice", &Config{ConfigPath: ConfigPath{Service: "service1", Name: "config1"}, Type: "bool", DefaultValue: "true"})
+	app.Handlers.SaveConfig("alice", &Config{ConfigPath: ConfigPath{Service: "service2", Name: "config1"}, Type: "bool", DefaultValue: "true"})
+
+	var events []client.Event
+	err := c.Watch(ctx, client.WatchOptions{Services: []string{"service2"}, LastEventId: &lastEventId}, func(event client.Event) error {
+		events = append(events, event)
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected watch to end when cancelled, but got %v", err)
+	}
+	if len(events) != 1 || events[0].Id != FormatEventId(app.Handlers.Events.Epoch(), 2) || events[0].Type != client.EventChange ||
+		events[0].Entry == nil || events[0].Entry.NewConfig == nil || events[0].Entry.NewConfig.DefaultValue != "true" {
+		t.Errorf("Expected service2/config1 to be created, but got %v", events)
+	}
+}
+
+func TestClientSnapshot(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+	ctx := context.Background()
+	c := client.New(client.Options{BaseURL: subject.URL})
+
+	configPath := ConfigPath{Service: "service1", Name: "config1"}
+	app.ConfigDb.AddConfig(&Config{ConfigPath: configPath, Type: "long", DefaultValue: "1"})
+	app.ConfigDb.AddOverride(&configPath, &Override{OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"}, Value: "2"})
+
+	snapshot, err := c.GetSnapshot(ctx, "service1")
+	if err != nil {
+		t.Fatalf("Failed to get snapshot: %v", err)
+	}
+	result, err := snapshot.Evaluate("config1", map[string]string{"user": "123"})
+	if err != nil || result.Value != "2" || result.MatchedOverride == nil {
+		t.Errorf("Expected the user override from the snapshot, but got %v, error: %v", result, err)
+	}
+}
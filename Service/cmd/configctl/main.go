@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	"Service/configctl"
+)
+
+func main() {
+	os.Exit(configctl.Run(os.Args[1:], os.Stdout, os.Stderr))
+}
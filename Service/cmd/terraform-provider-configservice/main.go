@@ -0,0 +1,13 @@
+package main
+
+import (
+	"Service/provider"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/plugin"
+)
+
+func main() {
+	plugin.Serve(&plugin.ServeOpts{
+		ProviderFunc: provider.New,
+	})
+}
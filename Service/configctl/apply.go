@@ -0,0 +1,403 @@
+package configctl
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"Service/client"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// File is the YAML document read by diff and apply. The overrides listed
+// under a config replace all of its overrides on the server.
+type File struct {
+	Configs []FileConfig `yaml:"configs"`
+}
+
+type FileConfig struct {
+	Service      string         `yaml:"service"`
+	Name         string         `yaml:"name"`
+	Type         string         `yaml:"type"`
+	DefaultValue string         `yaml:"defaultValue"`
+	Priority     []string       `yaml:"priority,omitempty"`
+	Rules        []FileRule     `yaml:"rules,omitempty"`
+	Rollouts     []FileRollout  `yaml:"rollouts,omitempty"`
+	Overrides    []FileOverride `yaml:"overrides,omitempty"`
+}
+
+type FileRule struct {
+	Name      string           `yaml:"name,omitempty"`
+	Priority  int              `yaml:"priority"`
+	Condition client.Condition `yaml:"condition"`
+	Value     string           `yaml:"value"`
+}
+
+type FileRollout struct {
+	EntityType string  `yaml:"entityType"`
+	Percentage float64 `yaml:"percentage"`
+	Value      string  `yaml:"value"`
+}
+
+type FileOverride struct {
+	EntityType string `yaml:"entityType"`
+	EntityId   string `yaml:"entityId"`
+	Value      string `yaml:"value"`
+}
+
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// Change is one difference between the file and the server. Override is
+// nil for changes to the config itself.
+type Change struct {
+	Action   string              `json:"action"`
+	Config   client.ConfigPath   `json:"config"`
+	Override *client.OverrideKey `json:"override,omitempty"`
+	OldValue string              `json:"oldValue,omitempty"`
+	NewValue string              `json:"newValue,omitempty"`
+
+	config   *client.Config
+	override *client.Override
+}
+
+// ReadFile loads and validates a config file.
+func ReadFile(filename string) (*File, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read config file")
+	}
+	var file File
+	err = yaml.Unmarshal(data, &file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", filename)
+	}
+
+	seen := make(map[client.ConfigPath]bool)
+	for _, config := range file.Configs {
+		path := client.ConfigPath{Service: config.Service, Name: config.Name}
+		if path.Service == "" || path.Name == "" || config.Type == "" {
+			return nil, errors.Errorf("%s: every config needs a service, name and type", filename)
+		}
+		if seen[path] {
+			return nil, errors.Errorf("%s: config %s/%s is listed twice", filename, path.Service, path.Name)
+		}
+		seen[path] = true
+
+		keys := make(map[client.OverrideKey]bool)
+		for _, override := range config.Overrides {
+			key := client.OverrideKey{EntityType: override.EntityType, EntityId: override.EntityId}
+			if key.EntityType == "" || key.EntityId == "" {
+				return nil, errors.Errorf("%s: overrides of %s/%s need an entityType and entityId", filename, path.Service, path.Name)
+			}
+			if keys[key] {
+				return nil, errors.Errorf("%s: override %s/%s of %s/%s is listed twice", filename, key.EntityType, key.EntityId, path.Service, path.Name)
+			}
+			keys[key] = true
+		}
+	}
+	return &file, nil
+}
+
+// Diff returns the changes needed to make the server match file. With
+// prune, configs missing from the file are deleted, but only in services
+// the file mentions.
+func Diff(ctx context.Context, c *client.Client, file *File, prune bool) ([]Change, error) {
+	existing, err := c.ListConfigs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[client.ConfigPath]client.Config, len(existing))
+	for _, config := range existing {
+		current[config.ConfigPath] = config
+	}
+
+	changes := []Change{}
+	wanted := make(map[client.ConfigPath]bool)
+	services := make(map[string]bool)
+	for _, fileConfig := range file.Configs {
+		config := client.Config{
+			ConfigPath:   client.ConfigPath{Service: fileConfig.Service, Name: fileConfig.Name},
+			Type:         fileConfig.Type,
+			DefaultValue: fileConfig.DefaultValue,
+			Priority:     fileConfig.Priority,
+		}
+		for _, rule := range fileConfig.Rules {
+			config.Rules = append(config.Rules, client.Rule(rule))
+		}
+		for _, rollout := range fileConfig.Rollouts {
+			config.Rollouts = append(config.Rollouts, client.Rollout(rollout))
+		}
+		wanted[config.ConfigPath] = true
+		services[config.Service] = true
+
+		old, found := current[config.ConfigPath]
+		var oldOverrides []client.Override
+		if !found {
+			changes = append(changes, Change{
+				Action:   ChangeCreate,
+				Config:   config.ConfigPath,
+				NewValue: describeConfig(&config),
+				config:   &config,
+			})
+		} else if old.Type != config.Type || old.DefaultValue != config.DefaultValue ||
+			!slices.Equal(old.Priority, config.Priority) || !rulesEqual(old.Rules, config.Rules) ||
+			!slices.Equal(old.Rollouts, config.Rollouts) {
+			// Saving the config clears its overrides, so every override in
+			// the file is created again.
+			changes = append(changes, Change{
+				Action:   ChangeUpdate,
+				Config:   config.ConfigPath,
+				OldValue: describeConfig(&old),
+				NewValue: describeConfig(&config),
+				config:   &config,
+			})
+		} else {
+			oldOverrides, err = c.ListOverrides(ctx, config.ConfigPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		changes = append(changes, diffOverrides(config.ConfigPath, oldOverrides, fileConfig.Overrides)...)
+	}
+
+	if prune {
+		sortConfigs(existing)
+		for _, config := range existing {
+			if services[config.Service] && !wanted[config.ConfigPath] {
+				changes = append(changes, Change{
+					Action:   ChangeDelete,
+					Config:   config.ConfigPath,
+					OldValue: describeConfig(&config),
+				})
+			}
+		}
+	}
+	return changes, nil
+}
+
+func diffOverrides(path client.ConfigPath, current []client.Override, wanted []FileOverride) []Change {
+	changes := []Change{}
+	currentByKey := make(map[client.OverrideKey]client.Override, len(current))
+	for _, override := range current {
+		currentByKey[override.OverrideKey] = override
+	}
+
+	wantedKeys := make(map[client.OverrideKey]bool, len(wanted))
+	for _, fileOverride := range wanted {
+		override := client.Override{
+			OverrideKey: client.OverrideKey{EntityType: fileOverride.EntityType, EntityId: fileOverride.EntityId},
+			Value:       fileOverride.Value,
+		}
+		wantedKeys[override.OverrideKey] = true
+
+		old, found := currentByKey[override.OverrideKey]
+		if found && old.Value == override.Value {
+			continue
+		}
+		change := Change{
+			Action:   ChangeCreate,
+			Config:   path,
+			Override: &override.OverrideKey,
+			NewValue: override.Value,
+			override: &override,
+		}
+		if found {
+			change.Action = ChangeUpdate
+			change.OldValue = old.Value
+		}
+		changes = append(changes, change)
+	}
+
+	sortOverrides(current)
+	for _, override := range current {
+		if !wantedKeys[override.OverrideKey] {
+			changes = append(changes, Change{
+				Action:   ChangeDelete,
+				Config:   path,
+				Override: &override.OverrideKey,
+				OldValue: override.Value,
+			})
+		}
+	}
+	return changes
+}
+
+// Apply makes the changes returned by Diff. Configs are saved before their
+// overrides, and deleted configs go last.
+func Apply(ctx context.Context, c *client.Client, changes []Change) error {
+	ordered := make([]Change, 0, len(changes))
+	for _, change := range changes {
+		if change.Override == nil && change.Action != ChangeDelete {
+			ordered = append(ordered, change)
+		}
+	}
+	for _, change := range changes {
+		if change.Override != nil {
+			ordered = append(ordered, change)
+		}
+	}
+	for _, change := range changes {
+		if change.Override == nil && change.Action == ChangeDelete {
+			ordered = append(ordered, change)
+		}
+	}
+
+	for _, change := range ordered {
+		var err error
+		switch {
+		case change.Override == nil && change.Action == ChangeDelete:
+			err = c.DeleteConfig(ctx, change.Config)
+		case change.Override == nil:
+			err = c.SetConfig(ctx, *change.config)
+		case change.Action == ChangeDelete:
+			err = c.DeleteOverride(ctx, change.Config, *change.Override)
+		default:
+			err = c.SetOverride(ctx, change.Config, *change.override)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to %s %s", change.Action, describeTarget(&change))
+		}
+	}
+	return nil
+}
+
+func (c *cli) runDiff(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	prune := flags.Bool("prune", false, "include configs missing from the file")
+	err := flags.Parse(args)
+	if err != nil {
+		return newUsageError("%v", err)
+	}
+	if flags.NArg() != 1 {
+		return newUsageError("diff takes one file")
+	}
+
+	file, err := ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	changes, err := Diff(ctx, c.client, file, *prune)
+	if err != nil {
+		return err
+	}
+	return c.printChanges(changes)
+}
+
+func (c *cli) runApply(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("apply", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	prune := flags.Bool("prune", false, "delete configs missing from the file in the services it lists")
+	dryRun := flags.Bool("dry-run", false, "print the changes without making them")
+	err := flags.Parse(args)
+	if err != nil {
+		return newUsageError("%v", err)
+	}
+	if flags.NArg() != 1 {
+		return newUsageError("apply takes one file")
+	}
+
+	file, err := ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	changes, err := Diff(ctx, c.client, file, *prune)
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		err = Apply(ctx, c.client, changes)
+		if err != nil {
+			return err
+		}
+	}
+	return c.printChanges(changes)
+}
+
+func (c *cli) printChanges(changes []Change) error {
+	if c.output == OutputTable && len(changes) == 0 {
+		_, err := fmt.Fprintln(c.stdout, "No changes")
+		return err
+	}
+	rows := make([][]string, 0, len(changes))
+	for _, change := range changes {
+		rows = append(rows, []string{change.Action, describeTarget(&change), change.OldValue, change.NewValue})
+	}
+	return c.print(changes, []string{"ACTION", "TARGET", "OLD", "NEW"}, rows)
+}
+
+func describeTarget(change *Change) string {
+	target := change.Config.Service + "/" + change.Config.Name
+	if change.Override != nil {
+		target += " " + change.Override.EntityType + "/" + change.Override.EntityId
+	}
+	return target
+}
+
+func describeConfig(config *client.Config) string {
+	description := config.Type + " " + config.DefaultValue
+	if len(config.Priority) > 0 {
+		description += " [" + strings.Join(config.Priority, ",") + "]"
+	}
+	if len(config.Rules) > 0 {
+		description += " " + describeRules(config.Rules)
+	}
+	if len(config.Rollouts) > 0 {
+		description += " " + describeRollouts(config.Rollouts)
+	}
+	return description
+}
+
+// describeRules lists rules as priority:condition=value.
+func describeRules(rules []client.Rule) string {
+	descriptions := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		descriptions = append(descriptions, fmt.Sprintf("%d:%s=%s", rule.Priority, rule.Condition.String(), rule.Value))
+	}
+	return strings.Join(descriptions, ",")
+}
+
+func rulesEqual(a []client.Rule, b []client.Rule) bool {
+	return slices.EqualFunc(a, b, func(x, y client.Rule) bool {
+		return x.Name == y.Name && x.Priority == y.Priority && x.Value == y.Value && x.Condition.Equal(&y.Condition)
+	})
+}
+
+// describeRollouts lists rollouts as percentage:entityType=value.
+func describeRollouts(rollouts []client.Rollout) string {
+	descriptions := make([]string, 0, len(rollouts))
+	for _, rollout := range rollouts {
+		descriptions = append(descriptions, fmt.Sprintf("%v%%:%s=%s", rollout.Percentage, rollout.EntityType, rollout.Value))
+	}
+	return strings.Join(descriptions, ",")
+}
+
+func sortConfigs(configs []client.Config) {
+	sort.Slice(configs, func(i, j int) bool {
+		if configs[i].Service != configs[j].Service {
+			return configs[i].Service < configs[j].Service
+		}
+		return configs[i].Name < configs[j].Name
+	})
+}
+
+func sortOverrides(overrides []client.Override) {
+	sort.Slice(overrides, func(i, j int) bool {
+		if overrides[i].EntityType != overrides[j].EntityType {
+			return overrides[i].EntityType < overrides[j].EntityType
+		}
+		return overrides[i].EntityId < overrides[j].EntityId
+	})
+}
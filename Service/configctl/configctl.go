@@ -0,0 +1,717 @@
+// Package configctl implements the configctl command line tool, see
+// cmd/configctl. It is kept out of the command itself so it can be tested
+// against a running service.
+package configctl
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"Service/client"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	OutputTable = "table"
+	OutputJson  = "json"
+)
+
+const usage = `Usage: configctl [flags] <command> [args]
+
+Commands:
+  configs list [-service <service>]
+  configs get <service>/<name>
+  configs set [-priority <type,...>] [-at <time>] [-revert-at <time>] <service>/<name> <type> <defaultValue>
+  configs delete <service>/<name>
+  overrides list <service>/<name>
+  overrides get <service>/<name> <entityType>/<entityId>
+  overrides set [-ttl <duration>] [-at <time>] [-revert-at <time>] <service>/<name> <entityType>/<entityId> <value>
+  overrides delete <service>/<name> <entityType>/<entityId>
+  segments list
+  segments get <segment>
+  segments set [-entity-type <type>] [-ids <id,...>] [-condition <json>] <segment>
+  segments delete <segment>
+  schedules list [-service <service>]
+  schedules cancel <id>
+  eval [-explain] <service>/<name> [<entityType>=<entityId> ...]
+  diff [-prune] <file.yaml>
+  apply [-prune] [-dry-run] <file.yaml>
+
+Times are RFC 3339, such as 2026-01-01T00:00:00Z.
+
+Flags:
+`
+
+// usageError is returned for bad command lines, Run prints the usage after
+// it.
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
+func newUsageError(format string, args ...any) error {
+	return &usageError{message: fmt.Sprintf(format, args...)}
+}
+
+type cli struct {
+	client *client.Client
+	output string
+	stdout io.Writer
+}
+
+// Run executes a configctl command line, without the program name, and
+// returns the exit code.
+func Run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("configctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	endpoint := flags.String("endpoint", getEnv("CONFIGSERVICE_ENDPOINT", "http://localhost:8080"), "config service base URL")
+	actor := flags.String("actor", getEnv("CONFIGSERVICE_ACTOR", os.Getenv("USER")), "name recorded in the config history")
+	output := flags.String("output", OutputTable, "output format, table or json")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if *output != OutputTable && *output != OutputJson {
+		fmt.Fprintf(stderr, "invalid output %q, must be table or json\n", *output)
+		return 2
+	}
+
+	c := &cli{
+		client: client.New(client.Options{
+			BaseURL: strings.TrimSuffix(*endpoint, "/"),
+			Actor:   *actor,
+		}),
+		output: *output,
+		stdout: stdout,
+	}
+	err = c.run(context.Background(), flags.Args())
+	var usageErr *usageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintln(stderr, usageErr.message)
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func (c *cli) run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return newUsageError("missing command")
+	}
+	command, args := args[0], args[1:]
+	switch command {
+	case "configs":
+		return c.runConfigs(ctx, args)
+	case "overrides":
+		return c.runOverrides(ctx, args)
+	case "segments":
+		return c.runSegments(ctx, args)
+	case "schedules":
+		return c.runSchedules(ctx, args)
+	case "eval":
+		return c.runEval(ctx, args)
+	case "diff":
+		return c.runDiff(ctx, args)
+	case "apply":
+		return c.runApply(ctx, args)
+	default:
+		return newUsageError("unknown command %q", command)
+	}
+}
+
+func (c *cli) runConfigs(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return newUsageError("missing configs command")
+	}
+	command, args := args[0], args[1:]
+	switch command {
+	case "list":
+		flags := flag.NewFlagSet("configs list", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		service := flags.String("service", "", "only list configs of this service")
+		err := flags.Parse(args)
+		if err != nil {
+			return newUsageError("%v", err)
+		}
+		configs, err := c.client.ListConfigs(ctx)
+		if err != nil {
+			return err
+		}
+		filtered := []client.Config{}
+		for _, config := range configs {
+			if *service == "" || config.Service == *service {
+				filtered = append(filtered, config)
+			}
+		}
+		sortConfigs(filtered)
+		return c.printConfigs(filtered)
+
+	case "get":
+		path, err := parseArgPath(args, 1)
+		if err != nil {
+			return err
+		}
+		config, err := c.client.GetConfig(ctx, path)
+		if err != nil {
+			return err
+		}
+		return c.printConfigs([]client.Config{config})
+
+	case "set":
+		flags := flag.NewFlagSet("configs set", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		priority := flags.String("priority", "", "comma separated entity types, most important first")
+		scheduleFlags := addScheduleFlags(flags)
+		err := flags.Parse(args)
+		if err != nil {
+			return newUsageError("%v", err)
+		}
+		schedule, err := scheduleFlags.parse()
+		if err != nil {
+			return err
+		}
+		args = flags.Args()
+		path, err := parseArgPath(args, 3)
+		if err != nil {
+			return err
+		}
+		config := client.Config{
+			ConfigPath:   path,
+			Type:         args[1],
+			DefaultValue: args[2],
+		}
+		if *priority != "" {
+			config.Priority = strings.Split(*priority, ",")
+		}
+		// Rules and rollouts aren't set from the command line, keep the
+		// existing ones.
+		existing, err := c.client.GetConfig(ctx, path)
+		if err != nil && !client.IsNotFound(err) {
+			return err
+		}
+		config.Rules = existing.Rules
+		config.Rollouts = existing.Rollouts
+		if schedule != nil {
+			pending, err := c.client.ScheduleConfig(ctx, config, *schedule)
+			if err != nil {
+				return err
+			}
+			if pending != nil {
+				return c.printScheduledChanges([]client.ScheduledChange{*pending})
+			}
+		} else {
+			err = c.client.SetConfig(ctx, config)
+			if err != nil {
+				return err
+			}
+		}
+		config, err = c.client.GetConfig(ctx, path)
+		if err != nil {
+			return err
+		}
+		return c.printConfigs([]client.Config{config})
+
+	case "delete":
+		path, err := parseArgPath(args, 1)
+		if err != nil {
+			return err
+		}
+		return c.client.DeleteConfig(ctx, path)
+
+	default:
+		return newUsageError("unknown configs command %q", command)
+	}
+}
+
+func (c *cli) runOverrides(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return newUsageError("missing overrides command")
+	}
+	command, args := args[0], args[1:]
+	switch command {
+	case "list":
+		path, err := parseArgPath(args, 1)
+		if err != nil {
+			return err
+		}
+		overrides, err := c.client.ListOverrides(ctx, path)
+		if err != nil {
+			return err
+		}
+		sortOverrides(overrides)
+		return c.printOverrides(overrides)
+
+	case "get":
+		path, key, err := parseArgOverride(args, 2)
+		if err != nil {
+			return err
+		}
+		override, err := c.client.GetOverride(ctx, path, key)
+		if err != nil {
+			return err
+		}
+		return c.printOverrides([]client.Override{override})
+
+	case "set":
+		flags := flag.NewFlagSet("overrides set", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		ttl := flags.Duration("ttl", 0, "how long the override applies, such as 2h")
+		scheduleFlags := addScheduleFlags(flags)
+		err := flags.Parse(args)
+		if err != nil {
+			return newUsageError("%v", err)
+		}
+		if *ttl < 0 || (*ttl > 0 && *ttl < time.Second) {
+			return newUsageError("invalid -ttl %v, must be at least 1s", *ttl)
+		}
+		schedule, err := scheduleFlags.parse()
+		if err != nil {
+			return err
+		}
+		args = flags.Args()
+		path, key, err := parseArgOverride(args, 3)
+		if err != nil {
+			return err
+		}
+		override := client.Override{
+			OverrideKey: key,
+			Value:       args[2],
+			TTL:         int64(*ttl / time.Second),
+		}
+		if schedule != nil {
+			pending, err := c.client.ScheduleOverride(ctx, path, override, *schedule)
+			if err != nil {
+				return err
+			}
+			if pending != nil {
+				return c.printScheduledChanges([]client.ScheduledChange{*pending})
+			}
+		} else {
+			err = c.client.SetOverride(ctx, path, override)
+			if err != nil {
+				return err
+			}
+		}
+		override, err = c.client.GetOverride(ctx, path, key)
+		if err != nil {
+			return err
+		}
+		return c.printOverrides([]client.Override{override})
+
+	case "delete":
+		path, key, err := parseArgOverride(args, 2)
+		if err != nil {
+			return err
+		}
+		return c.client.DeleteOverride(ctx, path, key)
+
+	default:
+		return newUsageError("unknown overrides command %q", command)
+	}
+}
+
+func (c *cli) runSegments(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return newUsageError("missing segments command")
+	}
+	command, args := args[0], args[1:]
+	switch command {
+	case "list":
+		if len(args) != 0 {
+			return newUsageError("expected 0 arguments but got %d", len(args))
+		}
+		segments, err := c.client.ListSegments(ctx)
+		if err != nil {
+			return err
+		}
+		return c.printSegments(segments)
+
+	case "get":
+		if len(args) != 1 {
+			return newUsageError("expected 1 arguments but got %d", len(args))
+		}
+		segment, _, err := c.client.GetSegment(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		return c.printSegments([]client.Segment{segment})
+
+	case "set":
+		flags := flag.NewFlagSet("segments set", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		entityType := flags.String("entity-type", "", "entity type of the listed ids")
+		ids := flags.String("ids", "", "comma separated entity ids in the segment")
+		condition := flags.String("condition", "", "condition as JSON that puts requests in the segment")
+		err := flags.Parse(args)
+		if err != nil {
+			return newUsageError("%v", err)
+		}
+		args = flags.Args()
+		if len(args) != 1 {
+			return newUsageError("expected 1 arguments but got %d", len(args))
+		}
+		segment := client.Segment{
+			Name:       args[0],
+			EntityType: *entityType,
+		}
+		if *ids != "" {
+			segment.EntityIds = strings.Split(*ids, ",")
+		}
+		if *condition != "" {
+			segment.Condition = &client.Condition{}
+			err = json.Unmarshal([]byte(*condition), segment.Condition)
+			if err != nil {
+				return newUsageError("invalid condition: %v", err)
+			}
+		}
+		err = c.client.SetSegment(ctx, segment)
+		if err != nil {
+			return err
+		}
+		segment, _, err = c.client.GetSegment(ctx, segment.Name)
+		if err != nil {
+			return err
+		}
+		return c.printSegments([]client.Segment{segment})
+
+	case "delete":
+		if len(args) != 1 {
+			return newUsageError("expected 1 arguments but got %d", len(args))
+		}
+		return c.client.DeleteSegment(ctx, args[0])
+
+	default:
+		return newUsageError("unknown segments command %q", command)
+	}
+}
+
+func (c *cli) runSchedules(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return newUsageError("missing schedules command")
+	}
+	command, args := args[0], args[1:]
+	switch command {
+	case "list":
+		flags := flag.NewFlagSet("schedules list", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		service := flags.String("service", "", "only list changes to configs of this service")
+		err := flags.Parse(args)
+		if err != nil {
+			return newUsageError("%v", err)
+		}
+		changes, err := c.client.ListScheduledChanges(ctx, *service)
+		if err != nil {
+			return err
+		}
+		return c.printScheduledChanges(changes)
+
+	case "cancel":
+		if len(args) != 1 {
+			return newUsageError("expected 1 arguments but got %d", len(args))
+		}
+		return c.client.CancelScheduledChange(ctx, args[0])
+
+	default:
+		return newUsageError("unknown schedules command %q", command)
+	}
+}
+
+// scheduleFlags are the -at and -revert-at flags of the set commands.
+type scheduleFlags struct {
+	applyAt  *string
+	revertAt *string
+}
+
+func addScheduleFlags(flags *flag.FlagSet) scheduleFlags {
+	return scheduleFlags{
+		applyAt:  flags.String("at", "", "time to make the change, now when empty"),
+		revertAt: flags.String("revert-at", "", "time to undo the change"),
+	}
+}
+
+// parse returns the schedule given by the flags, or nil when neither was
+// set.
+func (f scheduleFlags) parse() (*client.Schedule, error) {
+	if *f.applyAt == "" && *f.revertAt == "" {
+		return nil, nil
+	}
+	schedule := &client.Schedule{}
+	var err error
+	schedule.ApplyAt, err = parseTime("at", *f.applyAt)
+	if err != nil {
+		return nil, err
+	}
+	schedule.RevertAt, err = parseTime("revert-at", *f.revertAt)
+	if err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func parseTime(name string, arg string) (*time.Time, error) {
+	if arg == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, arg)
+	if err != nil {
+		return nil, newUsageError("invalid -%s: %v", name, err)
+	}
+	return &parsed, nil
+}
+
+func (c *cli) runEval(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("eval", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	explain := flags.Bool("explain", false, "list everything considered and why the value won")
+	err := flags.Parse(args)
+	if err != nil {
+		return newUsageError("%v", err)
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		return newUsageError("eval takes a config path and attributes")
+	}
+	path, err := parsePath(args[0])
+	if err != nil {
+		return err
+	}
+	attributes := make(map[string]string)
+	for _, arg := range args[1:] {
+		entityType, entityId, found := strings.Cut(arg, "=")
+		if !found || entityType == "" {
+			return newUsageError("attribute %q must be entityType=entityId", arg)
+		}
+		attributes[entityType] = entityId
+	}
+
+	if *explain {
+		value, err := c.client.ExplainValue(ctx, path, attributes)
+		if err != nil {
+			return err
+		}
+		return c.printExplanation(value)
+	}
+	value, err := c.client.GetValue(ctx, path, attributes)
+	if err != nil {
+		return err
+	}
+	matched := "default"
+	if value.MatchedOverride != nil {
+		matched = value.MatchedOverride.EntityType + "/" + value.MatchedOverride.EntityId
+	}
+	if value.MatchedRule != nil {
+		matched = fmt.Sprintf("rule %d", value.MatchedRule.Index)
+		if value.MatchedRule.Name != "" {
+			matched += " " + value.MatchedRule.Name
+		}
+	}
+	if value.MatchedRollout != nil {
+		matched = fmt.Sprintf("rollout %d, %s/%s in bucket %d",
+			value.MatchedRollout.Index, value.MatchedRollout.EntityType, value.MatchedRollout.EntityId, value.MatchedRollout.Bucket)
+	}
+	return c.print(value, []string{"TYPE", "VALUE", "MATCHED"}, [][]string{{value.Type, value.Value, matched}})
+}
+
+// printExplanation lists every candidate in the order of precedence, then
+// the decision in table mode.
+func (c *cli) printExplanation(value client.Value) error {
+	explanation := value.Explanation
+	if explanation == nil {
+		return errors.New("service returned no explanation")
+	}
+	matched := func(matched bool) string {
+		if matched {
+			return "yes"
+		}
+		return "no"
+	}
+	rows := [][]string{}
+	for _, candidate := range explanation.Overrides {
+		state := matched(candidate.Matched)
+		if !candidate.Found {
+			state = "not found"
+		} else if candidate.ExpiresAt != nil && !candidate.ExpiresAt.After(time.Now()) && !candidate.Matched {
+			state = "expired"
+		}
+		rows = append(rows, []string{"override " + candidate.EntityType + "/" + candidate.EntityId, candidate.Value, state})
+	}
+	for _, candidate := range explanation.Rules {
+		name := fmt.Sprintf("rule %d", candidate.Index)
+		if candidate.Name != "" {
+			name += " " + candidate.Name
+		}
+		rows = append(rows, []string{fmt.Sprintf("%s, priority %d", name, candidate.Priority), candidate.Value, matched(candidate.Matched)})
+	}
+	for _, candidate := range explanation.Rollouts {
+		name := fmt.Sprintf("rollout %d, %v%% of %s", candidate.Index, candidate.Percentage, candidate.EntityType)
+		if candidate.Bucket != nil {
+			name += fmt.Sprintf(", %s in bucket %d", candidate.EntityId, *candidate.Bucket)
+		}
+		rows = append(rows, []string{name, candidate.Value, matched(candidate.Matched)})
+	}
+	rows = append(rows, []string{"default", explanation.DefaultValue, "yes"})
+
+	err := c.print(value, []string{"CANDIDATE", "VALUE", "MATCHED"}, rows)
+	if err != nil || c.output == OutputJson {
+		return err
+	}
+	_, err = fmt.Fprintf(c.stdout, "\nValue %s from %s: %s\n", value.Value, explanation.Source, explanation.Decision)
+	return err
+}
+
+func (c *cli) printConfigs(configs []client.Config) error {
+	rows := make([][]string, 0, len(configs))
+	for _, config := range configs {
+		rows = append(rows, []string{
+			config.Service,
+			config.Name,
+			config.Type,
+			config.DefaultValue,
+			strings.Join(config.Priority, ","),
+			describeRules(config.Rules),
+			describeRollouts(config.Rollouts),
+			fmt.Sprint(config.Revision),
+		})
+	}
+	return c.print(configs, []string{"SERVICE", "NAME", "TYPE", "DEFAULT", "PRIORITY", "RULES", "ROLLOUTS", "REVISION"}, rows)
+}
+
+func (c *cli) printOverrides(overrides []client.Override) error {
+	rows := make([][]string, 0, len(overrides))
+	for _, override := range overrides {
+		expiresAt := ""
+		if override.ExpiresAt != nil {
+			expiresAt = override.ExpiresAt.Format(time.RFC3339)
+		}
+		rows = append(rows, []string{override.EntityType, override.EntityId, override.Value, expiresAt})
+	}
+	return c.print(overrides, []string{"ENTITY TYPE", "ENTITY ID", "VALUE", "EXPIRES AT"}, rows)
+}
+
+func (c *cli) printSegments(segments []client.Segment) error {
+	rows := make([][]string, 0, len(segments))
+	for _, segment := range segments {
+		condition := ""
+		if segment.Condition != nil {
+			condition = segment.Condition.String()
+		}
+		rows = append(rows, []string{
+			segment.Name,
+			segment.EntityType,
+			strings.Join(segment.EntityIds, ","),
+			condition,
+			fmt.Sprint(segment.Revision),
+		})
+	}
+	return c.print(segments, []string{"NAME", "ENTITY TYPE", "ENTITY IDS", "CONDITION", "REVISION"}, rows)
+}
+
+func (c *cli) printScheduledChanges(changes []client.ScheduledChange) error {
+	rows := make([][]string, 0, len(changes))
+	for _, change := range changes {
+		target, value, revertAt := "", "", ""
+		if change.Config != nil {
+			value = change.Config.DefaultValue
+		}
+		if change.Override != nil {
+			target = change.Override.EntityType + "/" + change.Override.EntityId
+			value = change.Override.Value
+		}
+		if change.RevertAt != nil {
+			revertAt = change.RevertAt.Format(time.RFC3339)
+		}
+		rows = append(rows, []string{
+			change.Id,
+			change.ApplyAt.Format(time.RFC3339),
+			change.Action,
+			change.Service + "/" + change.Name,
+			target,
+			value,
+			revertAt,
+			change.Actor,
+		})
+	}
+	return c.print(changes, []string{"ID", "APPLY AT", "ACTION", "CONFIG", "OVERRIDE", "VALUE", "REVERT AT", "ACTOR"}, rows)
+}
+
+// print writes value as JSON or rows as a table, depending on the output
+// mode.
+func (c *cli) print(value any, headers []string, rows [][]string) error {
+	if c.output == OutputJson {
+		encoder := json.NewEncoder(c.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+
+	writer := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	return writer.Flush()
+}
+
+func parsePath(arg string) (client.ConfigPath, error) {
+	service, name, found := strings.Cut(arg, "/")
+	if !found || service == "" || name == "" || strings.Contains(name, "/") {
+		return client.ConfigPath{}, newUsageError("config %q must be service/name", arg)
+	}
+	return client.ConfigPath{Service: service, Name: name}, nil
+}
+
+func parseKey(arg string) (client.OverrideKey, error) {
+	entityType, entityId, found := strings.Cut(arg, "/")
+	if !found || entityType == "" || entityId == "" || strings.Contains(entityId, "/") {
+		return client.OverrideKey{}, newUsageError("override %q must be entityType/entityId", arg)
+	}
+	return client.OverrideKey{EntityType: entityType, EntityId: entityId}, nil
+}
+
+// parseArgPath checks args has count arguments and parses the first as a
+// config path.
+func parseArgPath(args []string, count int) (client.ConfigPath, error) {
+	if len(args) != count {
+		return client.ConfigPath{}, newUsageError("expected %d arguments but got %d", count, len(args))
+	}
+	return parsePath(args[0])
+}
+
+// parseArgOverride checks args has count arguments and parses the first two
+// as a config path and override key.
+func parseArgOverride(args []string, count int) (client.ConfigPath, client.OverrideKey, error) {
+	path, err := parseArgPath(args, count)
+	if err != nil {
+		return client.ConfigPath{}, client.OverrideKey{}, err
+	}
+	key, err := parseKey(args[1])
+	if err != nil {
+		return client.ConfigPath{}, client.OverrideKey{}, err
+	}
+	return path, key, nil
+}
+
+func getEnv(key string, fallback string) string {
+	if value, found := os.LookupEnv(key); found {
+		return value
+	}
+	return fallback
+}
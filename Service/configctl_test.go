@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"Service/configctl"
+)
+
+func RunConfigctl(t *testing.T, url string, args ...string) (string, int) {
+	var stdout, stderr bytes.Buffer
+	code := configctl.Run(append([]string{"-endpoint", url, "-actor", "alice"}, args...), &stdout, &stderr)
+	if code != 0 {
+		t.Logf("configctl %v: %s", args, stderr.String())
+	}
+	return stdout.String(), code
+}
+
+func TestConfigctlCommands(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	_, code := RunConfigctl(t, subject.URL, "configs", "set", "-priority", "user,team", "service1/config1", "long", "1")
+	if code != 0 {
+		t.Fatalf("Expected config set to succeed, but got exit code %d", code)
+	}
+	_, code = RunConfigctl(t, subject.URL, "overrides", "set", "service1/config1", "user/123", "2")
+	if code != 0 {
+		t.Fatalf("Expected override set to succeed, but got exit code %d", code)
+	}
+
+	output, code := RunConfigctl(t, subject.URL, "configs", "list")
+	if code != 0 || !strings.Contains(output, "SERVICE") || !strings.Contains(output, "user,team") {
+		t.Errorf("Expected a config table, but got %d %q", code, output)
+	}
+
+	output, code = RunConfigctl(t, subject.URL, "-output", "json", "overrides", "list", "service1/config1")
+	var overrides []map[string]string
+	err := json.Unmarshal([]byte(output), &overrides)
+	if code != 0 || err != nil || len(overrides) != 1 || overrides[0]["value"] != "2" {
+		t.Errorf("Expected one override as json, but got %d %q, error: %v", code, output, err)
+	}
+
+	output, code = RunConfigctl(t, subject.URL, "-output", "json", "eval", "service1/config1", "user=123")
+	var value map[string]any
+	err = json.Unmarshal([]byte(output), &value)
+	if code != 0 || err != nil || value["value"] != "2" {
+		t.Errorf("Expected the override value, but got %d %q, error: %v", code, output, err)
+	}
+	output, code = RunConfigctl(t, subject.URL, "eval", "service1/config1", "user=456")
+	if code != 0 || !strings.Contains(output, "default") {
+		t.Errorf("Expected the default value, but got %d %q", code, output)
+	}
+
+	_, code = RunConfigctl(t, subject.URL, "segments", "set", "-condition", `{"attribute":"team","operator":"equals","values":["infra"]}`, "infra")
+	if code != 0 {
+		t.Fatalf("Expected segment set to succeed, but got exit code %d", code)
+	}
+	_, code = RunConfigctl(t, subject.URL, "overrides", "set", "service1/config1", "segment/infra", "3")
+	if code != 0 {
+		t.Fatalf("Expected segment override set to succeed, but got exit code %d", code)
+	}
+	output, code = RunConfigctl(t, subject.URL, "eval", "service1/config1", "user=456", "team=infra")
+	if code != 0 || !strings.Contains(output, "segment/infra") {
+		t.Errorf("Expected the segment override, but got %d %q", code, output)
+	}
+	output, code = RunConfigctl(t, subject.URL, "eval", "-explain", "service1/config1", "user=123", "team=infra")
+	if code != 0 || !strings.Contains(output, "override team/infra") || !strings.Contains(output, "Value 2 from override") ||
+		!strings.Contains(output, "takes precedence over override segment/infra") {
+		t.Errorf("Expected the explanation, but got %d %q", code, output)
+	}
+	output, code = RunConfigctl(t, subject.URL, "segments", "list")
+	if code != 0 || !strings.Contains(output, "team equals infra") {
+		t.Errorf("Expected a segment table, but got %d %q", code, output)
+	}
+	_, code = RunConfigctl(t, subject.URL, "segments", "delete", "infra")
+	if code != 1 {
+		t.Errorf("Expected deleting a used segment to exit 1, but got %d", code)
+	}
+	_, code = RunConfigctl(t, subject.URL, "overrides", "delete", "service1/config1", "segment/infra")
+	if code != 0 {
+		t.Errorf("Expected override delete to succeed, but got exit code %d", code)
+	}
+	_, code = RunConfigctl(t, subject.URL, "segments", "delete", "infra")
+	if code != 0 {
+		t.Errorf("Expected segment delete to succeed, but got exit code %d", code)
+	}
+
+	output, code = RunConfigctl(t, subject.URL, "-output", "json", "overrides", "set", "-ttl", "1h", "service1/config1", "user/555", "9")
+	overrides = nil
+	err = json.Unmarshal([]byte(output), &overrides)
+	if code != 0 || err != nil || len(overrides) != 1 || overrides[0]["expiresAt"] == "" {
+		t.Errorf("Expected the override to expire, but got %d %q, error: %v", code, output, err)
+	}
+	_, code = RunConfigctl(t, subject.URL, "overrides", "set", "-ttl", "1ms", "service1/config1", "user/555", "9")
+	if code != 2 {
+		t.Errorf("Expected a ttl under a second to exit 2, but got %d", code)
+	}
+
+	applyAt := time.Now().Add(time.Hour).Format(time.RFC3339)
+	output, code = RunConfigctl(t, subject.URL, "-output", "json", "overrides", "set", "-at", applyAt, "service1/config1", "user/789", "4")
+	var pending []map[string]any
+	err = json.Unmarshal([]byte(output), &pending)
+	if code != 0 || err != nil || len(pending) != 1 || pending[0]["action"] != "setOverride" {
+		t.Fatalf("Expected the override to be scheduled, but got %d %q, error: %v", code, output, err)
+	}
+	output, code = RunConfigctl(t, subject.URL, "schedules", "list", "-service", "service1")
+	if code != 0 || !strings.Contains(output, "user/789") || !strings.Contains(output, applyAt) {
+		t.Errorf("Expected a scheduled change table, but got %d %q", code, output)
+	}
+	_, code = RunConfigctl(t, subject.URL, "schedules", "cancel", pending[0]["id"].(string))
+	if code != 0 {
+		t.Errorf("Expected schedule cancel to succeed, but got exit code %d", code)
+	}
+	_, code = RunConfigctl(t, subject.URL, "configs", "set", "-revert-at", "tomorrow", "service1/config1", "long", "5")
+	if code != 2 {
+		t.Errorf("Expected a bad time to exit 2, but got %d", code)
+	}
+
+	_, code = RunConfigctl(t, subject.URL, "overrides", "delete", "service1/config1", "user/123")
+	if code != 0 {
+		t.Errorf("Expected override delete to succeed, but got exit code %d", code)
+	}
+	_, code = RunConfigctl(t, subject.URL, "configs", "delete", "service1/config1")
+	if code != 0 {
+		t.Errorf("Expected config delete to succeed, but got exit code %d", code)
+	}
+	_, code = RunConfigctl(t, subject.URL, "configs", "get", "service1/config1")
+	if code != 1 {
+		t.Errorf("Expected a missing config to exit 1, but got %d", code)
+	}
+	_, code = RunConfigctl(t, subject.URL, "configs", "get", "config1")
+	if code != 2 {
+		t.Errorf("Expected a bad path to exit 2, but got %d", code)
+	}
+}
+
+func TestConfigctlApply(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	RunConfigctl(t, subject.URL, "configs", "set", "service1/config1", "bool", "false")
+	RunConfigctl(t, subject.URL, "overrides", "set", "service1/config1", "user/1", "true")
+	RunConfigctl(t, subject.URL, "overrides", "set", "service1/config1", "user/2", "true")
+	RunConfigctl(t, subject.URL, "configs", "set", "service1/old", "str", "x")
+	RunConfigctl(t, subject.URL, "configs", "set", "service2/other", "str", "y")
+
+	filename := filepath.Join(t.TempDir(), "configs.yaml")
+	err := os.WriteFile(filename, []byte(`configs:
+  - service: service1
+    name: config1
+    type: bool
+    defaultValue: true
+    overrides:
+      - entityType: user
+        entityId: 1
+        value: true
+      - entityType: team
+        entityId: a
+        value: false
+  - service: service1
+    name: config2
+    type: long
+    defaultValue: 5
+    rules:
+      - name: small teams
+        priority: 1
+        value: 10
+        condition:
+          any:
+            - attribute: teamSize
+              operator: between
+              values: [1, 5]
+            - attribute: appVersion
+              operator: semverLt
+              values: [2.0.0]
+`), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	output, code := RunConfigctl(t, subject.URL, "-output", "json", "diff", "-prune", filename)
+	var changes []configctl.Change
+	err = json.Unmarshal([]byte(output), &changes)
+	if code != 0 || err != nil {
+		t.Fatalf("Expected a diff, but got %d %q, error: %v", code, output, err)
+	}
+	actions := []string{}
+	for _, change := range changes {
+		target := change.Config.Name
+		if change.Override != nil {
+			target += "/" + change.Override.EntityId
+		}
+		actions = append(actions, change.Action+" "+target)
+	}
+	expected := "update config1,create config1/1,create config1/a,create config2,delete old"
+	if strings.Join(actions, ",") != expected {
+		t.Errorf("Expected changes %s, but got %v", expected, actions)
+	}
+
+	_, code = RunConfigctl(t, subject.URL, "apply", "-prune", filename)
+	if code != 0 {
+		t.Fatalf("Expected apply to succeed, but got exit code %d", code)
+	}
+	output, _ = RunConfigctl(t, subject.URL, "diff", "-prune", filename)
+	if strings.TrimSpace(output) != "No changes" {
+		t.Errorf("Expected no changes after apply, but got %q", output)
+	}
+	output, _ = RunConfigctl(t, subject.URL, "configs", "list")
+	if !strings.Contains(output, "service2") || strings.Contains(output, "old") ||
+		!strings.Contains(output, "1:(teamSize between [1,5] or appVersion semverLt 2.0.0)=10") {
+		t.Errorf("Expected other services kept and old pruned, but got %q", output)
+	}
+}
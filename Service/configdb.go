@@ -1,27 +1,155 @@
 package main
 
 import (
+	"container/heap"
 	"maps"
 	"slices"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+// ConfigStore is the storage interface the handlers depend on. ConfigDb keeps
+// everything in memory, FileConfigDb persists to a local file and
+// RedisConfigDb stores everything in redis.
+type ConfigStore interface {
+	GetConfigs() ([]Config, error)
+	// AddConfig creates config or replaces it, clearing its overrides.
+	AddConfig(config *Config) error
+	// UpdateConfig replaces config and keeps its overrides.
+	UpdateConfig(config *Config) error
+	GetConfig(path *ConfigPath) (Config, error)
+	DeleteConfig(path *ConfigPath) error
+	GetOverrides(config *ConfigPath) ([]Override, error)
+	// GetOverridesOfType returns the overrides of config for one entity
+	// type, such as those targeting segments, without reading the others.
+	GetOverridesOfType(config *ConfigPath, entityType string) ([]Override, error)
+	AddOverride(config *ConfigPath, override *Override) error
+	GetOverride(config *ConfigPath, overrideKey *OverrideKey) (Override, bool, error)
+	DeleteOverride(config *ConfigPath, overrideKey *OverrideKey) error
+	// PopExpiredOverrides takes the overrides due to expire at or before now
+	// out of the expiry index. The index isn't updated when an override is
+	// deleted or written again, so callers must check each one is still
+	// stored and expired.
+	PopExpiredOverrides(now time.Time) ([]OverrideRef, error)
+	// RestoreConfig replaces config and its whole override set in one step,
+	// creating the config if it doesn't exist.
+	RestoreConfig(config *Config, overrides []Override) error
+	// AddHistory appends to the change history of entry's config. History
+	// is kept after the config itself is deleted.
+	AddHistory(entry *HistoryEntry) error
+	// GetHistory returns the changes to a config, oldest first.
+	GetHistory(config *ConfigPath) ([]HistoryEntry, error)
+	GetSegments() ([]Segment, error)
+	// GetSegment returns ErrSegmentNotFound when there is no segment named
+	// name.
+	GetSegment(name string) (Segment, error)
+	AddSegment(segment *Segment) error
+	DeleteSegment(name string) error
+	GetScheduledChanges() ([]ScheduledChange, error)
+	AddScheduledChange(change *ScheduledChange) error
+	// DeleteScheduledChange reports whether the change was still stored, so
+	// that only one of several services sharing a store applies it.
+	DeleteScheduledChange(id string) (bool, error)
+	Close() error
+}
+
 type ConfigOverrides map[string]Override
 
+// OverrideRef identifies an override across all configs.
+type OverrideRef struct {
+	ConfigPath
+	OverrideKey
+}
+
+type expiryEntry struct {
+	ExpiresAt time.Time
+	Ref       OverrideRef
+}
+
+// expiryHeap orders overrides by when they expire, soonest first.
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].ExpiresAt.Before(h[j].ExpiresAt) }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x any) {
+	*h = append(*h, x.(expiryEntry))
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// ConfigDb is safe for concurrent use. Reads take a shared lock so value
+// evaluation from many request goroutines never waits on other readers.
 type ConfigDb struct {
 	Config ConfigDbConfig
 
+	mu sync.RWMutex
+
 	Configs   map[string]Config
 	Overrides map[string]ConfigOverrides
+	History   map[string][]HistoryEntry
+	Segments  map[string]Segment
+	Schedules map[string]ScheduledChange
+
+	// expiries indexes the overrides that have an expiry.
+	expiries expiryHeap
 }
 
+const (
+	BackendMemory = "memory"
+	BackendFile   = "file"
+	BackendRedis  = "redis"
+)
+
 type ConfigDbConfig struct {
+	// Backend selects the ConfigStore implementation, one of BackendMemory,
+	// BackendFile or BackendRedis.
+	Backend string
+	// Path is the snapshot file used by the file backend, its write-ahead
+	// log is kept next to it.
+	Path string
+	// SnapshotEvery is the number of logged mutations after which the file
+	// backend writes a new snapshot.
+	SnapshotEvery int
+
+	Address  string
 	User     string
 	Password string
 	Database string
 }
 
+func NewConfigDb(config ConfigDbConfig) *ConfigDb {
+	return &ConfigDb{
+		Config:    config,
+		Configs:   make(map[string]Config),
+		Overrides: make(map[string]ConfigOverrides),
+		History:   make(map[string][]HistoryEntry),
+		Segments:  make(map[string]Segment),
+		Schedules: make(map[string]ScheduledChange),
+	}
+}
+
+func NewConfigStore(config ConfigDbConfig) (ConfigStore, error) {
+	switch config.Backend {
+	case BackendMemory, "":
+		return NewConfigDb(config), nil
+	case BackendFile:
+		return OpenFileConfigDb(config)
+	case BackendRedis:
+		return NewRedisConfigDb(config), nil
+	default:
+		return nil, errors.Errorf("unknown config db backend %q", config.Backend)
+	}
+}
+
 func GetConfigPathStr(config *ConfigPath) string {
 	return config.Service + "/" +
 		config.Name
@@ -33,10 +161,17 @@ func GetOverridePathStr(override *OverrideKey) string {
 }
 
 func (db *ConfigDb) GetConfigs() ([]Config, error) {
-	return slices.Collect(maps.Values(db.Configs)), nil
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	configs := make([]Config, 0, len(db.Configs))
+	return slices.AppendSeq(configs, maps.Values(db.Configs)), nil
 }
 
 func (db *ConfigDb) AddConfig(config *Config) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	strPath := GetConfigPathStr(&config.ConfigPath)
 	db.Configs[strPath] = *config
 	db.Overrides[strPath] = make(ConfigOverrides)
@@ -44,49 +179,98 @@ func (db *ConfigDb) AddConfig(config *Config) error {
 	return nil
 }
 
+func (db *ConfigDb) UpdateConfig(config *Config) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	strPath := GetConfigPathStr(&config.ConfigPath)
+	db.Configs[strPath] = *config
+	if _, found := db.Overrides[strPath]; !found {
+		db.Overrides[strPath] = make(ConfigOverrides)
+	}
+
+	return nil
+}
+
 func (db *ConfigDb) GetConfig(path *ConfigPath) (Config, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
 	strPath := GetConfigPathStr(path)
 	if value, found := db.Configs[strPath]; found {
 		return value, nil
 	} else {
-		return Config{}, errors.New("Config not found")
+		return Config{}, ErrConfigNotFound
 	}
 }
 
 func (db *ConfigDb) DeleteConfig(path *ConfigPath) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	strPath := GetConfigPathStr(path)
 	delete(db.Configs, strPath)
+	delete(db.Overrides, strPath)
 	return nil
 }
 
 func (db *ConfigDb) GetOverrides(config *ConfigPath) ([]Override, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	configStr := GetConfigPathStr(config)
+	configOverrides, found := db.Overrides[configStr]
+	if !found {
+		return []Override{}, ErrConfigNotFound
+	}
+
+	values := make([]Override, 0, len(configOverrides))
+	return slices.AppendSeq(values, maps.Values(configOverrides)), nil
+}
+
+func (db *ConfigDb) GetOverridesOfType(config *ConfigPath, entityType string) ([]Override, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
 	configStr := GetConfigPathStr(config)
 	configOverrides, found := db.Overrides[configStr]
 	if !found {
-		return []Override{}, errors.New("Config not found")
+		return []Override{}, ErrConfigNotFound
 	}
 
-	values := slices.Collect(maps.Values(configOverrides))
+	values := []Override{}
+	for _, override := range configOverrides {
+		if override.EntityType == entityType {
+			values = append(values, override)
+		}
+	}
 	return values, nil
 }
 
 func (db *ConfigDb) AddOverride(config *ConfigPath, override *Override) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	configStr := GetConfigPathStr(config)
 	configOverrides, found := db.Overrides[configStr]
 	if !found {
-		return errors.New("Config not found")
+		return ErrConfigNotFound
 	}
 
 	overrideStr := GetOverridePathStr(&override.OverrideKey)
 	configOverrides[overrideStr] = *override
+	db.indexExpiry(config, override)
 	return nil
 }
 
 func (db *ConfigDb) GetOverride(config *ConfigPath, overrideKey *OverrideKey) (Override, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
 	configStr := GetConfigPathStr(config)
 	configOverrides, found := db.Overrides[configStr]
 	if !found {
-		return Override{}, false, errors.New("Config not found")
+		return Override{}, false, ErrConfigNotFound
 	}
 
 	overrideStr := GetOverridePathStr(overrideKey)
@@ -99,6 +283,9 @@ func (db *ConfigDb) GetOverride(config *ConfigPath, overrideKey *OverrideKey) (O
 }
 
 func (db *ConfigDb) DeleteOverride(config *ConfigPath, overrideKey *OverrideKey) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	configStr := GetConfigPathStr(config)
 	configOverrides, found := db.Overrides[configStr]
 	if !found {
@@ -109,3 +296,123 @@ func (db *ConfigDb) DeleteOverride(config *ConfigPath, overrideKey *OverrideKey)
 	delete(configOverrides, overrideStr)
 	return nil
 }
+
+func (db *ConfigDb) RestoreConfig(config *Config, overrides []Override) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	strPath := GetConfigPathStr(&config.ConfigPath)
+	configOverrides := make(ConfigOverrides, len(overrides))
+	for _, override := range overrides {
+		configOverrides[GetOverridePathStr(&override.OverrideKey)] = override
+		db.indexExpiry(&config.ConfigPath, &override)
+	}
+	db.Configs[strPath] = *config
+	db.Overrides[strPath] = configOverrides
+	return nil
+}
+
+// indexExpiry adds override to the expiry index if it expires. Callers must
+// hold mu.
+func (db *ConfigDb) indexExpiry(config *ConfigPath, override *Override) {
+	if override.ExpiresAt == nil {
+		return
+	}
+	heap.Push(&db.expiries, expiryEntry{
+		ExpiresAt: *override.ExpiresAt,
+		Ref:       OverrideRef{ConfigPath: *config, OverrideKey: override.OverrideKey},
+	})
+}
+
+func (db *ConfigDb) PopExpiredOverrides(now time.Time) ([]OverrideRef, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	refs := []OverrideRef{}
+	for len(db.expiries) > 0 && !db.expiries[0].ExpiresAt.After(now) {
+		entry := heap.Pop(&db.expiries).(expiryEntry)
+		refs = append(refs, entry.Ref)
+	}
+	return refs, nil
+}
+
+func (db *ConfigDb) AddHistory(entry *HistoryEntry) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	strPath := GetConfigPathStr(&entry.ConfigPath)
+	db.History[strPath] = append(db.History[strPath], *entry)
+	return nil
+}
+
+func (db *ConfigDb) GetHistory(config *ConfigPath) ([]HistoryEntry, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	history := db.History[GetConfigPathStr(config)]
+	return append(make([]HistoryEntry, 0, len(history)), history...), nil
+}
+
+func (db *ConfigDb) GetSegments() ([]Segment, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	segments := make([]Segment, 0, len(db.Segments))
+	return slices.AppendSeq(segments, maps.Values(db.Segments)), nil
+}
+
+func (db *ConfigDb) GetSegment(name string) (Segment, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	segment, found := db.Segments[name]
+	if !found {
+		return Segment{}, ErrSegmentNotFound
+	}
+	return segment, nil
+}
+
+func (db *ConfigDb) AddSegment(segment *Segment) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.Segments[segment.Name] = *segment
+	return nil
+}
+
+func (db *ConfigDb) DeleteSegment(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	delete(db.Segments, name)
+	return nil
+}
+
+func (db *ConfigDb) GetScheduledChanges() ([]ScheduledChange, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	changes := make([]ScheduledChange, 0, len(db.Schedules))
+	return slices.AppendSeq(changes, maps.Values(db.Schedules)), nil
+}
+
+func (db *ConfigDb) AddScheduledChange(change *ScheduledChange) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.Schedules[change.Id] = *change
+	return nil
+}
+
+func (db *ConfigDb) DeleteScheduledChange(id string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, found := db.Schedules[id]
+	delete(db.Schedules, id)
+	return found, nil
+}
+
+func (db *ConfigDb) Close() error {
+	return nil
+}
@@ -0,0 +1,703 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RunConfigStoreConformance exercises the behaviour every ConfigStore
+// implementation must share.
+func RunConfigStoreConformance(t *testing.T, buildStore func(t *testing.T) ConfigStore) {
+	configPath := ConfigPath{
+		Service: "service1",
+		Name:    "config1",
+	}
+	overrideKey := OverrideKey{EntityType: "user", EntityId: "123"}
+
+	t.Run("AddAndGetConfig", func(t *testing.T) {
+		store := buildStore(t)
+		err := store.AddConfig(&Config{
+			ConfigPath:   configPath,
+			Type:         "str",
+			DefaultValue: "value1",
+		})
+		if err != nil {
+			t.Fatalf("Failed to add config: %v", err)
+		}
+
+		config, err := store.GetConfig(&configPath)
+		if err != nil {
+			t.Fatalf("Failed to get config: %v", err)
+		}
+		if config.ConfigPath != configPath || config.Type != "str" || config.DefaultValue != "value1" {
+			t.Errorf("Expected service1/config1 string config with value1, got %v", config)
+		}
+
+		configs, err := store.GetConfigs()
+		if err != nil {
+			t.Fatalf("Failed to get configs: %v", err)
+		}
+		if len(configs) != 1 {
+			t.Errorf("Expected 1 config, but got %v", configs)
+		}
+	})
+
+	t.Run("Segments", func(t *testing.T) {
+		store := buildStore(t)
+		_, err := store.GetSegment("beta")
+		if !errors.Is(err, ErrSegmentNotFound) {
+			t.Errorf("Expected a missing segment to be not found, but got %v", err)
+		}
+
+		err = store.AddSegment(&Segment{Name: "beta", EntityType: "user", EntityIds: []string{"1", "2"}, Revision: 1})
+		if err != nil {
+			t.Fatalf("Failed to add segment: %v", err)
+		}
+		err = store.AddSegment(&Segment{Name: "ios", Condition: &Condition{Attribute: "platform", Operator: "equals", Values: []string{"ios"}}, Revision: 1})
+		if err != nil {
+			t.Fatalf("Failed to add segment: %v", err)
+		}
+		segment, err := store.GetSegment("beta")
+		if err != nil || segment.EntityType != "user" || len(segment.EntityIds) != 2 || segment.Revision != 1 {
+			t.Errorf("Expected segment beta with 2 users, but got %v, error: %v", segment, err)
+		}
+		segments, err := store.GetSegments()
+		if err != nil || len(segments) != 2 {
+			t.Errorf("Expected 2 segments, but got %v, error: %v", segments, err)
+		}
+
+		err = store.DeleteSegment("beta")
+		if err != nil {
+			t.Fatalf("Failed to delete segment: %v", err)
+		}
+		segments, err = store.GetSegments()
+		if err != nil || len(segments) != 1 || segments[0].Condition == nil {
+			t.Errorf("Expected only segment ios to remain, but got %v, error: %v", segments, err)
+		}
+	})
+
+	t.Run("ScheduledChanges", func(t *testing.T) {
+		store := buildStore(t)
+		applyAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+		err := store.AddScheduledChange(&ScheduledChange{Id: "a", ConfigPath: configPath, Action: ScheduledActionSetOverride, Type: "str",
+			Override: &Override{OverrideKey: overrideKey, Value: "on"}, ApplyAt: applyAt})
+		if err != nil {
+			t.Fatalf("Failed to add scheduled change: %v", err)
+		}
+		err = store.AddScheduledChange(&ScheduledChange{Id: "b", ConfigPath: configPath, Action: ScheduledActionSetConfig, Type: "str",
+			Config: &Config{ConfigPath: configPath, Type: "str", DefaultValue: "x"}, ApplyAt: applyAt})
+		if err != nil {
+			t.Fatalf("Failed to add scheduled change: %v", err)
+		}
+		changes, err := store.GetScheduledChanges()
+		if err != nil || len(changes) != 2 {
+			t.Errorf("Expected 2 scheduled changes, but got %v, error: %v", changes, err)
+		}
+
+		deleted, err := store.DeleteScheduledChange("a")
+		if err != nil || !deleted {
+			t.Errorf("Expected change a to be deleted, but got %v, error: %v", deleted, err)
+		}
+		deleted, err = store.DeleteScheduledChange("a")
+		if err != nil || deleted {
+			t.Errorf("Expected change a to be gone, but got %v, error: %v", deleted, err)
+		}
+		changes, err = store.GetScheduledChanges()
+		if err != nil || len(changes) != 1 || changes[0].Config == nil || !changes[0].ApplyAt.Equal(applyAt) {
+			t.Errorf("Expected only change b to remain, but got %v, error: %v", changes, err)
+		}
+	})
+
+	t.Run("GetMissingConfig", func(t *testing.T) {
+		store := buildStore(t)
+		_, err := store.GetConfig(&configPath)
+		if err == nil {
+			t.Errorf("Expected error when getting missing config, got nil")
+		}
+		_, err = store.GetOverrides(&configPath)
+		if err == nil {
+			t.Errorf("Expected error when listing overrides of missing config, got nil")
+		}
+		_, _, err = store.GetOverride(&configPath, &overrideKey)
+		if err == nil {
+			t.Errorf("Expected error when getting override of missing config, got nil")
+		}
+		err = store.AddOverride(&configPath, &Override{OverrideKey: overrideKey, Value: "override1"})
+		if err == nil {
+			t.Errorf("Expected error when adding override to missing config, got nil")
+		}
+	})
+
+	t.Run("DeleteConfig", func(t *testing.T) {
+		store := buildStore(t)
+		store.AddConfig(&Config{
+			ConfigPath:   configPath,
+			Type:         "str",
+			DefaultValue: "value1",
+		})
+		store.AddOverride(&configPath, &Override{OverrideKey: overrideKey, Value: "override1"})
+
+		err := store.DeleteConfig(&configPath)
+		if err != nil {
+			t.Fatalf("Failed to delete config: %v", err)
+		}
+		_, err = store.GetConfig(&configPath)
+		if err == nil {
+			t.Errorf("Expected error when getting deleted config, got nil")
+		}
+		_, err = store.GetOverrides(&configPath)
+		if err == nil {
+			t.Errorf("Expected overrides of deleted config to be removed")
+		}
+	})
+
+	t.Run("Overrides", func(t *testing.T) {
+		store := buildStore(t)
+		store.AddConfig(&Config{
+			ConfigPath:   configPath,
+			Type:         "str",
+			DefaultValue: "value1",
+		})
+
+		overrides, err := store.GetOverrides(&configPath)
+		if err != nil {
+			t.Fatalf("Failed to get overrides: %v", err)
+		}
+		if overrides == nil || len(overrides) != 0 {
+			t.Errorf("Expected empty override list, but got %v", overrides)
+		}
+
+		err = store.AddOverride(&configPath, &Override{OverrideKey: overrideKey, Value: "override1"})
+		if err != nil {
+			t.Fatalf("Failed to add override: %v", err)
+		}
+		err = store.AddOverride(&configPath, &Override{
+			OverrideKey: OverrideKey{EntityType: "group", EntityId: "456"},
+			Value:       "override2",
+		})
+		if err != nil {
+			t.Fatalf("Failed to add override: %v", err)
+		}
+		expiresAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+		err = store.AddOverride(&configPath, &Override{OverrideKey: overrideKey, Value: "override3", ExpiresAt: &expiresAt})
+		if err != nil {
+			t.Fatalf("Failed to replace override: %v", err)
+		}
+
+		override, found, err := store.GetOverride(&configPath, &overrideKey)
+		if err != nil || !found {
+			t.Fatalf("Expected override to be present, got error: %v", err)
+		}
+		if override.Value != "override3" || override.ExpiresAt == nil || !override.ExpiresAt.Equal(expiresAt) {
+			t.Errorf("Expected override value to be override3 expiring at %v, got %v", expiresAt, override)
+		}
+
+		overrides, err = store.GetOverrides(&configPath)
+		if err != nil {
+			t.Fatalf("Failed to get overrides: %v", err)
+		}
+		if len(overrides) != 2 {
+			t.Errorf("Expected 2 overrides, but got %v", overrides)
+		}
+
+		err = store.DeleteOverride(&configPath, &overrideKey)
+		if err != nil {
+			t.Fatalf("Failed to delete override: %v", err)
+		}
+		_, found, err = store.GetOverride(&configPath, &overrideKey)
+		if found || err != nil {
+			t.Errorf("Expected override to be deleted, but it still exists")
+		}
+		err = store.DeleteOverride(&configPath, &overrideKey)
+		if err != nil {
+			t.Errorf("Expected deleting a missing override to succeed, got %v", err)
+		}
+	})
+
+	t.Run("GetOverridesOfType", func(t *testing.T) {
+		store := buildStore(t)
+		store.AddConfig(&Config{
+			ConfigPath:   configPath,
+			Type:         "str",
+			DefaultValue: "value1",
+		})
+		keys := []OverrideKey{
+			{EntityType: "segment", EntityId: "beta"},
+			{EntityType: "segment", EntityId: "staff"},
+			{EntityType: "segments", EntityId: "other"},
+			{EntityType: "user", EntityId: "segment"},
+			{EntityType: "seg*", EntityId: "glob"},
+		}
+		for _, key := range keys {
+			err := store.AddOverride(&configPath, &Override{OverrideKey: key, Value: "override"})
+			if err != nil {
+				t.Fatalf("Failed to add override: %v", err)
+			}
+		}
+
+		overrides, err := store.GetOverridesOfType(&configPath, "segment")
+		if err != nil {
+			t.Fatalf("Failed to get overrides: %v", err)
+		}
+		ids := []string{}
+		for _, override := range overrides {
+			ids = append(ids, override.EntityId)
+		}
+		slices.Sort(ids)
+		if !slices.Equal(ids, []string{"beta", "staff"}) {
+			t.Errorf("Expected the beta and staff segment overrides, got %v", overrides)
+		}
+
+		overrides, err = store.GetOverridesOfType(&configPath, "seg*")
+		if err != nil {
+			t.Fatalf("Failed to get overrides: %v", err)
+		}
+		if len(overrides) != 1 || overrides[0].EntityId != "glob" {
+			t.Errorf("Expected only the seg* override, got %v", overrides)
+		}
+
+		_, err = store.GetOverridesOfType(&ConfigPath{Service: "missing", Name: "missing"}, "segment")
+		if !errors.Is(err, ErrConfigNotFound) {
+			t.Errorf("Expected ErrConfigNotFound for a missing config, got %v", err)
+		}
+
+		overrides, err = store.GetOverrides(&configPath)
+		if err != nil {
+			t.Fatalf("Failed to get overrides: %v", err)
+		}
+		if len(overrides) != len(keys) {
+			t.Errorf("Expected all %v overrides, got %v", len(keys), overrides)
+		}
+
+		err = store.DeleteOverride(&configPath, &keys[0])
+		if err != nil {
+			t.Fatalf("Failed to delete override: %v", err)
+		}
+		_, found, err := store.GetOverride(&configPath, &keys[0])
+		if err != nil || found {
+			t.Errorf("Expected the beta segment override to be deleted, got found: %v, error: %v", found, err)
+		}
+		_, found, err = store.GetOverride(&configPath, &keys[1])
+		if err != nil || !found {
+			t.Errorf("Expected the staff segment override to be kept, got error: %v", err)
+		}
+
+		store.AddConfig(&Config{
+			ConfigPath:   configPath,
+			Type:         "str",
+			DefaultValue: "value2",
+		})
+		overrides, err = store.GetOverridesOfType(&configPath, "segment")
+		if err != nil || len(overrides) != 0 {
+			t.Errorf("Expected replacing the config to clear its segment overrides, got %v, error: %v", overrides, err)
+		}
+	})
+
+	t.Run("PopExpiredOverrides", func(t *testing.T) {
+		store := buildStore(t)
+		store.AddConfig(&Config{
+			ConfigPath:   configPath,
+			Type:         "str",
+			DefaultValue: "value1",
+		})
+		now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+		expiries := map[OverrideKey]time.Time{
+			{EntityType: "user", EntityId: "1"}:               now.Add(time.Minute),
+			{EntityType: SegmentEntityType, EntityId: "beta"}: now.Add(time.Minute),
+			// Less than a millisecond later still isn't due a minute on.
+			{EntityType: "user", EntityId: "2"}: now.Add(time.Minute + 500*time.Microsecond),
+			{EntityType: "user", EntityId: "3"}: now.Add(time.Hour),
+		}
+		for key, expiresAt := range expiries {
+			err := store.AddOverride(&configPath, &Override{OverrideKey: key, Value: "override", ExpiresAt: &expiresAt})
+			if err != nil {
+				t.Fatalf("Failed to add override: %v", err)
+			}
+		}
+		store.AddOverride(&configPath, &Override{OverrideKey: OverrideKey{EntityType: "user", EntityId: "4"}, Value: "override"})
+
+		popIds := func(at time.Time) []string {
+			refs, err := store.PopExpiredOverrides(at)
+			if err != nil {
+				t.Fatalf("Failed to pop expired overrides: %v", err)
+			}
+			ids := []string{}
+			for _, ref := range refs {
+				if ref.ConfigPath != configPath {
+					t.Errorf("Expected refs to %v, got %v", configPath, ref)
+				}
+				ids = append(ids, ref.EntityId)
+			}
+			slices.Sort(ids)
+			return ids
+		}
+		if ids := popIds(now); len(ids) != 0 {
+			t.Errorf("Expected nothing due yet, got %v", ids)
+		}
+		if ids := popIds(now.Add(time.Minute)); !slices.Equal(ids, []string{"1", "beta"}) {
+			t.Errorf("Expected the overrides expiring in a minute, got %v", ids)
+		}
+		if ids := popIds(now.Add(time.Minute)); len(ids) != 0 {
+			t.Errorf("Expected popped overrides to leave the index, got %v", ids)
+		}
+		if ids := popIds(now.Add(2 * time.Hour)); !slices.Equal(ids, []string{"2", "3"}) {
+			t.Errorf("Expected the remaining expiring overrides, got %v", ids)
+		}
+	})
+
+	t.Run("AddConfigClearsOverrides", func(t *testing.T) {
+		store := buildStore(t)
+		store.AddConfig(&Config{
+			ConfigPath:   configPath,
+			Type:         "str",
+			DefaultValue: "value1",
+		})
+		store.AddOverride(&configPath, &Override{OverrideKey: overrideKey, Value: "override1"})
+
+		err := store.AddConfig(&Config{
+			ConfigPath:   configPath,
+			Type:         "str",
+			DefaultValue: "value2",
+		})
+		if err != nil {
+			t.Fatalf("Failed to replace config: %v", err)
+		}
+		config, err := store.GetConfig(&configPath)
+		if err != nil {
+			t.Fatalf("Failed to get config: %v", err)
+		}
+		if config.DefaultValue != "value2" {
+			t.Errorf("Expected defaultValue value2, got %v", config.DefaultValue)
+		}
+		_, found, err := store.GetOverride(&configPath, &overrideKey)
+		if err != nil || found {
+			t.Errorf("Expected replacing the config to clear its overrides, got found: %v, error: %v", found, err)
+		}
+	})
+
+	t.Run("UpdateConfig", func(t *testing.T) {
+		store := buildStore(t)
+		store.AddConfig(&Config{
+			ConfigPath:   configPath,
+			Type:         "str",
+			DefaultValue: "value1",
+		})
+		store.AddOverride(&configPath, &Override{OverrideKey: overrideKey, Value: "override1"})
+
+		err := store.UpdateConfig(&Config{
+			ConfigPath:   configPath,
+			Type:         "str",
+			DefaultValue: "value1",
+			Revision:     2,
+		})
+		if err != nil {
+			t.Fatalf("Failed to update config: %v", err)
+		}
+		config, err := store.GetConfig(&configPath)
+		if err != nil {
+			t.Fatalf("Failed to get config: %v", err)
+		}
+		if config.Revision != 2 {
+			t.Errorf("Expected revision 2, got %v", config.Revision)
+		}
+		_, found, err := store.GetOverride(&configPath, &overrideKey)
+		if err != nil || !found {
+			t.Errorf("Expected UpdateConfig to leave the overrides alone, got error: %v", err)
+		}
+	})
+
+	t.Run("RestoreConfig", func(t *testing.T) {
+		store := buildStore(t)
+		store.AddConfig(&Config{
+			ConfigPath:   configPath,
+			Type:         "str",
+			DefaultValue: "value1",
+		})
+		store.AddOverride(&configPath, &Override{OverrideKey: overrideKey, Value: "override1"})
+
+		otherKey := OverrideKey{EntityType: "group", EntityId: "456"}
+		segmentKey := OverrideKey{EntityType: SegmentEntityType, EntityId: "beta"}
+		err := store.RestoreConfig(&Config{
+			ConfigPath:   configPath,
+			Type:         "str",
+			DefaultValue: "value2",
+			Revision:     3,
+		}, []Override{{OverrideKey: otherKey, Value: "override2"}, {OverrideKey: segmentKey, Value: "override3"}})
+		if err != nil {
+			t.Fatalf("Failed to restore config: %v", err)
+		}
+		config, err := store.GetConfig(&configPath)
+		if err != nil {
+			t.Fatalf("Failed to get config: %v", err)
+		}
+		if config.DefaultValue != "value2" || config.Revision != 3 {
+			t.Errorf("Expected restored config, got %v", config)
+		}
+		overrides, err := store.GetOverrides(&configPath)
+		if err != nil {
+			t.Fatalf("Failed to get overrides: %v", err)
+		}
+		if len(overrides) != 2 {
+			t.Errorf("Expected only the restored overrides, got %v", overrides)
+		}
+		overrides, err = store.GetOverridesOfType(&configPath, SegmentEntityType)
+		if err != nil {
+			t.Fatalf("Failed to get overrides: %v", err)
+		}
+		if len(overrides) != 1 || overrides[0].OverrideKey != segmentKey {
+			t.Errorf("Expected only the restored segment override, got %v", overrides)
+		}
+
+		store.DeleteConfig(&configPath)
+		err = store.RestoreConfig(&Config{
+			ConfigPath:   configPath,
+			Type:         "str",
+			DefaultValue: "value3",
+		}, []Override{})
+		if err != nil {
+			t.Fatalf("Failed to restore deleted config: %v", err)
+		}
+		overrides, err = store.GetOverrides(&configPath)
+		if err != nil || len(overrides) != 0 {
+			t.Errorf("Expected deleted config to be restored without overrides, got %v, error: %v", overrides, err)
+		}
+	})
+
+	t.Run("History", func(t *testing.T) {
+		store := buildStore(t)
+		history, err := store.GetHistory(&configPath)
+		if err != nil {
+			t.Fatalf("Failed to get history: %v", err)
+		}
+		if history == nil || len(history) != 0 {
+			t.Errorf("Expected empty history, but got %v", history)
+		}
+
+		config := Config{
+			ConfigPath:   configPath,
+			Type:         "str",
+			DefaultValue: "value1",
+		}
+		store.AddConfig(&config)
+		entries := []HistoryEntry{
+			{ConfigPath: configPath, Actor: "alice", Action: HistoryActionCreateConfig, NewConfig: &config},
+			{ConfigPath: configPath, Actor: "bob", Action: HistoryActionDeleteConfig, OldConfig: &config},
+		}
+		for _, entry := range entries {
+			err = store.AddHistory(&entry)
+			if err != nil {
+				t.Fatalf("Failed to add history: %v", err)
+			}
+		}
+		store.DeleteConfig(&configPath)
+
+		history, err = store.GetHistory(&configPath)
+		if err != nil {
+			t.Fatalf("Failed to get history: %v", err)
+		}
+		if len(history) != 2 {
+			t.Fatalf("Expected 2 history entries to outlive the config, but got %v", history)
+		}
+		if history[0].Actor != "alice" || history[1].Actor != "bob" {
+			t.Errorf("Expected history oldest first, but got %v", history)
+		}
+		if history[0].NewConfig == nil || history[0].NewConfig.DefaultValue != "value1" {
+			t.Errorf("Expected history to keep the new config, but got %v", history[0].NewConfig)
+		}
+	})
+
+	t.Run("ConcurrentAccess", func(t *testing.T) {
+		store := buildStore(t)
+		store.AddConfig(&Config{
+			ConfigPath:   configPath,
+			Type:         "str",
+			DefaultValue: "value1",
+		})
+
+		const workers = 8
+		const iterations = 50
+		var wg sync.WaitGroup
+		for worker := 0; worker < workers; worker++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < iterations; i++ {
+					key := OverrideKey{EntityType: "user", EntityId: strconv.Itoa(worker*iterations + i)}
+					store.AddOverride(&configPath, &Override{OverrideKey: key, Value: "override"})
+					if i%2 == 0 {
+						store.DeleteOverride(&configPath, &key)
+					}
+					store.AddConfig(&Config{
+						ConfigPath:   ConfigPath{Service: "service2", Name: strconv.Itoa(worker)},
+						Type:         "str",
+						DefaultValue: strconv.Itoa(i),
+					})
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for i := 0; i < iterations; i++ {
+					key := OverrideKey{EntityType: "user", EntityId: strconv.Itoa(i)}
+					store.GetOverride(&configPath, &key)
+					store.GetOverrides(&configPath)
+					store.GetConfig(&configPath)
+					store.GetConfigs()
+				}
+			}()
+		}
+		wg.Wait()
+
+		overrides, err := store.GetOverrides(&configPath)
+		if err != nil {
+			t.Fatalf("Failed to get overrides: %v", err)
+		}
+		if len(overrides) != workers*iterations/2 {
+			t.Errorf("Expected %d overrides, but got %d", workers*iterations/2, len(overrides))
+		}
+		configs, err := store.GetConfigs()
+		if err != nil {
+			t.Fatalf("Failed to get configs: %v", err)
+		}
+		if len(configs) != workers+1 {
+			t.Errorf("Expected %d configs, but got %d", workers+1, len(configs))
+		}
+	})
+}
+
+func TestConfigDbConformance(t *testing.T) {
+	RunConfigStoreConformance(t, func(t *testing.T) ConfigStore {
+		return NewConfigDb(ConfigDbConfig{})
+	})
+}
+
+func TestFileConfigDbConformance(t *testing.T) {
+	RunConfigStoreConformance(t, func(t *testing.T) ConfigStore {
+		db, err := OpenFileConfigDb(ConfigDbConfig{
+			Path: filepath.Join(t.TempDir(), "configs.json"),
+		})
+		if err != nil {
+			t.Fatalf("Failed to open file config db: %v", err)
+		}
+		return db
+	})
+}
+
+func TestRedisConfigDbConformance(t *testing.T) {
+	RunConfigStoreConformance(t, func(t *testing.T) ConfigStore {
+		db, _ := BuildRedisConfigDb(t)
+		return db
+	})
+}
+
+func TestFileConfigDbSurvivesReopen(t *testing.T) {
+	dbConfig := ConfigDbConfig{
+		Path: filepath.Join(t.TempDir(), "configs.json"),
+	}
+	db, err := OpenFileConfigDb(dbConfig)
+	if err != nil {
+		t.Fatalf("Failed to open file config db: %v", err)
+	}
+
+	configPath := ConfigPath{
+		Service: "service1",
+		Name:    "config1",
+	}
+	overrideKey := OverrideKey{EntityType: "user", EntityId: "123"}
+	db.AddConfig(&Config{
+		ConfigPath:   configPath,
+		Type:         "str",
+		DefaultValue: "value1",
+	})
+	db.AddOverride(&configPath, &Override{OverrideKey: overrideKey, Value: "override1"})
+	expiresAt := time.Now().Add(-time.Minute)
+	expiringKey := OverrideKey{EntityType: "user", EntityId: "456"}
+	db.AddOverride(&configPath, &Override{OverrideKey: expiringKey, Value: "override2", ExpiresAt: &expiresAt})
+	db.AddSegment(&Segment{Name: "beta", EntityType: "user", EntityIds: []string{"123"}})
+	db.AddScheduledChange(&ScheduledChange{Id: "launch", ConfigPath: configPath, Action: ScheduledActionSetConfig, ApplyAt: time.Now()})
+
+	reopened, err := OpenFileConfigDb(dbConfig)
+	if err != nil {
+		t.Fatalf("Failed to reopen file config db: %v", err)
+	}
+	config, err := reopened.GetConfig(&configPath)
+	if err != nil {
+		t.Fatalf("Failed to get config after reopen: %v", err)
+	}
+	if config.DefaultValue != "value1" {
+		t.Errorf("Expected defaultValue value1, got %v", config.DefaultValue)
+	}
+	override, found, err := reopened.GetOverride(&configPath, &overrideKey)
+	if err != nil || !found {
+		t.Fatalf("Expected override to survive reopen, got error: %v", err)
+	}
+	if override.Value != "override1" {
+		t.Errorf("Expected override value to be override1, got %v", override.Value)
+	}
+	segment, err := reopened.GetSegment("beta")
+	if err != nil || len(segment.EntityIds) != 1 {
+		t.Errorf("Expected segment to survive reopen, got %v, error: %v", segment, err)
+	}
+	changes, err := reopened.GetScheduledChanges()
+	if err != nil || len(changes) != 1 || changes[0].Id != "launch" {
+		t.Errorf("Expected scheduled change to survive reopen, got %v, error: %v", changes, err)
+	}
+	refs, err := reopened.PopExpiredOverrides(time.Now())
+	if err != nil || len(refs) != 1 || refs[0].OverrideKey != expiringKey {
+		t.Errorf("Expected the replayed override in the expiry index, got %v, error: %v", refs, err)
+	}
+
+	// The index is rebuilt from the snapshot too.
+	err = reopened.Close()
+	if err != nil {
+		t.Fatalf("Failed to close file config db: %v", err)
+	}
+	reopened, err = OpenFileConfigDb(dbConfig)
+	if err != nil {
+		t.Fatalf("Failed to reopen file config db: %v", err)
+	}
+	refs, err = reopened.PopExpiredOverrides(time.Now())
+	if err != nil || len(refs) != 1 || refs[0].OverrideKey != expiringKey {
+		t.Errorf("Expected the snapshotted override in the expiry index, got %v, error: %v", refs, err)
+	}
+}
+
+func TestNewConfigStoreSelectsBackend(t *testing.T) {
+	store, err := NewConfigStore(ConfigDbConfig{Backend: BackendMemory})
+	if err != nil {
+		t.Fatalf("Failed to build memory store: %v", err)
+	}
+	if _, ok := store.(*ConfigDb); !ok {
+		t.Errorf("Expected memory backend to build a ConfigDb, got %T", store)
+	}
+
+	store, err = NewConfigStore(ConfigDbConfig{
+		Backend: BackendFile,
+		Path:    filepath.Join(t.TempDir(), "configs.json"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to build file store: %v", err)
+	}
+	if _, ok := store.(*FileConfigDb); !ok {
+		t.Errorf("Expected file backend to build a FileConfigDb, got %T", store)
+	}
+
+	store, err = NewConfigStore(ConfigDbConfig{Backend: BackendRedis})
+	if err != nil {
+		t.Fatalf("Failed to build redis store: %v", err)
+	}
+	store.Close()
+	if _, ok := store.(*RedisConfigDb); !ok {
+		t.Errorf("Expected redis backend to build a RedisConfigDb, got %T", store)
+	}
+
+	_, err = NewConfigStore(ConfigDbConfig{Backend: "postgres"})
+	if err == nil {
+		t.Errorf("Expected error for unknown backend, got nil")
+	}
+}
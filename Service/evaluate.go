@@ -0,0 +1,101 @@
+package main
+
+import (
+	"time"
+
+	"Service/evaluator"
+
+	"github.com/pkg/errors"
+)
+
+// ToEvaluatorConfig converts config for the evaluator package, without its
+// overrides.
+func ToEvaluatorConfig(config *Config) evaluator.Config {
+	converted := evaluator.Config{
+		Type:         config.Type,
+		DefaultValue: config.DefaultValue,
+		Priority:     config.Priority,
+		Revision:     config.Revision,
+		Rules:        ToEvaluatorRules(config.Rules),
+	}
+	for _, rollout := range config.Rollouts {
+		converted.Rollouts = append(converted.Rollouts, evaluator.Rollout(rollout))
+	}
+	return converted
+}
+
+// storeSource reads the overrides of a config and the segments they target
+// from the store while it is evaluated. Entity overrides are looked up one
+// key at a time, so a request only reads the overrides it could match.
+type storeSource struct {
+	db   ConfigStore
+	path *ConfigPath
+}
+
+func (s *storeSource) Override(key evaluator.OverrideKey) (evaluator.Override, bool, error) {
+	override, found, err := s.db.GetOverride(s.path, (*OverrideKey)(&key))
+	if err != nil {
+		return evaluator.Override{}, false, errors.Wrap(err, "failed to get override from db")
+	}
+	return evaluator.Override{Value: override.Value, ExpiresAt: override.ExpiresAt}, found, nil
+}
+
+func (s *storeSource) SegmentOverrides() (map[string]evaluator.Override, error) {
+	overrides, err := s.db.GetOverridesOfType(s.path, SegmentEntityType)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get overrides from db")
+	}
+	byName := make(map[string]evaluator.Override, len(overrides))
+	for _, override := range overrides {
+		byName[override.EntityId] = evaluator.Override{Value: override.Value, ExpiresAt: override.ExpiresAt}
+	}
+	return byName, nil
+}
+
+func (s *storeSource) Segment(name string) (evaluator.Segment, bool, error) {
+	segment, err := s.db.GetSegment(name)
+	if errors.Is(err, ErrSegmentNotFound) {
+		return evaluator.Segment{}, false, nil
+	}
+	if err != nil {
+		return evaluator.Segment{}, false, errors.Wrap(err, "failed to get segment from db")
+	}
+	return ToEvaluatorSegment(&segment), true, nil
+}
+
+// newEvaluator evaluates config against the overrides and segments in the
+// store, the same way clients evaluate snapshots.
+func (h *Handlers) newEvaluator(config *Config) *evaluator.Evaluator {
+	return &evaluator.Evaluator{
+		Service:         config.Service,
+		Name:            config.Name,
+		Config:          ToEvaluatorConfig(config),
+		DefaultPriority: h.DefaultPriority,
+		Source:          &storeSource{db: h.ConfigDb, path: &config.ConfigPath},
+	}
+}
+
+// NewValueResponse encodes an evaluator result in format.
+func NewValueResponse(result *evaluator.Result, format string) GetConfigValueResponse {
+	return GetConfigValueResponse{
+		Type:            result.Type,
+		Value:           EncodeConfigValue(result.Type, result.Value, format),
+		MatchedOverride: (*OverrideKey)(result.MatchedOverride),
+		MatchedRule:     (*RuleMatch)(result.MatchedRule),
+		MatchedRollout:  (*RolloutMatch)(result.MatchedRollout),
+	}
+}
+
+// EvaluateConfig resolves the value of a config for a request with the
+// given entity attributes.
+func (h *Handlers) EvaluateConfig(path *ConfigPath, attributes map[string]string, format string) (GetConfigValueResponse, error) {
+	config, err := h.ConfigDb.GetConfig(path)
+	if err != nil {
+		return GetConfigValueResponse{}, errors.Wrap(err, "failed to get config from db")
+	}
+	result, err := h.newEvaluator(&config).Evaluate(attributes, time.Now())
+	if err != nil {
+		return GetConfigValueResponse{}, err
+	}
+	return NewValueResponse(&result, format), nil
+}
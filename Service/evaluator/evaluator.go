@@ -0,0 +1,417 @@
+// Package evaluator resolves config values for ConfigService. The service
+// evaluates every request with it, and clients can use it to evaluate
+// locally against a snapshot downloaded from /services/{service}/snapshot
+// instead of calling the service for every value.
+package evaluator
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FormatVersion is the snapshot layout produced by the service. It changes
+// whenever the layout does, Parse rejects snapshots from newer versions.
+// Version 2 added rollouts, version 3 rules, version 4 segments, version 5
+// made the revision a string and version 6 added override expiry.
+const FormatVersion = 6
+
+// BucketCount is the number of buckets entities are hashed into for
+// rollouts, so percentages are honoured to a hundredth of a percent.
+const BucketCount = 10000
+
+var ErrConfigNotFound = errors.New("config not found")
+
+// Snapshot is every config of one service with its overrides. Values are
+// kept in the service's string form, Result.Type says how to read them.
+type Snapshot struct {
+	FormatVersion int    `json:"formatVersion"`
+	Service       string `json:"service"`
+	// Revision is the service revision the snapshot was taken at, the same
+	// cursor returned by the long-poll endpoint.
+	Revision string `json:"revision"`
+	// DefaultPriority is the service wide entity type precedence for configs
+	// without their own.
+	DefaultPriority []string          `json:"defaultPriority"`
+	Configs         map[string]Config `json:"configs"`
+	// Segments holds every segment the configs' overrides target, by name.
+	Segments map[string]Segment `json:"segments,omitempty"`
+}
+
+type Config struct {
+	Type         string   `json:"type"`
+	DefaultValue string   `json:"defaultValue"`
+	Priority     []string `json:"priority,omitempty"`
+	Revision     int64    `json:"revision"`
+	// Overrides maps entity type to entity id to override. Overrides of
+	// segments are under SegmentEntityType.
+	Overrides map[string]map[string]Override `json:"overrides,omitempty"`
+	// Rules apply to requests no override matched, Rollouts to those no
+	// rule matched either.
+	Rules    []Rule    `json:"rules,omitempty"`
+	Rollouts []Rollout `json:"rollouts,omitempty"`
+}
+
+// Rollout gives Value to Percentage of the entities of EntityType. Entities
+// are bucketed by a hash of the config path and entity id, so an entity in
+// the rollout stays in it as the percentage grows.
+type Rollout struct {
+	EntityType string  `json:"entityType"`
+	Percentage float64 `json:"percentage"`
+	Value      string  `json:"value"`
+}
+
+// RolloutMatch identifies the rollout that produced a value and the bucket
+// the entity fell in.
+type RolloutMatch struct {
+	Index      int    `json:"index"`
+	EntityType string `json:"entityType"`
+	EntityId   string `json:"entityId"`
+	Bucket     int    `json:"bucket"`
+}
+
+// Override is written as just its value unless it expires, as overrides
+// were before version 6.
+type Override struct {
+	Value string
+	// ExpiresAt is when the override stops applying, it may expire while
+	// the snapshot is in use.
+	ExpiresAt *time.Time
+}
+
+type expiringOverride struct {
+	Value     string     `json:"value"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+func (o Override) MarshalJSON() ([]byte, error) {
+	if o.ExpiresAt == nil {
+		return json.Marshal(o.Value)
+	}
+	return json.Marshal(expiringOverride(o))
+}
+
+func (o *Override) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*o = Override{}
+		return json.Unmarshal(data, &o.Value)
+	}
+	var override expiringOverride
+	err := json.Unmarshal(data, &override)
+	if err != nil {
+		return err
+	}
+	*o = Override(override)
+	return nil
+}
+
+// Expired reports whether the override no longer applies at now.
+func (o *Override) Expired(now time.Time) bool {
+	return o.ExpiresAt != nil && !o.ExpiresAt.After(now)
+}
+
+type OverrideKey struct {
+	EntityType string `json:"entityType"`
+	EntityId   string `json:"entityId"`
+}
+
+type Result struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	// MatchedOverride is the override that produced Value, or nil when the
+	// default value was used.
+	MatchedOverride *OverrideKey `json:"matchedOverride,omitempty"`
+	// MatchedRule is set instead when a rule produced Value.
+	MatchedRule *RuleMatch `json:"matchedRule,omitempty"`
+	// MatchedRollout is set instead when a rollout produced Value.
+	MatchedRollout *RolloutMatch `json:"matchedRollout,omitempty"`
+}
+
+// Parse decodes a snapshot downloaded from the service.
+func Parse(data []byte) (*Snapshot, error) {
+	var snapshot struct {
+		Snapshot
+		// Before version 5 the revision was a number that started over when
+		// the service restarted, it can't be polled from and is dropped.
+		Revision json.RawMessage `json:"revision"`
+	}
+	err := json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode snapshot")
+	}
+	if snapshot.FormatVersion > FormatVersion {
+		return nil, errors.Errorf("snapshot format version %d is newer than the supported %d", snapshot.FormatVersion, FormatVersion)
+	}
+	if snapshot.FormatVersion >= 5 && snapshot.Revision != nil {
+		err = json.Unmarshal(snapshot.Revision, &snapshot.Snapshot.Revision)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode snapshot revision")
+		}
+	}
+	return &snapshot.Snapshot, nil
+}
+
+// Evaluate resolves the value of the named config for a request with the
+// given entity attributes.
+func (s *Snapshot) Evaluate(name string, attributes map[string]string) (Result, error) {
+	return s.EvaluateAt(name, attributes, time.Now())
+}
+
+// EvaluateAt is Evaluate with overrides expired at now skipped.
+func (s *Snapshot) EvaluateAt(name string, attributes map[string]string, now time.Time) (Result, error) {
+	evaluator, err := s.Evaluator(name)
+	if err != nil {
+		return Result{}, err
+	}
+	return evaluator.Evaluate(attributes, now)
+}
+
+// Evaluator returns an Evaluator for the named config reading its
+// overrides and segments from the snapshot.
+func (s *Snapshot) Evaluator(name string) (*Evaluator, error) {
+	config, found := s.Configs[name]
+	if !found {
+		return nil, ErrConfigNotFound
+	}
+	return &Evaluator{
+		Service:         s.Service,
+		Name:            name,
+		Config:          config,
+		DefaultPriority: s.DefaultPriority,
+		Source:          &snapshotSource{overrides: config.Overrides, segments: s.Segments},
+	}, nil
+}
+
+// Source gives an Evaluator the overrides of its config and the segments
+// they target. The service reads them from its store, only asking for the
+// overrides a request can match.
+type Source interface {
+	// Override returns the config's override for key, or false when it has
+	// none.
+	Override(key OverrideKey) (Override, bool, error)
+	// SegmentOverrides returns the config's overrides targeting segments by
+	// segment name.
+	SegmentOverrides() (map[string]Override, error)
+	// Segment returns the named segment, or false when it doesn't exist.
+	Segment(name string) (Segment, bool, error)
+}
+
+type snapshotSource struct {
+	overrides map[string]map[string]Override
+	segments  map[string]Segment
+}
+
+func (s *snapshotSource) Override(key OverrideKey) (Override, bool, error) {
+	override, found := s.overrides[key.EntityType][key.EntityId]
+	return override, found, nil
+}
+
+func (s *snapshotSource) SegmentOverrides() (map[string]Override, error) {
+	return s.overrides[SegmentEntityType], nil
+}
+
+func (s *snapshotSource) Segment(name string) (Segment, bool, error) {
+	segment, found := s.segments[name]
+	return segment, found, nil
+}
+
+// Evaluator resolves the value of one config. Precedence lives here alone
+// so the service, snapshots and clients can't disagree: the first entity
+// override in priority order, then the first segment override by segment
+// name whose segment has the request, then the first matching rule by
+// priority, then the first rollout including the request's entity and
+// finally the default value. Overrides expired at the time of evaluation
+// are skipped.
+type Evaluator struct {
+	Service string
+	Name    string
+	// Config.Overrides is ignored, overrides come from Source.
+	Config Config
+	// DefaultPriority is the entity type precedence when Config has none.
+	DefaultPriority []string
+	Source          Source
+}
+
+// Evaluate resolves the config's value for a request with the given entity
+// attributes at now.
+func (e *Evaluator) Evaluate(attributes map[string]string, now time.Time) (Result, error) {
+	return e.evaluate(attributes, now, nil)
+}
+
+// Explain resolves the value like Evaluate and lists everything that was
+// considered along the way.
+func (e *Evaluator) Explain(attributes map[string]string, now time.Time) (Result, Explanation, error) {
+	priority := GetEntityPriority(e.Config.Priority, e.DefaultPriority)
+	explanation := Explanation{
+		Priority:  append([]string{}, priority...),
+		Overrides: []OverrideCandidate{},
+		Rules:     []RuleCandidate{},
+		Rollouts:  []RolloutCandidate{},
+	}
+	result, err := e.evaluate(attributes, now, &explanation)
+	if err != nil {
+		return Result{}, Explanation{}, err
+	}
+	return result, explanation, nil
+}
+
+// evaluate stops at the first match unless explanation is set, then it
+// goes on to fill in every candidate.
+func (e *Evaluator) evaluate(attributes map[string]string, now time.Time, explanation *Explanation) (Result, error) {
+	config := &e.Config
+	var result *Result
+
+	priority := GetEntityPriority(config.Priority, e.DefaultPriority)
+	for _, key := range OrderOverrideKeys(attributes, priority) {
+		if key.EntityType == SegmentEntityType {
+			// Segment membership isn't given as an attribute.
+			continue
+		}
+		override, found, err := e.Source.Override(key)
+		if err != nil {
+			return Result{}, err
+		}
+		matched := found && !override.Expired(now)
+		if matched && result == nil {
+			result = &Result{Type: config.Type, Value: override.Value, MatchedOverride: &key}
+			if explanation == nil {
+				return *result, nil
+			}
+		}
+		if explanation != nil {
+			explanation.Overrides = append(explanation.Overrides, newOverrideCandidate(key, override, found, matched))
+		}
+	}
+
+	segmentOverrides, err := e.Source.SegmentOverrides()
+	if err != nil {
+		return Result{}, err
+	}
+	names := slices.Sorted(maps.Keys(segmentOverrides))
+	for _, name := range names {
+		override := segmentOverrides[name]
+		matched := false
+		if !override.Expired(now) {
+			segment, found, err := e.Source.Segment(name)
+			if err != nil {
+				return Result{}, err
+			}
+			matched = found && segment.Contains(attributes)
+		}
+		key := OverrideKey{EntityType: SegmentEntityType, EntityId: name}
+		if matched && result == nil {
+			result = &Result{Type: config.Type, Value: override.Value, MatchedOverride: &key}
+			if explanation == nil {
+				return *result, nil
+			}
+		}
+		if explanation != nil {
+			explanation.Overrides = append(explanation.Overrides, newOverrideCandidate(key, override, true, matched))
+		}
+	}
+
+	for _, i := range OrderRules(config.Rules) {
+		rule := config.Rules[i]
+		matched := rule.Condition.Evaluate(attributes)
+		if matched && result == nil {
+			result = &Result{Type: config.Type, Value: rule.Value, MatchedRule: &RuleMatch{Index: i, Name: rule.Name}}
+			if explanation == nil {
+				return *result, nil
+			}
+		}
+		if explanation != nil {
+			explanation.Rules = append(explanation.Rules, RuleCandidate{
+				Index:    i,
+				Name:     rule.Name,
+				Priority: rule.Priority,
+				Value:    rule.Value,
+				Matched:  matched,
+			})
+		}
+	}
+
+	for i, rollout := range config.Rollouts {
+		candidate := RolloutCandidate{
+			Index:      i,
+			EntityType: rollout.EntityType,
+			Percentage: rollout.Percentage,
+			Value:      rollout.Value,
+		}
+		if entityId, found := attributes[rollout.EntityType]; found {
+			bucket := Bucket(e.Service, e.Name, entityId)
+			candidate.EntityId = entityId
+			candidate.Bucket = &bucket
+			candidate.Matched = rollout.Includes(bucket)
+		}
+		if candidate.Matched && result == nil {
+			result = &Result{Type: config.Type, Value: rollout.Value, MatchedRollout: &RolloutMatch{
+				Index:      i,
+				EntityType: rollout.EntityType,
+				EntityId:   candidate.EntityId,
+				Bucket:     *candidate.Bucket,
+			}}
+			if explanation == nil {
+				return *result, nil
+			}
+		}
+		if explanation != nil {
+			explanation.Rollouts = append(explanation.Rollouts, candidate)
+		}
+	}
+
+	if result == nil {
+		result = &Result{Type: config.Type, Value: config.DefaultValue}
+	}
+	return *result, nil
+}
+
+// Bucket hashes an entity into one of BucketCount buckets for the rollouts
+// of a config.
+func Bucket(service string, name string, entityId string) int {
+	sum := sha256.Sum256([]byte(service + "/" + name + "/" + entityId))
+	return int(binary.BigEndian.Uint64(sum[:8]) % BucketCount)
+}
+
+// Includes reports whether the rollout's percentage covers entities in
+// bucket.
+func (r *Rollout) Includes(bucket int) bool {
+	return float64(bucket) < r.Percentage*BucketCount/100
+}
+
+// OrderOverrideKeys returns the override keys to try for a set of request
+// attributes, most important first. Entity types listed in priority come
+// first in that order, any remaining attributes follow sorted by entity type
+// so evaluation never depends on map iteration order.
+func OrderOverrideKeys(attributes map[string]string, priority []string) []OverrideKey {
+	keys := make([]OverrideKey, 0, len(attributes))
+	for _, entityType := range priority {
+		if entityId, found := attributes[entityType]; found {
+			keys = append(keys, OverrideKey{EntityType: entityType, EntityId: entityId})
+		}
+	}
+
+	remaining := []string{}
+	for entityType := range attributes {
+		if !slices.Contains(priority, entityType) {
+			remaining = append(remaining, entityType)
+		}
+	}
+	slices.Sort(remaining)
+	for _, entityType := range remaining {
+		keys = append(keys, OverrideKey{EntityType: entityType, EntityId: attributes[entityType]})
+	}
+	return keys
+}
+
+// GetEntityPriority returns the priority list that applies to a config.
+func GetEntityPriority(configPriority []string, defaultPriority []string) []string {
+	if len(configPriority) > 0 {
+		return configPriority
+	}
+	return defaultPriority
+}
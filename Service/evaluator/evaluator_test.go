@@ -0,0 +1,264 @@
+package evaluator
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"Service/evaluator/evaluatortest"
+
+	"github.com/pkg/errors"
+)
+
+func BuildSnapshot(t *testing.T, corpusCase *evaluatortest.Case) *Snapshot {
+	snapshot := &Snapshot{
+		FormatVersion:   FormatVersion,
+		Service:         evaluatortest.Service,
+		DefaultPriority: corpusCase.DefaultPriority,
+		Configs:         make(map[string]Config),
+		Segments:        make(map[string]Segment),
+	}
+	for _, corpusSegment := range corpusCase.Segments {
+		segment := Segment{EntityType: corpusSegment.EntityType, EntityIds: corpusSegment.EntityIds}
+		if corpusSegment.Condition != nil {
+			segment.Condition = &Condition{}
+			err := json.Unmarshal(corpusSegment.Condition, segment.Condition)
+			if err != nil {
+				t.Fatalf("Failed to decode condition of segment %s: %v", corpusSegment.Name, err)
+			}
+		}
+		snapshot.Segments[corpusSegment.Name] = segment
+	}
+	for _, corpusConfig := range corpusCase.Configs {
+		config := Config{
+			Type:         corpusConfig.Type,
+			DefaultValue: corpusConfig.DefaultValue,
+			Priority:     corpusConfig.Priority,
+			Overrides:    make(map[string]map[string]Override),
+		}
+		for _, override := range corpusConfig.Overrides {
+			if config.Overrides[override.EntityType] == nil {
+				config.Overrides[override.EntityType] = make(map[string]Override)
+			}
+			config.Overrides[override.EntityType][override.EntityId] = Override{Value: override.Value, ExpiresAt: override.ExpiresAt}
+		}
+		for _, corpusRule := range corpusConfig.Rules {
+			rule := Rule{Name: corpusRule.Name, Priority: corpusRule.Priority, Value: corpusRule.Value}
+			err := json.Unmarshal(corpusRule.Condition, &rule.Condition)
+			if err != nil {
+				t.Fatalf("Failed to decode condition of %s: %v", corpusConfig.Name, err)
+			}
+			config.Rules = append(config.Rules, rule)
+		}
+		for _, rollout := range corpusConfig.Rollouts {
+			config.Rollouts = append(config.Rollouts, Rollout(rollout))
+		}
+		snapshot.Configs[corpusConfig.Name] = config
+	}
+	return snapshot
+}
+
+func TestEvaluateCorpus(t *testing.T) {
+	corpus, err := evaluatortest.LoadCorpus()
+	if err != nil {
+		t.Fatalf("Failed to load corpus: %v", err)
+	}
+	for _, corpusCase := range corpus {
+		t.Run(corpusCase.Name, func(t *testing.T) {
+			snapshot := BuildSnapshot(t, &corpusCase)
+			for _, evaluation := range corpusCase.Evaluations {
+				result, err := snapshot.Evaluate(evaluation.Config, evaluation.Attributes)
+				if evaluation.NotFound {
+					if !errors.Is(err, ErrConfigNotFound) {
+						t.Errorf("Expected %s to be missing, but got %v, error: %v", evaluation.Config, result, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("Failed to evaluate %s: %v", evaluation.Config, err)
+				}
+				if result.Value != evaluation.Value {
+					t.Errorf("Expected %s with %v to be %q, but got %q", evaluation.Config, evaluation.Attributes, evaluation.Value, result.Value)
+				}
+				if !MatchesKey(result.MatchedOverride, evaluation.MatchedOverride) {
+					t.Errorf("Expected %s with %v to match %v, but got %v", evaluation.Config, evaluation.Attributes, evaluation.MatchedOverride, result.MatchedOverride)
+				}
+				if !MatchesRule(result.MatchedRule, evaluation.MatchedRule) {
+					t.Errorf("Expected %s with %v to match rule %v, but got %v", evaluation.Config, evaluation.Attributes, evaluation.MatchedRule, result.MatchedRule)
+				}
+				if !MatchesRollout(result.MatchedRollout, evaluation.MatchedRollout) {
+					t.Errorf("Expected %s with %v to match rollout %v, but got %v", evaluation.Config, evaluation.Attributes, evaluation.MatchedRollout, result.MatchedRollout)
+				}
+
+				evaluator, err := snapshot.Evaluator(evaluation.Config)
+				if err != nil {
+					t.Fatalf("Failed to get evaluator of %s: %v", evaluation.Config, err)
+				}
+				explained, explanation, err := evaluator.Explain(evaluation.Attributes, time.Now())
+				if err != nil {
+					t.Fatalf("Failed to explain %s: %v", evaluation.Config, err)
+				}
+				if !reflect.DeepEqual(explained, result) {
+					t.Errorf("Expected explaining %s with %v to give %v, but got %v", evaluation.Config, evaluation.Attributes, result, explained)
+				}
+				if len(explanation.Rules) != len(snapshot.Configs[evaluation.Config].Rules) || len(explanation.Rollouts) != len(snapshot.Configs[evaluation.Config].Rollouts) {
+					t.Errorf("Expected the explanation of %s to list every rule and rollout, got %+v", evaluation.Config, explanation)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRejectsNewerFormat(t *testing.T) {
+	_, err := Parse([]byte(`{"formatVersion": ` + strconv.Itoa(FormatVersion+1) + `, "configs": {}}`))
+	if err == nil {
+		t.Errorf("Expected a newer format version to be rejected")
+	}
+	snapshot, err := Parse([]byte(`{"formatVersion": 1, "service": "service1", "revision": 7,
+		"configs": {"flag": {"type": "bool", "defaultValue": "true", "overrides": {"user": {"1": "false"}}}}}`))
+	if err != nil {
+		t.Fatalf("Failed to parse snapshot: %v", err)
+	}
+	if snapshot.Revision != "" {
+		t.Errorf("Expected the numeric revision of an old snapshot to be dropped, but got %v", snapshot.Revision)
+	}
+	result, err := snapshot.Evaluate("flag", nil)
+	if err != nil || result.Value != "true" {
+		t.Errorf("Expected flag to be true, but got %v, error: %v", result, err)
+	}
+	result, err = snapshot.Evaluate("flag", map[string]string{"user": "1"})
+	if err != nil || result.Value != "false" {
+		t.Errorf("Expected an override given as just its value to apply, but got %v, error: %v", result, err)
+	}
+}
+
+func MatchesKey(actual *OverrideKey, expected *evaluatortest.OverrideKey) bool {
+	if actual == nil || expected == nil {
+		return actual == nil && expected == nil
+	}
+	return actual.EntityType == expected.EntityType && actual.EntityId == expected.EntityId
+}
+
+func MatchesRollout(actual *RolloutMatch, expected *int) bool {
+	if actual == nil || expected == nil {
+		return actual == nil && expected == nil
+	}
+	return actual.Index == *expected
+}
+
+func MatchesRule(actual *RuleMatch, expected *int) bool {
+	if actual == nil || expected == nil {
+		return actual == nil && expected == nil
+	}
+	return actual.Index == *expected
+}
+
+func TestRolloutBuckets(t *testing.T) {
+	matchRollout := func(rollouts []Rollout, userId string) *RolloutMatch {
+		evaluator := Evaluator{
+			Service: "service1",
+			Name:    "launch",
+			Config:  Config{Type: "bool", DefaultValue: "false", Rollouts: rollouts},
+			Source:  &snapshotSource{},
+		}
+		result, err := evaluator.Evaluate(map[string]string{"user": userId}, time.Now())
+		if err != nil {
+			t.Fatalf("Failed to evaluate: %v", err)
+		}
+		return result.MatchedRollout
+	}
+
+	// Roughly the rollout's percentage of entities fall in it.
+	rollouts := []Rollout{{EntityType: "user", Percentage: 30, Value: "true"}}
+	included := 0
+	for i := 0; i < 10000; i++ {
+		if matchRollout(rollouts, strconv.Itoa(i)) != nil {
+			included++
+		}
+	}
+	if included < 2800 || included > 3200 {
+		t.Errorf("Expected about 3000 of 10000 users in a 30%% rollout, but got %d", included)
+	}
+
+	// Buckets depend on the config, so the same users aren't in every
+	// rollout.
+	if Bucket("service1", "launch", "1") == Bucket("service1", "other", "1") &&
+		Bucket("service1", "launch", "2") == Bucket("service1", "other", "2") {
+		t.Errorf("Expected buckets to differ between configs")
+	}
+	if match := matchRollout([]Rollout{{EntityType: "user", Percentage: 0, Value: "true"}}, "1"); match != nil {
+		t.Errorf("Expected a 0%% rollout to include nobody, but got %v", match)
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	// Ordered as in the semver.org precedence example.
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "v1.0.1", "1.2", "1.10.0", "2.0.0+build.5"}
+	for i := range ordered {
+		for j := range ordered {
+			a, err := ParseVersion(ordered[i])
+			if err != nil {
+				t.Fatalf("Failed to parse %s: %v", ordered[i], err)
+			}
+			b, err := ParseVersion(ordered[j])
+			if err != nil {
+				t.Fatalf("Failed to parse %s: %v", ordered[j], err)
+			}
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			if comparison := a.Compare(b); comparison != expected {
+				t.Errorf("Expected %s compared to %s to be %d, but got %d", ordered[i], ordered[j], expected, comparison)
+			}
+		}
+	}
+
+	for _, invalid := range []string{"", "1.2.3.4", "1.x", "1.0.0-", "1.0.0-a..b", "-1.0"} {
+		_, err := ParseVersion(invalid)
+		if err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestConditionValidate(t *testing.T) {
+	valid := []Condition{
+		{Attribute: "country", Operator: OperatorIn, Values: []string{"US", "CA"}},
+		{Any: []Condition{{Attribute: "age", Operator: OperatorBetween, Values: []string{"13", "17"}}}},
+		{All: []Condition{{Attribute: "appVersion", Operator: OperatorSemverLt, Values: []string{"2.0.0"}}}},
+	}
+	for _, condition := range valid {
+		err := condition.Validate()
+		if err != nil {
+			t.Errorf("Expected %s to be valid, but got %v", condition.String(), err)
+		}
+	}
+
+	invalid := []Condition{
+		{},
+		{Attribute: "country"},
+		{Attribute: "country", Operator: "like", Values: []string{"US"}},
+		{Attribute: "country", Operator: OperatorEquals, Values: []string{"US", "CA"}},
+		{Attribute: "country", Operator: OperatorIn},
+		{Attribute: "email", Operator: OperatorMatches, Values: []string{"("}},
+		{Attribute: "appVersion", Operator: OperatorSemverGt, Values: []string{"latest"}},
+		{Attribute: "age", Operator: OperatorGt, Values: []string{"old"}},
+		{Attribute: "age", Operator: OperatorBetween, Values: []string{"17", "13"}},
+		{All: []Condition{{Attribute: "age"}}},
+		{All: []Condition{{Attribute: "a", Operator: OperatorEquals, Values: []string{"1"}}}, Attribute: "b"},
+		{All: []Condition{{Attribute: "a", Operator: OperatorEquals, Values: []string{"1"}}},
+			Any: []Condition{{Attribute: "b", Operator: OperatorEquals, Values: []string{"1"}}}},
+	}
+	for _, condition := range invalid {
+		err := condition.Validate()
+		if err == nil {
+			t.Errorf("Expected %s to be invalid", condition.String())
+		}
+	}
+}
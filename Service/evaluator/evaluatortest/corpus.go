@@ -0,0 +1,100 @@
+// Package evaluatortest loads the evaluation corpus in evaluator/testdata.
+// The evaluator and the service both run every case in it so local
+// evaluation can't drift from the service.
+package evaluatortest
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Service is the service every corpus config belongs to.
+const Service = "service1"
+
+type OverrideKey struct {
+	EntityType string `json:"entityType"`
+	EntityId   string `json:"entityId"`
+}
+
+type Override struct {
+	OverrideKey
+	Value     string     `json:"value"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type Rollout struct {
+	EntityType string  `json:"entityType"`
+	Percentage float64 `json:"percentage"`
+	Value      string  `json:"value"`
+}
+
+// Rule keeps its condition undecoded so it can be read into the evaluator's
+// types without this package importing it.
+type Rule struct {
+	Name      string          `json:"name"`
+	Priority  int             `json:"priority"`
+	Condition json.RawMessage `json:"condition"`
+	Value     string          `json:"value"`
+}
+
+// Segment keeps its condition undecoded like Rule, it is null when the
+// segment only lists entities.
+type Segment struct {
+	Name       string          `json:"name"`
+	EntityType string          `json:"entityType"`
+	EntityIds  []string        `json:"entityIds"`
+	Condition  json.RawMessage `json:"condition"`
+}
+
+type Config struct {
+	Name         string     `json:"name"`
+	Type         string     `json:"type"`
+	DefaultValue string     `json:"defaultValue"`
+	Priority     []string   `json:"priority"`
+	Overrides    []Override `json:"overrides"`
+	Rules        []Rule     `json:"rules"`
+	Rollouts     []Rollout  `json:"rollouts"`
+}
+
+// Evaluation is the expected result of evaluating Config with Attributes.
+type Evaluation struct {
+	Config          string            `json:"config"`
+	Attributes      map[string]string `json:"attributes"`
+	Value           string            `json:"value"`
+	MatchedOverride *OverrideKey      `json:"matchedOverride"`
+	// MatchedRule and MatchedRollout are the indexes of the rule or rollout
+	// expected to match.
+	MatchedRule    *int `json:"matchedRule"`
+	MatchedRollout *int `json:"matchedRollout"`
+	NotFound       bool `json:"notFound"`
+}
+
+type Case struct {
+	Name            string       `json:"name"`
+	DefaultPriority []string     `json:"defaultPriority"`
+	Segments        []Segment    `json:"segments"`
+	Configs         []Config     `json:"configs"`
+	Evaluations     []Evaluation `json:"evaluations"`
+}
+
+// LoadCorpus reads the corpus, it can be called from tests in any package.
+func LoadCorpus() ([]Case, error) {
+	_, file, _, _ := runtime.Caller(0)
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(file), "..", "testdata", "corpus.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read corpus")
+	}
+	var corpus struct {
+		Cases []Case `json:"cases"`
+	}
+	err = json.Unmarshal(data, &corpus)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode corpus")
+	}
+	return corpus.Cases, nil
+}
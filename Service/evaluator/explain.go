@@ -0,0 +1,60 @@
+package evaluator
+
+import "time"
+
+// Explanation is everything an Evaluator considered while resolving a
+// value, including what lost to the match.
+type Explanation struct {
+	// Priority is the entity type precedence the overrides were tried in.
+	Priority []string `json:"priority"`
+	// Overrides lists the entity overrides in the order they are tried,
+	// then the config's segment overrides by segment name.
+	Overrides []OverrideCandidate `json:"overrides"`
+	// Rules lists the config's rules in the order they are tried.
+	Rules    []RuleCandidate    `json:"rules"`
+	Rollouts []RolloutCandidate `json:"rollouts"`
+}
+
+// OverrideCandidate is an override key checked for a request. Found is set
+// when the config has an override for it, Matched when that override
+// applies: it hasn't expired and, for a segment, the request is in it.
+type OverrideCandidate struct {
+	OverrideKey
+	Found     bool       `json:"found"`
+	Value     string     `json:"value,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Matched   bool       `json:"matched"`
+}
+
+type RuleCandidate struct {
+	Index    int    `json:"index"`
+	Name     string `json:"name,omitempty"`
+	Priority int    `json:"priority"`
+	Value    string `json:"value"`
+	Matched  bool   `json:"matched"`
+}
+
+// RolloutCandidate is one of a config's rollouts. EntityId and Bucket are
+// set when the request has an entity of EntityType.
+type RolloutCandidate struct {
+	Index      int     `json:"index"`
+	EntityType string  `json:"entityType"`
+	Percentage float64 `json:"percentage"`
+	Value      string  `json:"value"`
+	EntityId   string  `json:"entityId,omitempty"`
+	Bucket     *int    `json:"bucket,omitempty"`
+	Matched    bool    `json:"matched"`
+}
+
+func newOverrideCandidate(key OverrideKey, override Override, found bool, matched bool) OverrideCandidate {
+	candidate := OverrideCandidate{
+		OverrideKey: key,
+		Found:       found,
+		Matched:     matched,
+	}
+	if found {
+		candidate.Value = override.Value
+		candidate.ExpiresAt = override.ExpiresAt
+	}
+	return candidate
+}
@@ -0,0 +1,323 @@
+package evaluator
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Condition operators compare a request attribute with Values.
+const (
+	// OperatorEquals matches when the attribute equals the single value.
+	OperatorEquals = "equals"
+	// OperatorIn matches when the attribute equals any of the values.
+	OperatorIn = "in"
+	// OperatorMatches matches when the attribute contains a match of the
+	// regular expression in the single value, anchor it to match all of it.
+	OperatorMatches = "matches"
+
+	// The semver operators compare the attribute as a semantic version, such
+	// as 1.4.0-beta.1, with the single value.
+	OperatorSemverEq  = "semverEq"
+	OperatorSemverGt  = "semverGt"
+	OperatorSemverGte = "semverGte"
+	OperatorSemverLt  = "semverLt"
+	OperatorSemverLte = "semverLte"
+
+	// The numeric operators compare the attribute as a number with the
+	// single value, or with the inclusive range of two values for between.
+	OperatorGt      = "gt"
+	OperatorGte     = "gte"
+	OperatorLt      = "lt"
+	OperatorLte     = "lte"
+	OperatorBetween = "between"
+)
+
+// Rule gives Value to requests whose attributes satisfy Condition. Rules are
+// tried from the highest Priority down, rules of equal priority in the order
+// they are listed.
+type Rule struct {
+	// Name optionally identifies the rule in responses.
+	Name      string    `json:"name,omitempty"`
+	Priority  int       `json:"priority"`
+	Condition Condition `json:"condition"`
+	Value     string    `json:"value"`
+}
+
+// Condition is either a comparison of one attribute using Operator and
+// Values, or a combination of nested conditions that all (All) or any (Any)
+// of must hold. A comparison against an attribute the request doesn't have,
+// or can't be read as a number or version, doesn't hold.
+type Condition struct {
+	All       []Condition `json:"all,omitempty" yaml:"all,omitempty"`
+	Any       []Condition `json:"any,omitempty" yaml:"any,omitempty"`
+	Attribute string      `json:"attribute,omitempty" yaml:"attribute,omitempty"`
+	Operator  string      `json:"operator,omitempty" yaml:"operator,omitempty"`
+	Values    []string    `json:"values,omitempty" yaml:"values,omitempty"`
+}
+
+// RuleMatch identifies the rule that produced a value by its index in the
+// config's rules.
+type RuleMatch struct {
+	Index int    `json:"index"`
+	Name  string `json:"name,omitempty"`
+}
+
+// OrderRules returns the indexes of rules in the order they are tried.
+func OrderRules(rules []Rule) []int {
+	order := make([]int, len(rules))
+	for i := range rules {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(rules[b].Priority, rules[a].Priority)
+	})
+	return order
+}
+
+// Evaluate reports whether the condition holds for a request's attributes.
+func (c *Condition) Evaluate(attributes map[string]string) bool {
+	switch {
+	case len(c.All) > 0:
+		for i := range c.All {
+			if !c.All[i].Evaluate(attributes) {
+				return false
+			}
+		}
+		return true
+	case len(c.Any) > 0:
+		for i := range c.Any {
+			if c.Any[i].Evaluate(attributes) {
+				return true
+			}
+		}
+		return false
+	}
+
+	value, found := attributes[c.Attribute]
+	if !found || len(c.Values) == 0 {
+		return false
+	}
+	switch c.Operator {
+	case OperatorEquals:
+		return value == c.Values[0]
+	case OperatorIn:
+		return slices.Contains(c.Values, value)
+	case OperatorMatches:
+		pattern, err := compilePattern(c.Values[0])
+		return err == nil && pattern.MatchString(value)
+	case OperatorSemverEq, OperatorSemverGt, OperatorSemverGte, OperatorSemverLt, OperatorSemverLte:
+		version, err := ParseVersion(value)
+		if err != nil {
+			return false
+		}
+		other, err := ParseVersion(c.Values[0])
+		if err != nil {
+			return false
+		}
+		return compareHolds(c.Operator, version.Compare(other))
+	case OperatorGt, OperatorGte, OperatorLt, OperatorLte, OperatorBetween:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		bounds, err := parseNumbers(c.Values)
+		if err != nil {
+			return false
+		}
+		if c.Operator == OperatorBetween {
+			return len(bounds) == 2 && number >= bounds[0] && number <= bounds[1]
+		}
+		return compareHolds(c.Operator, compareNumbers(number, bounds[0]))
+	default:
+		return false
+	}
+}
+
+// Validate checks the condition is well formed, so that a rule can't be
+// saved that silently never matches.
+func (c *Condition) Validate() error {
+	combinations := 0
+	if len(c.All) > 0 {
+		combinations++
+	}
+	if len(c.Any) > 0 {
+		combinations++
+	}
+	if combinations > 0 {
+		if combinations > 1 || c.Attribute != "" || c.Operator != "" || len(c.Values) > 0 {
+			return errors.New("a condition must combine conditions with all or any, or compare an attribute, not both")
+		}
+		for i := range c.All {
+			err := c.All[i].Validate()
+			if err != nil {
+				return errors.Wrapf(err, "all %d", i)
+			}
+		}
+		for i := range c.Any {
+			err := c.Any[i].Validate()
+			if err != nil {
+				return errors.Wrapf(err, "any %d", i)
+			}
+		}
+		return nil
+	}
+
+	if c.Attribute == "" {
+		return errors.New("a condition needs an attribute, all or any")
+	}
+	switch c.Operator {
+	case OperatorEquals:
+		return expectValues(c, 1)
+	case OperatorIn:
+		if len(c.Values) == 0 {
+			return errors.Errorf("operator %s needs at least one value", c.Operator)
+		}
+		return nil
+	case OperatorMatches:
+		err := expectValues(c, 1)
+		if err != nil {
+			return err
+		}
+		_, err = compilePattern(c.Values[0])
+		return err
+	case OperatorSemverEq, OperatorSemverGt, OperatorSemverGte, OperatorSemverLt, OperatorSemverLte:
+		err := expectValues(c, 1)
+		if err != nil {
+			return err
+		}
+		_, err = ParseVersion(c.Values[0])
+		return err
+	case OperatorGt, OperatorGte, OperatorLt, OperatorLte:
+		err := expectValues(c, 1)
+		if err != nil {
+			return err
+		}
+		_, err = parseNumbers(c.Values)
+		return err
+	case OperatorBetween:
+		err := expectValues(c, 2)
+		if err != nil {
+			return err
+		}
+		bounds, err := parseNumbers(c.Values)
+		if err != nil {
+			return err
+		}
+		if bounds[0] > bounds[1] {
+			return errors.Errorf("operator %s needs the lower bound first", c.Operator)
+		}
+		return nil
+	case "":
+		return errors.Errorf("the condition on %s needs an operator", c.Attribute)
+	default:
+		return errors.Errorf("unknown operator %q", c.Operator)
+	}
+}
+
+// Equal reports whether two conditions are the same, treating missing and
+// empty lists alike.
+func (c *Condition) Equal(other *Condition) bool {
+	equal := func(a, b Condition) bool { return a.Equal(&b) }
+	return c.Attribute == other.Attribute && c.Operator == other.Operator &&
+		slices.Equal(c.Values, other.Values) &&
+		slices.EqualFunc(c.All, other.All, equal) &&
+		slices.EqualFunc(c.Any, other.Any, equal)
+}
+
+// String describes the condition in a compact form for people, such as
+// (country in [US,CA] and appVersion semverGte 2.0.0).
+func (c *Condition) String() string {
+	if len(c.All) > 0 || len(c.Any) > 0 {
+		conditions, separator := c.All, " and "
+		if len(c.Any) > 0 {
+			conditions, separator = c.Any, " or "
+		}
+		descriptions := make([]string, 0, len(conditions))
+		for i := range conditions {
+			descriptions = append(descriptions, conditions[i].String())
+		}
+		return "(" + strings.Join(descriptions, separator) + ")"
+	}
+	if len(c.Values) == 1 && c.Operator != OperatorIn {
+		return fmt.Sprintf("%s %s %s", c.Attribute, c.Operator, c.Values[0])
+	}
+	return fmt.Sprintf("%s %s [%s]", c.Attribute, c.Operator, strings.Join(c.Values, ","))
+}
+
+// RulesEqual reports whether two lists of rules are the same.
+func RulesEqual(a []Rule, b []Rule) bool {
+	return slices.EqualFunc(a, b, func(x, y Rule) bool {
+		return x.Name == y.Name && x.Priority == y.Priority && x.Value == y.Value && x.Condition.Equal(&y.Condition)
+	})
+}
+
+func expectValues(c *Condition, count int) error {
+	if len(c.Values) != count {
+		return errors.Errorf("operator %s needs %d values but got %d", c.Operator, count, len(c.Values))
+	}
+	return nil
+}
+
+func parseNumbers(values []string) ([]float64, error) {
+	numbers := make([]float64, 0, len(values))
+	for _, value := range values {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, errors.Errorf("%q is not a number", value)
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, nil
+}
+
+func compareNumbers(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareHolds reports whether a comparison result satisfies operator.
+func compareHolds(operator string, comparison int) bool {
+	switch operator {
+	case OperatorSemverEq:
+		return comparison == 0
+	case OperatorSemverGt, OperatorGt:
+		return comparison > 0
+	case OperatorSemverGte, OperatorGte:
+		return comparison >= 0
+	case OperatorSemverLt, OperatorLt:
+		return comparison < 0
+	case OperatorSemverLte, OperatorLte:
+		return comparison <= 0
+	default:
+		return false
+	}
+}
+
+// patterns caches compiled regular expressions, the same few patterns are
+// evaluated for every request.
+var patterns sync.Map
+
+func compilePattern(expression string) (*regexp.Regexp, error) {
+	if pattern, found := patterns.Load(expression); found {
+		return pattern.(*regexp.Regexp), nil
+	}
+	pattern, err := regexp.Compile(expression)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid regular expression %q", expression)
+	}
+	patterns.Store(expression, pattern)
+	return pattern, nil
+}
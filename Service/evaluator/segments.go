@@ -0,0 +1,27 @@
+package evaluator
+
+import "slices"
+
+// SegmentEntityType is the entity type of overrides that target a segment,
+// their entity id is the segment name. Requests don't send it as an
+// attribute, segment membership is worked out from the other attributes.
+const SegmentEntityType = "segment"
+
+// Segment is a named group of entities shared between configs. A request is
+// in the segment when its EntityType attribute is one of EntityIds, or when
+// its attributes satisfy Condition.
+type Segment struct {
+	EntityType string     `json:"entityType,omitempty"`
+	EntityIds  []string   `json:"entityIds,omitempty"`
+	Condition  *Condition `json:"condition,omitempty"`
+}
+
+// Contains reports whether a request with attributes is in the segment.
+func (s *Segment) Contains(attributes map[string]string) bool {
+	if s.EntityType != "" {
+		if entityId, found := attributes[s.EntityType]; found && slices.Contains(s.EntityIds, entityId) {
+			return true
+		}
+	}
+	return s.Condition != nil && s.Condition.Evaluate(attributes)
+}
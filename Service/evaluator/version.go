@@ -0,0 +1,96 @@
+package evaluator
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Version is a semantic version as described by semver.org. A leading v
+// is accepted, as are versions with fewer than three numbers such as 1.2,
+// the missing numbers are read as 0.
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string
+}
+
+func ParseVersion(value string) (Version, error) {
+	text := strings.TrimPrefix(value, "v")
+	// Build metadata doesn't affect precedence.
+	text, _, _ = strings.Cut(text, "+")
+	text, prerelease, hasPrerelease := strings.Cut(text, "-")
+
+	parts := strings.Split(text, ".")
+	if len(parts) > 3 {
+		return Version{}, errors.Errorf("%q is not a semantic version", value)
+	}
+	numbers := make([]uint64, 3)
+	for i, part := range parts {
+		number, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return Version{}, errors.Errorf("%q is not a semantic version", value)
+		}
+		numbers[i] = number
+	}
+
+	version := Version{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}
+	if hasPrerelease {
+		version.Prerelease = strings.Split(prerelease, ".")
+		for _, identifier := range version.Prerelease {
+			if identifier == "" {
+				return Version{}, errors.Errorf("%q has an empty prerelease identifier", value)
+			}
+		}
+	}
+	return version, nil
+}
+
+// Compare returns -1, 0 or 1 when v is lower than, equal to or higher than
+// other. A prerelease is lower than its release, prerelease identifiers are
+// compared numerically when both are numbers.
+func (v Version) Compare(other Version) int {
+	for _, pair := range [][2]uint64{{v.Major, other.Major}, {v.Minor, other.Minor}, {v.Patch, other.Patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(v.Prerelease) == 0 && len(other.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(other.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(other.Prerelease); i++ {
+		comparison := compareIdentifiers(v.Prerelease[i], other.Prerelease[i])
+		if comparison != 0 {
+			return comparison
+		}
+	}
+	return compareNumbers(float64(len(v.Prerelease)), float64(len(other.Prerelease)))
+}
+
+// compareIdentifiers orders prerelease identifiers, numeric identifiers
+// come before alphanumeric ones.
+func compareIdentifiers(a string, b string) int {
+	aNumber, aErr := strconv.ParseUint(a, 10, 64)
+	bNumber, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareNumbers(float64(aNumber), float64(bNumber))
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/rand"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ChangeEvent is a change to a config or one of its overrides as streamed to
+// watchers. Ids increase by one with every change so a client can resume
+// from the last event it saw.
+type ChangeEvent struct {
+	Id    uint64
+	Entry HistoryEntry
+}
+
+// FormatEventId gives clients the id of an event as the epoch of the broker
+// that published it followed by the event's id. The epoch is new every time
+// the service starts, so ids from before a restart, or from another
+// instance, are never mistaken for ids of this one.
+func FormatEventId(epoch string, id uint64) string {
+	return epoch + "-" + strconv.FormatUint(id, 10)
+}
+
+// ParseEventId splits an id made by FormatEventId.
+func ParseEventId(eventId string) (string, uint64, error) {
+	epoch, idStr, found := strings.Cut(eventId, "-")
+	if !found || epoch == "" {
+		return "", 0, errors.Errorf("event id %q has no epoch", eventId)
+	}
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return "", 0, errors.Errorf("event id %q has no number", eventId)
+	}
+	return epoch, id, nil
+}
+
+// EventBroker fans changes out to watchers. It keeps the most recent events
+// in a ring buffer so reconnecting clients can catch up on what they missed,
+// a client that has fallen further behind than the buffer has to resync.
+//
+// Subscribers are only signalled that something changed and then read the
+// events themselves with Since, so a slow watcher never blocks a write.
+type EventBroker struct {
+	mu sync.Mutex
+
+	// epoch tells this broker's events apart from those of earlier runs,
+	// whose ids started from zero as well.
+	epoch string
+
+	// events is a ring buffer, the event with id n is at (n-1) % len(events).
+	events []ChangeEvent
+	lastId uint64
+
+	subscribers map[chan struct{}]struct{}
+}
+
+const defaultEventBufferSize = 1000
+
+func NewEventBroker(bufferSize int) *EventBroker {
+	if bufferSize <= 0 {
+		bufferSize = defaultEventBufferSize
+	}
+	return &EventBroker{
+		epoch:       strings.ToLower(rand.Text()),
+		events:      make([]ChangeEvent, bufferSize),
+		subscribers: make(map[chan struct{}]struct{}),
+	}
+}
+
+// Publish records entry as the next event and wakes every subscriber.
+func (b *EventBroker) Publish(entry HistoryEntry) ChangeEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastId++
+	event := ChangeEvent{
+		Id:    b.lastId,
+		Entry: entry,
+	}
+	b.events[(event.Id-1)%uint64(len(b.events))] = event
+
+	for notify := range b.subscribers {
+		select {
+		case notify <- struct{}{}:
+		default:
+			// Already signalled, the subscriber will pick this event up
+			// with the ones before it.
+		}
+	}
+	return event
+}
+
+// Epoch identifies this broker's events, see FormatEventId.
+func (b *EventBroker) Epoch() string {
+	return b.epoch
+}
+
+// LastId is the id of the most recent event, or zero before any change.
+func (b *EventBroker) LastId() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.lastId
+}
+
+// Since returns the events after id of epoch, oldest first. It returns
+// false when id is from another epoch, such as from before a restart, or
+// when events after id have already been dropped from the buffer, and the
+// caller can't catch up.
+func (b *EventBroker) Since(epoch string, id uint64) ([]ChangeEvent, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if epoch != b.epoch || id > b.lastId {
+		return nil, false
+	}
+	size := uint64(len(b.events))
+	if b.lastId-id > size {
+		return nil, false
+	}
+
+	events := make([]ChangeEvent, 0, b.lastId-id)
+	for next := id + 1; next <= b.lastId; next++ {
+		events = append(events, b.events[(next-1)%size])
+	}
+	return events, true
+}
+
+// Subscribe returns a channel that receives a signal after new events are
+// published. Call the returned func to stop receiving them.
+func (b *EventBroker) Subscribe() (<-chan struct{}, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	notify := make(chan struct{}, 1)
+	b.subscribers[notify] = struct{}{}
+	return notify, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.subscribers, notify)
+	}
+}
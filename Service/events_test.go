@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestEventBrokerSince(t *testing.T) {
+	broker := NewEventBroker(3)
+	for _, name := range []string{"config1", "config2", "config3", "config4"} {
+		broker.Publish(HistoryEntry{ConfigPath: ConfigPath{Service: "service1", Name: name}})
+	}
+
+	events, ok := broker.Since(broker.Epoch(), 2)
+	if !ok || len(events) != 2 {
+		t.Fatalf("Expected 2 events after id 2, but got %v", events)
+	}
+	if events[0].Id != 3 || events[0].Entry.Name != "config3" || events[1].Id != 4 || events[1].Entry.Name != "config4" {
+		t.Errorf("Expected events 3 and 4 in order, but got %v", events)
+	}
+	events, ok = broker.Since(broker.Epoch(), 4)
+	if !ok || len(events) != 0 {
+		t.Errorf("Expected no events after the latest id, but got %v", events)
+	}
+
+	_, ok = broker.Since(broker.Epoch(), 0)
+	if ok {
+		t.Errorf("Expected events dropped from the buffer to require a reset")
+	}
+	_, ok = broker.Since(broker.Epoch(), 5)
+	if ok {
+		t.Errorf("Expected an id from the future to require a reset")
+	}
+	_, ok = NewEventBroker(3).Since(broker.Epoch(), 2)
+	if ok {
+		t.Errorf("Expected an id from another epoch to require a reset")
+	}
+}
+
+func TestEventIds(t *testing.T) {
+	broker := NewEventBroker(3)
+	eventId := FormatEventId(broker.Epoch(), 42)
+	epoch, id, err := ParseEventId(eventId)
+	if err != nil || epoch != broker.Epoch() || id != 42 {
+		t.Errorf("Expected %s to parse back, but got %v %v %v", eventId, epoch, id, err)
+	}
+	if broker.Epoch() == NewEventBroker(3).Epoch() {
+		t.Errorf("Expected every broker to get its own epoch")
+	}
+	for _, eventId := range []string{"42", "-42", "abc-", "abc-x"} {
+		_, _, err = ParseEventId(eventId)
+		if err == nil {
+			t.Errorf("Expected %q to be rejected", eventId)
+		}
+	}
+}
+
+func TestEventBrokerSubscribe(t *testing.T) {
+	broker := NewEventBroker(10)
+	notify, unsubscribe := broker.Subscribe()
+
+	broker.Publish(HistoryEntry{})
+	broker.Publish(HistoryEntry{})
+	select {
+	case <-notify:
+	default:
+		t.Fatalf("Expected subscriber to be signalled")
+	}
+	select {
+	case <-notify:
+		t.Errorf("Expected signals to be coalesced")
+	default:
+	}
+
+	unsubscribe()
+	broker.Publish(HistoryEntry{})
+	select {
+	case <-notify:
+		t.Errorf("Expected no signal after unsubscribing")
+	default:
+	}
+}
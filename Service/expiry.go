@@ -0,0 +1,137 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultExpiryInterval = time.Minute
+
+// expiryActor is recorded in the history of overrides deleted once expired.
+const expiryActor = "expiry"
+
+// ExpiryCollector deletes expired overrides. They stop applying as soon as
+// they expire, deleting them moves their config on to a new revision so
+// snapshots and caches drop them too.
+type ExpiryCollector struct {
+	Interval time.Duration
+
+	handlers Handlers
+}
+
+func NewExpiryCollector(interval time.Duration, handlers Handlers) *ExpiryCollector {
+	if interval <= 0 {
+		interval = defaultExpiryInterval
+	}
+	return &ExpiryCollector{
+		Interval: interval,
+		handlers: handlers,
+	}
+}
+
+// Run deletes expired overrides every Interval until stop is closed, or
+// forever when stop is nil.
+func (c *ExpiryCollector) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		removed, err := c.handlers.RemoveExpiredOverrides(time.Now().UTC())
+		if err != nil {
+			log.Printf("Failed to remove expired overrides: %v", err)
+		}
+		if removed > 0 {
+			log.Printf("Removed %d expired overrides", removed)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// OverrideExpired reports whether override no longer applies at now.
+func OverrideExpired(override *Override, now time.Time) bool {
+	return override.ExpiresAt != nil && !override.ExpiresAt.After(now)
+}
+
+// SetOverrideExpiry sets the expiry of an override written at from, given
+// either as a ttl in seconds or as the override's ExpiresAt.
+func SetOverrideExpiry(override *Override, ttl int64, from time.Time) error {
+	if ttl < 0 {
+		return NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, "ttl must be a positive number of seconds")
+	}
+	if ttl > 0 {
+		if override.ExpiresAt != nil {
+			return NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, "override takes either a ttl or expiresAt")
+		}
+		expiresAt := from.Add(time.Duration(ttl) * time.Second)
+		override.ExpiresAt = &expiresAt
+	}
+	if override.ExpiresAt == nil {
+		return nil
+	}
+	if !override.ExpiresAt.After(from) {
+		return NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, "expiresAt must be after the override is made")
+	}
+	expiresAt := override.ExpiresAt.UTC()
+	override.ExpiresAt = &expiresAt
+	return nil
+}
+
+// SameExpiry reports whether two overrides expire at the same time.
+func SameExpiry(a *Override, b *Override) bool {
+	if a.ExpiresAt == nil || b.ExpiresAt == nil {
+		return a.ExpiresAt == nil && b.ExpiresAt == nil
+	}
+	return a.ExpiresAt.Equal(*b.ExpiresAt)
+}
+
+// RemoveExpiredOverrides deletes every override expired at now and returns
+// how many it deleted. Only the overrides the store's expiry index has due
+// are read. They are out of the index once popped, so a failure to delete
+// one is logged and the others are still deleted.
+func (h *Handlers) RemoveExpiredOverrides(now time.Time) (int, error) {
+	refs, err := h.ConfigDb.PopExpiredOverrides(now)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get expired overrides from db")
+	}
+	removed := 0
+	for _, ref := range refs {
+		found, err := h.removeExpiredOverride(&ref.ConfigPath, &ref.OverrideKey, now)
+		if err != nil {
+			log.Printf("Failed to remove expired override %s %s: %v", GetConfigPathStr(&ref.ConfigPath), GetOverridePathStr(&ref.OverrideKey), err)
+			continue
+		}
+		if found {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// removeExpiredOverride deletes an override if it is still expired, it may
+// have been deleted or replaced since it was indexed.
+func (h *Handlers) removeExpiredOverride(path *ConfigPath, key *OverrideKey, now time.Time) (bool, error) {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+
+	override, found, err := h.ConfigDb.GetOverride(path, key)
+	if errors.Is(err, ErrConfigNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get override from db")
+	}
+	if !found || !OverrideExpired(&override, now) {
+		return false, nil
+	}
+	err = h.removeOverride(expiryActor, path, key)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to remove override")
+	}
+	return true, nil
+}
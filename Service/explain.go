@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExplainConfig resolves the value of a config like EvaluateConfig and
+// explains how it got there, see ValueExplanation.
+func (h *Handlers) ExplainConfig(path *ConfigPath, attributes map[string]string, format string) (GetConfigValueResponse, error) {
+	config, err := h.ConfigDb.GetConfig(path)
+	if err != nil {
+		return GetConfigValueResponse{}, errors.Wrap(err, "failed to get config from db")
+	}
+	result, evaluated, err := h.newEvaluator(&config).Explain(attributes, time.Now())
+	if err != nil {
+		return GetConfigValueResponse{}, err
+	}
+	explanation := ValueExplanation{
+		DefaultValue: EncodeConfigValue(config.Type, config.DefaultValue, format),
+		Priority:     evaluated.Priority,
+		Overrides:    make([]OverrideCandidate, 0, len(evaluated.Overrides)),
+		Rules:        make([]RuleCandidate, 0, len(evaluated.Rules)),
+		Rollouts:     make([]RolloutCandidate, 0, len(evaluated.Rollouts)),
+	}
+	// Everything that matched, most important first, the first one wins.
+	matched := []string{}
+
+	for _, candidate := range evaluated.Overrides {
+		key := OverrideKey(candidate.OverrideKey)
+		explained := OverrideCandidate{
+			OverrideKey: key,
+			Found:       candidate.Found,
+			ExpiresAt:   candidate.ExpiresAt,
+			Matched:     candidate.Matched,
+		}
+		if candidate.Found {
+			explained.Value = EncodeConfigValue(config.Type, candidate.Value, format)
+		}
+		if candidate.Matched {
+			matched = append(matched, "override "+GetOverridePathStr(&key))
+		}
+		explanation.Overrides = append(explanation.Overrides, explained)
+	}
+	for _, candidate := range evaluated.Rules {
+		if candidate.Matched {
+			matched = append(matched, describeRule(candidate.Index, candidate.Name))
+		}
+		explanation.Rules = append(explanation.Rules, RuleCandidate{
+			Index:    candidate.Index,
+			Name:     candidate.Name,
+			Priority: candidate.Priority,
+			Value:    EncodeConfigValue(config.Type, candidate.Value, format),
+			Matched:  candidate.Matched,
+		})
+	}
+	for _, candidate := range evaluated.Rollouts {
+		if candidate.Matched {
+			matched = append(matched, fmt.Sprintf("rollout %d", candidate.Index))
+		}
+		explanation.Rollouts = append(explanation.Rollouts, RolloutCandidate{
+			Index:      candidate.Index,
+			EntityType: candidate.EntityType,
+			Percentage: candidate.Percentage,
+			Value:      EncodeConfigValue(config.Type, candidate.Value, format),
+			EntityId:   candidate.EntityId,
+			Bucket:     candidate.Bucket,
+			Matched:    candidate.Matched,
+		})
+	}
+
+	switch {
+	case result.MatchedOverride != nil && result.MatchedOverride.EntityType == SegmentEntityType:
+		explanation.Source = ValueSourceSegment
+		explanation.Decision = fmt.Sprintf("no entity override matched, segment %s is the first matching segment by name",
+			result.MatchedOverride.EntityId)
+	case result.MatchedOverride != nil:
+		explanation.Source = ValueSourceOverride
+		explanation.Decision = fmt.Sprintf("override %s is the first matching override in priority order",
+			GetOverridePathStr((*OverrideKey)(result.MatchedOverride)))
+	case result.MatchedRule != nil:
+		explanation.Source = ValueSourceRule
+		explanation.Decision = fmt.Sprintf("no override matched, %s comes first of the matching rules by priority",
+			describeRule(result.MatchedRule.Index, result.MatchedRule.Name))
+	case result.MatchedRollout != nil:
+		match := result.MatchedRollout
+		explanation.Source = ValueSourceRollout
+		explanation.Decision = fmt.Sprintf("no override or rule matched, %s %s is in bucket %d which rollout %d covers",
+			match.EntityType, match.EntityId, match.Bucket, match.Index)
+	default:
+		explanation.Source = ValueSourceDefault
+		explanation.Decision = "no override, rule or rollout matched, the default applies"
+	}
+	if len(matched) > 1 {
+		explanation.Decision += ", it takes precedence over " + strings.Join(matched[1:], ", ")
+	}
+
+	response := NewValueResponse(&result, format)
+	response.Explanation = &explanation
+	return response, nil
+}
+
+func describeRule(index int, name string) string {
+	if name == "" {
+		return fmt.Sprintf("rule %d", index)
+	}
+	return fmt.Sprintf("rule %d %q", index, name)
+}
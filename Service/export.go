@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ExportFormatVersion is bumped whenever ExportDocument changes in a way
+// older services can't import.
+const ExportFormatVersion = 1
+
+const (
+	DocumentEncodingJson = "json"
+	DocumentEncodingYaml = "yaml"
+)
+
+const (
+	// ImportModeMerge creates and updates the configs and overrides in the
+	// document and leaves everything else alone.
+	ImportModeMerge = "merge"
+	// ImportModeReplace makes the store match the document exactly,
+	// deleting configs and overrides it doesn't list.
+	ImportModeReplace = "replace"
+)
+
+// GetDocumentEncoding returns the export document encoding requested by r,
+// from the format query parameter or else a YAML Content-Type.
+func GetDocumentEncoding(r *http.Request) (string, error) {
+	encoding := r.URL.Query().Get("format")
+	if encoding == "" && strings.Contains(r.Header.Get("Content-Type"), "yaml") {
+		encoding = DocumentEncodingYaml
+	}
+	if encoding == "" {
+		encoding = DocumentEncodingJson
+	}
+	if encoding != DocumentEncodingJson && encoding != DocumentEncodingYaml {
+		return "", NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Sprintf("unknown format %q, expected json or yaml", encoding))
+	}
+	return encoding, nil
+}
+
+// Export dumps every segment, config and override as an ExportDocument.
+func (h *Handlers) Export(r *http.Request) (*HttpResponse, error) {
+	format, err := h.GetValueFormat(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get value format from request")
+	}
+	encoding, err := GetDocumentEncoding(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get format from request")
+	}
+
+	document, err := h.BuildExportDocument(format)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build export")
+	}
+
+	var respBytes []byte
+	if encoding == DocumentEncodingYaml {
+		var buffer bytes.Buffer
+		encoder := yaml.NewEncoder(&buffer)
+		encoder.SetIndent(2)
+		err = encoder.Encode(document)
+		if err == nil {
+			err = encoder.Close()
+		}
+		respBytes = buffer.Bytes()
+	} else {
+		respBytes, err = json.Marshal(document)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal response")
+	}
+	return &HttpResponse{
+		Status: http.StatusOK,
+		Data:   respBytes,
+	}, nil
+}
+
+// BuildExportDocument reads every segment, config and override. It holds
+// the write lock so the document is a consistent backup, exports are rare
+// enough that briefly holding up changes doesn't matter.
+func (h *Handlers) BuildExportDocument(format string) (ExportDocument, error) {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+
+	configs, err := h.ConfigDb.GetConfigs()
+	if err != nil {
+		return ExportDocument{}, errors.Wrap(err, "failed to get configs from db")
+	}
+	slices.SortFunc(configs, func(a, b Config) int {
+		return cmp.Or(strings.Compare(a.Service, b.Service), strings.Compare(a.Name, b.Name))
+	})
+
+	segments, err := h.ConfigDb.GetSegments()
+	if err != nil {
+		return ExportDocument{}, errors.Wrap(err, "failed to get segments from db")
+	}
+	slices.SortFunc(segments, func(a, b Segment) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	document := ExportDocument{
+		FormatVersion: ExportFormatVersion,
+		Configs:       make([]ExportConfig, 0, len(configs)),
+	}
+	for _, segment := range segments {
+		document.Segments = append(document.Segments, ExportSegment{
+			Name:       segment.Name,
+			EntityType: segment.EntityType,
+			EntityIds:  segment.EntityIds,
+			Condition:  segment.Condition,
+		})
+	}
+	for _, config := range configs {
+		overrides, err := h.ConfigDb.GetOverrides(&config.ConfigPath)
+		if err != nil {
+			return ExportDocument{}, errors.Wrap(err, "failed to get overrides from db")
+		}
+		sortOverrides(overrides)
+
+		exportConfig := ExportConfig{
+			Service:      config.Service,
+			Name:         config.Name,
+			Type:         config.Type,
+			DefaultValue: EncodeConfigValue(config.Type, config.DefaultValue, format),
+			Priority:     config.Priority,
+		}
+		for _, rule := range config.Rules {
+			exportConfig.Rules = append(exportConfig.Rules, ExportRule{
+				Name:      rule.Name,
+				Priority:  rule.Priority,
+				Condition: rule.Condition,
+				Value:     EncodeConfigValue(config.Type, rule.Value, format),
+			})
+		}
+		for _, rollout := range config.Rollouts {
+			exportConfig.Rollouts = append(exportConfig.Rollouts, ExportRollout{
+				EntityType: rollout.EntityType,
+				Percentage: rollout.Percentage,
+				Value:      EncodeConfigValue(config.Type, rollout.Value, format),
+			})
+		}
+		for _, override := range overrides {
+			exportConfig.Overrides = append(exportConfig.Overrides, ExportOverride{
+				EntityType: override.EntityType,
+				EntityId:   override.EntityId,
+				Value:      EncodeConfigValue(config.Type, override.Value, format),
+				ExpiresAt:  override.ExpiresAt,
+			})
+		}
+		document.Configs = append(document.Configs, exportConfig)
+	}
+	return document, nil
+}
+
+// Import loads an ExportDocument. The mode query parameter picks merge, the
+// default, or replace, and dryRun=true reports the changes without making
+// them.
+func (h *Handlers) Import(r *http.Request) (*HttpResponse, error) {
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = ImportModeMerge
+	}
+	dryRun := false
+	if dryRunStr := r.URL.Query().Get("dryRun"); dryRunStr != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(dryRunStr)
+		if err != nil {
+			return nil, NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, "dryRun must be true or false")
+		}
+	}
+	encoding, err := GetDocumentEncoding(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get format from request")
+	}
+
+	var document ExportDocument
+	if encoding == DocumentEncodingYaml {
+		bodyBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read request body")
+		}
+		err = yaml.Unmarshal(bodyBytes, &document)
+		if err != nil {
+			return nil, NewApiError(http.StatusBadRequest, ErrorCodeMalformedRequest, "malformed request body: "+err.Error())
+		}
+	} else {
+		err = DecodeRequestBody(r, &document)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode request body")
+		}
+	}
+
+	report, err := h.ImportConfigs(GetActor(r), &document, mode, "", dryRun)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to import configs")
+	}
+
+	respBytes, err := json.Marshal(report)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal response")
+	}
+	return &HttpResponse{
+		Status: http.StatusOK,
+		Data:   respBytes,
+	}, nil
+}
+
+type importPlan struct {
+	Report ImportReport
+	// Segments are saved before the configs whose overrides may target them.
+	Segments []Segment
+	// Restores replace a config and its whole override set.
+	Restores []importRestore
+	Deletes  []ConfigPath
+}
+
+type importRestore struct {
+	Config    Config
+	Overrides []Override
+}
+
+// planImport works out the changes that load document in mode, validating
+// all of it first. Configs already matching the document are left alone so
+// an import doesn't move them on to a new revision. When service is set,
+// replace only deletes configs of that service. Segments are created or
+// updated in either mode but never deleted, they may be shared with configs
+// the document doesn't cover.
+func (h *Handlers) planImport(document *ExportDocument, mode string, service string) (importPlan, error) {
+	plan := importPlan{
+		Report: ImportReport{
+			Mode:    mode,
+			Created: []ImportChange{},
+			Updated: []ImportChange{},
+			Deleted: []ImportChange{},
+		},
+	}
+	if mode != ImportModeMerge && mode != ImportModeReplace {
+		return plan, NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Sprintf("unknown mode %q, expected merge or replace", mode))
+	}
+	// Documents without a version, such as hand written ones, are read as
+	// the current version.
+	if document.FormatVersion != 0 && document.FormatVersion != ExportFormatVersion {
+		return plan, NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Sprintf("unsupported formatVersion %d", document.FormatVersion))
+	}
+
+	configs, err := h.ConfigDb.GetConfigs()
+	if err != nil {
+		return plan, errors.Wrap(err, "failed to get configs from db")
+	}
+	current := make(map[string]Config, len(configs))
+	for _, config := range configs {
+		current[GetConfigPathStr(&config.ConfigPath)] = config
+	}
+
+	segments, err := h.planSegmentImport(&plan, document)
+	if err != nil {
+		return plan, err
+	}
+
+	now := time.Now().UTC()
+	imported := make(map[string]bool, len(document.Configs))
+	for i := range document.Configs {
+		config, overrides, err := DecodeExportConfig(&document.Configs[i])
+		if err != nil {
+			return plan, err
+		}
+		pathStr := GetConfigPathStr(&config.ConfigPath)
+		for _, override := range overrides {
+			if override.EntityType == SegmentEntityType && !segments[override.EntityId] {
+				return plan, NewApiError(http.StatusNotFound, ErrorCodeSegmentNotFound, fmt.Sprintf(
+					"override of %s targets unknown segment %s", pathStr, override.EntityId))
+			}
+		}
+		if imported[pathStr] {
+			return plan, NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Sprintf("config %s is listed twice", pathStr))
+		}
+		imported[pathStr] = true
+
+		existing, found := current[pathStr]
+		var existingOverrides []Override
+		if found {
+			existingOverrides, err = h.ConfigDb.GetOverrides(&config.ConfigPath)
+			if err != nil {
+				return plan, errors.Wrap(err, "failed to get overrides from db")
+			}
+		}
+		restore, changed, err := planConfigImport(&plan.Report, mode, &config, overrides, &existing, found, existingOverrides, now)
+		if err != nil {
+			return plan, err
+		}
+		if changed {
+			plan.Restores = append(plan.Restores, restore)
+		}
+	}
+
+	if mode == ImportModeReplace {
+		slices.SortFunc(configs, func(a, b Config) int {
+			return cmp.Or(strings.Compare(a.Service, b.Service), strings.Compare(a.Name, b.Name))
+		})
+		for _, config := range configs {
+			if service != "" && config.Service != service {
+				continue
+			}
+			if !imported[GetConfigPathStr(&config.ConfigPath)] {
+				plan.Deletes = append(plan.Deletes, config.ConfigPath)
+				plan.Report.Deleted = append(plan.Report.Deleted, ImportChange{ConfigPath: config.ConfigPath})
+			}
+		}
+	}
+	return plan, nil
+}
+
+// planSegmentImport adds the document's new and changed segments to plan,
+// returning the names of every segment that exists once they are saved.
+func (h *Handlers) planSegmentImport(plan *importPlan, document *ExportDocument) (map[string]bool, error) {
+	existing, err := h.ConfigDb.GetSegments()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get segments from db")
+	}
+	current := make(map[string]Segment, len(existing))
+	for _, segment := range existing {
+		current[segment.Name] = segment
+	}
+	names := make(map[string]bool, len(existing)+len(document.Segments))
+	for name := range current {
+		names[name] = true
+	}
+
+	imported := make(map[string]bool, len(document.Segments))
+	for _, exportSegment := range document.Segments {
+		segment := Segment{
+			Name:       exportSegment.Name,
+			EntityType: exportSegment.EntityType,
+			EntityIds:  exportSegment.EntityIds,
+			Condition:  exportSegment.Condition,
+		}
+		err := ValidateSegment(&segment)
+		if err != nil {
+			return nil, err
+		}
+		if imported[segment.Name] {
+			return nil, NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Sprintf("segment %s is listed twice", segment.Name))
+		}
+		imported[segment.Name] = true
+		names[segment.Name] = true
+
+		old, found := current[segment.Name]
+		change := ImportChange{Segment: segment.Name}
+		if !found {
+			plan.Report.Created = append(plan.Report.Created, change)
+		} else if !SegmentsEqual(&old, &segment) {
+			plan.Report.Updated = append(plan.Report.Updated, change)
+		} else {
+			continue
+		}
+		plan.Segments = append(plan.Segments, segment)
+	}
+	return names, nil
+}
+
+// planConfigImport compares one imported config with the stored one,
+// adding the differences to report. Imported overrides already expired at
+// now are left out, writing them would only have them collected again.
+func planConfigImport(report *ImportReport, mode string, config *Config, overrides []Override,
+	existing *Config, found bool, existingOverrides []Override, now time.Time) (importRestore, bool, error) {
+	changed := false
+	if !found {
+		report.Created = append(report.Created, ImportChange{ConfigPath: config.ConfigPath})
+		changed = true
+	} else if existing.Type != config.Type || existing.DefaultValue != config.DefaultValue ||
+		!slices.Equal(existing.Priority, config.Priority) || !RulesEqual(existing.Rules, config.Rules) ||
+		!slices.Equal(existing.Rollouts, config.Rollouts) {
+		report.Updated = append(report.Updated, ImportChange{ConfigPath: config.ConfigPath})
+		changed = true
+	}
+
+	existingByKey := make(ConfigOverrides, len(existingOverrides))
+	for _, override := range existingOverrides {
+		existingByKey[GetOverridePathStr(&override.OverrideKey)] = override
+	}
+	final := make(ConfigOverrides, len(overrides))
+	if mode == ImportModeMerge {
+		for key, override := range existingByKey {
+			err := ValidateConfigValue(config.Type, override.Value)
+			if err != nil {
+				return importRestore{}, false, NewApiError(http.StatusConflict, ErrorCodeTypeConflict, fmt.Sprintf(
+					"cannot change type of %s from %s to %s, override %s: %s",
+					GetConfigPathStr(&config.ConfigPath), existing.Type, config.Type, key, err.Error()))
+			}
+			final[key] = override
+		}
+	}
+	for _, override := range overrides {
+		if OverrideExpired(&override, now) {
+			continue
+		}
+		key := GetOverridePathStr(&override.OverrideKey)
+		change := ImportChange{ConfigPath: config.ConfigPath, Override: &override.OverrideKey}
+		old, exists := existingByKey[key]
+		if !exists {
+			report.Created = append(report.Created, change)
+			changed = true
+		} else if old.Value != override.Value || !SameExpiry(&old, &override) {
+			report.Updated = append(report.Updated, change)
+			changed = true
+		}
+		final[key] = override
+	}
+	if mode == ImportModeReplace {
+		sortOverrides(existingOverrides)
+		for _, override := range existingOverrides {
+			if _, kept := final[GetOverridePathStr(&override.OverrideKey)]; !kept {
+				report.Deleted = append(report.Deleted, ImportChange{ConfigPath: config.ConfigPath, Override: &override.OverrideKey})
+				changed = true
+			}
+		}
+	}
+
+	restore := importRestore{
+		Config:    *config,
+		Overrides: make([]Override, 0, len(final)),
+	}
+	for _, override := range final {
+		restore.Overrides = append(restore.Overrides, override)
+	}
+	sortOverrides(restore.Overrides)
+	return restore, changed, nil
+}
+
+// DecodeExportConfig validates an imported config and converts it and its
+// overrides into their stored form.
+func DecodeExportConfig(exportConfig *ExportConfig) (Config, []Override, error) {
+	config := Config{
+		ConfigPath: ConfigPath{
+			Service: exportConfig.Service,
+			Name:    exportConfig.Name,
+		},
+		Type:     exportConfig.Type,
+		Priority: exportConfig.Priority,
+	}
+	if config.Service == "" || config.Name == "" || config.Type == "" {
+		return Config{}, nil, NewApiError(http.StatusBadRequest, ErrorCodeMissingField, "config service, name and type are required")
+	}
+	pathStr := GetConfigPathStr(&config.ConfigPath)
+
+	var err error
+	config.DefaultValue, err = DecodeConfigValue(exportConfig.DefaultValue)
+	if err == nil {
+		err = ValidateConfigValue(config.Type, config.DefaultValue)
+	}
+	if err != nil {
+		return Config{}, nil, NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, fmt.Sprintf("invalid default value of %s: %s", pathStr, err.Error()))
+	}
+	for _, exportRule := range exportConfig.Rules {
+		rule := Rule{
+			Name:      exportRule.Name,
+			Priority:  exportRule.Priority,
+			Condition: exportRule.Condition,
+		}
+		rule.Value, err = DecodeConfigValue(exportRule.Value)
+		if err != nil {
+			return Config{}, nil, NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, fmt.Sprintf("invalid rule value of %s: %s", pathStr, err.Error()))
+		}
+		config.Rules = append(config.Rules, rule)
+	}
+	err = ValidateRules(config.Type, config.Rules)
+	if err != nil {
+		return Config{}, nil, errors.Wrapf(err, "invalid rules of %s", pathStr)
+	}
+	for _, exportRollout := range exportConfig.Rollouts {
+		rollout := Rollout{
+			EntityType: exportRollout.EntityType,
+			Percentage: exportRollout.Percentage,
+		}
+		rollout.Value, err = DecodeConfigValue(exportRollout.Value)
+		if err != nil {
+			return Config{}, nil, NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, fmt.Sprintf("invalid rollout value of %s: %s", pathStr, err.Error()))
+		}
+		config.Rollouts = append(config.Rollouts, rollout)
+	}
+	err = ValidateRollouts(config.Type, config.Rollouts)
+	if err != nil {
+		return Config{}, nil, errors.Wrapf(err, "invalid rollouts of %s", pathStr)
+	}
+
+	overrides := make([]Override, 0, len(exportConfig.Overrides))
+	seen := make(map[string]bool, len(exportConfig.Overrides))
+	for _, exportOverride := range exportConfig.Overrides {
+		override := Override{
+			OverrideKey: OverrideKey{
+				EntityType: exportOverride.EntityType,
+				EntityId:   exportOverride.EntityId,
+			},
+			ExpiresAt: exportOverride.ExpiresAt,
+		}
+		if override.EntityType == "" || override.EntityId == "" {
+			return Config{}, nil, NewApiError(http.StatusBadRequest, ErrorCodeMissingField, fmt.Sprintf("overrides of %s need an entityType and entityId", pathStr))
+		}
+		key := GetOverridePathStr(&override.OverrideKey)
+		if seen[key] {
+			return Config{}, nil, NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Sprintf("override %s of %s is listed twice", key, pathStr))
+		}
+		seen[key] = true
+
+		override.Value, err = DecodeConfigValue(exportOverride.Value)
+		if err == nil {
+			err = ValidateConfigValue(config.Type, override.Value)
+		}
+		if err != nil {
+			return Config{}, nil, NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, fmt.Sprintf("invalid value of override %s of %s: %s", key, pathStr, err.Error()))
+		}
+		overrides = append(overrides, override)
+	}
+	return config, overrides, nil
+}
+
+func sortOverrides(overrides []Override) {
+	slices.SortFunc(overrides, func(a, b Override) int {
+		return cmp.Or(strings.Compare(a.EntityType, b.EntityType), strings.Compare(a.EntityId, b.EntityId))
+	})
+}
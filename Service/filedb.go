@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FileConfigDb keeps the working set in an in-memory ConfigDb and makes it
+// durable with a write-ahead log. Every mutation is appended to the log and
+// synced before it is applied. Once SnapshotEvery records have been logged
+// the whole state is written to a snapshot and the log is truncated. On
+// startup the snapshot is loaded and the log replayed on top of it.
+//
+// The snapshot lives at ConfigDbConfig.Path and the log next to it with a
+// .wal suffix.
+type FileConfigDb struct {
+	*ConfigDb
+
+	// writeMu serializes mutations so log order always matches the order
+	// they were applied in.
+	writeMu sync.Mutex
+	wal     *os.File
+	// sequence is the number of the last record written to the log.
+	sequence uint64
+	// walRecords counts records logged since the last snapshot.
+	walRecords int
+}
+
+const defaultSnapshotEvery = 1000
+
+// Every log record is framed by a header holding the payload length and its
+// CRC-32 so a torn write at the end of the log can be detected on replay.
+const walHeaderSize = 8
+
+// Records larger than this can only come from a corrupt header.
+const walMaxRecordSize = 64 << 20
+
+const (
+	walOpAddConfig      = "addConfig"
+	walOpUpdateConfig   = "updateConfig"
+	walOpDeleteConfig   = "deleteConfig"
+	walOpAddOverride    = "addOverride"
+	walOpDeleteOverride = "deleteOverride"
+	walOpRestoreConfig  = "restoreConfig"
+	walOpAddHistory     = "addHistory"
+	walOpAddSegment     = "addSegment"
+	walOpDeleteSegment  = "deleteSegment"
+	walOpAddSchedule    = "addSchedule"
+	walOpDeleteSchedule = "deleteSchedule"
+)
+
+type walRecord struct {
+	Sequence    uint64        `json:"sequence"`
+	Op          string        `json:"op"`
+	Config      *Config       `json:"config,omitempty"`
+	ConfigPath  *ConfigPath   `json:"configPath,omitempty"`
+	Override    *Override     `json:"override,omitempty"`
+	Overrides   []Override    `json:"overrides,omitempty"`
+	OverrideKey *OverrideKey  `json:"overrideKey,omitempty"`
+	History     *HistoryEntry `json:"history,omitempty"`
+	Segment     *Segment      `json:"segment,omitempty"`
+	SegmentName string        `json:"segmentName,omitempty"`
+	// Schedule is a ScheduledChange, ScheduleId the id of one.
+	Schedule   *ScheduledChange `json:"schedule,omitempty"`
+	ScheduleId string           `json:"scheduleId,omitempty"`
+}
+
+type fileConfigDbData struct {
+	// Sequence is the last log record included in the snapshot.
+	Sequence  uint64                    `json:"sequence"`
+	Configs   []Config                  `json:"configs"`
+	Overrides map[string][]Override     `json:"overrides"`
+	History   map[string][]HistoryEntry `json:"history"`
+	Segments  []Segment                 `json:"segments"`
+	Schedules []ScheduledChange         `json:"schedules"`
+}
+
+func OpenFileConfigDb(config ConfigDbConfig) (*FileConfigDb, error) {
+	if config.Path == "" {
+		return nil, errors.New("file config db requires a path")
+	}
+	if config.SnapshotEvery <= 0 {
+		config.SnapshotEvery = defaultSnapshotEvery
+	}
+	db := &FileConfigDb{
+		ConfigDb: NewConfigDb(config),
+	}
+
+	err := db.loadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	err = db.replayWal()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *FileConfigDb) walPath() string {
+	return db.Config.Path + ".wal"
+}
+
+func (db *FileConfigDb) loadSnapshot() error {
+	dataBytes, err := os.ReadFile(db.Config.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read config db snapshot")
+	}
+
+	var data fileConfigDbData
+	err = json.Unmarshal(dataBytes, &data)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode config db snapshot")
+	}
+	db.sequence = data.Sequence
+	for _, config := range data.Configs {
+		strPath := GetConfigPathStr(&config.ConfigPath)
+		db.Configs[strPath] = config
+		configOverrides := make(ConfigOverrides)
+		for _, override := range data.Overrides[strPath] {
+			configOverrides[GetOverridePathStr(&override.OverrideKey)] = override
+			db.indexExpiry(&config.ConfigPath, &override)
+		}
+		db.Overrides[strPath] = configOverrides
+	}
+	for strPath, history := range data.History {
+		db.History[strPath] = history
+	}
+	for _, segment := range data.Segments {
+		db.Segments[segment.Name] = segment
+	}
+	for _, change := range data.Schedules {
+		db.Schedules[change.Id] = change
+	}
+	return nil
+}
+
+// replayWal applies every complete record newer than the snapshot. A record
+// cut short or failing its checksum marks where a crash interrupted a
+// write, the log is truncated there so new records follow the last good one.
+func (db *FileConfigDb) replayWal() error {
+	wal, err := os.OpenFile(db.walPath(), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "failed to open config db log")
+	}
+
+	reader := bufio.NewReader(wal)
+	var validSize int64
+	for {
+		record, size, err := readWalRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Discarding config db log after offset %d: %v\n", validSize, err)
+			break
+		}
+		validSize += size
+		db.walRecords++
+		if record.Sequence <= db.sequence {
+			continue
+		}
+		db.sequence = record.Sequence
+		db.applyWalRecord(&record)
+	}
+
+	err = wal.Truncate(validSize)
+	if err == nil {
+		_, err = wal.Seek(validSize, io.SeekStart)
+	}
+	if err != nil {
+		wal.Close()
+		return errors.Wrap(err, "failed to truncate config db log")
+	}
+	db.wal = wal
+	return nil
+}
+
+func readWalRecord(reader *bufio.Reader) (walRecord, int64, error) {
+	var record walRecord
+	header := make([]byte, walHeaderSize)
+	n, err := io.ReadFull(reader, header)
+	if err == io.EOF {
+		return record, 0, io.EOF
+	}
+	if err != nil {
+		return record, 0, errors.Wrapf(err, "incomplete record header of %d bytes", n)
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	if length > walMaxRecordSize {
+		return record, 0, errors.Errorf("record length %d is too large", length)
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(reader, payload)
+	if err != nil {
+		return record, 0, errors.Wrap(err, "incomplete record payload")
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return record, 0, errors.New("record checksum mismatch")
+	}
+	err = json.Unmarshal(payload, &record)
+	if err != nil {
+		return record, 0, errors.Wrap(err, "failed to decode record")
+	}
+	return record, int64(walHeaderSize + len(payload)), nil
+}
+
+// applyWalRecord replays a logged mutation against the in-memory state.
+// Records were validated before they were logged so errors are ignored.
+func (db *FileConfigDb) applyWalRecord(record *walRecord) {
+	switch record.Op {
+	case walOpAddConfig:
+		db.ConfigDb.AddConfig(record.Config)
+	case walOpUpdateConfig:
+		db.ConfigDb.UpdateConfig(record.Config)
+	case walOpDeleteConfig:
+		db.ConfigDb.DeleteConfig(record.ConfigPath)
+	case walOpAddOverride:
+		db.ConfigDb.AddOverride(record.ConfigPath, record.Override)
+	case walOpDeleteOverride:
+		db.ConfigDb.DeleteOverride(record.ConfigPath, record.OverrideKey)
+	case walOpRestoreConfig:
+		db.ConfigDb.RestoreConfig(record.Config, record.Overrides)
+	case walOpAddHistory:
+		db.ConfigDb.AddHistory(record.History)
+	case walOpAddSegment:
+		db.ConfigDb.AddSegment(record.Segment)
+	case walOpDeleteSegment:
+		db.ConfigDb.DeleteSegment(record.SegmentName)
+	case walOpAddSchedule:
+		db.ConfigDb.AddScheduledChange(record.Schedule)
+	case walOpDeleteSchedule:
+		db.ConfigDb.DeleteScheduledChange(record.ScheduleId)
+	default:
+		fmt.Fprintf(os.Stderr, "Skipping unknown config db log record %q\n", record.Op)
+	}
+}
+
+// commit logs record, applies it and snapshots once enough records have
+// accumulated. Only failing to log the record fails the commit. Callers must
+// hold writeMu.
+func (db *FileConfigDb) commit(record walRecord) error {
+	record.Sequence = db.sequence + 1
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode config db log record")
+	}
+	frame := make([]byte, walHeaderSize, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	frame = append(frame, payload...)
+
+	offset, err := db.wal.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errors.Wrap(err, "failed to write config db log")
+	}
+	_, err = db.wal.Write(frame)
+	if err == nil {
+		err = db.wal.Sync()
+	}
+	if err != nil {
+		// Drop whatever part of the record made it to disk so later
+		// records aren't stranded behind it on replay.
+		db.wal.Truncate(offset)
+		db.wal.Seek(offset, io.SeekStart)
+		return errors.Wrap(err, "failed to write config db log")
+	}
+	db.sequence = record.Sequence
+	db.walRecords++
+	db.applyWalRecord(&record)
+
+	if db.walRecords >= db.Config.SnapshotEvery {
+		// The record is already durable in the log, so a failed snapshot
+		// only delays compaction. The next commit tries again.
+		err = db.snapshot()
+		if err != nil {
+			log.Printf("Failed to snapshot config db, the log keeps growing until it works: %v", err)
+		}
+	}
+	return nil
+}
+
+// snapshot writes the whole state to a temporary file, renames it over the
+// snapshot and then empties the log. A crash between the two steps is safe,
+// replay skips records the snapshot already includes. Callers must hold
+// writeMu.
+func (db *FileConfigDb) snapshot() error {
+	db.ConfigDb.mu.RLock()
+	data := fileConfigDbData{
+		Sequence:  db.sequence,
+		Configs:   []Config{},
+		Overrides: make(map[string][]Override),
+		History:   db.History,
+		Segments:  []Segment{},
+		Schedules: []ScheduledChange{},
+	}
+	for strPath, config := range db.Configs {
+		data.Configs = append(data.Configs, config)
+		overrides := []Override{}
+		for _, override := range db.Overrides[strPath] {
+			overrides = append(overrides, override)
+		}
+		data.Overrides[strPath] = overrides
+	}
+	for _, segment := range db.Segments {
+		data.Segments = append(data.Segments, segment)
+	}
+	for _, change := range db.Schedules {
+		data.Schedules = append(data.Schedules, change)
+	}
+	db.ConfigDb.mu.RUnlock()
+
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode config db snapshot")
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(db.Config.Path), filepath.Base(db.Config.Path)+".tmp*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create config db snapshot")
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(dataBytes)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to write config db snapshot")
+	}
+	err = os.Rename(tmpFile.Name(), db.Config.Path)
+	if err != nil {
+		return errors.Wrap(err, "failed to replace config db snapshot")
+	}
+
+	err = db.wal.Truncate(0)
+	if err == nil {
+		_, err = db.wal.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to truncate config db log")
+	}
+	db.walRecords = 0
+	return nil
+}
+
+// Close snapshots the current state so the next start has no log to replay.
+func (db *FileConfigDb) Close() error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	if db.wal == nil {
+		return nil
+	}
+	err := db.snapshot()
+	closeErr := db.wal.Close()
+	db.wal = nil
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func (db *FileConfigDb) AddConfig(config *Config) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	return db.commit(walRecord{
+		Op:     walOpAddConfig,
+		Config: config,
+	})
+}
+
+func (db *FileConfigDb) UpdateConfig(config *Config) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	return db.commit(walRecord{
+		Op:     walOpUpdateConfig,
+		Config: config,
+	})
+}
+
+func (db *FileConfigDb) DeleteConfig(path *ConfigPath) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	return db.commit(walRecord{
+		Op:         walOpDeleteConfig,
+		ConfigPath: path,
+	})
+}
+
+func (db *FileConfigDb) AddOverride(config *ConfigPath, override *Override) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	_, err := db.ConfigDb.GetConfig(config)
+	if err != nil {
+		return err
+	}
+	return db.commit(walRecord{
+		Op:         walOpAddOverride,
+		ConfigPath: config,
+		Override:   override,
+	})
+}
+
+func (db *FileConfigDb) DeleteOverride(config *ConfigPath, overrideKey *OverrideKey) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	return db.commit(walRecord{
+		Op:          walOpDeleteOverride,
+		ConfigPath:  config,
+		OverrideKey: overrideKey,
+	})
+}
+
+func (db *FileConfigDb) RestoreConfig(config *Config, overrides []Override) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	return db.commit(walRecord{
+		Op:        walOpRestoreConfig,
+		Config:    config,
+		Overrides: overrides,
+	})
+}
+
+func (db *FileConfigDb) AddHistory(entry *HistoryEntry) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	return db.commit(walRecord{
+		Op:      walOpAddHistory,
+		History: entry,
+	})
+}
+
+func (db *FileConfigDb) AddSegment(segment *Segment) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	return db.commit(walRecord{
+		Op:      walOpAddSegment,
+		Segment: segment,
+	})
+}
+
+func (db *FileConfigDb) DeleteSegment(name string) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	return db.commit(walRecord{
+		Op:          walOpDeleteSegment,
+		SegmentName: name,
+	})
+}
+
+func (db *FileConfigDb) AddScheduledChange(change *ScheduledChange) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	return db.commit(walRecord{
+		Op:       walOpAddSchedule,
+		Schedule: change,
+	})
+}
+
+func (db *FileConfigDb) DeleteScheduledChange(id string) (bool, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	db.ConfigDb.mu.RLock()
+	_, found := db.Schedules[id]
+	db.ConfigDb.mu.RUnlock()
+	if !found {
+		return false, nil
+	}
+	err := db.commit(walRecord{
+		Op:         walOpDeleteSchedule,
+		ScheduleId: id,
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func OpenTestFileConfigDb(t *testing.T, dbConfig ConfigDbConfig) *FileConfigDb {
+	db, err := OpenFileConfigDb(dbConfig)
+	if err != nil {
+		t.Fatalf("Failed to open file config db: %v", err)
+	}
+	return db
+}
+
+func AddTestConfigs(t *testing.T, db ConfigStore, count int) {
+	for i := 0; i < count; i++ {
+		err := db.AddConfig(&Config{
+			ConfigPath:   ConfigPath{Service: "service1", Name: "config" + strconv.Itoa(i)},
+			Type:         "long",
+			DefaultValue: strconv.Itoa(i),
+		})
+		if err != nil {
+			t.Fatalf("Failed to add config: %v", err)
+		}
+	}
+}
+
+func TestFileConfigDbReplaysLog(t *testing.T) {
+	dbConfig := ConfigDbConfig{
+		Path:          filepath.Join(t.TempDir(), "configs.json"),
+		SnapshotEvery: 100,
+	}
+	db := OpenTestFileConfigDb(t, dbConfig)
+	configPath := ConfigPath{Service: "service1", Name: "config0"}
+	AddTestConfigs(t, db, 3)
+	db.AddOverride(&configPath, &Override{
+		OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"},
+		Value:       "7",
+	})
+	db.DeleteConfig(&ConfigPath{Service: "service1", Name: "config2"})
+
+	// Nothing has been snapshotted yet, so everything comes from the log.
+	if _, err := os.Stat(dbConfig.Path); !os.IsNotExist(err) {
+		t.Fatalf("Expected no snapshot before %d records, got %v", dbConfig.SnapshotEvery, err)
+	}
+
+	reopened := OpenTestFileConfigDb(t, dbConfig)
+	configs, _ := reopened.GetConfigs()
+	if len(configs) != 2 {
+		t.Errorf("Expected 2 configs after replay, but got %v", configs)
+	}
+	override, found, err := reopened.GetOverride(&configPath, &OverrideKey{EntityType: "user", EntityId: "123"})
+	if err != nil || !found || override.Value != "7" {
+		t.Errorf("Expected override with value 7 after replay, got %v %v %v", override, found, err)
+	}
+}
+
+func TestFileConfigDbCompactsIntoSnapshot(t *testing.T) {
+	dbConfig := ConfigDbConfig{
+		Path:          filepath.Join(t.TempDir(), "configs.json"),
+		SnapshotEvery: 4,
+	}
+	db := OpenTestFileConfigDb(t, dbConfig)
+	AddTestConfigs(t, db, 6)
+
+	if _, err := os.Stat(dbConfig.Path); err != nil {
+		t.Fatalf("Expected a snapshot after %d records, got %v", dbConfig.SnapshotEvery, err)
+	}
+	if db.walRecords != 2 {
+		t.Errorf("Expected 2 records left in the log, but got %d", db.walRecords)
+	}
+
+	reopened := OpenTestFileConfigDb(t, dbConfig)
+	configs, _ := reopened.GetConfigs()
+	if len(configs) != 6 {
+		t.Errorf("Expected 6 configs after reopen, but got %d", len(configs))
+	}
+
+	err := reopened.Close()
+	if err != nil {
+		t.Fatalf("Failed to close file config db: %v", err)
+	}
+	info, err := os.Stat(dbConfig.Path + ".wal")
+	if err != nil || info.Size() != 0 {
+		t.Errorf("Expected close to leave an empty log, got %v %v", info, err)
+	}
+}
+
+func TestFileConfigDbRetriesFailedSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	dbConfig := ConfigDbConfig{
+		Path:          filepath.Join(dir, "configs.json"),
+		SnapshotEvery: 2,
+	}
+	db := OpenTestFileConfigDb(t, dbConfig)
+
+	// The snapshot can't be written while its directory is missing, but the
+	// records are logged so the writes still succeed.
+	db.Config.Path = filepath.Join(dir, "missing", "configs.json")
+	AddTestConfigs(t, db, 3)
+	if db.walRecords != 3 {
+		t.Errorf("Expected 3 records left in the log, but got %d", db.walRecords)
+	}
+
+	db.Config.Path = dbConfig.Path
+	err := db.AddConfig(&Config{ConfigPath: ConfigPath{Service: "service2", Name: "config1"}, Type: "bool", DefaultValue: "true"})
+	if err != nil {
+		t.Fatalf("Failed to add config: %v", err)
+	}
+	if db.walRecords != 0 {
+		t.Errorf("Expected the next commit to snapshot, but %d records are left in the log", db.walRecords)
+	}
+
+	reopened := OpenTestFileConfigDb(t, dbConfig)
+	configs, _ := reopened.GetConfigs()
+	if len(configs) != 4 {
+		t.Errorf("Expected 4 configs after reopen, but got %d", len(configs))
+	}
+}
+
+func TestFileConfigDbRecoversFromTornWrite(t *testing.T) {
+	// Cut the last record off part way through its header and part way
+	// through its payload, as if the process died while writing it.
+	for _, cut := range []int64{5, walHeaderSize + 3} {
+		t.Run("cut"+strconv.FormatInt(cut, 10), func(t *testing.T) {
+			dbConfig := ConfigDbConfig{
+				Path:          filepath.Join(t.TempDir(), "configs.json"),
+				SnapshotEvery: 100,
+			}
+			db := OpenTestFileConfigDb(t, dbConfig)
+			AddTestConfigs(t, db, 3)
+			db.wal.Close()
+
+			walPath := dbConfig.Path + ".wal"
+			info, err := os.Stat(walPath)
+			if err != nil {
+				t.Fatalf("Failed to stat log: %v", err)
+			}
+			err = os.Truncate(walPath, info.Size()-cut)
+			if err != nil {
+				t.Fatalf("Failed to truncate log: %v", err)
+			}
+
+			recovered := OpenTestFileConfigDb(t, dbConfig)
+			configs, _ := recovered.GetConfigs()
+			if len(configs) != 2 {
+				t.Errorf("Expected the 2 complete records to be recovered, but got %v", configs)
+			}
+			_, err = recovered.GetConfig(&ConfigPath{Service: "service1", Name: "config2"})
+			if err == nil {
+				t.Errorf("Expected the torn record to be discarded")
+			}
+
+			// New writes land after the last good record and survive.
+			err = recovered.AddConfig(&Config{
+				ConfigPath:   ConfigPath{Service: "service1", Name: "config3"},
+				Type:         "long",
+				DefaultValue: "3",
+			})
+			if err != nil {
+				t.Fatalf("Failed to add config after recovery: %v", err)
+			}
+			recovered.wal.Close()
+
+			reopened := OpenTestFileConfigDb(t, dbConfig)
+			configs, _ = reopened.GetConfigs()
+			if len(configs) != 3 {
+				t.Errorf("Expected 3 configs after writing past the torn record, but got %v", configs)
+			}
+		})
+	}
+}
+
+func TestFileConfigDbStopsAtCorruptRecord(t *testing.T) {
+	dbConfig := ConfigDbConfig{
+		Path:          filepath.Join(t.TempDir(), "configs.json"),
+		SnapshotEvery: 100,
+	}
+	db := OpenTestFileConfigDb(t, dbConfig)
+	AddTestConfigs(t, db, 3)
+	db.wal.Close()
+
+	// Flip a byte inside the second record's payload.
+	walBytes, err := os.ReadFile(dbConfig.Path + ".wal")
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	firstSize := len(walBytes) / 3
+	walBytes[firstSize+walHeaderSize+2] ^= 0xff
+	err = os.WriteFile(dbConfig.Path+".wal", walBytes, 0o644)
+	if err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	recovered := OpenTestFileConfigDb(t, dbConfig)
+	configs, _ := recovered.GetConfigs()
+	if len(configs) != 1 {
+		t.Errorf("Expected only the record before the corruption to be recovered, but got %v", configs)
+	}
+}
+
+func TestFileConfigDbSkipsRecordsInSnapshot(t *testing.T) {
+	dbConfig := ConfigDbConfig{
+		Path:          filepath.Join(t.TempDir(), "configs.json"),
+		SnapshotEvery: 100,
+	}
+	db := OpenTestFileConfigDb(t, dbConfig)
+	configPath := ConfigPath{Service: "service1", Name: "config0"}
+	AddTestConfigs(t, db, 1)
+	db.DeleteConfig(&configPath)
+	db.wal.Close()
+
+	// Simulate a crash after the snapshot was written but before the log
+	// was truncated: the snapshot and the full log are both on disk.
+	walBytes, err := os.ReadFile(dbConfig.Path + ".wal")
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	db = OpenTestFileConfigDb(t, dbConfig)
+	AddTestConfigs(t, db, 1)
+	db.Close()
+	err = os.WriteFile(dbConfig.Path+".wal", walBytes, 0o644)
+	if err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	recovered := OpenTestFileConfigDb(t, dbConfig)
+	_, err = recovered.GetConfig(&configPath)
+	if err != nil {
+		t.Errorf("Expected config from the snapshot to survive replaying older records, got %v", err)
+	}
+}
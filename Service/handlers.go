@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
-	"maps"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
@@ -15,48 +18,88 @@ type HttpResponse struct {
 	Data   []byte
 }
 
-func GetConfigPath(urlVars map[string]string) (*ConfigPath, error) {
-	service, ok := urlVars["service"]
-	if !ok {
-		return nil, errors.New("missing service in url vars")
-	}
-	name, ok := urlVars["name"]
-	if !ok {
-		return nil, errors.New("missing name in url vars")
+// GetConfigPath reads the config path from the url vars of a route that
+// has {service} and {name}, mux only matches such a route when both are set.
+func GetConfigPath(urlVars map[string]string) *ConfigPath {
+	return &ConfigPath{
+		Service: urlVars["service"],
+		Name:    urlVars["name"],
 	}
+}
 
-	return &ConfigPath{
-		Service: service,
-		Name:    name,
-	}, nil
+// GetOverrideKey reads the override key from the url vars of a route that
+// has {entityType} and {entityId}.
+func GetOverrideKey(urlVars map[string]string) *OverrideKey {
+	return &OverrideKey{
+		EntityType: urlVars["entityType"],
+		EntityId:   urlVars["entityId"],
+	}
 }
 
-func GetOverrideKey(urlVars map[string]string) (*OverrideKey, error) {
-	entityType, ok := urlVars["entityType"]
-	if !ok {
-		return nil, errors.New("missing entityType in url vars")
+// DecodeRequestBody reads the JSON body of r into requestBody. Numbers are
+// decoded as json.Number so long values keep their full precision.
+func DecodeRequestBody(r *http.Request, requestBody any) error {
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read request body")
 	}
-	entityId, ok := urlVars["entityId"]
-	if !ok {
-		return nil, errors.New("missing entityId in url vars")
+	decoder := json.NewDecoder(bytes.NewReader(bodyBytes))
+	decoder.UseNumber()
+	err = decoder.Decode(requestBody)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, ErrorCodeMalformedRequest, "malformed request body: "+err.Error())
 	}
-	return &OverrideKey{
-		EntityType: entityType,
-		EntityId:   entityId,
-	}, nil
+	return nil
 }
 
 type Handlers struct {
-	ConfigDb ConfigDb
+	ConfigDb ConfigStore
+	// DefaultPriority is the entity type precedence used for configs that
+	// don't declare their own.
+	DefaultPriority []string
+	// ValueFormat is the default encoding for values in responses, callers
+	// can pick another with the valueFormat query parameter.
+	ValueFormat string
+	// Events receives every recorded change for watchers.
+	Events *EventBroker
+	// Sync is set when configs are synced from a directory.
+	Sync *DirectorySync
+
+	// writeMu serializes changes so each one sees the state left by the
+	// previous one, see changes.go.
+	writeMu *sync.Mutex
+}
+
+// GetValueFormat returns the value encoding requested by r.
+func (h *Handlers) GetValueFormat(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("valueFormat")
+	if format == "" {
+		format = h.ValueFormat
+	}
+	if format == "" {
+		format = ValueFormatTyped
+	}
+	err := ValidateValueFormat(format)
+	if err != nil {
+		return "", NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, err.Error())
+	}
+	return format, nil
 }
 
 func (h *Handlers) ListConfigs(r *http.Request) (*HttpResponse, error) {
+	format, err := h.GetValueFormat(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get value format from request")
+	}
 	configs, err := h.ConfigDb.GetConfigs()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get configs from db")
 	}
 	response := ListConfigsResponse{
-		Configs: configs,
+		Configs: make([]ConfigPayload, 0, len(configs)),
+	}
+	for _, config := range configs {
+		response.Configs = append(response.Configs, NewConfigPayload(config, format))
 	}
 	body, err := json.Marshal(response)
 	if err != nil {
@@ -71,27 +114,39 @@ func (h *Handlers) ListConfigs(r *http.Request) (*HttpResponse, error) {
 
 func (h *Handlers) PostConfig(r *http.Request) (*HttpResponse, error) {
 	var requestBody PostConfigRequest
-	bodyBytes, err := io.ReadAll(r.Body)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to read request body")
-	}
-	err = json.Unmarshal(bodyBytes, &requestBody)
+	err := DecodeRequestBody(r, &requestBody)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to decode request body")
 	}
 	if requestBody.Config.Name == "" ||
 		requestBody.Config.Service == "" {
-		return nil, errors.New("config name and service are required")
+		return nil, NewApiError(http.StatusBadRequest, ErrorCodeMissingField, "config name and service are required")
 	}
-
-	err = h.ConfigDb.AddConfig(&requestBody.Config)
+	config, err := DecodeConfigPayload(&requestBody.Config)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to add config to db")
+		return nil, err
 	}
 
 	response := PostConfigResponse{
 		Message: "Success",
 	}
+	if requestBody.Schedule != nil {
+		change := NewScheduledChange(GetActor(r), requestBody.Schedule, time.Now().UTC())
+		change.ConfigPath = config.ConfigPath
+		change.Action = ScheduledActionSetConfig
+		change.Type = config.Type
+		change.Config = &config
+		response.Scheduled, err = h.scheduleFromRequest(r, &change)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to schedule config")
+		}
+	} else {
+		err = h.SaveConfig(GetActor(r), &config)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to save config")
+		}
+	}
+
 	respBytes, err := json.Marshal(response)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to marshal response")
@@ -102,11 +157,65 @@ func (h *Handlers) PostConfig(r *http.Request) (*HttpResponse, error) {
 	}, nil
 }
 
+// PutConfig replaces the config at the request path keeping its overrides,
+// unlike PostConfig, or creates it when it doesn't exist.
+func (h *Handlers) PutConfig(r *http.Request) (*HttpResponse, error) {
+	configPath := GetConfigPath(mux.Vars(r))
+	var requestBody PutConfigRequest
+	err := DecodeRequestBody(r, &requestBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode request body")
+	}
+	bodyPath := requestBody.Config.ConfigPath
+	if (bodyPath.Service != "" || bodyPath.Name != "") && bodyPath != *configPath {
+		return nil, NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, "config service and name must match the path")
+	}
+	config, err := DecodeConfigPayload(&requestBody.Config)
+	if err != nil {
+		return nil, err
+	}
+	config.ConfigPath = *configPath
+
+	err = h.UpdateConfig(GetActor(r), &config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update config")
+	}
+
+	respBytes, err := json.Marshal(PutConfigResponse{Message: "Success"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal response")
+	}
+	return &HttpResponse{
+		Status: http.StatusOK,
+		Data:   respBytes,
+	}, nil
+}
+
+// DecodeConfigPayload reads the typed values of a config sent in a request.
+func DecodeConfigPayload(payload *ConfigPayload) (Config, error) {
+	config := payload.Config
+	var err error
+	config.DefaultValue, err = DecodeConfigValue(payload.DefaultValue)
+	if err != nil {
+		return Config{}, NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, "invalid default value: "+err.Error())
+	}
+	config.Rules, err = DecodeRules(payload.Rules)
+	if err != nil {
+		return Config{}, errors.Wrap(err, "failed to decode rules")
+	}
+	config.Rollouts, err = DecodeRollouts(payload.Rollouts)
+	if err != nil {
+		return Config{}, errors.Wrap(err, "failed to decode rollouts")
+	}
+	return config, nil
+}
+
 func (h *Handlers) GetConfig(r *http.Request) (*HttpResponse, error) {
 	urlVars := mux.Vars(r)
-	configPath, err := GetConfigPath(urlVars)
+	configPath := GetConfigPath(urlVars)
+	format, err := h.GetValueFormat(r)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get config name from request")
+		return nil, errors.Wrap(err, "failed to get value format from request")
 	}
 
 	config, err := h.ConfigDb.GetConfig(configPath)
@@ -115,7 +224,7 @@ func (h *Handlers) GetConfig(r *http.Request) (*HttpResponse, error) {
 	}
 
 	response := GetConfigResponse{
-		Config: config,
+		Config: NewConfigPayload(config, format),
 	}
 	responseBytes, err := json.Marshal(response)
 	if err != nil {
@@ -130,14 +239,11 @@ func (h *Handlers) GetConfig(r *http.Request) (*HttpResponse, error) {
 
 func (h *Handlers) DeleteConfig(r *http.Request) (*HttpResponse, error) {
 	urlVars := mux.Vars(r)
-	configPath, err := GetConfigPath(urlVars)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to get config name from request")
-	}
+	configPath := GetConfigPath(urlVars)
 
-	err = h.ConfigDb.DeleteConfig(configPath)
+	err := h.RemoveConfig(GetActor(r), configPath)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to delete config from db")
+		return nil, errors.Wrap(err, "failed to remove config")
 	}
 
 	response := DeleteConfigResponse{
@@ -155,16 +261,24 @@ func (h *Handlers) DeleteConfig(r *http.Request) (*HttpResponse, error) {
 
 func (h *Handlers) ListOverrides(r *http.Request) (*HttpResponse, error) {
 	urlVars := mux.Vars(r)
-	configPath, err := GetConfigPath(urlVars)
+	configPath := GetConfigPath(urlVars)
+	format, err := h.GetValueFormat(r)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get config name from request")
+		return nil, errors.Wrap(err, "failed to get value format from request")
+	}
+	config, err := h.ConfigDb.GetConfig(configPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get config from db")
 	}
 	overrides, err := h.ConfigDb.GetOverrides(configPath)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get overrides from db")
 	}
 	response := ListOverridesResponse{
-		Overrides: overrides,
+		Overrides: make([]OverridePayload, 0, len(overrides)),
+	}
+	for _, override := range overrides {
+		response.Overrides = append(response.Overrides, NewOverridePayload(override, config.Type, format))
 	}
 	respBytes, err := json.Marshal(response)
 	if err != nil {
@@ -178,29 +292,53 @@ func (h *Handlers) ListOverrides(r *http.Request) (*HttpResponse, error) {
 
 func (h *Handlers) PostOverride(r *http.Request) (*HttpResponse, error) {
 	urlVars := mux.Vars(r)
-	configPath, err := GetConfigPath(urlVars)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to get config name from request")
-	}
+	configPath := GetConfigPath(urlVars)
 
 	var requestBody PostConfigOverrideRequest
-	bodyBytes, err := io.ReadAll(r.Body)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to read request body")
-	}
-	err = json.Unmarshal(bodyBytes, &requestBody)
+	err := DecodeRequestBody(r, &requestBody)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to decode request body")
 	}
+	if requestBody.Override.EntityType == "" ||
+		requestBody.Override.EntityId == "" {
+		return nil, NewApiError(http.StatusBadRequest, ErrorCodeMissingField, "override entityType and entityId are required")
+	}
 
-	err = h.ConfigDb.AddOverride(configPath, &requestBody.Override)
+	override := requestBody.Override.Override
+	override.Value, err = DecodeConfigValue(requestBody.Override.Value)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to add override to db")
+		return nil, NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, "invalid override value: "+err.Error())
 	}
 
 	response := PostConfigOverrideResponse{
 		Message: "Success",
 	}
+	now := time.Now().UTC()
+	if requestBody.Schedule != nil {
+		change := NewScheduledChange(GetActor(r), requestBody.Schedule, now)
+		// A ttl runs from when the override is made.
+		err = SetOverrideExpiry(&override, requestBody.Override.TTL, change.ApplyAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get override expiry")
+		}
+		change.ConfigPath = *configPath
+		change.Action = ScheduledActionSetOverride
+		change.Override = &override
+		response.Scheduled, err = h.scheduleFromRequest(r, &change)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to schedule override")
+		}
+	} else {
+		err = SetOverrideExpiry(&override, requestBody.Override.TTL, now)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get override expiry")
+		}
+		err = h.SaveOverride(GetActor(r), configPath, &override)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to save override")
+		}
+	}
+
 	respBytes, err := json.Marshal(response)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to marshal response")
@@ -214,23 +352,25 @@ func (h *Handlers) PostOverride(r *http.Request) (*HttpResponse, error) {
 
 func (h *Handlers) GetOverride(r *http.Request) (*HttpResponse, error) {
 	urlVars := mux.Vars(r)
-	configPath, err := GetConfigPath(urlVars)
+	configPath := GetConfigPath(urlVars)
+	overrideKey := GetOverrideKey(urlVars)
+	format, err := h.GetValueFormat(r)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get config name from request")
+		return nil, errors.Wrap(err, "failed to get value format from request")
 	}
-	overrideKey, err := GetOverrideKey(urlVars)
+	config, err := h.ConfigDb.GetConfig(configPath)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get override key from request")
+		return nil, errors.Wrap(err, "failed to get config from db")
 	}
 	override, found, err := h.ConfigDb.GetOverride(configPath, overrideKey)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get override from db")
 	}
 	if !found {
-		return nil, errors.New("override not found")
+		return nil, NewApiError(http.StatusNotFound, ErrorCodeOverrideNotFound, "override not found")
 	}
 	response := GetOverrideResponse{
-		Override: override,
+		Override: NewOverridePayload(override, config.Type, format),
 	}
 	respBytes, err := json.Marshal(response)
 	if err != nil {
@@ -244,17 +384,11 @@ func (h *Handlers) GetOverride(r *http.Request) (*HttpResponse, error) {
 
 func (h *Handlers) DeleteOverride(r *http.Request) (*HttpResponse, error) {
 	urlVars := mux.Vars(r)
-	configPath, err := GetConfigPath(urlVars)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to get config name from request")
-	}
-	overrideKey, err := GetOverrideKey(urlVars)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to get override key from request")
-	}
-	err = h.ConfigDb.DeleteOverride(configPath, overrideKey)
+	configPath := GetConfigPath(urlVars)
+	overrideKey := GetOverrideKey(urlVars)
+	err := h.RemoveOverride(GetActor(r), configPath, overrideKey)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to delete override from db")
+		return nil, errors.Wrap(err, "failed to remove override")
 	}
 	response := DeleteOverrideResponse{
 		Message: "Success",
@@ -271,47 +405,31 @@ func (h *Handlers) DeleteOverride(r *http.Request) (*HttpResponse, error) {
 
 func (h *Handlers) GetConfigValue(r *http.Request) (*HttpResponse, error) {
 	urlVars := mux.Vars(r)
-	configPath, err := GetConfigPath(urlVars)
+	configPath := GetConfigPath(urlVars)
+	format, err := h.GetValueFormat(r)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get config name from request")
+		return nil, errors.Wrap(err, "failed to get value format from request")
 	}
 
-	config, err := h.ConfigDb.GetConfig(configPath)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to get config from db")
-	}
-	configValue := config.DefaultValue
-
 	var requestBody GetConfigValueRequest
-	bodyBytes, err := io.ReadAll(r.Body)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to read request body")
-	}
-	err = json.Unmarshal(bodyBytes, &requestBody)
+	err = DecodeRequestBody(r, &requestBody)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to decode request body")
 	}
 
-	entityAttributes := maps.All(requestBody.Attributes)
-	for key, value := range entityAttributes {
-		overrideKey := OverrideKey{
-			EntityType: key,
-			EntityId:   value,
-		}
-
-		override, found, err := h.ConfigDb.GetOverride(configPath, &overrideKey)
+	evaluate := h.EvaluateConfig
+	if explainStr := r.URL.Query().Get("explain"); explainStr != "" {
+		explain, err := strconv.ParseBool(explainStr)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to get override from db")
+			return nil, NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, "explain must be true or false")
 		}
-		if found {
-			configValue = override.Value
-			break
+		if explain {
+			evaluate = h.ExplainConfig
 		}
 	}
-
-	response := GetConfigValueResponse{
-		Type:  config.Type,
-		Value: configValue,
+	response, err := evaluate(configPath, requestBody.Attributes, format)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to evaluate config")
 	}
 	responseBytes, err := json.Marshal(response)
 	if err != nil {
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// ActorHeader names the caller making a change, it is recorded in the
+// config's history.
+const ActorHeader = "X-Actor"
+
+const anonymousActor = "anonymous"
+
+func GetActor(r *http.Request) string {
+	actor := r.Header.Get(ActorHeader)
+	if actor == "" {
+		return anonymousActor
+	}
+	return actor
+}
+
+// recordHistory stamps entry with the time and actor, appends it to the
+// config's history and publishes it to watchers.
+func (h *Handlers) recordHistory(actor string, entry HistoryEntry) error {
+	entry.Timestamp = time.Now().UTC()
+	entry.Actor = actor
+	err := h.ConfigDb.AddHistory(&entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to add history to db")
+	}
+	h.Events.Publish(entry)
+	return nil
+}
+
+func NewHistoryEntryPayload(entry HistoryEntry, format string) HistoryEntryPayload {
+	payload := HistoryEntryPayload{
+		HistoryEntry: entry,
+	}
+	// Old values are typed by the config they belonged to, a rollback or
+	// import can change the type.
+	oldType := entry.Type
+	if entry.OldConfig != nil {
+		oldType = entry.OldConfig.Type
+	}
+	if entry.OldConfig != nil {
+		config := NewConfigPayload(*entry.OldConfig, format)
+		payload.OldConfig = &config
+	}
+	if entry.NewConfig != nil {
+		config := NewConfigPayload(*entry.NewConfig, format)
+		payload.NewConfig = &config
+	}
+	if entry.OldOverride != nil {
+		override := NewOverridePayload(*entry.OldOverride, oldType, format)
+		payload.OldOverride = &override
+	}
+	if entry.NewOverride != nil {
+		override := NewOverridePayload(*entry.NewOverride, entry.Type, format)
+		payload.NewOverride = &override
+	}
+	for _, override := range entry.OldOverrides {
+		payload.OldOverrides = append(payload.OldOverrides, NewOverridePayload(override, oldType, format))
+	}
+	for _, override := range entry.NewOverrides {
+		payload.NewOverrides = append(payload.NewOverrides, NewOverridePayload(override, entry.Type, format))
+	}
+	return payload
+}
+
+// GetHistory lists the changes to a config, oldest first. The limit query
+// parameter keeps only the most recent entries.
+func (h *Handlers) GetHistory(r *http.Request) (*HttpResponse, error) {
+	urlVars := mux.Vars(r)
+	configPath := GetConfigPath(urlVars)
+	format, err := h.GetValueFormat(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get value format from request")
+	}
+	limit := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 0 {
+			return nil, NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, "limit must be a non-negative integer")
+		}
+	}
+
+	history, err := h.ConfigDb.GetHistory(configPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get history from db")
+	}
+	if len(history) == 0 {
+		// A config that never existed has no history at all.
+		_, err = h.ConfigDb.GetConfig(configPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get config from db")
+		}
+	}
+	if limit > 0 && len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+
+	response := GetHistoryResponse{
+		History: make([]HistoryEntryPayload, 0, len(history)),
+	}
+	for _, entry := range history {
+		response.History = append(response.History, NewHistoryEntryPayload(entry, format))
+	}
+	respBytes, err := json.Marshal(response)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal response")
+	}
+	return &HttpResponse{
+		Status: http.StatusOK,
+		Data:   respBytes,
+	}, nil
+}
+
+// GetConfigAtRevision replays history up to revision to rebuild the config
+// and its overrides as they were then.
+func GetConfigAtRevision(history []HistoryEntry, revision int64) (Config, []Override, error) {
+	var config *Config
+	overrides := make(ConfigOverrides)
+	found := false
+	for _, entry := range history {
+		if entry.Revision > revision {
+			break
+		}
+		found = entry.Revision == revision
+
+		switch entry.Action {
+		case HistoryActionCreateConfig:
+			config = entry.NewConfig
+			overrides = make(ConfigOverrides)
+		case HistoryActionUpdateConfig:
+			// OldOverrides lists the overrides the update cleared, it is
+			// empty when they were kept.
+			config = entry.NewConfig
+			for _, override := range entry.OldOverrides {
+				delete(overrides, GetOverridePathStr(&override.OverrideKey))
+			}
+		case HistoryActionDeleteConfig:
+			config = nil
+			overrides = make(ConfigOverrides)
+		case HistoryActionCreateOverride, HistoryActionUpdateOverride:
+			overrides[GetOverridePathStr(&entry.NewOverride.OverrideKey)] = *entry.NewOverride
+		case HistoryActionDeleteOverride:
+			delete(overrides, GetOverridePathStr(entry.OverrideKey))
+		case HistoryActionRollback, HistoryActionImport:
+			config = entry.NewConfig
+			overrides = make(ConfigOverrides)
+			for _, override := range entry.NewOverrides {
+				overrides[GetOverridePathStr(&override.OverrideKey)] = override
+			}
+		}
+	}
+	if !found {
+		return Config{}, nil, NewApiError(http.StatusNotFound, ErrorCodeRevisionNotFound, fmt.Sprintf("revision %d not found", revision))
+	}
+	if config == nil {
+		return Config{}, nil, NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, fmt.Sprintf("config was deleted at revision %d", revision))
+	}
+
+	values := make([]Override, 0, len(overrides))
+	for _, override := range overrides {
+		values = append(values, override)
+	}
+	slices.SortFunc(values, func(a, b Override) int {
+		return strings.Compare(GetOverridePathStr(&a.OverrideKey), GetOverridePathStr(&b.OverrideKey))
+	})
+	return *config, values, nil
+}
+
+// PostRollback restores a config and its overrides to an earlier revision,
+// including a config that has since been deleted.
+func (h *Handlers) PostRollback(r *http.Request) (*HttpResponse, error) {
+	urlVars := mux.Vars(r)
+	configPath := GetConfigPath(urlVars)
+	format, err := h.GetValueFormat(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get value format from request")
+	}
+	var requestBody RollbackConfigRequest
+	err = DecodeRequestBody(r, &requestBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode request body")
+	}
+	if requestBody.Revision <= 0 {
+		return nil, NewApiError(http.StatusBadRequest, ErrorCodeMissingField, "a positive revision is required")
+	}
+
+	config, overrides, err := h.RollbackConfig(GetActor(r), configPath, requestBody.Revision)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to roll back config")
+	}
+
+	response := RollbackConfigResponse{
+		Config:    NewConfigPayload(config, format),
+		Overrides: make([]OverridePayload, 0, len(overrides)),
+	}
+	for _, override := range overrides {
+		response.Overrides = append(response.Overrides, NewOverridePayload(override, config.Type, format))
+	}
+	respBytes, err := json.Marshal(response)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal response")
+	}
+	return &HttpResponse{
+		Status: http.StatusOK,
+		Data:   respBytes,
+	}, nil
+}
@@ -1,22 +1,58 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"Service/evaluator"
+	"Service/evaluator/evaluatortest"
+
+	"github.com/pkg/errors"
 )
 
+func BuildTestApplication(t *testing.T) Application {
+	app, err := BuildApplication()
+	if err != nil {
+		t.Fatalf("Failed to build application: %v", err)
+	}
+	return app
+}
+
+func TestBuildApplicationRejectsInvalidEnv(t *testing.T) {
+	for _, key := range []string{"CONFIG_EVENT_BUFFER", "CONFIG_DB_SNAPSHOT_EVERY", "CONFIG_SYNC_INTERVAL"} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, "soon")
+			_, err := BuildApplication()
+			if err == nil || !strings.Contains(err.Error(), key) {
+				t.Errorf("Expected an error naming %s, but got %v", key, err)
+			}
+		})
+	}
+}
+
 func MakeServerRequest(t *testing.T, call func() (*http.Response, error)) []byte {
+	return MakeServerRequestWithStatus(t, http.StatusOK, call)
+}
+
+func MakeServerRequestWithStatus(t *testing.T, status int, call func() (*http.Response, error)) []byte {
 	res, err := call()
 	if err != nil {
 		t.Fatalf("Failed to make request to test server: %v", err)
 	}
 
-	if res.StatusCode != http.StatusOK {
-		t.Errorf("Expected status code %d, but got %d", http.StatusOK, res.StatusCode)
+	if res.StatusCode != status {
+		t.Errorf("Expected status code %d, but got %d", status, res.StatusCode)
 	}
 
 	body, err := io.ReadAll(res.Body)
@@ -29,7 +65,7 @@ func MakeServerRequest(t *testing.T, call func() (*http.Response, error)) []byte
 }
 
 func TestGetConfigs(t *testing.T) {
-	app := BuildApplication()
+	app := BuildTestApplication(t)
 	subject := httptest.NewServer(BuildServer(&app))
 	defer subject.Close()
 
@@ -38,7 +74,7 @@ func TestGetConfigs(t *testing.T) {
 			Service: "service1",
 			Name:    "config1",
 		},
-		Type:         "string",
+		Type:         "str",
 		DefaultValue: "value1",
 	})
 	if err != nil {
@@ -60,7 +96,7 @@ func TestGetConfigs(t *testing.T) {
 }
 
 func TestAddConfig(t *testing.T) {
-	app := BuildApplication()
+	app := BuildTestApplication(t)
 	subject := httptest.NewServer(BuildServer(&app))
 	defer subject.Close()
 
@@ -69,7 +105,7 @@ func TestAddConfig(t *testing.T) {
 			"config": {
 				"service": "service1",
 				"name": "config2",
-				"type": "string",
+				"type": "str",
 				"defaultValue": "value2"
 			}
 		}`
@@ -100,7 +136,7 @@ func TestAddConfig(t *testing.T) {
 }
 
 func TestGetConfig(t *testing.T) {
-	app := BuildApplication()
+	app := BuildTestApplication(t)
 	subject := httptest.NewServer(BuildServer(&app))
 	defer subject.Close()
 
@@ -110,7 +146,7 @@ func TestGetConfig(t *testing.T) {
 	}
 	app.ConfigDb.AddConfig(&Config{
 		ConfigPath:   configPath,
-		Type:         "string",
+		Type:         "str",
 		DefaultValue: "value1",
 	})
 
@@ -126,7 +162,7 @@ func TestGetConfig(t *testing.T) {
 	if response.Config.Service != "service1" || response.Config.Name != "config1" {
 		t.Errorf("Expected service1/config1, got %v/%v", response.Config.Service, response.Config.Name)
 	}
-	if response.Config.Type != "string" {
+	if response.Config.Type != "str" {
 		t.Errorf("Expected type string, got %v", response.Config.Type)
 	}
 	if response.Config.DefaultValue != "value1" {
@@ -135,7 +171,7 @@ func TestGetConfig(t *testing.T) {
 }
 
 func TestDeleteConfig(t *testing.T) {
-	app := BuildApplication()
+	app := BuildTestApplication(t)
 	subject := httptest.NewServer(BuildServer(&app))
 	defer subject.Close()
 
@@ -145,7 +181,7 @@ func TestDeleteConfig(t *testing.T) {
 	}
 	app.ConfigDb.AddConfig(&Config{
 		ConfigPath:   configPath,
-		Type:         "string",
+		Type:         "str",
 		DefaultValue: "value1",
 	})
 
@@ -170,7 +206,7 @@ func TestDeleteConfig(t *testing.T) {
 }
 
 func TestGetOverrides(t *testing.T) {
-	app := BuildApplication()
+	app := BuildTestApplication(t)
 	subject := httptest.NewServer(BuildServer(&app))
 	defer subject.Close()
 
@@ -180,7 +216,7 @@ func TestGetOverrides(t *testing.T) {
 	}
 	app.ConfigDb.AddConfig(&Config{
 		ConfigPath:   configPath,
-		Type:         "string",
+		Type:         "str",
 		DefaultValue: "value1",
 	})
 	// Add multiple overrides
@@ -204,7 +240,7 @@ func TestGetOverrides(t *testing.T) {
 }
 
 func TestAddOverride(t *testing.T) {
-	app := BuildApplication()
+	app := BuildTestApplication(t)
 	subject := httptest.NewServer(BuildServer(&app))
 	defer subject.Close()
 
@@ -214,7 +250,7 @@ func TestAddOverride(t *testing.T) {
 	}
 	app.ConfigDb.AddConfig(&Config{
 		ConfigPath:   configPath,
-		Type:         "string",
+		Type:         "str",
 		DefaultValue: "value1",
 	})
 	reqBody := `{"override": {"entityType": "user", "entityId": "123", "value": "override1"}}`
@@ -244,7 +280,7 @@ func TestAddOverride(t *testing.T) {
 }
 
 func TestGetOverride(t *testing.T) {
-	app := BuildApplication()
+	app := BuildTestApplication(t)
 	subject := httptest.NewServer(BuildServer(&app))
 	defer subject.Close()
 
@@ -255,7 +291,7 @@ func TestGetOverride(t *testing.T) {
 	overrideKey := OverrideKey{EntityType: "user", EntityId: "123"}
 	app.ConfigDb.AddConfig(&Config{
 		ConfigPath:   configPath,
-		Type:         "string",
+		Type:         "str",
 		DefaultValue: "value1",
 	})
 	app.ConfigDb.AddOverride(&configPath, &Override{OverrideKey: overrideKey, Value: "override1"})
@@ -274,7 +310,7 @@ func TestGetOverride(t *testing.T) {
 }
 
 func TestDeleteOverride(t *testing.T) {
-	app := BuildApplication()
+	app := BuildTestApplication(t)
 	subject := httptest.NewServer(BuildServer(&app))
 	defer subject.Close()
 
@@ -285,7 +321,7 @@ func TestDeleteOverride(t *testing.T) {
 	overrideKey := OverrideKey{EntityType: "user", EntityId: "123"}
 	app.ConfigDb.AddConfig(&Config{
 		ConfigPath:   configPath,
-		Type:         "string",
+		Type:         "str",
 		DefaultValue: "value1",
 	})
 	app.ConfigDb.AddOverride(&configPath, &Override{OverrideKey: overrideKey, Value: "override1"})
@@ -311,7 +347,7 @@ func TestDeleteOverride(t *testing.T) {
 }
 
 func TestGetConfigDefaultValue(t *testing.T) {
-	app := BuildApplication()
+	app := BuildTestApplication(t)
 	subject := httptest.NewServer(BuildServer(&app))
 	defer subject.Close()
 
@@ -321,7 +357,7 @@ func TestGetConfigDefaultValue(t *testing.T) {
 	}
 	app.ConfigDb.AddConfig(&Config{
 		ConfigPath:   configPath,
-		Type:         "string",
+		Type:         "str",
 		DefaultValue: "value1",
 	})
 	override := Override{
@@ -349,7 +385,7 @@ func TestGetConfigDefaultValue(t *testing.T) {
 		t.Fatalf("Failed to unmarshal response body: %v", err)
 	}
 	config := response
-	if config.Type != "string" {
+	if config.Type != "str" {
 		t.Errorf("Expected type to be string, but got %v", config.Type)
 	}
 	if config.Value != "value1" {
@@ -358,7 +394,7 @@ func TestGetConfigDefaultValue(t *testing.T) {
 }
 
 func TestGetConfigValueOverrideBySingleEntity(t *testing.T) {
-	app := BuildApplication()
+	app := BuildTestApplication(t)
 	subject := httptest.NewServer(BuildServer(&app))
 	defer subject.Close()
 
@@ -368,7 +404,7 @@ func TestGetConfigValueOverrideBySingleEntity(t *testing.T) {
 	}
 	app.ConfigDb.AddConfig(&Config{
 		ConfigPath:   configPath,
-		Type:         "string",
+		Type:         "str",
 		DefaultValue: "value1",
 	})
 	override := Override{
@@ -391,7 +427,7 @@ func TestGetConfigValueOverrideBySingleEntity(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to unmarshal response body: %v", err)
 	}
-	if response.Type != "string" {
+	if response.Type != "str" {
 		t.Errorf("Expected type to be string, but got %v", response.Type)
 	}
 	if response.Value != "override1" {
@@ -400,7 +436,7 @@ func TestGetConfigValueOverrideBySingleEntity(t *testing.T) {
 }
 
 func TestGetConfigValueOverrideByMultipleEntity(t *testing.T) {
-	app := BuildApplication()
+	app := BuildTestApplication(t)
 	subject := httptest.NewServer(BuildServer(&app))
 	defer subject.Close()
 
@@ -410,7 +446,7 @@ func TestGetConfigValueOverrideByMultipleEntity(t *testing.T) {
 	}
 	app.ConfigDb.AddConfig(&Config{
 		ConfigPath:   configPath,
-		Type:         "string",
+		Type:         "str",
 		DefaultValue: "value1",
 	})
 	// Add two overrides, only one should match
@@ -437,10 +473,2083 @@ func TestGetConfigValueOverrideByMultipleEntity(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to unmarshal response body: %v", err)
 	}
-	if response.Type != "string" {
+	if response.Type != "str" {
 		t.Errorf("Expected type to be string, but got %v", response.Type)
 	}
 	if response.Value != "override1" {
 		t.Errorf("Expected value to be override1, but got %v", response.Value)
 	}
 }
+
+func TestConcurrentRequests(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	app.ConfigDb.AddConfig(&Config{
+		ConfigPath: ConfigPath{
+			Service: "service1",
+			Name:    "config1",
+		},
+		Type:         "str",
+		DefaultValue: "value1",
+	})
+
+	const workers = 8
+	const iterations = 25
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				reqBody := fmt.Sprintf(`{"config": {"service": "service2", "name": "config%d", "type": "str", "defaultValue": "value%d"}}`, worker, i)
+				MakeServerRequest(t, func() (*http.Response, error) {
+					return http.Post(subject.URL+"/configs", "application/json", strings.NewReader(reqBody))
+				})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				reqBody := fmt.Sprintf(`{"override": {"entityType": "user", "entityId": "%d-%d", "value": "override"}}`, worker, i)
+				MakeServerRequest(t, func() (*http.Response, error) {
+					return http.Post(subject.URL+"/configs/service1/config1/overrides", "application/json", strings.NewReader(reqBody))
+				})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				reqBody := fmt.Sprintf(`{"attributes": {"user": "%d-%d"}}`, worker, i)
+				MakeServerRequest(t, func() (*http.Response, error) {
+					return http.Post(subject.URL+"/configs/service1/config1/value", "application/json", strings.NewReader(reqBody))
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	overrides, err := app.ConfigDb.GetOverrides(&ConfigPath{Service: "service1", Name: "config1"})
+	if err != nil {
+		t.Fatalf("Failed to get overrides from ConfigDb: %v", err)
+	}
+	if len(overrides) != workers*iterations {
+		t.Errorf("Expected %d overrides, but got %d", workers*iterations, len(overrides))
+	}
+}
+
+func TestGetConfigValueOverridePriority(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	configPath := ConfigPath{
+		Service: "service1",
+		Name:    "config1",
+	}
+	app.ConfigDb.AddConfig(&Config{
+		ConfigPath:   configPath,
+		Type:         "str",
+		DefaultValue: "value1",
+		Priority:     []string{"user", "group", "org"},
+	})
+	app.ConfigDb.AddOverride(&configPath, &Override{
+		OverrideKey: OverrideKey{EntityType: "org", EntityId: "1"},
+		Value:       "override-org",
+	})
+	app.ConfigDb.AddOverride(&configPath, &Override{
+		OverrideKey: OverrideKey{EntityType: "group", EntityId: "456"},
+		Value:       "override-group",
+	})
+	app.ConfigDb.AddOverride(&configPath, &Override{
+		OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"},
+		Value:       "override-user",
+	})
+
+	cases := []struct {
+		attributes string
+		value      string
+		matched    *OverrideKey
+	}{
+		{`{"org": "1", "group": "456", "user": "123"}`, "override-user", &OverrideKey{EntityType: "user", EntityId: "123"}},
+		{`{"org": "1", "group": "456", "user": "999"}`, "override-group", &OverrideKey{EntityType: "group", EntityId: "456"}},
+		{`{"org": "1", "user": "999"}`, "override-org", &OverrideKey{EntityType: "org", EntityId: "1"}},
+		{`{"user": "999"}`, "value1", nil},
+	}
+	for _, c := range cases {
+		// Repeat each request so map ordering differences would show up.
+		for i := 0; i < 10; i++ {
+			body := MakeServerRequest(t, func() (*http.Response, error) {
+				return http.Post(
+					subject.URL+"/configs/service1/config1/value",
+					"application/json",
+					strings.NewReader(`{"attributes": `+c.attributes+`}`),
+				)
+			})
+
+			var response GetConfigValueResponse
+			err := json.Unmarshal(body, &response)
+			if err != nil {
+				t.Fatalf("Failed to unmarshal response body: %v", err)
+			}
+			if response.Value != c.value {
+				t.Errorf("Expected value to be %v for %v, but got %v", c.value, c.attributes, response.Value)
+			}
+			if (c.matched == nil) != (response.MatchedOverride == nil) ||
+				(c.matched != nil && *c.matched != *response.MatchedOverride) {
+				t.Errorf("Expected matched override %v for %v, but got %v", c.matched, c.attributes, response.MatchedOverride)
+			}
+		}
+	}
+}
+
+func TestGetConfigValueDefaultPriority(t *testing.T) {
+	app := BuildTestApplication(t)
+	app.Handlers.DefaultPriority = []string{"user", "group"}
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	configPath := ConfigPath{
+		Service: "service1",
+		Name:    "config1",
+	}
+	app.ConfigDb.AddConfig(&Config{
+		ConfigPath:   configPath,
+		Type:         "str",
+		DefaultValue: "value1",
+	})
+	app.ConfigDb.AddOverride(&configPath, &Override{
+		OverrideKey: OverrideKey{EntityType: "group", EntityId: "456"},
+		Value:       "override-group",
+	})
+	app.ConfigDb.AddOverride(&configPath, &Override{
+		OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"},
+		Value:       "override-user",
+	})
+	app.ConfigDb.AddOverride(&configPath, &Override{
+		OverrideKey: OverrideKey{EntityType: "device", EntityId: "abc"},
+		Value:       "override-device",
+	})
+
+	for i := 0; i < 10; i++ {
+		body := MakeServerRequest(t, func() (*http.Response, error) {
+			return http.Post(
+				subject.URL+"/configs/service1/config1/value",
+				"application/json",
+				strings.NewReader(`{"attributes": {"device": "abc", "group": "456", "user": "123"}}`),
+			)
+		})
+		var response GetConfigValueResponse
+		err := json.Unmarshal(body, &response)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response body: %v", err)
+		}
+		if response.Value != "override-user" {
+			t.Errorf("Expected value to be override-user, but got %v", response.Value)
+		}
+	}
+
+	// Entity types missing from the priority list are tried in name order.
+	body := MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(
+			subject.URL+"/configs/service1/config1/value",
+			"application/json",
+			strings.NewReader(`{"attributes": {"device": "abc", "zone": "1", "account": "2"}}`),
+		)
+	})
+	var response GetConfigValueResponse
+	err := json.Unmarshal(body, &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+	if response.Value != "override-device" {
+		t.Errorf("Expected value to be override-device, but got %v", response.Value)
+	}
+}
+
+func TestAddConfigValidatesType(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	cases := []struct {
+		configType   string
+		defaultValue string
+		status       int
+	}{
+		{"bool", "true", http.StatusOK},
+		{"bool", "false", http.StatusOK},
+		{"bool", "banana", http.StatusUnprocessableEntity},
+		{"long", "42", http.StatusOK},
+		{"long", "-7", http.StatusOK},
+		{"long", "4.2", http.StatusUnprocessableEntity},
+		{"float", "4.2", http.StatusOK},
+		{"float", "1e3", http.StatusOK},
+		{"float", "NaN", http.StatusUnprocessableEntity},
+		{"float", "four", http.StatusUnprocessableEntity},
+		{"str", "anything", http.StatusOK},
+		{"string", "anything", http.StatusUnprocessableEntity},
+		{"banana", "anything", http.StatusUnprocessableEntity},
+		{"", "anything", http.StatusUnprocessableEntity},
+	}
+	for _, c := range cases {
+		reqBody := fmt.Sprintf(`{"config": {"service": "service1", "name": "config1", "type": %q, "defaultValue": %q}}`, c.configType, c.defaultValue)
+		body := MakeServerRequestWithStatus(t, c.status, func() (*http.Response, error) {
+			return http.Post(subject.URL+"/configs", "application/json", strings.NewReader(reqBody))
+		})
+		if c.status == http.StatusOK {
+			continue
+		}
+
+		var response SimpleResponse
+		err := json.Unmarshal(body, &response)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response body: %v", err)
+		}
+		if !strings.Contains(response.Message, "invalid default value") {
+			t.Errorf("Expected message about the invalid default value for %v %v, but got %v", c.configType, c.defaultValue, response.Message)
+		}
+	}
+}
+
+func TestAddOverrideValidatesType(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	configPath := ConfigPath{
+		Service: "service1",
+		Name:    "config1",
+	}
+	app.ConfigDb.AddConfig(&Config{
+		ConfigPath:   configPath,
+		Type:         "long",
+		DefaultValue: "1",
+	})
+
+	body := MakeServerRequestWithStatus(t, http.StatusUnprocessableEntity, func() (*http.Response, error) {
+		return http.Post(
+			subject.URL+"/configs/service1/config1/overrides",
+			"application/json",
+			strings.NewReader(`{"override": {"entityType": "user", "entityId": "123", "value": "banana"}}`),
+		)
+	})
+	var response SimpleResponse
+	err := json.Unmarshal(body, &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+	if !strings.Contains(response.Message, "not a valid long") {
+		t.Errorf("Expected message about the invalid long, but got %v", response.Message)
+	}
+	_, found, _ := app.ConfigDb.GetOverride(&configPath, &OverrideKey{EntityType: "user", EntityId: "123"})
+	if found {
+		t.Errorf("Expected invalid override to be rejected, but it was stored")
+	}
+
+	MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(
+			subject.URL+"/configs/service1/config1/overrides",
+			"application/json",
+			strings.NewReader(`{"override": {"entityType": "user", "entityId": "123", "value": "42"}}`),
+		)
+	})
+}
+
+func TestGetConfigValueTyped(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	// Values may be posted either as strings or as native JSON values.
+	configs := []string{
+		`{"config": {"service": "service1", "name": "bool", "type": "bool", "defaultValue": true}}`,
+		`{"config": {"service": "service1", "name": "long", "type": "long", "defaultValue": "9007199254740993"}}`,
+		`{"config": {"service": "service1", "name": "float", "type": "float", "defaultValue": 2.5}}`,
+		`{"config": {"service": "service1", "name": "str", "type": "str", "defaultValue": "42"}}`,
+	}
+	for _, reqBody := range configs {
+		MakeServerRequest(t, func() (*http.Response, error) {
+			return http.Post(subject.URL+"/configs", "application/json", strings.NewReader(reqBody))
+		})
+	}
+	MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(
+			subject.URL+"/configs/service1/long/overrides",
+			"application/json",
+			strings.NewReader(`{"override": {"entityType": "user", "entityId": "123", "value": -12}}`),
+		)
+	})
+
+	cases := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{"bool", "", `true`},
+		{"long", "", `9007199254740993`},
+		{"float", "", `2.5`},
+		{"str", "", `"42"`},
+		{"bool", "?valueFormat=string", `"true"`},
+		{"long", "?valueFormat=string", `"9007199254740993"`},
+		{"float", "?valueFormat=string", `"2.5"`},
+	}
+	for _, c := range cases {
+		body := MakeServerRequest(t, func() (*http.Response, error) {
+			return http.Post(
+				subject.URL+"/configs/service1/"+c.name+"/value"+c.query,
+				"application/json",
+				strings.NewReader(`{"attributes": {}}`),
+			)
+		})
+		var response struct {
+			Value json.RawMessage `json:"value"`
+		}
+		err := json.Unmarshal(body, &response)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response body: %v", err)
+		}
+		if string(response.Value) != c.expected {
+			t.Errorf("Expected %v%v to be %v, but got %s", c.name, c.query, c.expected, response.Value)
+		}
+	}
+
+	body := MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/configs/service1/long/overrides/user/123")
+	})
+	if !strings.Contains(string(body), `"value":-12`) {
+		t.Errorf("Expected override value to be the number -12, but got %s", body)
+	}
+	body = MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/configs/service1/bool?valueFormat=string")
+	})
+	if !strings.Contains(string(body), `"defaultValue":"true"`) {
+		t.Errorf("Expected defaultValue to be the string true, but got %s", body)
+	}
+
+	MakeServerRequestWithStatus(t, http.StatusBadRequest, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/configs/service1/bool?valueFormat=xml")
+	})
+}
+
+func TestGetConfigValueStringFormatDefault(t *testing.T) {
+	app := BuildTestApplication(t)
+	app.Handlers.ValueFormat = ValueFormatString
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	app.ConfigDb.AddConfig(&Config{
+		ConfigPath: ConfigPath{
+			Service: "service1",
+			Name:    "config1",
+		},
+		Type:         "long",
+		DefaultValue: "42",
+	})
+
+	body := MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(
+			subject.URL+"/configs/service1/config1/value",
+			"application/json",
+			strings.NewReader(`{"attributes": {}}`),
+		)
+	})
+	var response GetConfigValueResponse
+	err := json.Unmarshal(body, &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+	if response.Value != "42" {
+		t.Errorf("Expected value to be the string 42, but got %#v", response.Value)
+	}
+}
+
+func MakeErrorRequest(t *testing.T, status int, code string, call func() (*http.Response, error)) ErrorResponse {
+	body := MakeServerRequestWithStatus(t, status, call)
+	var response ErrorResponse
+	err := json.Unmarshal(body, &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal error response body %s: %v", body, err)
+	}
+	if response.Code != code {
+		t.Errorf("Expected error code %v, but got %v", code, response.Code)
+	}
+	if response.Message == "" {
+		t.Errorf("Expected error message, but got none")
+	}
+	return response
+}
+
+func TestErrorNotFound(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	MakeErrorRequest(t, http.StatusNotFound, ErrorCodeConfigNotFound, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/configs/service1/missing")
+	})
+	MakeErrorRequest(t, http.StatusNotFound, ErrorCodeConfigNotFound, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/configs/service1/missing/overrides")
+	})
+	MakeErrorRequest(t, http.StatusNotFound, ErrorCodeConfigNotFound, func() (*http.Response, error) {
+		return http.Post(
+			subject.URL+"/configs/service1/missing/overrides",
+			"application/json",
+			strings.NewReader(`{"override": {"entityType": "user", "entityId": "123", "value": "override1"}}`),
+		)
+	})
+	MakeErrorRequest(t, http.StatusNotFound, ErrorCodeConfigNotFound, func() (*http.Response, error) {
+		return http.Post(
+			subject.URL+"/configs/service1/missing/value",
+			"application/json",
+			strings.NewReader(`{"attributes": {}}`),
+		)
+	})
+
+	app.ConfigDb.AddConfig(&Config{
+		ConfigPath: ConfigPath{
+			Service: "service1",
+			Name:    "config1",
+		},
+		Type:         "str",
+		DefaultValue: "value1",
+	})
+	MakeErrorRequest(t, http.StatusNotFound, ErrorCodeOverrideNotFound, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/configs/service1/config1/overrides/user/123")
+	})
+}
+
+func TestErrorBadRequest(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	app.ConfigDb.AddConfig(&Config{
+		ConfigPath: ConfigPath{
+			Service: "service1",
+			Name:    "config1",
+		},
+		Type:         "str",
+		DefaultValue: "value1",
+	})
+
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeMalformedRequest, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/configs", "application/json", strings.NewReader(`{"config": `))
+	})
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeMalformedRequest, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/configs/service1/config1/overrides", "application/json", strings.NewReader(`not json`))
+	})
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeMalformedRequest, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/configs/service1/config1/value", "application/json", strings.NewReader(`{"attributes": []}`))
+	})
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeMissingField, func() (*http.Response, error) {
+		return http.Post(
+			subject.URL+"/configs",
+			"application/json",
+			strings.NewReader(`{"config": {"name": "config2", "type": "str", "defaultValue": "value"}}`),
+		)
+	})
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeMissingField, func() (*http.Response, error) {
+		return http.Post(
+			subject.URL+"/configs",
+			"application/json",
+			strings.NewReader(`{"config": {"service": "service1", "type": "str", "defaultValue": "value"}}`),
+		)
+	})
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeMissingField, func() (*http.Response, error) {
+		return http.Post(
+			subject.URL+"/configs/service1/config1/overrides",
+			"application/json",
+			strings.NewReader(`{"override": {"entityType": "user", "value": "override1"}}`),
+		)
+	})
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeInvalidParameter, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/configs?valueFormat=xml")
+	})
+}
+
+func TestErrorUnprocessable(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	MakeErrorRequest(t, http.StatusUnprocessableEntity, ErrorCodeInvalidValue, func() (*http.Response, error) {
+		return http.Post(
+			subject.URL+"/configs",
+			"application/json",
+			strings.NewReader(`{"config": {"service": "service1", "name": "config1", "type": "bool", "defaultValue": "banana"}}`),
+		)
+	})
+	MakeErrorRequest(t, http.StatusUnprocessableEntity, ErrorCodeInvalidValue, func() (*http.Response, error) {
+		return http.Post(
+			subject.URL+"/configs",
+			"application/json",
+			strings.NewReader(`{"config": {"service": "service1", "name": "config1", "type": "bool", "defaultValue": {"a": 1}}}`),
+		)
+	})
+}
+
+func TestPostConfigClearsOverrides(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	configPath := ConfigPath{
+		Service: "service1",
+		Name:    "config1",
+	}
+	app.ConfigDb.AddConfig(&Config{
+		ConfigPath:   configPath,
+		Type:         "str",
+		DefaultValue: "1",
+	})
+	app.ConfigDb.AddOverride(&configPath, &Override{
+		OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"},
+		Value:       "banana",
+	})
+
+	// The override doesn't fit the new type, but it goes with the old config.
+	MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(
+			subject.URL+"/configs",
+			"application/json",
+			strings.NewReader(`{"config": {"service": "service1", "name": "config1", "type": "long", "defaultValue": 1}}`),
+		)
+	})
+	overrides, err := app.ConfigDb.GetOverrides(&configPath)
+	if err != nil || len(overrides) != 0 {
+		t.Errorf("Expected re-posting the config to clear its overrides, but got %v, error: %v", overrides, err)
+	}
+	history, err := app.ConfigDb.GetHistory(&configPath)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	entry := history[len(history)-1]
+	if entry.Action != HistoryActionUpdateConfig || len(entry.OldOverrides) != 1 || entry.OldOverrides[0].EntityId != "123" {
+		t.Errorf("Expected the update to record the cleared override, but got %v", entry)
+	}
+}
+
+func TestPutConfigKeepsOverrides(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	configPath := ConfigPath{Service: "service1", Name: "config1"}
+	app.ConfigDb.AddConfig(&Config{ConfigPath: configPath, Type: "str", DefaultValue: "1"})
+	app.ConfigDb.AddOverride(&configPath, &Override{
+		OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"},
+		Value:       "banana",
+	})
+	put := func(body string) func() (*http.Response, error) {
+		return func() (*http.Response, error) {
+			request, err := http.NewRequest("PUT", subject.URL+"/configs/service1/config1", strings.NewReader(body))
+			if err != nil {
+				return nil, err
+			}
+			return http.DefaultClient.Do(request)
+		}
+	}
+
+	MakeServerRequest(t, put(`{"config": {"type": "str", "defaultValue": "2"}}`))
+	config, err := app.ConfigDb.GetConfig(&configPath)
+	if err != nil || config.DefaultValue != "2" {
+		t.Errorf("Expected the config to be updated, but got %v, error: %v", config, err)
+	}
+	overrides, err := app.ConfigDb.GetOverrides(&configPath)
+	if err != nil || len(overrides) != 1 {
+		t.Errorf("Expected the override to be kept, but got %v, error: %v", overrides, err)
+	}
+
+	// The kept override doesn't fit the new type.
+	MakeErrorRequest(t, http.StatusConflict, ErrorCodeTypeConflict, put(`{"config": {"type": "long", "defaultValue": 1}}`))
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeInvalidParameter,
+		put(`{"config": {"service": "service1", "name": "config2", "type": "str", "defaultValue": "3"}}`))
+}
+
+func TestGetHistory(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	MakeErrorRequest(t, http.StatusNotFound, ErrorCodeConfigNotFound, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/configs/service1/config1/history")
+	})
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+		actor  string
+	}{
+		{"POST", "/configs", `{"config": {"service": "service1", "name": "config1", "type": "long", "defaultValue": 1}}`, "alice"},
+		{"POST", "/configs", `{"config": {"service": "service1", "name": "config1", "type": "long", "defaultValue": 2}}`, "bob"},
+		{"POST", "/configs/service1/config1/overrides", `{"override": {"entityType": "user", "entityId": "123", "value": 3}}`, "alice"},
+		{"POST", "/configs/service1/config1/overrides", `{"override": {"entityType": "user", "entityId": "123", "value": 4}}`, ""},
+		{"DELETE", "/configs/service1/config1/overrides/user/123", ``, "bob"},
+		{"DELETE", "/configs/service1/config1/overrides/user/123", ``, "bob"},
+		{"POST", "/configs/service1/config1/overrides", `{"override": {"entityType": "user", "entityId": "456", "value": 5}}`, "alice"},
+		{"DELETE", "/configs/service1/config1", ``, "carol"},
+	}
+	for _, request := range requests {
+		MakeServerRequest(t, func() (*http.Response, error) {
+			req, err := http.NewRequest(request.method, subject.URL+request.path, strings.NewReader(request.body))
+			if err != nil {
+				return nil, err
+			}
+			if request.actor != "" {
+				req.Header.Set(ActorHeader, request.actor)
+			}
+			return http.DefaultClient.Do(req)
+		})
+	}
+
+	body := MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/configs/service1/config1/history")
+	})
+	var response GetHistoryResponse
+	err := json.Unmarshal(body, &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+
+	expected := []struct {
+		action string
+		actor  string
+	}{
+		{HistoryActionCreateConfig, "alice"},
+		{HistoryActionUpdateConfig, "bob"},
+		{HistoryActionCreateOverride, "alice"},
+		{HistoryActionUpdateOverride, "anonymous"},
+		{HistoryActionDeleteOverride, "bob"},
+		{HistoryActionCreateOverride, "alice"},
+		{HistoryActionDeleteConfig, "carol"},
+	}
+	if len(response.History) != len(expected) {
+		t.Fatalf("Expected %d history entries, but got %d: %s", len(expected), len(response.History), body)
+	}
+	for i, entry := range response.History {
+		if entry.Action != expected[i].action || entry.Actor != expected[i].actor {
+			t.Errorf("Expected entry %d to be %v by %v, but got %v by %v", i, expected[i].action, expected[i].actor, entry.Action, entry.Actor)
+		}
+		if entry.Timestamp.IsZero() {
+			t.Errorf("Expected entry %d to have a timestamp", i)
+		}
+	}
+
+	update := response.History[1]
+	if update.OldConfig == nil || update.OldConfig.DefaultValue != float64(1) ||
+		update.NewConfig == nil || update.NewConfig.DefaultValue != float64(2) {
+		t.Errorf("Expected config update from 1 to 2, but got %v to %v", update.OldConfig, update.NewConfig)
+	}
+	overrideUpdate := response.History[3]
+	if overrideUpdate.OldOverride == nil || overrideUpdate.OldOverride.Value != float64(3) ||
+		overrideUpdate.NewOverride == nil || overrideUpdate.NewOverride.Value != float64(4) {
+		t.Errorf("Expected override update from 3 to 4, but got %v to %v", overrideUpdate.OldOverride, overrideUpdate.NewOverride)
+	}
+	deletion := response.History[6]
+	if len(deletion.OldOverrides) != 1 || deletion.OldOverrides[0].EntityId != "456" {
+		t.Errorf("Expected config deletion to record the removed override, but got %v", deletion.OldOverrides)
+	}
+
+	body = MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/configs/service1/config1/history?limit=2")
+	})
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+	if len(response.History) != 2 || response.History[1].Action != HistoryActionDeleteConfig {
+		t.Errorf("Expected the 2 most recent entries, but got %s", body)
+	}
+}
+
+func TestRollbackConfig(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	configPath := ConfigPath{Service: "service1", Name: "config1"}
+	changes := []func() error{
+		func() error {
+			return app.Handlers.SaveConfig("alice", &Config{ConfigPath: configPath, Type: "long", DefaultValue: "1"})
+		},
+		func() error {
+			return app.Handlers.SaveOverride("alice", &configPath, &Override{OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"}, Value: "2"})
+		},
+		func() error {
+			return app.Handlers.SaveConfig("alice", &Config{ConfigPath: configPath, Type: "long", DefaultValue: "3"})
+		},
+		func() error {
+			return app.Handlers.SaveOverride("alice", &configPath, &Override{OverrideKey: OverrideKey{EntityType: "user", EntityId: "456"}, Value: "4"})
+		},
+		func() error {
+			return app.Handlers.SaveOverride("alice", &configPath, &Override{OverrideKey: OverrideKey{EntityType: "user", EntityId: "456"}, Value: "5"})
+		},
+	}
+	for _, change := range changes {
+		err := change()
+		if err != nil {
+			t.Fatalf("Failed to change config: %v", err)
+		}
+	}
+	config, err := app.ConfigDb.GetConfig(&configPath)
+	if err != nil {
+		t.Fatalf("Failed to get config: %v", err)
+	}
+	if config.Revision != 5 {
+		t.Fatalf("Expected revision 5, but got %v", config.Revision)
+	}
+
+	body := MakeServerRequest(t, func() (*http.Response, error) {
+		req, err := http.NewRequest("POST", subject.URL+"/configs/service1/config1/rollback", strings.NewReader(`{"revision": 2}`))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(ActorHeader, "bob")
+		return http.DefaultClient.Do(req)
+	})
+	var response RollbackConfigResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+	if response.Config.DefaultValue != float64(1) || response.Config.Revision != 6 {
+		t.Errorf("Expected default value 1 at revision 6, but got %s", body)
+	}
+	if len(response.Overrides) != 1 || response.Overrides[0].EntityId != "123" || response.Overrides[0].Value != float64(2) {
+		t.Errorf("Expected only the user/123 override, but got %s", body)
+	}
+	overrides, err := app.ConfigDb.GetOverrides(&configPath)
+	if err != nil {
+		t.Fatalf("Failed to get overrides: %v", err)
+	}
+	if len(overrides) != 1 || overrides[0].EntityId != "123" {
+		t.Errorf("Expected overrides restored to revision 2, but got %v", overrides)
+	}
+
+	history, err := app.ConfigDb.GetHistory(&configPath)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	rollback := history[len(history)-1]
+	if rollback.Action != HistoryActionRollback || rollback.Actor != "bob" ||
+		rollback.Revision != 6 || rollback.RollbackRevision != 2 {
+		t.Errorf("Expected rollback to be recorded as revision 6, but got %v", rollback)
+	}
+	if rollback.OldConfig == nil || rollback.OldConfig.DefaultValue != "3" || len(rollback.OldOverrides) != 1 {
+		t.Errorf("Expected rollback to record the replaced state, but got %v", rollback)
+	}
+
+	// A deleted config can be brought back, and rolling back to a rollback
+	// restores what it restored.
+	err = app.Handlers.RemoveConfig("alice", &configPath)
+	if err != nil {
+		t.Fatalf("Failed to remove config: %v", err)
+	}
+	MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/configs/service1/config1/rollback", "application/json", strings.NewReader(`{"revision": 6}`))
+	})
+	config, err = app.ConfigDb.GetConfig(&configPath)
+	if err != nil {
+		t.Fatalf("Failed to get config: %v", err)
+	}
+	if config.DefaultValue != "1" || config.Revision != 8 {
+		t.Errorf("Expected default value 1 at revision 8, but got %v", config)
+	}
+	overrides, err = app.ConfigDb.GetOverrides(&configPath)
+	if err != nil || len(overrides) != 1 {
+		t.Errorf("Expected deleted overrides to be restored, but got %v, error: %v", overrides, err)
+	}
+
+	MakeErrorRequest(t, http.StatusNotFound, ErrorCodeRevisionNotFound, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/configs/service1/config1/rollback", "application/json", strings.NewReader(`{"revision": 99}`))
+	})
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeInvalidParameter, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/configs/service1/config1/rollback", "application/json", strings.NewReader(`{"revision": 7}`))
+	})
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeMissingField, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/configs/service1/config1/rollback", "application/json", strings.NewReader(`{}`))
+	})
+}
+
+func TestExportImport(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	configPath := ConfigPath{Service: "service1", Name: "config1"}
+	err := app.Handlers.SaveConfig("alice", &Config{ConfigPath: configPath, Type: "long", DefaultValue: "9007199254740993", Priority: []string{"user"}})
+	if err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	err = app.Handlers.SaveOverride("alice", &configPath, &Override{OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"}, Value: "2"})
+	if err != nil {
+		t.Fatalf("Failed to save override: %v", err)
+	}
+	err = app.Handlers.SaveConfig("alice", &Config{ConfigPath: ConfigPath{Service: "service0", Name: "config2"}, Type: "bool", DefaultValue: "true",
+		Rules: []Rule{{Priority: 1, Condition: Condition{Attribute: "country", Operator: "in", Values: []string{"US", "CA"}}, Value: "false"}}})
+	if err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	err = app.Handlers.SaveSegment("alice", &Segment{Name: "staff", EntityType: "user", EntityIds: []string{"1"}})
+	if err != nil {
+		t.Fatalf("Failed to save segment: %v", err)
+	}
+	err = app.Handlers.SaveOverride("alice", &ConfigPath{Service: "service0", Name: "config2"}, &Override{OverrideKey: OverrideKey{EntityType: SegmentEntityType, EntityId: "staff"}, Value: "true"})
+	if err != nil {
+		t.Fatalf("Failed to save override: %v", err)
+	}
+
+	exported := MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/export")
+	})
+	expected := `{"formatVersion":1,"segments":[{"name":"staff","entityType":"user","entityIds":["1"]}],"configs":[` +
+		`{"service":"service0","name":"config2","type":"bool","defaultValue":true,` +
+		`"rules":[{"priority":1,"condition":{"attribute":"country","operator":"in","values":["US","CA"]},"value":false}],` +
+		`"overrides":[{"entityType":"segment","entityId":"staff","value":true}]},` +
+		`{"service":"service1","name":"config1","type":"long","defaultValue":9007199254740993,"priority":["user"],` +
+		`"overrides":[{"entityType":"user","entityId":"123","value":2}]}]}`
+	if string(exported) != expected {
+		t.Errorf("Expected export %s, but got %s", expected, exported)
+	}
+	exportedYaml := MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/export?format=yaml")
+	})
+
+	// Both encodings load into an empty service as the same state.
+	for _, encoding := range []string{"application/json", "application/yaml"} {
+		target := BuildTestApplication(t)
+		targetServer := httptest.NewServer(BuildServer(&target))
+		document := exported
+		if encoding == "application/yaml" {
+			document = exportedYaml
+		}
+		body := MakeServerRequest(t, func() (*http.Response, error) {
+			return http.Post(targetServer.URL+"/import", encoding, bytes.NewReader(document))
+		})
+		var report ImportReport
+		err = json.Unmarshal(body, &report)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response body: %v", err)
+		}
+		if report.Mode != ImportModeMerge || len(report.Created) != 5 || report.Created[0].Segment != "staff" || len(report.Updated) != 0 {
+			t.Errorf("Expected the segment, 2 configs and 2 overrides created, but got %s", body)
+		}
+		reexported := MakeServerRequest(t, func() (*http.Response, error) {
+			return http.Get(targetServer.URL + "/export")
+		})
+		if string(reexported) != expected {
+			t.Errorf("Expected %s import to round trip, but got %s", encoding, reexported)
+		}
+		targetServer.Close()
+	}
+
+	// Replace deletes what the document doesn't list, a dry run only
+	// reports it.
+	document := `{"configs":[{"service":"service1","name":"config1","type":"long","defaultValue":"5","priority":["user"],
+		"overrides":[{"entityType":"user","entityId":"456","value":6}]}]}`
+	body := MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/import?mode=replace&dryRun=true", "application/json", strings.NewReader(document))
+	})
+	var report ImportReport
+	err = json.Unmarshal(body, &report)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+	if !report.DryRun || len(report.Created) != 1 || report.Created[0].Override.EntityId != "456" ||
+		len(report.Updated) != 1 || report.Updated[0].Override != nil || len(report.Deleted) != 2 {
+		t.Errorf("Expected an override created, the config updated and 2 deletions, but got %s", body)
+	}
+	config, err := app.ConfigDb.GetConfig(&configPath)
+	if err != nil || config.DefaultValue != "9007199254740993" {
+		t.Errorf("Expected a dry run to change nothing, but got %v, error: %v", config, err)
+	}
+
+	MakeServerRequest(t, func() (*http.Response, error) {
+		req, err := http.NewRequest("POST", subject.URL+"/import?mode=replace", strings.NewReader(document))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(ActorHeader, "bob")
+		return http.DefaultClient.Do(req)
+	})
+	configs, err := app.ConfigDb.GetConfigs()
+	if err != nil || len(configs) != 1 || configs[0].DefaultValue != "5" || configs[0].Revision != 3 {
+		t.Errorf("Expected only config1 left at revision 3, but got %v, error: %v", configs, err)
+	}
+	overrides, err := app.ConfigDb.GetOverrides(&configPath)
+	if err != nil || len(overrides) != 1 || overrides[0].EntityId != "456" {
+		t.Errorf("Expected only the imported override, but got %v, error: %v", overrides, err)
+	}
+	history, err := app.ConfigDb.GetHistory(&configPath)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	entry := history[len(history)-1]
+	if entry.Action != HistoryActionImport || entry.Actor != "bob" || len(entry.OldOverrides) != 1 || len(entry.NewOverrides) != 1 {
+		t.Errorf("Expected the import to be recorded, but got %v", entry)
+	}
+
+	// Importing the same document again changes nothing.
+	body = MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/import?mode=replace", "application/json", strings.NewReader(document))
+	})
+	if string(body) != `{"mode":"replace","dryRun":false,"created":[],"updated":[],"deleted":[]}` {
+		t.Errorf("Expected an empty report, but got %s", body)
+	}
+
+	// A single invalid value rejects the whole document.
+	MakeErrorRequest(t, http.StatusUnprocessableEntity, ErrorCodeInvalidValue, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/import", "application/json", strings.NewReader(`{"configs":[
+			{"service":"service2","name":"config1","type":"bool","defaultValue":true},
+			{"service":"service2","name":"config2","type":"long","defaultValue":"x"}]}`))
+	})
+	_, err = app.ConfigDb.GetConfig(&ConfigPath{Service: "service2", Name: "config1"})
+	if !errors.Is(err, ErrConfigNotFound) {
+		t.Errorf("Expected nothing imported from an invalid document, but got %v", err)
+	}
+	MakeErrorRequest(t, http.StatusConflict, ErrorCodeTypeConflict, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/import", "application/json", strings.NewReader(`{"configs":[
+			{"service":"service1","name":"config1","type":"bool","defaultValue":true}]}`))
+	})
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeInvalidParameter, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/import?mode=overwrite", "application/json", strings.NewReader(document))
+	})
+	MakeErrorRequest(t, http.StatusNotFound, ErrorCodeSegmentNotFound, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/import", "application/json", strings.NewReader(`{"configs":[
+			{"service":"service2","name":"config1","type":"bool","defaultValue":true,
+			"overrides":[{"entityType":"segment","entityId":"testers","value":false}]}]}`))
+	})
+	// Replace leaves segments alone.
+	_, err = app.ConfigDb.GetSegment("staff")
+	if err != nil {
+		t.Errorf("Expected the segment to be kept, but got %v", err)
+	}
+}
+
+type WatchEvent struct {
+	Id   string
+	Type string
+	Data string
+}
+
+// OpenWatch starts a watch, the stream is closed when the test ends.
+func OpenWatch(t *testing.T, url string, lastEventId string) *bufio.Reader {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		t.Fatalf("Failed to create watch request: %v", err)
+	}
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open watch: %v", err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, but got %v", res.StatusCode)
+	}
+	if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Expected an event stream, but got %v", contentType)
+	}
+	return bufio.NewReader(res.Body)
+}
+
+// ReadWatchEvent reads the next event from a watch, skipping comments.
+func ReadWatchEvent(t *testing.T, reader *bufio.Reader) WatchEvent {
+	var event WatchEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read watch event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if event.Type != "" {
+				return event
+			}
+			continue
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			event.Id = value
+		case "event":
+			event.Type = value
+		case "data":
+			event.Data = value
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	// Closed after the watches, it waits for open streams to finish.
+	t.Cleanup(subject.Close)
+
+	watch := OpenWatch(t, subject.URL+"/watch?service=service1", "")
+
+	configPath := ConfigPath{Service: "service1", Name: "config1"}
+	changes := []func() error{
+		func() error {
+			return app.Handlers.SaveConfig("alice", &Config{ConfigPath: ConfigPath{Service: "service2", Name: "config1"}, Type: "bool", DefaultValue: "true"})
+		},
+		func() error {
+			return app.Handlers.SaveConfig("alice", &Config{ConfigPath: configPath, Type: "bool", DefaultValue: "true"})
+		},
+		func() error {
+			return app.Handlers.SaveOverride("bob", &configPath, &Override{OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"}, Value: "false"})
+		},
+	}
+	for _, change := range changes {
+		err := change()
+		if err != nil {
+			t.Fatalf("Failed to change config: %v", err)
+		}
+	}
+
+	eventId := func(id uint64) string {
+		return FormatEventId(app.Handlers.Events.Epoch(), id)
+	}
+	event := ReadWatchEvent(t, watch)
+	if event.Id != eventId(2) || event.Type != WatchEventChange {
+		t.Fatalf("Expected change event 2, but got %v", event)
+	}
+	var entry HistoryEntryPayload
+	err := json.Unmarshal([]byte(event.Data), &entry)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal event data: %v", err)
+	}
+	if entry.ConfigPath != configPath || entry.Action != HistoryActionCreateConfig ||
+		entry.NewConfig == nil || entry.NewConfig.DefaultValue != true {
+		t.Errorf("Expected service1/config1 to be created, but got %s", event.Data)
+	}
+	event = ReadWatchEvent(t, watch)
+	err = json.Unmarshal([]byte(event.Data), &entry)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal event data: %v", err)
+	}
+	if event.Id != eventId(3) || entry.Action != HistoryActionCreateOverride || entry.Actor != "bob" ||
+		entry.NewOverride == nil || entry.NewOverride.Value != false {
+		t.Errorf("Expected user/123 override to be created, but got %v", event)
+	}
+
+	// Resuming replays what was missed.
+	resumed := OpenWatch(t, subject.URL+"/watch?config=service1/config1", eventId(2))
+	event = ReadWatchEvent(t, resumed)
+	if event.Id != eventId(3) || event.Type != WatchEventChange {
+		t.Errorf("Expected resumed watch to replay event 3, but got %v", event)
+	}
+
+	// Ids the service doesn't know about can't be resumed from.
+	reset := OpenWatch(t, subject.URL+"/watch", eventId(99))
+	event = ReadWatchEvent(t, reset)
+	if event.Id != eventId(3) || event.Type != WatchEventReset || event.Data != `{"lastEventId":"`+eventId(3)+`"}` {
+		t.Errorf("Expected reset event, but got %v", event)
+	}
+
+	// Nor can ids from before a restart, even once the new counter has
+	// passed them.
+	restarted := OpenWatch(t, subject.URL+"/watch", FormatEventId("previous", 2))
+	event = ReadWatchEvent(t, restarted)
+	if event.Id != eventId(3) || event.Type != WatchEventReset {
+		t.Errorf("Expected reset event for an id from another epoch, but got %v", event)
+	}
+
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeInvalidParameter, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/watch?config=service1")
+	})
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeInvalidParameter, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/watch?lastEventId=3")
+	})
+}
+
+func TestPollService(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	poll := func(query string) PollServiceResponse {
+		body := MakeServerRequest(t, func() (*http.Response, error) {
+			return http.Get(subject.URL + "/services/service1/poll" + query)
+		})
+		var response PollServiceResponse
+		err := json.Unmarshal(body, &response)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response body: %v", err)
+		}
+		return response
+	}
+
+	revision := func(id uint64) string {
+		return FormatEventId(app.Handlers.Events.Epoch(), id)
+	}
+	response := poll("")
+	if !response.Reset || response.Revision != revision(0) || len(response.Configs) != 0 {
+		t.Errorf("Expected an empty reset at revision 0, but got %v", response)
+	}
+
+	config1 := ConfigPath{Service: "service1", Name: "config1"}
+	app.Handlers.SaveConfig("alice", &Config{ConfigPath: config1, Type: "long", DefaultValue: "1"})
+	app.Handlers.SaveConfig("alice", &Config{ConfigPath: ConfigPath{Service: "service2", Name: "config1"}, Type: "long", DefaultValue: "1"})
+	response = poll("?revision=" + revision(0))
+	if response.Reset || response.Revision != revision(2) || len(response.Configs) != 1 ||
+		response.Configs[0].ConfigPath != config1 || response.Configs[0].DefaultValue != float64(1) {
+		t.Errorf("Expected service1/config1 to have changed by revision 2, but got %v", response)
+	}
+
+	// A poll with nothing new waits for the next change in the service.
+	results := make(chan PollServiceResponse)
+	go func() {
+		results <- poll("?revision=" + revision(2) + "&timeout=5")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	app.Handlers.SaveConfig("alice", &Config{ConfigPath: ConfigPath{Service: "service2", Name: "config2"}, Type: "long", DefaultValue: "2"})
+	app.Handlers.RemoveConfig("alice", &config1)
+	response = <-results
+	if response.Revision != revision(4) || len(response.Configs) != 0 ||
+		len(response.Deleted) != 1 || response.Deleted[0] != "config1" {
+		t.Errorf("Expected service1/config1 to be deleted at revision 4, but got %v", response)
+	}
+
+	response = poll("?revision=" + revision(4) + "&timeout=0")
+	if response.Revision != revision(4) || len(response.Configs) != 0 || len(response.Deleted) != 0 {
+		t.Errorf("Expected no changes after the timeout, but got %v", response)
+	}
+
+	app.Handlers.SaveConfig("alice", &Config{ConfigPath: ConfigPath{Service: "service1", Name: "config3"}, Type: "long", DefaultValue: "3"})
+	response = poll("?revision=" + revision(99))
+	if !response.Reset || response.Revision != revision(5) || len(response.Configs) != 1 || response.Configs[0].Name != "config3" {
+		t.Errorf("Expected an unknown revision to reset to every config at revision 5, but got %v", response)
+	}
+
+	// A cursor from before a restart resets too, even once the new revisions
+	// have caught up with it.
+	response = poll("?revision=" + FormatEventId("previous", 1) + "&timeout=5")
+	if !response.Reset || response.Revision != revision(5) || len(response.Configs) != 1 {
+		t.Errorf("Expected a revision from another epoch to reset, but got %v", response)
+	}
+
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeInvalidParameter, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/services/service1/poll?revision=1")
+	})
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeInvalidParameter, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/services/service1/poll?revision=" + revision(1) + "&timeout=-1")
+	})
+}
+
+func TestGetValuesBatch(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	configs := []Config{
+		{ConfigPath: ConfigPath{Service: "service1", Name: "config1"}, Type: "bool", DefaultValue: "false"},
+		{ConfigPath: ConfigPath{Service: "service1", Name: "config2"}, Type: "long", DefaultValue: "1"},
+		{ConfigPath: ConfigPath{Service: "service2", Name: "config1"}, Type: "str", DefaultValue: "default"},
+	}
+	for _, config := range configs {
+		app.ConfigDb.AddConfig(&config)
+	}
+	app.ConfigDb.AddOverride(&configs[0].ConfigPath, &Override{OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"}, Value: "true"})
+	app.ConfigDb.AddOverride(&configs[2].ConfigPath, &Override{OverrideKey: OverrideKey{EntityType: "group", EntityId: "456"}, Value: "group"})
+
+	body := MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(
+			subject.URL+"/services/service1/values",
+			"application/json",
+			strings.NewReader(`{"attributes": {"user": "123", "group": "456"}, "names": ["config2", "config1", "missing"]}`))
+	})
+	var response GetValuesResponse
+	err := json.Unmarshal(body, &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+	if len(response.Values) != 3 {
+		t.Fatalf("Expected 3 values, but got %s", body)
+	}
+	if response.Values[0].Name != "config2" || response.Values[0].Value != float64(1) || response.Values[0].MatchedOverride != nil {
+		t.Errorf("Expected config2 to use its default value, but got %v", response.Values[0])
+	}
+	if response.Values[1].Name != "config1" || response.Values[1].Value != true ||
+		response.Values[1].MatchedOverride == nil || response.Values[1].MatchedOverride.EntityType != "user" {
+		t.Errorf("Expected config1 to match the user override, but got %v", response.Values[1])
+	}
+	if response.Values[2].Name != "missing" || response.Values[2].Error == nil || response.Values[2].Error.Code != ErrorCodeConfigNotFound {
+		t.Errorf("Expected missing config to report an error, but got %v", response.Values[2])
+	}
+
+	body = MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(
+			subject.URL+"/values?valueFormat=string",
+			"application/json",
+			strings.NewReader(`{"attributes": {"user": "123", "group": "456"}, "configs": [
+				{"service": "service1", "name": "config1"},
+				{"service": "service2", "name": "config1"}
+			]}`))
+	})
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+	if len(response.Values) != 2 || response.Values[0].Service != "service1" || response.Values[0].Value != "true" ||
+		response.Values[1].Service != "service2" || response.Values[1].Value != "group" {
+		t.Errorf("Expected values from both services, but got %s", body)
+	}
+
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeMissingField, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/services/service1/values", "application/json", strings.NewReader(`{"names": []}`))
+	})
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeMissingField, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/values", "application/json", strings.NewReader(`{"configs": [{"service": "service1"}]}`))
+	})
+}
+
+// TestEvaluatorCorpus runs the evaluator's corpus against the service, both
+// through the value endpoint and through a downloaded snapshot, so the two
+// can't disagree.
+func TestEvaluatorCorpus(t *testing.T) {
+	corpus, err := evaluatortest.LoadCorpus()
+	if err != nil {
+		t.Fatalf("Failed to load corpus: %v", err)
+	}
+	for _, corpusCase := range corpus {
+		t.Run(corpusCase.Name, func(t *testing.T) {
+			app := BuildTestApplication(t)
+			app.Handlers.DefaultPriority = corpusCase.DefaultPriority
+			subject := httptest.NewServer(BuildServer(&app))
+			defer subject.Close()
+
+			for _, corpusSegment := range corpusCase.Segments {
+				segment := Segment{Name: corpusSegment.Name, EntityType: corpusSegment.EntityType, EntityIds: corpusSegment.EntityIds}
+				if corpusSegment.Condition != nil {
+					segment.Condition = &Condition{}
+					err := json.Unmarshal(corpusSegment.Condition, segment.Condition)
+					if err != nil {
+						t.Fatalf("Failed to decode condition of segment %s: %v", corpusSegment.Name, err)
+					}
+				}
+				app.ConfigDb.AddSegment(&segment)
+			}
+			for _, corpusConfig := range corpusCase.Configs {
+				configPath := ConfigPath{Service: evaluatortest.Service, Name: corpusConfig.Name}
+				config := Config{
+					ConfigPath:   configPath,
+					Type:         corpusConfig.Type,
+					DefaultValue: corpusConfig.DefaultValue,
+					Priority:     corpusConfig.Priority,
+				}
+				for _, corpusRule := range corpusConfig.Rules {
+					rule := Rule{Name: corpusRule.Name, Priority: corpusRule.Priority, Value: corpusRule.Value}
+					err := json.Unmarshal(corpusRule.Condition, &rule.Condition)
+					if err != nil {
+						t.Fatalf("Failed to decode condition of %s: %v", corpusConfig.Name, err)
+					}
+					config.Rules = append(config.Rules, rule)
+				}
+				for _, rollout := range corpusConfig.Rollouts {
+					config.Rollouts = append(config.Rollouts, Rollout(rollout))
+				}
+				app.ConfigDb.AddConfig(&config)
+				for _, override := range corpusConfig.Overrides {
+					app.ConfigDb.AddOverride(&configPath, &Override{
+						OverrideKey: OverrideKey(override.OverrideKey),
+						Value:       override.Value,
+						ExpiresAt:   override.ExpiresAt,
+					})
+				}
+			}
+
+			body := MakeServerRequest(t, func() (*http.Response, error) {
+				return http.Get(subject.URL + "/services/" + evaluatortest.Service + "/snapshot")
+			})
+			snapshot, err := evaluator.Parse(body)
+			if err != nil {
+				t.Fatalf("Failed to parse snapshot: %v", err)
+			}
+
+			for _, evaluation := range corpusCase.Evaluations {
+				requestBytes, err := json.Marshal(GetConfigValueRequest{Attributes: evaluation.Attributes})
+				if err != nil {
+					t.Fatalf("Failed to marshal request: %v", err)
+				}
+				url := subject.URL + "/configs/" + evaluatortest.Service + "/" + evaluation.Config + "/value?valueFormat=string"
+				post := func() (*http.Response, error) {
+					return http.Post(url, "application/json", strings.NewReader(string(requestBytes)))
+				}
+				local, localErr := snapshot.Evaluate(evaluation.Config, evaluation.Attributes)
+
+				if evaluation.NotFound {
+					MakeErrorRequest(t, http.StatusNotFound, ErrorCodeConfigNotFound, post)
+					if !errors.Is(localErr, evaluator.ErrConfigNotFound) {
+						t.Errorf("Expected %s to be missing from the snapshot, but got %v, error: %v", evaluation.Config, local, localErr)
+					}
+					continue
+				}
+				var response GetConfigValueResponse
+				err = json.Unmarshal(MakeServerRequest(t, post), &response)
+				if err != nil {
+					t.Fatalf("Failed to unmarshal response body: %v", err)
+				}
+				if response.Value != evaluation.Value || !MatchesCorpusKey(response.MatchedOverride, evaluation.MatchedOverride) ||
+					!MatchesCorpusRule(response.MatchedRule, evaluation.MatchedRule) ||
+					!MatchesCorpusRollout(response.MatchedRollout, evaluation.MatchedRollout) {
+					t.Errorf("Expected %s with %v to be %q from %v, but the service returned %v from %v",
+						evaluation.Config, evaluation.Attributes, evaluation.Value, evaluation.MatchedOverride, response.Value, response.MatchedOverride)
+				}
+				explained, err := app.Handlers.ExplainConfig(&ConfigPath{Service: evaluatortest.Service, Name: evaluation.Config}, evaluation.Attributes, ValueFormatString)
+				if err != nil || explained.Value != evaluation.Value || !MatchesCorpusKey(explained.MatchedOverride, evaluation.MatchedOverride) ||
+					!MatchesCorpusRule(explained.MatchedRule, evaluation.MatchedRule) ||
+					!MatchesCorpusRollout(explained.MatchedRollout, evaluation.MatchedRollout) {
+					t.Errorf("Expected %s with %v to be explained as %q from %v, but got %v from %v, error: %v",
+						evaluation.Config, evaluation.Attributes, evaluation.Value, evaluation.MatchedOverride, explained.Value, explained.MatchedOverride, err)
+				}
+
+				if localErr != nil {
+					t.Fatalf("Failed to evaluate %s from the snapshot: %v", evaluation.Config, localErr)
+				}
+				var localKey *OverrideKey
+				if local.MatchedOverride != nil {
+					localKey = (*OverrideKey)(local.MatchedOverride)
+				}
+				if local.Value != evaluation.Value || local.Type != corpusTypeOf(&corpusCase, evaluation.Config) ||
+					!MatchesCorpusKey(localKey, evaluation.MatchedOverride) ||
+					!MatchesCorpusRule((*RuleMatch)(local.MatchedRule), evaluation.MatchedRule) ||
+					!MatchesCorpusRollout((*RolloutMatch)(local.MatchedRollout), evaluation.MatchedRollout) {
+					t.Errorf("Expected %s with %v to be %q from %v, but the snapshot returned %v",
+						evaluation.Config, evaluation.Attributes, evaluation.Value, evaluation.MatchedOverride, local)
+				}
+			}
+		})
+	}
+}
+
+func MatchesCorpusKey(actual *OverrideKey, expected *evaluatortest.OverrideKey) bool {
+	if actual == nil || expected == nil {
+		return actual == nil && expected == nil
+	}
+	return actual.EntityType == expected.EntityType && actual.EntityId == expected.EntityId
+}
+
+func MatchesCorpusRule(actual *RuleMatch, expected *int) bool {
+	if actual == nil || expected == nil {
+		return actual == nil && expected == nil
+	}
+	return actual.Index == *expected
+}
+
+func MatchesCorpusRollout(actual *RolloutMatch, expected *int) bool {
+	if actual == nil || expected == nil {
+		return actual == nil && expected == nil
+	}
+	return actual.Index == *expected
+}
+
+func corpusTypeOf(corpusCase *evaluatortest.Case, name string) string {
+	for _, config := range corpusCase.Configs {
+		if config.Name == name {
+			return config.Type
+		}
+	}
+	return ""
+}
+
+func TestGetServiceSnapshot(t *testing.T) {
+	app := BuildTestApplication(t)
+	app.Handlers.DefaultPriority = []string{"user", "group"}
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	config1 := ConfigPath{Service: "service1", Name: "config1"}
+	app.Handlers.SaveConfig("alice", &Config{ConfigPath: config1, Type: "long", DefaultValue: "1", Priority: []string{"group"}})
+	app.Handlers.SaveOverride("alice", &config1, &Override{OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"}, Value: "2"})
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	app.Handlers.SaveOverride("alice", &config1, &Override{OverrideKey: OverrideKey{EntityType: "user", EntityId: "456"}, Value: "3", ExpiresAt: &expiresAt})
+	app.Handlers.SaveConfig("alice", &Config{ConfigPath: ConfigPath{Service: "service1", Name: "config2"}, Type: "bool", DefaultValue: "true"})
+	app.Handlers.SaveConfig("alice", &Config{ConfigPath: ConfigPath{Service: "service2", Name: "config1"}, Type: "bool", DefaultValue: "true"})
+
+	body := MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/services/service1/snapshot")
+	})
+	var snapshot evaluator.Snapshot
+	err := json.Unmarshal(body, &snapshot)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+	if snapshot.FormatVersion != evaluator.FormatVersion || snapshot.Service != "service1" || snapshot.Revision != FormatEventId(app.Handlers.Events.Epoch(), 5) {
+		t.Errorf("Expected a service1 snapshot at revision 5, but got %s", body)
+	}
+	if len(snapshot.DefaultPriority) != 2 || len(snapshot.Configs) != 2 {
+		t.Errorf("Expected the service priority and 2 configs, but got %s", body)
+	}
+	config := snapshot.Configs["config1"]
+	if config.Type != "long" || config.DefaultValue != "1" || config.Revision != 3 || len(config.Priority) != 1 ||
+		config.Overrides["user"]["123"].Value != "2" || config.Overrides["user"]["456"].Value != "3" {
+		t.Errorf("Expected config1 with both overrides at revision 3, but got %v", config)
+	}
+	if !strings.Contains(string(body), `"123":"2"`) || config.Overrides["user"]["123"].ExpiresAt != nil ||
+		config.Overrides["user"]["456"].ExpiresAt == nil || !config.Overrides["user"]["456"].ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Expected only the expiring override to carry expiresAt, but got %s", body)
+	}
+	if snapshot.Configs["config2"].Overrides != nil {
+		t.Errorf("Expected config2 to have no overrides, but got %v", snapshot.Configs["config2"])
+	}
+}
+
+func TestConfigRollouts(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/configs", "application/json", strings.NewReader(`{"config": {
+			"service": "service1", "name": "launch", "type": "bool", "defaultValue": false,
+			"rollouts": [{"entityType": "user", "percentage": 30, "value": true}]}}`))
+	})
+	body := MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/configs/service1/launch")
+	})
+	var config GetConfigResponse
+	err := json.Unmarshal(body, &config)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+	if len(config.Config.Rollouts) != 1 || config.Config.Rollouts[0].Percentage != 30 || config.Config.Rollouts[0].Value != true {
+		t.Errorf("Expected a typed 30%% rollout, but got %s", body)
+	}
+
+	value := func(user string) GetConfigValueResponse {
+		body := MakeServerRequest(t, func() (*http.Response, error) {
+			return http.Post(subject.URL+"/configs/service1/launch/value", "application/json",
+				strings.NewReader(`{"attributes": {"user": "`+user+`"}}`))
+		})
+		var response GetConfigValueResponse
+		err := json.Unmarshal(body, &response)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response body: %v", err)
+		}
+		return response
+	}
+	response := value("5")
+	if response.Value != true || response.MatchedRollout == nil ||
+		*response.MatchedRollout != (RolloutMatch{Index: 0, EntityType: "user", EntityId: "5", Bucket: 952}) {
+		t.Errorf("Expected user 5 in the rollout in bucket 952, but got %v", response)
+	}
+	response = value("2")
+	if response.Value != false || response.MatchedRollout != nil {
+		t.Errorf("Expected user 2 outside the rollout, but got %v", response)
+	}
+
+	// Overrides take precedence over rollouts.
+	err = app.Handlers.SaveOverride("alice", &ConfigPath{Service: "service1", Name: "launch"}, &Override{OverrideKey: OverrideKey{EntityType: "user", EntityId: "5"}, Value: "false"})
+	if err != nil {
+		t.Fatalf("Failed to save override: %v", err)
+	}
+	response = value("5")
+	if response.Value != false || response.MatchedOverride == nil || response.MatchedRollout != nil {
+		t.Errorf("Expected the override to win over the rollout, but got %v", response)
+	}
+
+	invalid := []struct {
+		status  int
+		code    string
+		rollout string
+	}{
+		{http.StatusUnprocessableEntity, ErrorCodeInvalidValue, `{"entityType": "user", "percentage": 120, "value": true}`},
+		{http.StatusUnprocessableEntity, ErrorCodeInvalidValue, `{"entityType": "user", "percentage": 10, "value": "yes"}`},
+		{http.StatusBadRequest, ErrorCodeMissingField, `{"percentage": 10, "value": true}`},
+	}
+	for _, test := range invalid {
+		MakeErrorRequest(t, test.status, test.code, func() (*http.Response, error) {
+			return http.Post(subject.URL+"/configs", "application/json", strings.NewReader(`{"config": {
+				"service": "service1", "name": "launch", "type": "bool", "defaultValue": false,
+				"rollouts": [`+test.rollout+`]}}`))
+		})
+	}
+}
+
+func TestConfigRules(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/configs", "application/json", strings.NewReader(`{"config": {
+			"service": "service1", "name": "limit", "type": "long", "defaultValue": 10,
+			"rules": [
+				{"name": "new clients", "priority": 5, "value": 100, "condition": {"all": [
+					{"attribute": "platform", "operator": "in", "values": ["ios", "android"]},
+					{"attribute": "appVersion", "operator": "semverGte", "values": ["2.0.0"]}]}},
+				{"priority": 1, "value": 50, "condition": {"attribute": "plan", "operator": "equals", "values": ["pro"]}}
+			]}}`))
+	})
+	body := MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/configs/service1/limit")
+	})
+	var config GetConfigResponse
+	err := json.Unmarshal(body, &config)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+	if len(config.Config.Rules) != 2 || config.Config.Rules[0].Value != float64(100) ||
+		len(config.Config.Rules[0].Condition.All) != 2 || config.Config.Rules[1].Condition.Operator != "equals" {
+		t.Errorf("Expected both typed rules, but got %s", body)
+	}
+
+	value := func(attributes string) GetConfigValueResponse {
+		body := MakeServerRequest(t, func() (*http.Response, error) {
+			return http.Post(subject.URL+"/configs/service1/limit/value", "application/json",
+				strings.NewReader(`{"attributes": `+attributes+`}`))
+		})
+		var response GetConfigValueResponse
+		err := json.Unmarshal(body, &response)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response body: %v", err)
+		}
+		return response
+	}
+	response := value(`{"platform": "ios", "appVersion": "2.3.1", "plan": "pro"}`)
+	if response.Value != float64(100) || response.MatchedRule == nil || *response.MatchedRule != (RuleMatch{Index: 0, Name: "new clients"}) {
+		t.Errorf("Expected the higher priority rule to win, but got %v", response)
+	}
+	response = value(`{"platform": "ios", "appVersion": "1.9.0", "plan": "pro"}`)
+	if response.Value != float64(50) || response.MatchedRule == nil || response.MatchedRule.Index != 1 {
+		t.Errorf("Expected the pro plan rule to match old clients, but got %v", response)
+	}
+	response = value(`{"platform": "web"}`)
+	if response.Value != float64(10) || response.MatchedRule != nil {
+		t.Errorf("Expected the default without a matching rule, but got %v", response)
+	}
+
+	// Overrides take precedence over rules.
+	err = app.Handlers.SaveOverride("alice", &ConfigPath{Service: "service1", Name: "limit"}, &Override{OverrideKey: OverrideKey{EntityType: "plan", EntityId: "pro"}, Value: "20"})
+	if err != nil {
+		t.Fatalf("Failed to save override: %v", err)
+	}
+	response = value(`{"platform": "ios", "appVersion": "2.3.1", "plan": "pro"}`)
+	if response.Value != float64(20) || response.MatchedOverride == nil || response.MatchedRule != nil {
+		t.Errorf("Expected the override to win over the rules, but got %v", response)
+	}
+
+	invalid := []string{
+		`{"priority": 1, "value": 1, "condition": {"attribute": "plan", "operator": "like", "values": ["pro"]}}`,
+		`{"priority": 1, "value": 1, "condition": {"attribute": "email", "operator": "matches", "values": ["("]}}`,
+		`{"priority": 1, "value": 1, "condition": {"attribute": "age", "operator": "between", "values": ["1"]}}`,
+		`{"priority": 1, "value": 1, "condition": {}}`,
+		`{"priority": 1, "value": "many", "condition": {"attribute": "plan", "operator": "equals", "values": ["pro"]}}`,
+	}
+	for _, rule := range invalid {
+		MakeErrorRequest(t, http.StatusUnprocessableEntity, ErrorCodeInvalidValue, func() (*http.Response, error) {
+			return http.Post(subject.URL+"/configs", "application/json", strings.NewReader(`{"config": {
+				"service": "service1", "name": "limit", "type": "long", "defaultValue": 10,
+				"rules": [`+rule+`]}}`))
+		})
+	}
+}
+
+func TestSegments(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	path := ConfigPath{Service: "service1", Name: "beta"}
+	MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/configs", "application/json", strings.NewReader(`{"config": {
+			"service": "service1", "name": "beta", "type": "bool", "defaultValue": false}}`))
+	})
+	// Overrides can only target segments that exist.
+	MakeErrorRequest(t, http.StatusNotFound, ErrorCodeSegmentNotFound, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/configs/service1/beta/overrides", "application/json", strings.NewReader(`{"override": {
+			"entityType": "segment", "entityId": "testers", "value": true}}`))
+	})
+
+	MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/segments", "application/json", strings.NewReader(`{"segment": {
+			"name": "testers", "entityType": "user", "entityIds": ["1", "2"],
+			"condition": {"attribute": "email", "operator": "matches", "values": ["@example\\.com$"]}}}`))
+	})
+	MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/configs/service1/beta/overrides", "application/json", strings.NewReader(`{"override": {
+			"entityType": "segment", "entityId": "testers", "value": true}}`))
+	})
+
+	value := func(attributes string) GetConfigValueResponse {
+		body := MakeServerRequest(t, func() (*http.Response, error) {
+			return http.Post(subject.URL+"/configs/service1/beta/value", "application/json",
+				strings.NewReader(`{"attributes": `+attributes+`}`))
+		})
+		var response GetConfigValueResponse
+		err := json.Unmarshal(body, &response)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response body: %v", err)
+		}
+		return response
+	}
+	segmentKey := OverrideKey{EntityType: SegmentEntityType, EntityId: "testers"}
+	response := value(`{"user": "2"}`)
+	if response.Value != true || response.MatchedOverride == nil || *response.MatchedOverride != segmentKey {
+		t.Errorf("Expected a listed user to get the segment override, but got %v", response)
+	}
+	response = value(`{"user": "7", "email": "bob@example.com"}`)
+	if response.Value != true || response.MatchedOverride == nil || *response.MatchedOverride != segmentKey {
+		t.Errorf("Expected a user matching the condition to get the segment override, but got %v", response)
+	}
+	response = value(`{"user": "7", "segment": "testers"}`)
+	if response.Value != false || response.MatchedOverride != nil {
+		t.Errorf("Expected a user outside the segment to get the default, but got %v", response)
+	}
+
+	// Entity overrides take precedence over segments.
+	err := app.Handlers.SaveOverride("alice", &path, &Override{OverrideKey: OverrideKey{EntityType: "user", EntityId: "2"}, Value: "false"})
+	if err != nil {
+		t.Fatalf("Failed to save override: %v", err)
+	}
+	response = value(`{"user": "2"}`)
+	if response.Value != false || response.MatchedOverride == nil || response.MatchedOverride.EntityType != "user" {
+		t.Errorf("Expected the user override to win over the segment, but got %v", response)
+	}
+
+	// Changing the segment moves the configs using it on to a new revision.
+	config, err := app.ConfigDb.GetConfig(&path)
+	if err != nil {
+		t.Fatalf("Failed to get config: %v", err)
+	}
+	MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/segments", "application/json", strings.NewReader(`{"segment": {
+			"name": "testers", "entityType": "user", "entityIds": ["1", "2", "3"]}}`))
+	})
+	history, err := app.ConfigDb.GetHistory(&path)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	last := history[len(history)-1]
+	if last.Action != HistoryActionUpdateSegment || last.Revision != config.Revision+1 ||
+		last.OldSegment == nil || len(last.OldSegment.EntityIds) != 2 || last.NewSegment == nil || len(last.NewSegment.EntityIds) != 3 {
+		t.Errorf("Expected a segment update at revision %d, but got %v", config.Revision+1, last)
+	}
+	response = value(`{"user": "7", "email": "bob@example.com"}`)
+	if response.Value != false {
+		t.Errorf("Expected the removed condition to no longer match, but got %v", response)
+	}
+
+	body := MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/segments/testers")
+	})
+	var segment GetSegmentResponse
+	err = json.Unmarshal(body, &segment)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+	if segment.Segment.Revision != 2 || len(segment.UsedBy) != 1 || segment.UsedBy[0] != path {
+		t.Errorf("Expected revision 2 used by %v, but got %s", path, body)
+	}
+
+	deleteSegment := func() (*http.Response, error) {
+		request, err := http.NewRequest("DELETE", subject.URL+"/segments/testers", nil)
+		if err != nil {
+			return nil, err
+		}
+		return http.DefaultClient.Do(request)
+	}
+	MakeErrorRequest(t, http.StatusConflict, ErrorCodeSegmentInUse, deleteSegment)
+	err = app.Handlers.RemoveOverride("alice", &path, &segmentKey)
+	if err != nil {
+		t.Fatalf("Failed to delete override: %v", err)
+	}
+	MakeServerRequest(t, deleteSegment)
+	MakeErrorRequest(t, http.StatusNotFound, ErrorCodeSegmentNotFound, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/segments/testers")
+	})
+
+	invalid := []struct {
+		status  int
+		code    string
+		segment string
+	}{
+		{http.StatusBadRequest, ErrorCodeMissingField, `{"entityType": "user", "entityIds": ["1"]}`},
+		{http.StatusBadRequest, ErrorCodeMissingField, `{"name": "empty"}`},
+		{http.StatusBadRequest, ErrorCodeMissingField, `{"name": "ids", "entityIds": ["1"]}`},
+		{http.StatusUnprocessableEntity, ErrorCodeInvalidValue, `{"name": "a/b", "entityType": "user", "entityIds": ["1"]}`},
+		{http.StatusUnprocessableEntity, ErrorCodeInvalidValue, `{"name": "bad", "condition": {"attribute": "plan"}}`},
+	}
+	for _, test := range invalid {
+		MakeErrorRequest(t, test.status, test.code, func() (*http.Response, error) {
+			return http.Post(subject.URL+"/segments", "application/json", strings.NewReader(`{"segment": `+test.segment+`}`))
+		})
+	}
+}
+
+func TestScheduledChanges(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	path := ConfigPath{Service: "service1", Name: "launch"}
+	err := app.Handlers.SaveConfig("alice", &Config{ConfigPath: path, Type: "bool", DefaultValue: "false"})
+	if err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	now := time.Now().UTC()
+	at := func(offset time.Duration) string {
+		return now.Add(offset).Format(time.RFC3339Nano)
+	}
+	post := func(url string, body string) PostConfigResponse {
+		respBody := MakeServerRequest(t, func() (*http.Response, error) {
+			return http.Post(subject.URL+url, "application/json", strings.NewReader(body))
+		})
+		var response PostConfigResponse
+		err := json.Unmarshal(respBody, &response)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response body: %v", err)
+		}
+		return response
+	}
+	defaultValue := func() string {
+		config, err := app.ConfigDb.GetConfig(&path)
+		if err != nil {
+			t.Fatalf("Failed to get config: %v", err)
+		}
+		return config.DefaultValue
+	}
+	pending := func(url string) ListScheduledChangesResponse {
+		body := MakeServerRequest(t, func() (*http.Response, error) {
+			return http.Get(subject.URL + url)
+		})
+		var response ListScheduledChangesResponse
+		err := json.Unmarshal(body, &response)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response body: %v", err)
+		}
+		return response
+	}
+
+	// A launch at a future time that reverts later.
+	response := post("/configs", `{"config": {"service": "service1", "name": "launch", "type": "bool", "defaultValue": true},
+		"schedule": {"applyAt": "`+at(time.Hour)+`", "revertAt": "`+at(2*time.Hour)+`"}}`)
+	if response.Scheduled == nil || response.Scheduled.Action != ScheduledActionSetConfig || response.Scheduled.Config.DefaultValue != true {
+		t.Fatalf("Expected the config change to be scheduled, but got %v", response)
+	}
+	if value := defaultValue(); value != "false" {
+		t.Errorf("Expected the default to wait for the schedule, but got %v", value)
+	}
+	changes := pending("/configs/service1/launch/schedules")
+	if len(changes.Changes) != 1 || changes.Changes[0].Id != response.Scheduled.Id || changes.Changes[0].Actor != "anonymous" {
+		t.Errorf("Expected the pending change, but got %v", changes)
+	}
+	if changes = pending("/schedules?service=service2"); len(changes.Changes) != 0 {
+		t.Errorf("Expected no changes pending in service2, but got %v", changes)
+	}
+
+	applied, err := app.Handlers.ApplyDueChanges(now.Add(30 * time.Minute))
+	if err != nil || applied != 0 || defaultValue() != "false" {
+		t.Errorf("Expected nothing due yet, but got %d applied, error: %v", applied, err)
+	}
+	applied, err = app.Handlers.ApplyDueChanges(now.Add(time.Hour))
+	if err != nil || applied != 1 || defaultValue() != "true" {
+		t.Errorf("Expected the launch to be applied, but got %d applied, error: %v", applied, err)
+	}
+	changes = pending("/schedules")
+	if len(changes.Changes) != 1 || changes.Changes[0].RevertOf != response.Scheduled.Id || changes.Changes[0].Config.DefaultValue != false {
+		t.Errorf("Expected the revert to be pending, but got %v", changes)
+	}
+	applied, err = app.Handlers.ApplyDueChanges(now.Add(3 * time.Hour))
+	if err != nil || applied != 1 || defaultValue() != "false" {
+		t.Errorf("Expected the launch to be reverted, but got %d applied, error: %v", applied, err)
+	}
+	history, err := app.ConfigDb.GetHistory(&path)
+	if err != nil || len(history) != 3 || history[1].Actor != "anonymous" || history[2].Action != HistoryActionUpdateConfig {
+		t.Errorf("Expected the launch and revert in history, but got %v, error: %v", history, err)
+	}
+
+	// An override made now that only reverts, the revert deletes it.
+	response = post("/configs/service1/launch/overrides", `{"override": {"entityType": "user", "entityId": "1", "value": true},
+		"schedule": {"revertAt": "`+at(time.Hour)+`"}}`)
+	if response.Scheduled == nil || response.Scheduled.Action != ScheduledActionDeleteOverride {
+		t.Fatalf("Expected an override delete to be scheduled, but got %v", response)
+	}
+	_, found, err := app.ConfigDb.GetOverride(&path, &OverrideKey{EntityType: "user", EntityId: "1"})
+	if err != nil || !found {
+		t.Errorf("Expected the override to be made now, but got %v, error: %v", found, err)
+	}
+	applied, err = app.Handlers.ApplyDueChanges(now.Add(time.Hour))
+	_, found, _ = app.ConfigDb.GetOverride(&path, &OverrideKey{EntityType: "user", EntityId: "1"})
+	if err != nil || applied != 1 || found {
+		t.Errorf("Expected the override to be deleted, but got %d applied, error: %v", applied, err)
+	}
+
+	// A scheduled config write keeps the config's overrides through the
+	// write and its revert.
+	vip := OverrideKey{EntityType: "user", EntityId: "vip"}
+	err = app.Handlers.SaveOverride("alice", &path, &Override{OverrideKey: vip, Value: "true"})
+	if err != nil {
+		t.Fatalf("Failed to save override: %v", err)
+	}
+	vipKept := func(when string) {
+		override, found, err := app.ConfigDb.GetOverride(&path, &vip)
+		if err != nil || !found || override.Value != "true" {
+			t.Errorf("Expected the override to be kept %s, but got %v, %v, error: %v", when, override, found, err)
+		}
+	}
+	post("/configs", `{"config": {"service": "service1", "name": "launch", "type": "bool", "defaultValue": true},
+		"schedule": {"applyAt": "`+at(time.Hour)+`", "revertAt": "`+at(2*time.Hour)+`"}}`)
+	applied, err = app.Handlers.ApplyDueChanges(now.Add(time.Hour))
+	if err != nil || applied != 1 || defaultValue() != "true" {
+		t.Errorf("Expected the launch to be applied, but got %d applied, error: %v", applied, err)
+	}
+	vipKept("after the write")
+	applied, err = app.Handlers.ApplyDueChanges(now.Add(2 * time.Hour))
+	if err != nil || applied != 1 || defaultValue() != "false" {
+		t.Errorf("Expected the launch to be reverted, but got %d applied, error: %v", applied, err)
+	}
+	vipKept("after the revert")
+	history, err = app.ConfigDb.GetHistory(&path)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	_, overrides, err := GetConfigAtRevision(history, history[len(history)-1].Revision)
+	if err != nil || len(overrides) != 1 || overrides[0].OverrideKey != vip {
+		t.Errorf("Expected the history to keep the override, but got %v, error: %v", overrides, err)
+	}
+
+	// Cancelling.
+	response = post("/configs/service1/launch/overrides", `{"override": {"entityType": "user", "entityId": "2", "value": true},
+		"schedule": {"applyAt": "`+at(time.Hour)+`"}}`)
+	cancel := func() (*http.Response, error) {
+		request, err := http.NewRequest("DELETE", subject.URL+"/schedules/"+response.Scheduled.Id, nil)
+		if err != nil {
+			return nil, err
+		}
+		return http.DefaultClient.Do(request)
+	}
+	MakeServerRequest(t, cancel)
+	MakeErrorRequest(t, http.StatusNotFound, ErrorCodeScheduleNotFound, cancel)
+
+	// Changes that can no longer be made are dropped.
+	post("/configs/service1/launch/overrides", `{"override": {"entityType": "user", "entityId": "3", "value": true},
+		"schedule": {"applyAt": "`+at(time.Hour)+`"}}`)
+	err = app.Handlers.RemoveConfig("alice", &path)
+	if err != nil {
+		t.Fatalf("Failed to remove config: %v", err)
+	}
+	applied, err = app.Handlers.ApplyDueChanges(now.Add(time.Hour))
+	if err != nil || applied != 0 || len(pending("/schedules").Changes) != 0 {
+		t.Errorf("Expected the override of a deleted config to be dropped, but got %d applied, error: %v", applied, err)
+	}
+
+	invalid := []struct {
+		status int
+		code   string
+		url    string
+		body   string
+	}{
+		{http.StatusBadRequest, ErrorCodeInvalidParameter, "/configs", `{"config": {"service": "service1", "name": "launch", "type": "bool", "defaultValue": true},
+			"schedule": {"applyAt": "` + at(2*time.Hour) + `", "revertAt": "` + at(time.Hour) + `"}}`},
+		{http.StatusUnprocessableEntity, ErrorCodeInvalidValue, "/configs", `{"config": {"service": "service1", "name": "launch", "type": "bool", "defaultValue": "maybe"},
+			"schedule": {"applyAt": "` + at(time.Hour) + `"}}`},
+		{http.StatusNotFound, ErrorCodeConfigNotFound, "/configs/service1/launch/overrides", `{"override": {"entityType": "user", "entityId": "1", "value": true},
+			"schedule": {"applyAt": "` + at(time.Hour) + `"}}`},
+	}
+	for _, test := range invalid {
+		MakeErrorRequest(t, test.status, test.code, func() (*http.Response, error) {
+			return http.Post(subject.URL+test.url, "application/json", strings.NewReader(test.body))
+		})
+	}
+}
+
+func TestOverrideExpiry(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	path := ConfigPath{Service: "service1", Name: "config1"}
+	err := app.Handlers.SaveConfig("alice", &Config{ConfigPath: path, Type: "long", DefaultValue: "1"})
+	if err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	now := time.Now().UTC()
+	MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/configs/service1/config1/overrides", "application/json",
+			strings.NewReader(`{"override": {"entityType": "user", "entityId": "1", "value": 2, "ttl": 3600}}`))
+	})
+	body := MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/configs/service1/config1/overrides/user/1")
+	})
+	var getResponse GetOverrideResponse
+	err = json.Unmarshal(body, &getResponse)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+	expiresAt := getResponse.Override.ExpiresAt
+	if expiresAt == nil || expiresAt.Before(now.Add(time.Hour)) || expiresAt.After(now.Add(time.Hour+time.Minute)) {
+		t.Errorf("Expected the override to expire in an hour, but got %v", expiresAt)
+	}
+
+	// Overrides already expired are kept until collected but no longer
+	// apply, including segment overrides.
+	expired := now.Add(-time.Minute)
+	err = app.Handlers.SaveSegment("alice", &Segment{Name: "staff", EntityType: "user", EntityIds: []string{"3"}})
+	if err != nil {
+		t.Fatalf("Failed to save segment: %v", err)
+	}
+	for _, key := range []OverrideKey{{EntityType: "user", EntityId: "2"}, {EntityType: SegmentEntityType, EntityId: "staff"}} {
+		err = app.Handlers.SaveOverride("alice", &path, &Override{OverrideKey: key, Value: "3", ExpiresAt: &expired})
+		if err != nil {
+			t.Fatalf("Failed to save override: %v", err)
+		}
+	}
+	for _, attributes := range []map[string]string{{"user": "2"}, {"user": "3"}} {
+		value, err := app.Handlers.EvaluateConfig(&path, attributes, ValueFormatString)
+		if err != nil || value.Value != "1" || value.MatchedOverride != nil {
+			t.Errorf("Expected the default for %v, but got %v, error: %v", attributes, value, err)
+		}
+	}
+	body = MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/services/service1/snapshot")
+	})
+	var snapshot evaluator.Snapshot
+	err = json.Unmarshal(body, &snapshot)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+	if overrides := snapshot.Configs["config1"].Overrides; len(overrides) != 1 || overrides["user"]["1"].Value != "2" ||
+		overrides["user"]["1"].ExpiresAt == nil || !overrides["user"]["1"].ExpiresAt.Equal(*expiresAt) {
+		t.Errorf("Expected only the live override in the snapshot with its expiry, but got %v", overrides)
+	}
+	// The snapshot stops applying the override once it expires, as the
+	// service does.
+	result, err := snapshot.EvaluateAt("config1", map[string]string{"user": "1"}, expiresAt.Add(time.Second))
+	if err != nil || result.Value != "1" || result.MatchedOverride != nil {
+		t.Errorf("Expected the default once the override expired, but got %v, error: %v", result, err)
+	}
+	document, err := app.Handlers.BuildExportDocument(ValueFormatString)
+	if err != nil || len(document.Configs) != 1 || document.Configs[0].Overrides[2].ExpiresAt == nil {
+		t.Errorf("Expected the expiry to be exported, but got %v, error: %v", document, err)
+	}
+	document.Configs[0].Overrides[1].ExpiresAt = nil
+	report, err := app.Handlers.ImportConfigs("alice", &document, ImportModeMerge, "", true)
+	if err != nil || len(report.Created) != 0 || len(report.Updated) != 1 || *report.Updated[0].Override != (OverrideKey{EntityType: "user", EntityId: "1"}) {
+		t.Errorf("Expected importing without the expiry to update the override, but got %v, error: %v", report, err)
+	}
+
+	removed, err := app.Handlers.RemoveExpiredOverrides(now)
+	if err != nil || removed != 2 {
+		t.Errorf("Expected 2 expired overrides removed, but got %d, error: %v", removed, err)
+	}
+	history, err := app.ConfigDb.GetHistory(&path)
+	if last := history[len(history)-1]; err != nil || last.Action != HistoryActionDeleteOverride || last.Actor != expiryActor {
+		t.Errorf("Expected the removal in history, but got %v, error: %v", last, err)
+	}
+	removed, err = app.Handlers.RemoveExpiredOverrides(now.Add(2 * time.Hour))
+	if err != nil || removed != 1 {
+		t.Errorf("Expected the ttl override removed, but got %d, error: %v", removed, err)
+	}
+	overrides, err := app.ConfigDb.GetOverrides(&path)
+	if err != nil || len(overrides) != 0 {
+		t.Errorf("Expected no overrides left, but got %v, error: %v", overrides, err)
+	}
+
+	invalid := []string{
+		`{"override": {"entityType": "user", "entityId": "1", "value": 2, "ttl": -1}}`,
+		`{"override": {"entityType": "user", "entityId": "1", "value": 2, "expiresAt": "2001-01-01T00:00:00Z"}}`,
+		`{"override": {"entityType": "user", "entityId": "1", "value": 2, "ttl": 60, "expiresAt": "` + now.Add(time.Hour).Format(time.RFC3339) + `"}}`,
+	}
+	for _, body := range invalid {
+		MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeInvalidParameter, func() (*http.Response, error) {
+			return http.Post(subject.URL+"/configs/service1/config1/overrides", "application/json", strings.NewReader(body))
+		})
+	}
+}
+
+func TestExplainConfigValue(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	path := ConfigPath{Service: "service1", Name: "config1"}
+	err := app.Handlers.SaveSegment("alice", &Segment{Name: "staff", EntityType: "user", EntityIds: []string{"1"}})
+	if err != nil {
+		t.Fatalf("Failed to save segment: %v", err)
+	}
+	err = app.Handlers.SaveConfig("alice", &Config{
+		ConfigPath:   path,
+		Type:         "long",
+		DefaultValue: "1",
+		Priority:     []string{"user", "group"},
+		Rules:        []Rule{{Priority: 1, Condition: Condition{Attribute: "platform", Operator: "equals", Values: []string{"ios"}}, Value: "5"}},
+		Rollouts:     []Rollout{{EntityType: "user", Percentage: 100, Value: "6"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	expired := time.Now().Add(-time.Minute)
+	for _, override := range []Override{
+		{OverrideKey: OverrideKey{EntityType: "user", EntityId: "1"}, Value: "2"},
+		{OverrideKey: OverrideKey{EntityType: "group", EntityId: "a"}, Value: "3"},
+		{OverrideKey: OverrideKey{EntityType: "group", EntityId: "b"}, Value: "3", ExpiresAt: &expired},
+		{OverrideKey: OverrideKey{EntityType: SegmentEntityType, EntityId: "staff"}, Value: "4"},
+	} {
+		err = app.Handlers.SaveOverride("alice", &path, &override)
+		if err != nil {
+			t.Fatalf("Failed to save override: %v", err)
+		}
+	}
+	explain := func(query string, attributes string) GetConfigValueResponse {
+		body := MakeServerRequest(t, func() (*http.Response, error) {
+			return http.Post(subject.URL+"/configs/service1/config1/value"+query, "application/json",
+				strings.NewReader(`{"attributes": `+attributes+`}`))
+		})
+		var response GetConfigValueResponse
+		err := json.Unmarshal(body, &response)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal response body: %v", err)
+		}
+		return response
+	}
+
+	response := explain("?explain=true", `{"user": "1", "group": "a", "platform": "ios"}`)
+	explanation := response.Explanation
+	if response.Value != float64(2) || explanation == nil {
+		t.Fatalf("Expected the user override explained, but got %v", response)
+	}
+	if explanation.DefaultValue != float64(1) || !slices.Equal(explanation.Priority, []string{"user", "group"}) ||
+		explanation.Source != ValueSourceOverride {
+		t.Errorf("Expected the default, priority and source, but got %v", explanation)
+	}
+	matched := []string{}
+	for _, candidate := range explanation.Overrides {
+		if candidate.Matched {
+			matched = append(matched, GetOverridePathStr(&candidate.OverrideKey))
+		}
+	}
+	if len(explanation.Overrides) != 4 || !slices.Equal(matched, []string{"user/1", "group/a", "segment/staff"}) ||
+		explanation.Overrides[2].Found || explanation.Overrides[2].EntityType != "platform" {
+		t.Errorf("Expected every candidate override checked in order, but got %v", explanation.Overrides)
+	}
+	if len(explanation.Rules) != 1 || !explanation.Rules[0].Matched || len(explanation.Rollouts) != 1 || !explanation.Rollouts[0].Matched {
+		t.Errorf("Expected the rule and rollout to match, but got %v and %v", explanation.Rules, explanation.Rollouts)
+	}
+	if !strings.Contains(explanation.Decision, "takes precedence over override group/a, override segment/staff, rule 0, rollout 0") {
+		t.Errorf("Expected the decision to list what lost, but got %q", explanation.Decision)
+	}
+
+	// Expired overrides are found but don't match.
+	response = explain("?explain=true&valueFormat=string", `{"user": "2", "group": "b"}`)
+	explanation = response.Explanation
+	if response.Value != "6" || explanation.Source != ValueSourceRollout || explanation.Rollouts[0].Bucket == nil ||
+		!explanation.Overrides[1].Found || explanation.Overrides[1].Matched || explanation.Overrides[1].ExpiresAt == nil {
+		t.Errorf("Expected the rollout past the expired override, but got %v", response)
+	}
+	response = explain("", `{"user": "3"}`)
+	if response.Explanation != nil {
+		t.Errorf("Expected no explanation unless asked for, but got %v", response.Explanation)
+	}
+	MakeErrorRequest(t, http.StatusBadRequest, ErrorCodeInvalidParameter, func() (*http.Response, error) {
+		return http.Post(subject.URL+"/configs/service1/config1/value?explain=maybe", "application/json", strings.NewReader(`{}`))
+	})
+}
@@ -1,51 +1,198 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 )
 
 type Application struct {
 	ConfigDbConfig ConfigDbConfig
-	ConfigDb       ConfigDb
+	ConfigDb       ConfigStore
 	Handlers       Handlers
+	Scheduler      *Scheduler
+	Expiry         *ExpiryCollector
 }
 
+// shutdownTimeout is how long requests in flight, watch streams included,
+// get to finish once the server is told to stop.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	app := BuildApplication()
-	router := BuildServer(&app)
+	app, err := BuildApplication()
+	if err != nil {
+		log.Fatalf("Failed to build application: %v", err)
+	}
+	server := &http.Server{
+		Addr:    ":8080",
+		Handler: BuildServer(&app),
+	}
+
+	// Closed on shutdown to stop the background loops.
+	stop := make(chan struct{})
+	var loops sync.WaitGroup
+	if app.Handlers.Sync != nil {
+		log.Printf("Syncing configs from %s every %v", app.Handlers.Sync.Dir, app.Handlers.Sync.Interval)
+		loops.Go(func() { app.Handlers.Sync.Run(stop) })
+	}
+	loops.Go(func() { app.Scheduler.Run(stop) })
+	loops.Go(func() { app.Expiry.Run(stop) })
+
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		log.Println("Server shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		if err != nil {
+			log.Printf("Failed to shut down cleanly: %v", err)
+		}
+	}()
 
 	log.Println("Server starting on :8080")
-	err := http.ListenAndServe(":8080", router)
-	if err != nil {
+	err = server.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Server failed: %v", err)
 	}
+	<-shutdown
+
+	// Let a sync or scheduled change in progress finish before closing the
+	// store under it.
+	close(stop)
+	loops.Wait()
+	err = app.ConfigDb.Close()
+	if err != nil {
+		log.Fatalf("Failed to close config db: %v", err)
+	}
 }
 
-func BuildApplication() Application {
-	configDbConfig := ConfigDbConfig{
-		User:     "redis",
-		Password: "redis",
-		Database: "configs",
+// BuildApplication opens the store and wires up the handlers from the
+// environment. The background loops are started by main.
+func BuildApplication() (Application, error) {
+	configDbConfig, err := LoadConfigDbConfig()
+	if err != nil {
+		return Application{}, err
 	}
-	configDb := ConfigDb{
-		Config:    configDbConfig,
-		Configs:   make(map[string]Config),
-		Overrides: make(map[string]ConfigOverrides),
+	valueFormat := getEnv("CONFIG_VALUE_FORMAT", ValueFormatTyped)
+	err = ValidateValueFormat(valueFormat)
+	if err != nil {
+		return Application{}, errors.Wrap(err, "invalid CONFIG_VALUE_FORMAT")
+	}
+	eventBufferSize, err := getEnvInt("CONFIG_EVENT_BUFFER", defaultEventBufferSize)
+	if err != nil {
+		return Application{}, err
+	}
+	scheduleInterval, err := getEnvSeconds("CONFIG_SCHEDULE_INTERVAL", defaultScheduleInterval)
+	if err != nil {
+		return Application{}, err
+	}
+	expiryInterval, err := getEnvSeconds("CONFIG_EXPIRY_INTERVAL", defaultExpiryInterval)
+	if err != nil {
+		return Application{}, err
+	}
+	syncDir := getEnv("CONFIG_SYNC_DIR", "")
+	syncInterval, err := getEnvSeconds("CONFIG_SYNC_INTERVAL", defaultSyncInterval)
+	if err != nil {
+		return Application{}, err
+	}
+
+	configDb, err := NewConfigStore(configDbConfig)
+	if err != nil {
+		return Application{}, errors.Wrap(err, "failed to open config db")
 	}
 	handlers := Handlers{
-		ConfigDb: configDb,
+		ConfigDb:        configDb,
+		DefaultPriority: LoadEntityPriority(),
+		ValueFormat:     valueFormat,
+		Events:          NewEventBroker(eventBufferSize),
+		writeMu:         &sync.Mutex{},
+	}
+	if syncDir != "" {
+		handlers.Sync = NewDirectorySync(syncDir, syncInterval, getEnv("CONFIG_SYNC_DRY_RUN", "") == "true", handlers)
 	}
 
 	return Application{
 		ConfigDbConfig: configDbConfig,
 		ConfigDb:       configDb,
 		Handlers:       handlers,
+		Scheduler:      NewScheduler(scheduleInterval, handlers),
+		Expiry:         NewExpiryCollector(expiryInterval, handlers),
+	}, nil
+}
+
+// LoadConfigDbConfig reads the storage settings from the environment, falling
+// back to an in-memory store when CONFIG_DB_BACKEND is unset.
+func LoadConfigDbConfig() (ConfigDbConfig, error) {
+	snapshotEvery, err := getEnvInt("CONFIG_DB_SNAPSHOT_EVERY", defaultSnapshotEvery)
+	if err != nil {
+		return ConfigDbConfig{}, err
+	}
+	return ConfigDbConfig{
+		Backend:       getEnv("CONFIG_DB_BACKEND", BackendMemory),
+		Path:          getEnv("CONFIG_DB_PATH", "configs.json"),
+		SnapshotEvery: snapshotEvery,
+		Address:       getEnv("CONFIG_DB_ADDRESS", "localhost:6379"),
+		User:          getEnv("CONFIG_DB_USER", "redis"),
+		Password:      getEnv("CONFIG_DB_PASSWORD", "redis"),
+		Database:      getEnv("CONFIG_DB_DATABASE", "configs"),
+	}, nil
+}
+
+// LoadEntityPriority reads the service wide entity type precedence from
+// CONFIG_ENTITY_PRIORITY, a comma separated list such as "user,group,org".
+func LoadEntityPriority() []string {
+	priority := []string{}
+	for _, entityType := range strings.Split(getEnv("CONFIG_ENTITY_PRIORITY", ""), ",") {
+		entityType = strings.TrimSpace(entityType)
+		if entityType != "" {
+			priority = append(priority, entityType)
+		}
+	}
+	return priority
+}
+
+func getEnv(key string, fallback string) string {
+	if value, found := os.LookupEnv(key); found {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) (int, error) {
+	value, found := os.LookupEnv(key)
+	if !found {
+		return fallback, nil
+	}
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid %s", key)
+	}
+	return intValue, nil
+}
+
+// getEnvSeconds reads a duration given in whole seconds.
+func getEnvSeconds(key string, fallback time.Duration) (time.Duration, error) {
+	seconds, err := getEnvInt(key, int(fallback/time.Second))
+	if err != nil {
+		return 0, err
 	}
+	return time.Duration(seconds) * time.Second, nil
 }
 
 func BuildServer(app *Application) http.Handler {
@@ -62,6 +209,9 @@ func BuildServer(app *Application) http.Handler {
 	router.Methods("GET").
 		Path("/configs/{service}/{name}").
 		HandlerFunc(CatchErrors(handlers.GetConfig))
+	router.Methods("PUT").
+		Path("/configs/{service}/{name}").
+		HandlerFunc(CatchErrors(handlers.PutConfig))
 	router.Methods("DELETE").
 		Path("/configs/{service}/{name}").
 		HandlerFunc(CatchErrors(handlers.DeleteConfig))
@@ -80,9 +230,66 @@ func BuildServer(app *Application) http.Handler {
 		Path("/configs/{service}/{name}/overrides/{entityType}/{entityId}").
 		HandlerFunc(CatchErrors(handlers.DeleteOverride))
 
+	router.Methods("GET").
+		Path("/configs/{service}/{name}/history").
+		HandlerFunc(CatchErrors(handlers.GetHistory))
+	router.Methods("POST").
+		Path("/configs/{service}/{name}/rollback").
+		HandlerFunc(CatchErrors(handlers.PostRollback))
+
 	router.Methods("POST").
 		Path("/configs/{service}/{name}/value").
 		HandlerFunc(CatchErrors(handlers.GetConfigValue))
+	router.Methods("POST").
+		Path("/services/{service}/values").
+		HandlerFunc(CatchErrors(handlers.GetServiceValues))
+	router.Methods("POST").
+		Path("/values").
+		HandlerFunc(CatchErrors(handlers.GetValues))
+
+	router.Methods("GET").
+		Path("/configs/{service}/{name}/schedules").
+		HandlerFunc(CatchErrors(handlers.ListConfigScheduledChanges))
+	router.Methods("GET").
+		Path("/schedules").
+		HandlerFunc(CatchErrors(handlers.ListScheduledChanges))
+	router.Methods("DELETE").
+		Path("/schedules/{id}").
+		HandlerFunc(CatchErrors(handlers.DeleteScheduledChange))
+
+	router.Methods("GET").
+		Path("/segments").
+		HandlerFunc(CatchErrors(handlers.ListSegments))
+	router.Methods("POST").
+		Path("/segments").
+		HandlerFunc(CatchErrors(handlers.PostSegment))
+	router.Methods("GET").
+		Path("/segments/{segment}").
+		HandlerFunc(CatchErrors(handlers.GetSegment))
+	router.Methods("DELETE").
+		Path("/segments/{segment}").
+		HandlerFunc(CatchErrors(handlers.DeleteSegment))
+
+	router.Methods("GET").
+		Path("/export").
+		HandlerFunc(CatchErrors(handlers.Export))
+	router.Methods("POST").
+		Path("/import").
+		HandlerFunc(CatchErrors(handlers.Import))
+
+	router.Methods("GET").
+		Path("/sync").
+		HandlerFunc(CatchErrors(handlers.GetSyncStatus))
+
+	router.Methods("GET").
+		Path("/watch").
+		HandlerFunc(handlers.Watch)
+	router.Methods("GET").
+		Path("/services/{service}/poll").
+		HandlerFunc(CatchErrors(handlers.PollService))
+	router.Methods("GET").
+		Path("/services/{service}/snapshot").
+		HandlerFunc(CatchErrors(handlers.GetServiceSnapshot))
 
 	var finalHandler http.Handler = router
 	finalHandler = loggingMiddleware(finalHandler)
@@ -92,22 +299,26 @@ func BuildServer(app *Application) http.Handler {
 
 func CatchErrors(handler func(*http.Request) (*HttpResponse, error)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if panicErr := recover(); panicErr != nil {
+				fmt.Fprintf(os.Stderr, "Panic recovered: %+v\n", panicErr)
+				WriteError(w, GetApiError(errors.Errorf("panic: %v", panicErr)))
+			}
+		}()
+
 		res, err := handler(r)
-		if panicErr := recover(); panicErr != nil {
-			fmt.Fprintf(os.Stderr, "Panic recovered: %+v\n", panicErr)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error handling request: %+v\n", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			apiErr := GetApiError(err)
+			if apiErr.Status >= http.StatusInternalServerError {
+				fmt.Fprintf(os.Stderr, "Error handling request: %+v\n", err)
+			}
+			WriteError(w, apiErr)
 			return
 		}
 		w.WriteHeader(res.Status)
 		_, err = w.Write(res.Data)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing response: %+v\n", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 	}
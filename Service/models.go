@@ -1,9 +1,102 @@
 package main
 
+import (
+	"time"
+
+	"Service/evaluator"
+)
+
 type Config struct {
 	ConfigPath
 	Type         string `json:"type"`
 	DefaultValue string `json:"defaultValue"`
+	// Priority orders entity types from most to least important when more
+	// than one override matches a request. Falls back to the service wide
+	// priority when empty.
+	Priority []string `json:"priority,omitempty"`
+	// Rules give values to requests no override matched based on their
+	// attributes, the highest priority rule that matches wins.
+	Rules []Rule `json:"rules,omitempty"`
+	// Rollouts give values to a percentage of entities no override or rule
+	// matched, the first rollout including the request's entity wins.
+	Rollouts []Rollout `json:"rollouts,omitempty"`
+	// Revision increases with every change to the config or its overrides,
+	// it is assigned by the service and ignored on writes.
+	Revision int64 `json:"revision"`
+}
+
+// Rollout gives Value to Percentage of the entities of EntityType, see
+// evaluator.Rollout for how entities are bucketed.
+type Rollout struct {
+	EntityType string  `json:"entityType"`
+	Percentage float64 `json:"percentage"`
+	Value      string  `json:"value"`
+}
+
+// Rule gives Value to requests whose attributes satisfy Condition, see
+// evaluator.Rule for the order rules are tried in.
+type Rule struct {
+	Name      string    `json:"name,omitempty"`
+	Priority  int       `json:"priority"`
+	Condition Condition `json:"condition"`
+	Value     string    `json:"value"`
+}
+
+// Condition is shared with the evaluator package, conditions are nested and
+// the service has no reason to read them differently from local clients.
+type Condition = evaluator.Condition
+
+// SegmentEntityType is the entity type of overrides targeting a segment,
+// their entity id is the segment's name.
+const SegmentEntityType = evaluator.SegmentEntityType
+
+// Segment is a named group of entities that overrides of any config can
+// target, so changing the segment changes every one of those configs. A
+// request is in the segment when its EntityType attribute is listed in
+// EntityIds or when its attributes satisfy Condition.
+type Segment struct {
+	Name       string     `json:"name"`
+	EntityType string     `json:"entityType,omitempty"`
+	EntityIds  []string   `json:"entityIds,omitempty"`
+	Condition  *Condition `json:"condition,omitempty"`
+	// Revision increases with every change to the segment, it is assigned
+	// by the service and ignored on writes.
+	Revision int64 `json:"revision"`
+}
+
+// Scheduled change actions. Changes scheduled through the API set a config
+// or an override, the delete actions only undo changes that created one.
+const (
+	ScheduledActionSetConfig      = "setConfig"
+	ScheduledActionDeleteConfig   = "deleteConfig"
+	ScheduledActionSetOverride    = "setOverride"
+	ScheduledActionDeleteOverride = "deleteOverride"
+)
+
+// ScheduledChange is a write to a config or one of its overrides that the
+// Scheduler makes at ApplyAt on behalf of Actor. Once applied, a change with
+// a RevertAt schedules another change undoing it at that time.
+type ScheduledChange struct {
+	Id string `json:"id"`
+	ConfigPath
+	Action string `json:"action"`
+	// Type is the config's type when the change was scheduled.
+	Type string `json:"type"`
+	// Config is set for config actions, Override for override actions.
+	Config    *Config    `json:"config,omitempty"`
+	Override  *Override  `json:"override,omitempty"`
+	ApplyAt   time.Time  `json:"applyAt"`
+	RevertAt  *time.Time `json:"revertAt,omitempty"`
+	RevertOf  string     `json:"revertOf,omitempty"`
+	Actor     string     `json:"actor"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// Schedule is the optional timing of a config or override write. A write
+// without ApplyAt, or with one in the past, is made immediately.
+type Schedule struct {
+	ApplyAt  *time.Time `json:"applyAt,omitempty"`
+	RevertAt *time.Time `json:"revertAt,omitempty"`
 }
 
 type ConfigPath struct {
@@ -14,6 +107,9 @@ type ConfigPath struct {
 type Override struct {
 	OverrideKey
 	Value string `json:"value"`
+	// ExpiresAt is when the override stops applying, it is deleted soon
+	// after by the ExpiryCollector.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
 }
 
 type OverrideKey struct {
@@ -21,38 +117,149 @@ type OverrideKey struct {
 	EntityId   string `json:"entityId"`
 }
 
+// ConfigPayload is the wire form of a Config. DefaultValue is a native JSON
+// bool or number when the config type calls for it, see EncodeConfigValue.
+type ConfigPayload struct {
+	Config
+	DefaultValue any              `json:"defaultValue"`
+	Rules        []RulePayload    `json:"rules,omitempty"`
+	Rollouts     []RolloutPayload `json:"rollouts,omitempty"`
+}
+
+// RulePayload is the wire form of a Rule, typed like ConfigPayload.
+type RulePayload struct {
+	Rule
+	Value any `json:"value"`
+}
+
+// RolloutPayload is the wire form of a Rollout, typed like ConfigPayload.
+type RolloutPayload struct {
+	Rollout
+	Value any `json:"value"`
+}
+
+// OverridePayload is the wire form of an Override, typed like ConfigPayload.
+type OverridePayload struct {
+	Override
+	Value any `json:"value"`
+	// TTL is only read on writes, it sets ExpiresAt that many seconds after
+	// the override is made.
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+const (
+	HistoryActionCreateConfig   = "createConfig"
+	HistoryActionUpdateConfig   = "updateConfig"
+	HistoryActionDeleteConfig   = "deleteConfig"
+	HistoryActionCreateOverride = "createOverride"
+	HistoryActionUpdateOverride = "updateOverride"
+	HistoryActionDeleteOverride = "deleteOverride"
+	HistoryActionRollback       = "rollback"
+	HistoryActionImport         = "import"
+	// HistoryActionUpdateSegment is recorded for every config with an
+	// override targeting a segment when the segment changes.
+	HistoryActionUpdateSegment = "updateSegment"
+)
+
+// HistoryEntry records a single change to a config or one of its overrides.
+// Config changes fill OldConfig/NewConfig, override changes fill
+// OverrideKey and OldOverride/NewOverride. Deleting a config, or updating
+// it in a way that clears its overrides, also records the overrides that
+// were removed in OldOverrides. A rollback or import records the whole
+// config before and after in OldConfig/OldOverrides and
+// NewConfig/NewOverrides. A segment change records the segment before and
+// after in OldSegment/NewSegment.
+type HistoryEntry struct {
+	ConfigPath
+	// Revision is the config revision the change produced.
+	Revision  int64     `json:"revision"`
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	// Type is the config type at the time of the change.
+	Type string `json:"type"`
+
+	OldConfig    *Config      `json:"oldConfig,omitempty"`
+	NewConfig    *Config      `json:"newConfig,omitempty"`
+	OverrideKey  *OverrideKey `json:"overrideKey,omitempty"`
+	OldOverride  *Override    `json:"oldOverride,omitempty"`
+	NewOverride  *Override    `json:"newOverride,omitempty"`
+	OldOverrides []Override   `json:"oldOverrides,omitempty"`
+	NewOverrides []Override   `json:"newOverrides,omitempty"`
+	// RollbackRevision is the revision a rollback restored.
+	RollbackRevision int64    `json:"rollbackRevision,omitempty"`
+	OldSegment       *Segment `json:"oldSegment,omitempty"`
+	NewSegment       *Segment `json:"newSegment,omitempty"`
+}
+
+// HistoryEntryPayload is the wire form of a HistoryEntry with values typed
+// like ConfigPayload.
+type HistoryEntryPayload struct {
+	HistoryEntry
+	OldConfig    *ConfigPayload    `json:"oldConfig,omitempty"`
+	NewConfig    *ConfigPayload    `json:"newConfig,omitempty"`
+	OldOverride  *OverridePayload  `json:"oldOverride,omitempty"`
+	NewOverride  *OverridePayload  `json:"newOverride,omitempty"`
+	OldOverrides []OverridePayload `json:"oldOverrides,omitempty"`
+	NewOverrides []OverridePayload `json:"newOverrides,omitempty"`
+}
+
+// ScheduledChangePayload is the wire form of a ScheduledChange, typed like
+// ConfigPayload.
+type ScheduledChangePayload struct {
+	ScheduledChange
+	Config   *ConfigPayload   `json:"config,omitempty"`
+	Override *OverridePayload `json:"override,omitempty"`
+}
+
 type SimpleResponse struct {
 	Message string `json:"message"`
 }
 
 type ListConfigsResponse struct {
-	Configs []Config `json:"configs"`
+	Configs []ConfigPayload `json:"configs"`
 }
 
 type PostConfigRequest struct {
-	Config Config `json:"config"`
+	Config   ConfigPayload `json:"config"`
+	Schedule *Schedule     `json:"schedule,omitempty"`
 }
 
-type PostConfigResponse = SimpleResponse
+// PostConfigResponse is also the response to override writes. Scheduled is
+// set when the write was scheduled for later rather than made, or when a
+// revert was scheduled for it.
+type PostConfigResponse struct {
+	Message   string                  `json:"message"`
+	Scheduled *ScheduledChangePayload `json:"scheduled,omitempty"`
+}
+
+// PutConfigRequest replaces a config keeping its overrides. The config's
+// service and name may be left out, they come from the path.
+type PutConfigRequest struct {
+	Config ConfigPayload `json:"config"`
+}
+
+type PutConfigResponse = SimpleResponse
 
 type GetConfigResponse struct {
-	Config Config `json:"config"`
+	Config ConfigPayload `json:"config"`
 }
 
 type PostConfigOverrideRequest struct {
-	Override Override `json:"override"`
+	Override OverridePayload `json:"override"`
+	Schedule *Schedule       `json:"schedule,omitempty"`
 }
 
-type PostConfigOverrideResponse = SimpleResponse
+type PostConfigOverrideResponse = PostConfigResponse
 
 type DeleteConfigResponse = SimpleResponse
 
 type ListOverridesResponse struct {
-	Overrides []Override `json:"overrides"`
+	Overrides []OverridePayload `json:"overrides"`
 }
 
 type GetOverrideResponse struct {
-	Override Override `json:"override"`
+	Override OverridePayload `json:"override"`
 }
 
 type DeleteOverrideResponse = SimpleResponse
@@ -63,5 +270,245 @@ type GetConfigValueRequest struct {
 
 type GetConfigValueResponse struct {
 	Type  string `json:"type"`
-	Value string `json:"value"`
+	Value any    `json:"value"`
+	// MatchedOverride is the override that produced Value, or nil when the
+	// default value was used.
+	MatchedOverride *OverrideKey `json:"matchedOverride,omitempty"`
+	// MatchedRule is set instead when a rule produced Value.
+	MatchedRule *RuleMatch `json:"matchedRule,omitempty"`
+	// MatchedRollout is set instead when a rollout produced Value.
+	MatchedRollout *RolloutMatch `json:"matchedRollout,omitempty"`
+	// Explanation is only set when asked for with explain=true.
+	Explanation *ValueExplanation `json:"explanation,omitempty"`
+}
+
+// Sources of a value, from the highest precedence down.
+const (
+	ValueSourceOverride = "override"
+	ValueSourceSegment  = "segment"
+	ValueSourceRule     = "rule"
+	ValueSourceRollout  = "rollout"
+	ValueSourceDefault  = "default"
+)
+
+// ValueExplanation is everything considered while resolving a value. Unlike
+// evaluation it checks every candidate, so it also shows what matched but
+// lost to something of higher precedence.
+type ValueExplanation struct {
+	DefaultValue any `json:"defaultValue"`
+	// Priority is the entity type precedence the overrides were tried in.
+	Priority []string `json:"priority"`
+	// Overrides lists the entity overrides in the order they are tried,
+	// then the config's segment overrides by segment name.
+	Overrides []OverrideCandidate `json:"overrides"`
+	// Rules lists the config's rules in the order they are tried.
+	Rules    []RuleCandidate    `json:"rules"`
+	Rollouts []RolloutCandidate `json:"rollouts"`
+	// Source is one of the ValueSource constants, Decision says in words why
+	// it won.
+	Source   string `json:"source"`
+	Decision string `json:"decision"`
+}
+
+// OverrideCandidate is an override key checked for a request. Found is set
+// when the config has an override for it, Matched when that override
+// applies: it hasn't expired and, for a segment, the request is in it.
+type OverrideCandidate struct {
+	OverrideKey
+	Found     bool       `json:"found"`
+	Value     any        `json:"value,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Matched   bool       `json:"matched"`
+}
+
+type RuleCandidate struct {
+	Index    int    `json:"index"`
+	Name     string `json:"name,omitempty"`
+	Priority int    `json:"priority"`
+	Value    any    `json:"value"`
+	Matched  bool   `json:"matched"`
+}
+
+// RolloutCandidate is one of a config's rollouts. EntityId and Bucket are
+// set when the request has an entity of EntityType.
+type RolloutCandidate struct {
+	Index      int     `json:"index"`
+	EntityType string  `json:"entityType"`
+	Percentage float64 `json:"percentage"`
+	Value      any     `json:"value"`
+	EntityId   string  `json:"entityId,omitempty"`
+	Bucket     *int    `json:"bucket,omitempty"`
+	Matched    bool    `json:"matched"`
+}
+
+// RuleMatch identifies the rule that produced a value by its index in the
+// config's rules.
+type RuleMatch struct {
+	Index int    `json:"index"`
+	Name  string `json:"name,omitempty"`
+}
+
+// RolloutMatch identifies the rollout that produced a value by its index in
+// the config's rollouts, along with the entity and the bucket it fell in.
+type RolloutMatch struct {
+	Index      int    `json:"index"`
+	EntityType string `json:"entityType"`
+	EntityId   string `json:"entityId"`
+	Bucket     int    `json:"bucket"`
+}
+
+type ListScheduledChangesResponse struct {
+	Changes []ScheduledChangePayload `json:"changes"`
+}
+
+type DeleteScheduledChangeResponse = SimpleResponse
+
+type ListSegmentsResponse struct {
+	Segments []Segment `json:"segments"`
+}
+
+type PostSegmentRequest struct {
+	Segment Segment `json:"segment"`
+}
+
+type PostSegmentResponse = SimpleResponse
+
+type GetSegmentResponse struct {
+	Segment Segment `json:"segment"`
+	// UsedBy lists the configs with an override targeting the segment.
+	UsedBy []ConfigPath `json:"usedBy"`
+}
+
+type DeleteSegmentResponse = SimpleResponse
+
+type GetHistoryResponse struct {
+	History []HistoryEntryPayload `json:"history"`
+}
+
+type RollbackConfigRequest struct {
+	Revision int64 `json:"revision"`
+}
+
+type RollbackConfigResponse struct {
+	Config    ConfigPayload     `json:"config"`
+	Overrides []OverridePayload `json:"overrides"`
+}
+
+type PollServiceResponse struct {
+	// Revision is the cursor to pass to the next poll.
+	Revision string          `json:"revision"`
+	Configs  []ConfigPayload `json:"configs"`
+	// Deleted lists the names of configs removed since the last poll.
+	Deleted []string `json:"deleted"`
+	// Reset is set when Configs holds every config in the service rather
+	// than only the changed ones.
+	Reset bool `json:"reset"`
+}
+
+type GetServiceValuesRequest struct {
+	Attributes map[string]string `json:"attributes"`
+	Names      []string          `json:"names"`
+}
+
+type GetValuesRequest struct {
+	Attributes map[string]string `json:"attributes"`
+	Configs    []ConfigPath      `json:"configs"`
+}
+
+// ConfigValueResult is one config's value in a batch. A config that can't be
+// found has Error set instead of failing the whole batch.
+type ConfigValueResult struct {
+	ConfigPath
+	GetConfigValueResponse
+	Error *ErrorResponse `json:"error,omitempty"`
+}
+
+type GetValuesResponse struct {
+	Values []ConfigValueResult `json:"values"`
+}
+
+// ExportDocument holds every segment, config and override, it is served by
+// /export and read by /import in either JSON or YAML. Segments are sorted by
+// name, configs by path and overrides by entity so the same state always
+// exports the same document.
+type ExportDocument struct {
+	FormatVersion int             `json:"formatVersion" yaml:"formatVersion"`
+	Segments      []ExportSegment `json:"segments,omitempty" yaml:"segments,omitempty"`
+	Configs       []ExportConfig  `json:"configs" yaml:"configs"`
+}
+
+type ExportSegment struct {
+	Name       string     `json:"name" yaml:"name"`
+	EntityType string     `json:"entityType,omitempty" yaml:"entityType,omitempty"`
+	EntityIds  []string   `json:"entityIds,omitempty" yaml:"entityIds,omitempty"`
+	Condition  *Condition `json:"condition,omitempty" yaml:"condition,omitempty"`
+}
+
+type ExportConfig struct {
+	Service      string           `json:"service" yaml:"service"`
+	Name         string           `json:"name" yaml:"name"`
+	Type         string           `json:"type" yaml:"type"`
+	DefaultValue any              `json:"defaultValue" yaml:"defaultValue"`
+	Priority     []string         `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Rules        []ExportRule     `json:"rules,omitempty" yaml:"rules,omitempty"`
+	Rollouts     []ExportRollout  `json:"rollouts,omitempty" yaml:"rollouts,omitempty"`
+	Overrides    []ExportOverride `json:"overrides,omitempty" yaml:"overrides,omitempty"`
+}
+
+type ExportRule struct {
+	Name      string    `json:"name,omitempty" yaml:"name,omitempty"`
+	Priority  int       `json:"priority" yaml:"priority"`
+	Condition Condition `json:"condition" yaml:"condition"`
+	Value     any       `json:"value" yaml:"value"`
+}
+
+type ExportRollout struct {
+	EntityType string  `json:"entityType" yaml:"entityType"`
+	Percentage float64 `json:"percentage" yaml:"percentage"`
+	Value      any     `json:"value" yaml:"value"`
+}
+
+type ExportOverride struct {
+	EntityType string     `json:"entityType" yaml:"entityType"`
+	EntityId   string     `json:"entityId" yaml:"entityId"`
+	Value      any        `json:"value" yaml:"value"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty" yaml:"expiresAt,omitempty"`
+}
+
+// ImportChange is a config, or one of its overrides when Override is set,
+// changed by an import. Changed segments only set Segment.
+type ImportChange struct {
+	ConfigPath
+	Override *OverrideKey `json:"override,omitempty"`
+	Segment  string       `json:"segment,omitempty"`
+}
+
+type ImportReport struct {
+	Mode    string         `json:"mode"`
+	DryRun  bool           `json:"dryRun"`
+	Created []ImportChange `json:"created"`
+	Updated []ImportChange `json:"updated"`
+	Deleted []ImportChange `json:"deleted"`
+}
+
+type SyncStatus struct {
+	// Enabled is set when the service syncs from a directory, see
+	// DirectorySync.
+	Enabled  bool       `json:"enabled"`
+	Dir      string     `json:"dir,omitempty"`
+	DryRun   bool       `json:"dryRun"`
+	LastSync *time.Time `json:"lastSync,omitempty"`
+	// Error is set when the directory itself couldn't be read.
+	Error string           `json:"error,omitempty"`
+	Files []SyncFileStatus `json:"files"`
+}
+
+// SyncFileStatus is the outcome of syncing one service's file. Drift lists
+// what didn't match the file, Error why the file was rejected.
+type SyncFileStatus struct {
+	File    string        `json:"file"`
+	Service string        `json:"service"`
+	InSync  bool          `json:"inSync"`
+	Drift   *ImportReport `json:"drift,omitempty"`
+	Error   string        `json:"error,omitempty"`
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultPollTimeout = 30 * time.Second
+	maxPollTimeout     = 5 * time.Minute
+)
+
+// GetPollTimeout reads how long to wait for a change from the timeout query
+// parameter, in seconds.
+func GetPollTimeout(r *http.Request) (time.Duration, error) {
+	timeoutStr := r.URL.Query().Get("timeout")
+	if timeoutStr == "" {
+		return defaultPollTimeout, nil
+	}
+	seconds, err := strconv.Atoi(timeoutStr)
+	if err != nil || seconds < 0 {
+		return 0, NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, "timeout must be a non-negative number of seconds")
+	}
+	return min(time.Duration(seconds)*time.Second, maxPollTimeout), nil
+}
+
+// PollService is the long-poll alternative to Watch for clients that can't
+// keep a stream open. Service revisions are the ids of the watch events, so
+// the revision from a response can be passed straight back as the next
+// cursor. Without a revision, or with one the service can no longer catch
+// up from such as one from before a restart, every config in the service is
+// returned with reset set. Otherwise it waits until a config in the service
+// changes or the timeout passes and returns the configs that changed.
+func (h *Handlers) PollService(r *http.Request) (*HttpResponse, error) {
+	service := mux.Vars(r)["service"]
+	format, err := h.GetValueFormat(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get value format from request")
+	}
+	timeout, err := GetPollTimeout(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get timeout from request")
+	}
+
+	// Subscribe before looking for changes so none can slip in between.
+	notify, unsubscribe := h.Events.Subscribe()
+	defer unsubscribe()
+
+	revisionStr := r.URL.Query().Get("revision")
+	if revisionStr == "" {
+		return h.pollReset(service, format)
+	}
+	epoch, revision, err := ParseEventId(revisionStr)
+	if err != nil {
+		return nil, NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, "revision must be the revision of an earlier poll or snapshot")
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		events, ok := h.Events.Since(epoch, revision)
+		if !ok {
+			return h.pollReset(service, format)
+		}
+		changed := []string{}
+		for _, event := range events {
+			revision = event.Id
+			if event.Entry.Service == service && !slices.Contains(changed, event.Entry.Name) {
+				changed = append(changed, event.Entry.Name)
+			}
+		}
+		if len(changed) > 0 {
+			return h.pollChanges(service, changed, FormatEventId(epoch, revision), format)
+		}
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return h.pollChanges(service, changed, FormatEventId(epoch, revision), format)
+		case <-r.Context().Done():
+			return h.pollChanges(service, changed, FormatEventId(epoch, revision), format)
+		}
+	}
+}
+
+// pollChanges reports the current state of the named configs.
+func (h *Handlers) pollChanges(service string, names []string, revision string, format string) (*HttpResponse, error) {
+	response := PollServiceResponse{
+		Revision: revision,
+		Configs:  []ConfigPayload{},
+		Deleted:  []string{},
+	}
+	for _, name := range names {
+		config, found, err := h.findConfig(&ConfigPath{Service: service, Name: name})
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			response.Configs = append(response.Configs, NewConfigPayload(config, format))
+		} else {
+			response.Deleted = append(response.Deleted, name)
+		}
+	}
+	return pollResponse(&response)
+}
+
+// pollReset reports every config in the service.
+func (h *Handlers) pollReset(service string, format string) (*HttpResponse, error) {
+	// Read the revision first, a change landing in between is returned
+	// again by the next poll rather than lost.
+	response := PollServiceResponse{
+		Revision: FormatEventId(h.Events.Epoch(), h.Events.LastId()),
+		Configs:  []ConfigPayload{},
+		Deleted:  []string{},
+		Reset:    true,
+	}
+	configs, err := h.ConfigDb.GetConfigs()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get configs from db")
+	}
+	for _, config := range configs {
+		if config.Service == service {
+			response.Configs = append(response.Configs, NewConfigPayload(config, format))
+		}
+	}
+	return pollResponse(&response)
+}
+
+func pollResponse(response *PollServiceResponse) (*HttpResponse, error) {
+	respBytes, err := json.Marshal(response)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal response")
+	}
+	return &HttpResponse{
+		Status: http.StatusOK,
+		Data:   respBytes,
+	}, nil
+}
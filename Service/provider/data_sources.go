@@ -0,0 +1,112 @@
+package provider
+
+import (
+	"context"
+
+	"Service/client"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func dataSourceConfig() *schema.Resource {
+	return &schema.Resource{
+		Description: "Reads an existing config.",
+		ReadContext: dataSourceConfigRead,
+		Schema: map[string]*schema.Schema{
+			"service": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"name": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"type": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"default_value": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"priority": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"revision": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func dataSourceConfigRead(ctx context.Context, d *schema.ResourceData, meta any) diag.Diagnostics {
+	c := meta.(*client.Client)
+	path := client.ConfigPath{
+		Service: d.Get("service").(string),
+		Name:    d.Get("name").(string),
+	}
+	config, err := c.GetConfig(ctx, path)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	d.SetId(configId(path))
+	d.Set("type", config.Type)
+	d.Set("default_value", config.DefaultValue)
+	d.Set("priority", config.Priority)
+	d.Set("revision", int(config.Revision))
+	return nil
+}
+
+func dataSourceOverride() *schema.Resource {
+	return &schema.Resource{
+		Description: "Reads an existing override.",
+		ReadContext: dataSourceOverrideRead,
+		Schema: map[string]*schema.Schema{
+			"service": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"name": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"entity_type": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"entity_id": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"value": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func dataSourceOverrideRead(ctx context.Context, d *schema.ResourceData, meta any) diag.Diagnostics {
+	c := meta.(*client.Client)
+	path := client.ConfigPath{
+		Service: d.Get("service").(string),
+		Name:    d.Get("name").(string),
+	}
+	key := client.OverrideKey{
+		EntityType: d.Get("entity_type").(string),
+		EntityId:   d.Get("entity_id").(string),
+	}
+	override, err := c.GetOverride(ctx, path, key)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	d.SetId(overrideId(path, key))
+	d.Set("value", override.Value)
+	return nil
+}
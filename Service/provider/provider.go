@@ -0,0 +1,92 @@
+// Package provider is the Terraform provider for ConfigService. It manages
+// configs, overrides and segments through the REST endpoints using the
+// client package.
+package provider
+
+import (
+	"context"
+	"strings"
+
+	"Service/client"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/pkg/errors"
+)
+
+const defaultActor = "terraform"
+
+func New() *schema.Provider {
+	return &schema.Provider{
+		Schema: map[string]*schema.Schema{
+			"endpoint": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CONFIGSERVICE_ENDPOINT", "http://localhost:8080"),
+				Description: "Base URL of the config service.",
+			},
+			"actor": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CONFIGSERVICE_ACTOR", defaultActor),
+				Description: "Name recorded in the config history for changes made by Terraform.",
+			},
+		},
+		ResourcesMap: map[string]*schema.Resource{
+			"configservice_config":   resourceConfig(),
+			"configservice_override": resourceOverride(),
+			"configservice_segment":  resourceSegment(),
+		},
+		DataSourcesMap: map[string]*schema.Resource{
+			"configservice_config":   dataSourceConfig(),
+			"configservice_override": dataSourceOverride(),
+		},
+		ConfigureContextFunc: configure,
+	}
+}
+
+func configure(ctx context.Context, d *schema.ResourceData) (any, diag.Diagnostics) {
+	return client.New(client.Options{
+		BaseURL: strings.TrimSuffix(d.Get("endpoint").(string), "/"),
+		Actor:   d.Get("actor").(string),
+	}), nil
+}
+
+// configPathFromId parses the service/name id of a config. Everything
+// after the first slash is the name.
+func configPathFromId(id string) (client.ConfigPath, error) {
+	parts := strings.SplitN(id, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return client.ConfigPath{}, errors.Errorf("config id %q must be service/name", id)
+	}
+	return client.ConfigPath{Service: parts[0], Name: parts[1]}, nil
+}
+
+func configId(path client.ConfigPath) string {
+	return path.Service + "/" + path.Name
+}
+
+// overrideFromId parses the service/name/entityType/entityId id of an
+// override. Everything after the third slash is the entity id, which is
+// the part most likely to hold one.
+func overrideFromId(id string) (client.ConfigPath, client.OverrideKey, error) {
+	parts := strings.SplitN(id, "/", 4)
+	if len(parts) != 4 || parts[0] == "" || parts[1] == "" || parts[2] == "" || parts[3] == "" {
+		return client.ConfigPath{}, client.OverrideKey{}, errors.Errorf("override id %q must be service/name/entityType/entityId", id)
+	}
+	return client.ConfigPath{Service: parts[0], Name: parts[1]},
+		client.OverrideKey{EntityType: parts[2], EntityId: parts[3]},
+		nil
+}
+
+func overrideId(path client.ConfigPath, key client.OverrideKey) string {
+	return configId(path) + "/" + key.EntityType + "/" + key.EntityId
+}
+
+func stringList(values []any) []string {
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		strs = append(strs, value.(string))
+	}
+	return strs
+}
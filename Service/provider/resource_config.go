@@ -0,0 +1,257 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+
+	"Service/client"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/structure"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+func resourceConfig() *schema.Resource {
+	return &schema.Resource{
+		Description:   "A config and its default value. Overrides are managed with configservice_override.",
+		CreateContext: resourceConfigCreate,
+		ReadContext:   resourceConfigRead,
+		UpdateContext: resourceConfigUpdate,
+		DeleteContext: resourceConfigDelete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceConfigImport,
+		},
+		Schema: map[string]*schema.Schema{
+			"service": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"name": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"type": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "One of bool, str, long or float.",
+			},
+			"default_value": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Default value in string form, such as \"true\" or \"42\".",
+			},
+			"priority": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Entity types from most to least important when several overrides match.",
+			},
+			"rule": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Gives a value to requests no override matched whose attributes satisfy a condition, the highest priority matching rule wins.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"priority": {
+							Type:     schema.TypeInt,
+							Optional: true,
+						},
+						"condition": {
+							Type:             schema.TypeString,
+							Required:         true,
+							ValidateFunc:     validation.StringIsJSON,
+							DiffSuppressFunc: structure.SuppressJsonDiff,
+							Description:      "Condition as JSON, usually written with jsonencode.",
+						},
+						"value": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Value in string form, it must match the config's type.",
+						},
+					},
+				},
+			},
+			"rollout": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Gives a value to a percentage of the entities of one type that no override or rule matched, the first matching rollout wins.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"entity_type": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"percentage": {
+							Type:         schema.TypeFloat,
+							Required:     true,
+							ValidateFunc: validation.FloatBetween(0, 100),
+						},
+						"value": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Value in string form, it must match the config's type.",
+						},
+					},
+				},
+			},
+			"revision": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourceConfigCreate(ctx context.Context, d *schema.ResourceData, meta any) diag.Diagnostics {
+	return resourceConfigSave(ctx, d, meta, meta.(*client.Client).SetConfig)
+}
+
+// resourceConfigUpdate keeps the config's overrides, they may belong to
+// configservice_override resources.
+func resourceConfigUpdate(ctx context.Context, d *schema.ResourceData, meta any) diag.Diagnostics {
+	return resourceConfigSave(ctx, d, meta, meta.(*client.Client).UpdateConfig)
+}
+
+func resourceConfigSave(ctx context.Context, d *schema.ResourceData, meta any, save func(context.Context, client.Config) error) diag.Diagnostics {
+	rules, err := expandRules(d.Get("rule").([]any))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	config := client.Config{
+		ConfigPath: client.ConfigPath{
+			Service: d.Get("service").(string),
+			Name:    d.Get("name").(string),
+		},
+		Type:         d.Get("type").(string),
+		DefaultValue: d.Get("default_value").(string),
+		Priority:     stringList(d.Get("priority").([]any)),
+		Rules:        rules,
+		Rollouts:     expandRollouts(d.Get("rollout").([]any)),
+	}
+	err = save(ctx, config)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.SetId(configId(config.ConfigPath))
+	return resourceConfigRead(ctx, d, meta)
+}
+
+func resourceConfigRead(ctx context.Context, d *schema.ResourceData, meta any) diag.Diagnostics {
+	c := meta.(*client.Client)
+	path, err := configPathFromId(d.Id())
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	config, err := c.GetConfig(ctx, path)
+	if client.IsNotFound(err) {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	d.Set("service", config.Service)
+	d.Set("name", config.Name)
+	d.Set("type", config.Type)
+	d.Set("default_value", config.DefaultValue)
+	d.Set("priority", config.Priority)
+	rules, err := flattenRules(config.Rules)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.Set("rule", rules)
+	d.Set("rollout", flattenRollouts(config.Rollouts))
+	d.Set("revision", int(config.Revision))
+	return nil
+}
+
+func expandRules(values []any) ([]client.Rule, error) {
+	rules := make([]client.Rule, 0, len(values))
+	for _, value := range values {
+		block := value.(map[string]any)
+		rule := client.Rule{
+			Name:     block["name"].(string),
+			Priority: block["priority"].(int),
+			Value:    block["value"].(string),
+		}
+		err := json.Unmarshal([]byte(block["condition"].(string)), &rule.Condition)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func flattenRules(rules []client.Rule) ([]any, error) {
+	values := make([]any, 0, len(rules))
+	for _, rule := range rules {
+		condition, err := json.Marshal(rule.Condition)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, map[string]any{
+			"name":      rule.Name,
+			"priority":  rule.Priority,
+			"condition": string(condition),
+			"value":     rule.Value,
+		})
+	}
+	return values, nil
+}
+
+func expandRollouts(values []any) []client.Rollout {
+	rollouts := make([]client.Rollout, 0, len(values))
+	for _, value := range values {
+		block := value.(map[string]any)
+		rollouts = append(rollouts, client.Rollout{
+			EntityType: block["entity_type"].(string),
+			Percentage: block["percentage"].(float64),
+			Value:      block["value"].(string),
+		})
+	}
+	return rollouts
+}
+
+func flattenRollouts(rollouts []client.Rollout) []any {
+	values := make([]any, 0, len(rollouts))
+	for _, rollout := range rollouts {
+		values = append(values, map[string]any{
+			"entity_type": rollout.EntityType,
+			"percentage":  rollout.Percentage,
+			"value":       rollout.Value,
+		})
+	}
+	return values
+}
+
+func resourceConfigDelete(ctx context.Context, d *schema.ResourceData, meta any) diag.Diagnostics {
+	c := meta.(*client.Client)
+	path, err := configPathFromId(d.Id())
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = c.DeleteConfig(ctx, path)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.SetId("")
+	return nil
+}
+
+// resourceConfigImport accepts ids of the form service/name.
+func resourceConfigImport(ctx context.Context, d *schema.ResourceData, meta any) ([]*schema.ResourceData, error) {
+	_, err := configPathFromId(d.Id())
+	if err != nil {
+		return nil, err
+	}
+	return []*schema.ResourceData{d}, nil
+}
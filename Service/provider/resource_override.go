@@ -0,0 +1,119 @@
+package provider
+
+import (
+	"context"
+
+	"Service/client"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func resourceOverride() *schema.Resource {
+	return &schema.Resource{
+		Description:   "An override of a config's value for one entity.",
+		CreateContext: resourceOverrideSave,
+		ReadContext:   resourceOverrideRead,
+		UpdateContext: resourceOverrideSave,
+		DeleteContext: resourceOverrideDelete,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceOverrideImport,
+		},
+		Schema: map[string]*schema.Schema{
+			"service": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"name": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"entity_type": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"entity_id": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"value": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "Value in string form, it must match the config's type.",
+			},
+		},
+	}
+}
+
+func resourceOverrideSave(ctx context.Context, d *schema.ResourceData, meta any) diag.Diagnostics {
+	c := meta.(*client.Client)
+	path := client.ConfigPath{
+		Service: d.Get("service").(string),
+		Name:    d.Get("name").(string),
+	}
+	override := client.Override{
+		OverrideKey: client.OverrideKey{
+			EntityType: d.Get("entity_type").(string),
+			EntityId:   d.Get("entity_id").(string),
+		},
+		Value: d.Get("value").(string),
+	}
+	err := c.SetOverride(ctx, path, override)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.SetId(overrideId(path, override.OverrideKey))
+	return resourceOverrideRead(ctx, d, meta)
+}
+
+func resourceOverrideRead(ctx context.Context, d *schema.ResourceData, meta any) diag.Diagnostics {
+	c := meta.(*client.Client)
+	path, key, err := overrideFromId(d.Id())
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	// Not found covers both the override and its config being deleted.
+	override, err := c.GetOverride(ctx, path, key)
+	if client.IsNotFound(err) {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	d.Set("service", path.Service)
+	d.Set("name", path.Name)
+	d.Set("entity_type", override.EntityType)
+	d.Set("entity_id", override.EntityId)
+	d.Set("value", override.Value)
+	return nil
+}
+
+func resourceOverrideDelete(ctx context.Context, d *schema.ResourceData, meta any) diag.Diagnostics {
+	c := meta.(*client.Client)
+	path, key, err := overrideFromId(d.Id())
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	err = c.DeleteOverride(ctx, path, key)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.SetId("")
+	return nil
+}
+
+// resourceOverrideImport accepts ids of the form
+// service/name/entityType/entityId.
+func resourceOverrideImport(ctx context.Context, d *schema.ResourceData, meta any) ([]*schema.ResourceData, error) {
+	_, _, err := overrideFromId(d.Id())
+	if err != nil {
+		return nil, err
+	}
+	return []*schema.ResourceData{d}, nil
+}
@@ -0,0 +1,114 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+
+	"Service/client"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/structure"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+func resourceSegment() *schema.Resource {
+	return &schema.Resource{
+		Description:   "A named group of entities that overrides target with entity_type \"segment\" and the segment name as entity_id.",
+		CreateContext: resourceSegmentSave,
+		ReadContext:   resourceSegmentRead,
+		UpdateContext: resourceSegmentSave,
+		DeleteContext: resourceSegmentDelete,
+		Importer: &schema.ResourceImporter{
+			StateContext: schema.ImportStatePassthroughContext,
+		},
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"entity_type": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Entity type of entity_ids.",
+			},
+			"entity_ids": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Entities in the segment.",
+			},
+			"condition": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				ValidateFunc:     validation.StringIsJSON,
+				DiffSuppressFunc: structure.SuppressJsonDiff,
+				Description:      "Condition as JSON that puts requests in the segment, usually written with jsonencode.",
+			},
+			"revision": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourceSegmentSave(ctx context.Context, d *schema.ResourceData, meta any) diag.Diagnostics {
+	c := meta.(*client.Client)
+	segment := client.Segment{
+		Name:       d.Get("name").(string),
+		EntityType: d.Get("entity_type").(string),
+		EntityIds:  stringList(d.Get("entity_ids").([]any)),
+	}
+	if condition := d.Get("condition").(string); condition != "" {
+		segment.Condition = &client.Condition{}
+		err := json.Unmarshal([]byte(condition), segment.Condition)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+	err := c.SetSegment(ctx, segment)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.SetId(segment.Name)
+	return resourceSegmentRead(ctx, d, meta)
+}
+
+func resourceSegmentRead(ctx context.Context, d *schema.ResourceData, meta any) diag.Diagnostics {
+	c := meta.(*client.Client)
+	segment, _, err := c.GetSegment(ctx, d.Id())
+	if client.IsNotFound(err) {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	condition := ""
+	if segment.Condition != nil {
+		conditionBytes, err := json.Marshal(segment.Condition)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		condition = string(conditionBytes)
+	}
+	d.Set("name", segment.Name)
+	d.Set("entity_type", segment.EntityType)
+	d.Set("entity_ids", segment.EntityIds)
+	d.Set("condition", condition)
+	d.Set("revision", int(segment.Revision))
+	return nil
+}
+
+func resourceSegmentDelete(ctx context.Context, d *schema.ResourceData, meta any) diag.Diagnostics {
+	c := meta.(*client.Client)
+	err := c.DeleteSegment(ctx, d.Id())
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.SetId("")
+	return nil
+}
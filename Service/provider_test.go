@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"Service/provider"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// BuildTestProvider configures the provider against subject the way
+// Terraform would from a provider block.
+func BuildTestProvider(t *testing.T, subject *httptest.Server) (*schema.Provider, any) {
+	p := provider.New()
+	err := p.InternalValidate()
+	if err != nil {
+		t.Fatalf("Invalid provider: %v", err)
+	}
+	diags := p.Configure(context.Background(), terraform.NewResourceConfigRaw(map[string]any{
+		"endpoint": subject.URL + "/",
+		"actor":    "tf",
+	}))
+	ExpectNoDiags(t, diags)
+	return p, p.Meta()
+}
+
+func ExpectNoDiags(t *testing.T, diags diag.Diagnostics) {
+	t.Helper()
+	if diags.HasError() {
+		t.Fatalf("Expected no errors, but got %v", diags)
+	}
+}
+
+func TestProviderConfigResource(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+	ctx := context.Background()
+	p, meta := BuildTestProvider(t, subject)
+	resource := p.ResourcesMap["configservice_config"]
+	configPath := ConfigPath{Service: "service1", Name: "config1"}
+
+	d := schema.TestResourceDataRaw(t, resource.Schema, map[string]any{
+		"service":       "service1",
+		"name":          "config1",
+		"type":          "long",
+		"default_value": "1",
+		"priority":      []any{"user", "group"},
+		"rule": []any{
+			map[string]any{"priority": 2, "condition": `{"attribute": "plan", "operator": "equals", "values": ["pro"]}`, "value": "20"},
+		},
+		"rollout": []any{
+			map[string]any{"entity_type": "user", "percentage": 25.5, "value": "10"},
+		},
+	})
+	ExpectNoDiags(t, resource.CreateContext(ctx, d, meta))
+	if d.Id() != "service1/config1" || d.Get("revision").(int) != 1 {
+		t.Errorf("Expected service1/config1 at revision 1, but got %v at %v", d.Id(), d.Get("revision"))
+	}
+	config, err := app.ConfigDb.GetConfig(&configPath)
+	if err != nil || config.DefaultValue != "1" || len(config.Priority) != 2 ||
+		len(config.Rules) != 1 || config.Rules[0].Priority != 2 || config.Rules[0].Condition.Values[0] != "pro" ||
+		len(config.Rollouts) != 1 || config.Rollouts[0] != (Rollout{EntityType: "user", Percentage: 25.5, Value: "10"}) {
+		t.Errorf("Expected config to be created, but got %v, error: %v", config, err)
+	}
+	history, _ := app.ConfigDb.GetHistory(&configPath)
+	if len(history) != 1 || history[0].Actor != "tf" {
+		t.Errorf("Expected the change to be made by tf, but got %v", history)
+	}
+
+	// Overrides managed by configservice_override survive updates.
+	overrideKey := OverrideKey{EntityType: "user", EntityId: "123"}
+	err = app.Handlers.SaveOverride("tf", &configPath, &Override{OverrideKey: overrideKey, Value: "5"})
+	if err != nil {
+		t.Fatalf("Failed to save override: %v", err)
+	}
+	d.Set("default_value", "2")
+	ExpectNoDiags(t, resource.UpdateContext(ctx, d, meta))
+	config, _ = app.ConfigDb.GetConfig(&configPath)
+	if config.DefaultValue != "2" || d.Get("revision").(int) != 3 {
+		t.Errorf("Expected config to be updated to revision 3, but got %v", config)
+	}
+	override, found, err := app.ConfigDb.GetOverride(&configPath, &overrideKey)
+	if err != nil || !found || override.Value != "5" {
+		t.Errorf("Expected the override to be kept, but got %v, %v, error: %v", override, found, err)
+	}
+
+	// Changes made outside Terraform show up on refresh.
+	app.Handlers.SaveConfig("alice", &Config{ConfigPath: configPath, Type: "long", DefaultValue: "3"})
+	ExpectNoDiags(t, resource.ReadContext(ctx, d, meta))
+	if d.Get("default_value") != "3" || len(d.Get("priority").([]any)) != 0 || len(d.Get("rollout").([]any)) != 0 {
+		t.Errorf("Expected refresh to see default value 3 without a priority or rollouts, but got %v %v %v",
+			d.Get("default_value"), d.Get("priority"), d.Get("rollout"))
+	}
+
+	imported := resource.Data(nil)
+	imported.SetId("service1/config1")
+	states, err := resource.Importer.StateContext(ctx, imported, meta)
+	if err != nil || len(states) != 1 {
+		t.Fatalf("Failed to import config: %v", err)
+	}
+	ExpectNoDiags(t, resource.ReadContext(ctx, states[0], meta))
+	if states[0].Get("service") != "service1" || states[0].Get("type") != "long" || states[0].Get("default_value") != "3" {
+		t.Errorf("Expected imported config to be read, but got %v", states[0].State())
+	}
+	bad := resource.Data(nil)
+	bad.SetId("service1")
+	_, err = resource.Importer.StateContext(ctx, bad, meta)
+	if err == nil {
+		t.Errorf("Expected an import id without a name to fail")
+	}
+
+	invalid := schema.TestResourceDataRaw(t, resource.Schema, map[string]any{
+		"service":       "service1",
+		"name":          "config2",
+		"type":          "bool",
+		"default_value": "maybe",
+	})
+	if diags := resource.CreateContext(ctx, invalid, meta); !diags.HasError() {
+		t.Errorf("Expected an invalid default value to fail")
+	}
+
+	ExpectNoDiags(t, resource.DeleteContext(ctx, d, meta))
+	_, err = app.ConfigDb.GetConfig(&configPath)
+	if err != ErrConfigNotFound {
+		t.Errorf("Expected config to be deleted, but got %v", err)
+	}
+	ExpectNoDiags(t, resource.ReadContext(ctx, states[0], meta))
+	if states[0].Id() != "" {
+		t.Errorf("Expected a deleted config to be removed from state, but got %v", states[0].Id())
+	}
+}
+
+func TestProviderOverrideResource(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+	ctx := context.Background()
+	p, meta := BuildTestProvider(t, subject)
+	resource := p.ResourcesMap["configservice_override"]
+	configPath := ConfigPath{Service: "service1", Name: "config1"}
+	overrideKey := OverrideKey{EntityType: "user", EntityId: "123"}
+	app.Handlers.SaveConfig("alice", &Config{ConfigPath: configPath, Type: "bool", DefaultValue: "false"})
+
+	d := schema.TestResourceDataRaw(t, resource.Schema, map[string]any{
+		"service":     "service1",
+		"name":        "config1",
+		"entity_type": "user",
+		"entity_id":   "123",
+		"value":       "true",
+	})
+	ExpectNoDiags(t, resource.CreateContext(ctx, d, meta))
+	if d.Id() != "service1/config1/user/123" {
+		t.Errorf("Expected id service1/config1/user/123, but got %v", d.Id())
+	}
+	override, found, err := app.ConfigDb.GetOverride(&configPath, &overrideKey)
+	if err != nil || !found || override.Value != "true" {
+		t.Errorf("Expected override to be created, but got %v, error: %v", override, err)
+	}
+
+	d.Set("value", "false")
+	ExpectNoDiags(t, resource.UpdateContext(ctx, d, meta))
+	override, _, _ = app.ConfigDb.GetOverride(&configPath, &overrideKey)
+	if override.Value != "false" {
+		t.Errorf("Expected override to be updated, but got %v", override)
+	}
+
+	imported := resource.Data(nil)
+	imported.SetId("service1/config1/user/123")
+	states, err := resource.Importer.StateContext(ctx, imported, meta)
+	if err != nil || len(states) != 1 {
+		t.Fatalf("Failed to import override: %v", err)
+	}
+	ExpectNoDiags(t, resource.ReadContext(ctx, states[0], meta))
+	if states[0].Get("entity_id") != "123" || states[0].Get("value") != "false" || states[0].Get("name") != "config1" {
+		t.Errorf("Expected imported override to be read, but got %v", states[0].State())
+	}
+
+	invalid := schema.TestResourceDataRaw(t, resource.Schema, map[string]any{
+		"service":     "service1",
+		"name":        "config1",
+		"entity_type": "user",
+		"entity_id":   "456",
+		"value":       "maybe",
+	})
+	if diags := resource.CreateContext(ctx, invalid, meta); !diags.HasError() {
+		t.Errorf("Expected an invalid value to fail")
+	}
+
+	ExpectNoDiags(t, resource.DeleteContext(ctx, d, meta))
+	_, found, _ = app.ConfigDb.GetOverride(&configPath, &overrideKey)
+	if found {
+		t.Errorf("Expected override to be deleted")
+	}
+	ExpectNoDiags(t, resource.ReadContext(ctx, states[0], meta))
+	if states[0].Id() != "" {
+		t.Errorf("Expected a deleted override to be removed from state, but got %v", states[0].Id())
+	}
+}
+
+func TestProviderSegmentResource(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+	ctx := context.Background()
+	p, meta := BuildTestProvider(t, subject)
+	resource := p.ResourcesMap["configservice_segment"]
+
+	d := schema.TestResourceDataRaw(t, resource.Schema, map[string]any{
+		"name":        "staff",
+		"entity_type": "user",
+		"entity_ids":  []any{"1", "2"},
+		"condition":   `{"attribute": "email", "operator": "matches", "values": ["@example\\.com$"]}`,
+	})
+	ExpectNoDiags(t, resource.CreateContext(ctx, d, meta))
+	segment, err := app.ConfigDb.GetSegment("staff")
+	if err != nil || len(segment.EntityIds) != 2 || segment.Condition == nil || segment.Condition.Operator != "matches" {
+		t.Errorf("Expected segment to be created, but got %v, error: %v", segment, err)
+	}
+	if d.Id() != "staff" || d.Get("revision") != 1 {
+		t.Errorf("Expected id staff at revision 1, but got %v at %v", d.Id(), d.Get("revision"))
+	}
+
+	d.Set("entity_ids", []any{"1"})
+	d.Set("condition", "")
+	ExpectNoDiags(t, resource.UpdateContext(ctx, d, meta))
+	segment, _ = app.ConfigDb.GetSegment("staff")
+	if len(segment.EntityIds) != 1 || segment.Condition != nil || segment.Revision != 2 {
+		t.Errorf("Expected segment to be updated, but got %v", segment)
+	}
+
+	imported := resource.Data(nil)
+	imported.SetId("staff")
+	states, err := resource.Importer.StateContext(ctx, imported, meta)
+	if err != nil || len(states) != 1 {
+		t.Fatalf("Failed to import segment: %v", err)
+	}
+	ExpectNoDiags(t, resource.ReadContext(ctx, states[0], meta))
+	if states[0].Get("name") != "staff" || states[0].Get("entity_type") != "user" {
+		t.Errorf("Expected imported segment to be read, but got %v", states[0].State())
+	}
+
+	ExpectNoDiags(t, resource.DeleteContext(ctx, d, meta))
+	ExpectNoDiags(t, resource.ReadContext(ctx, states[0], meta))
+	if states[0].Id() != "" {
+		t.Errorf("Expected a deleted segment to be removed from state, but got %v", states[0].Id())
+	}
+}
+
+func TestProviderDataSources(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+	ctx := context.Background()
+	p, meta := BuildTestProvider(t, subject)
+	configPath := ConfigPath{Service: "service1", Name: "config1"}
+	app.Handlers.SaveConfig("alice", &Config{ConfigPath: configPath, Type: "float", DefaultValue: "1.5", Priority: []string{"user"}})
+	app.Handlers.SaveOverride("alice", &configPath, &Override{OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"}, Value: "2.5"})
+
+	dataSource := p.DataSourcesMap["configservice_config"]
+	d := schema.TestResourceDataRaw(t, dataSource.Schema, map[string]any{
+		"service": "service1",
+		"name":    "config1",
+	})
+	ExpectNoDiags(t, dataSource.ReadContext(ctx, d, meta))
+	if d.Id() != "service1/config1" || d.Get("type") != "float" || d.Get("default_value") != "1.5" ||
+		d.Get("revision").(int) != 2 || len(d.Get("priority").([]any)) != 1 {
+		t.Errorf("Expected config data to be read, but got %v", d.State())
+	}
+
+	dataSource = p.DataSourcesMap["configservice_override"]
+	d = schema.TestResourceDataRaw(t, dataSource.Schema, map[string]any{
+		"service":     "service1",
+		"name":        "config1",
+		"entity_type": "user",
+		"entity_id":   "123",
+	})
+	ExpectNoDiags(t, dataSource.ReadContext(ctx, d, meta))
+	if d.Get("value") != "2.5" {
+		t.Errorf("Expected override value 2.5, but got %v", d.Get("value"))
+	}
+
+	d = schema.TestResourceDataRaw(t, dataSource.Schema, map[string]any{
+		"service":     "service1",
+		"name":        "config1",
+		"entity_type": "user",
+		"entity_id":   "456",
+	})
+	if diags := dataSource.ReadContext(ctx, d, meta); !diags.HasError() {
+		t.Errorf("Expected a missing override to fail")
+	}
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisConfigDb stores configs and overrides in redis. All keys are
+// namespaced by ConfigDbConfig.Database:
+//
+//	<database>:configs                       hash of service/name -> Config
+//	<database>:overrides:<service>/<name>          hash of entityType/entityId -> Override
+//	<database>:segment-overrides:<service>/<name>  hash of segment/name -> Override
+//	<database>:history:<service>/<name>            list of HistoryEntry, oldest first
+//	<database>:segments                            hash of name -> Segment
+//	<database>:schedules                           hash of id -> ScheduledChange
+//	<database>:expiries                            sorted set of OverrideRef by expiresAt
+//
+// Keeping one override hash per config means evaluating a value is a single
+// HGET per entity attribute regardless of how many overrides exist. Segment
+// overrides have to be read as a whole, so they get a hash of their own and
+// reading them never walks the entity overrides.
+type RedisConfigDb struct {
+	Config ConfigDbConfig
+
+	client *redis.Client
+}
+
+// Number of override hash entries fetched per HSCAN round trip.
+const redisScanCount = 1000
+
+func NewRedisConfigDb(config ConfigDbConfig) *RedisConfigDb {
+	client := redis.NewClient(&redis.Options{
+		Addr:     config.Address,
+		Username: config.User,
+		Password: config.Password,
+	})
+	return &RedisConfigDb{
+		Config: config,
+		client: client,
+	}
+}
+
+func (db *RedisConfigDb) Close() error {
+	return db.client.Close()
+}
+
+func (db *RedisConfigDb) configsKey() string {
+	return db.Config.Database + ":configs"
+}
+
+func (db *RedisConfigDb) overridesKey(config *ConfigPath) string {
+	return db.Config.Database + ":overrides:" + GetConfigPathStr(config)
+}
+
+func (db *RedisConfigDb) segmentOverridesKey(config *ConfigPath) string {
+	return db.Config.Database + ":segment-overrides:" + GetConfigPathStr(config)
+}
+
+// overridesKeyOf returns the hash holding config's overrides of entityType.
+func (db *RedisConfigDb) overridesKeyOf(config *ConfigPath, entityType string) string {
+	if entityType == SegmentEntityType {
+		return db.segmentOverridesKey(config)
+	}
+	return db.overridesKey(config)
+}
+
+func (db *RedisConfigDb) historyKey(config *ConfigPath) string {
+	return db.Config.Database + ":history:" + GetConfigPathStr(config)
+}
+
+func (db *RedisConfigDb) segmentsKey() string {
+	return db.Config.Database + ":segments"
+}
+
+func (db *RedisConfigDb) schedulesKey() string {
+	return db.Config.Database + ":schedules"
+}
+
+func (db *RedisConfigDb) expiriesKey() string {
+	return db.Config.Database + ":expiries"
+}
+
+// expiryScore is t in unix milliseconds, rounded up so an override is
+// popped from the expiry index up to a millisecond late but never before it
+// has expired.
+func expiryScore(t time.Time) float64 {
+	return float64(t.Add(time.Millisecond - time.Nanosecond).UnixMilli())
+}
+
+// expiryMember is the expiry index entry of the override at key of config.
+func expiryMember(config *ConfigPath, key *OverrideKey) ([]byte, error) {
+	refBytes, err := json.Marshal(OverrideRef{ConfigPath: *config, OverrideKey: *key})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode override ref")
+	}
+	return refBytes, nil
+}
+
+// indexExpiry queues adding override to the expiry index on pipe if it
+// expires.
+func (db *RedisConfigDb) indexExpiry(ctx context.Context, pipe redis.Pipeliner, config *ConfigPath, override *Override) error {
+	if override.ExpiresAt == nil {
+		return nil
+	}
+	member, err := expiryMember(config, &override.OverrideKey)
+	if err != nil {
+		return err
+	}
+	pipe.ZAdd(ctx, db.expiriesKey(), redis.Z{Score: expiryScore(*override.ExpiresAt), Member: member})
+	return nil
+}
+
+// addOverrideScript writes an override only if its config exists, checking
+// and writing in one step so an override can't outlive a config deleted in
+// between. KEYS are the configs hash, the override hash and the expiry
+// index. ARGV is the config path, the override path and the override,
+// followed by its expiry score and index member when it expires. Returns 0
+// when the config doesn't exist.
+var addOverrideScript = redis.NewScript(`
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[2], ARGV[2], ARGV[3])
+if #ARGV > 3 then
+	redis.call("ZADD", KEYS[3], ARGV[4], ARGV[5])
+end
+return 1
+`)
+
+func (db *RedisConfigDb) GetConfigs() ([]Config, error) {
+	ctx := context.Background()
+	values, err := db.client.HVals(ctx, db.configsKey()).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read configs from redis")
+	}
+
+	configs := make([]Config, 0, len(values))
+	for _, value := range values {
+		var config Config
+		err = json.Unmarshal([]byte(value), &config)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode config")
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+func (db *RedisConfigDb) AddConfig(config *Config) error {
+	ctx := context.Background()
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode config")
+	}
+
+	_, err = db.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, db.configsKey(), GetConfigPathStr(&config.ConfigPath), configBytes)
+		pipe.Del(ctx, db.overridesKey(&config.ConfigPath), db.segmentOverridesKey(&config.ConfigPath))
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to write config to redis")
+	}
+	return nil
+}
+
+func (db *RedisConfigDb) UpdateConfig(config *Config) error {
+	ctx := context.Background()
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode config")
+	}
+
+	err = db.client.HSet(ctx, db.configsKey(), GetConfigPathStr(&config.ConfigPath), configBytes).Err()
+	if err != nil {
+		return errors.Wrap(err, "failed to write config to redis")
+	}
+	return nil
+}
+
+func (db *RedisConfigDb) GetConfig(path *ConfigPath) (Config, error) {
+	ctx := context.Background()
+	value, err := db.client.HGet(ctx, db.configsKey(), GetConfigPathStr(path)).Result()
+	if err == redis.Nil {
+		return Config{}, ErrConfigNotFound
+	}
+	if err != nil {
+		return Config{}, errors.Wrap(err, "failed to read config from redis")
+	}
+
+	var config Config
+	err = json.Unmarshal([]byte(value), &config)
+	if err != nil {
+		return Config{}, errors.Wrap(err, "failed to decode config")
+	}
+	return config, nil
+}
+
+func (db *RedisConfigDb) DeleteConfig(path *ConfigPath) error {
+	ctx := context.Background()
+	_, err := db.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, db.configsKey(), GetConfigPathStr(path))
+		pipe.Del(ctx, db.overridesKey(path), db.segmentOverridesKey(path))
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to delete config from redis")
+	}
+	return nil
+}
+
+func (db *RedisConfigDb) configExists(ctx context.Context, config *ConfigPath) (bool, error) {
+	found, err := db.client.HExists(ctx, db.configsKey(), GetConfigPathStr(config)).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read config from redis")
+	}
+	return found, nil
+}
+
+func (db *RedisConfigDb) GetOverrides(config *ConfigPath) ([]Override, error) {
+	ctx := context.Background()
+	found, err := db.configExists(ctx, config)
+	if err != nil {
+		return []Override{}, err
+	}
+	if !found {
+		return []Override{}, ErrConfigNotFound
+	}
+	overrides, err := db.scanOverrides(ctx, db.overridesKey(config), "", []Override{})
+	if err != nil {
+		return []Override{}, err
+	}
+	return db.scanOverrides(ctx, db.segmentOverridesKey(config), "", overrides)
+}
+
+func (db *RedisConfigDb) GetOverridesOfType(config *ConfigPath, entityType string) ([]Override, error) {
+	ctx := context.Background()
+	found, err := db.configExists(ctx, config)
+	if err != nil {
+		return []Override{}, err
+	}
+	if !found {
+		return []Override{}, ErrConfigNotFound
+	}
+	if entityType == SegmentEntityType {
+		return db.scanOverrides(ctx, db.segmentOverridesKey(config), "", []Override{})
+	}
+	return db.scanOverrides(ctx, db.overridesKey(config), escapeRedisPattern(entityType)+"/*", []Override{})
+}
+
+// scanOverrides appends the overrides in the hash at key whose path matches
+// the glob pattern match, or every override when match is empty.
+func (db *RedisConfigDb) scanOverrides(ctx context.Context, key string, match string, overrides []Override) ([]Override, error) {
+	// HSCAN rather than HGETALL so configs with very large override sets
+	// don't block redis while the whole hash is serialized.
+	iter := db.client.HScan(ctx, key, 0, match, redisScanCount).Iterator()
+	isValue := false
+	for iter.Next(ctx) {
+		// HSCAN yields alternating field and value entries.
+		if !isValue {
+			isValue = true
+			continue
+		}
+		isValue = false
+
+		var override Override
+		err := json.Unmarshal([]byte(iter.Val()), &override)
+		if err != nil {
+			return []Override{}, errors.Wrap(err, "failed to decode override")
+		}
+		overrides = append(overrides, override)
+	}
+	if err := iter.Err(); err != nil {
+		return []Override{}, errors.Wrap(err, "failed to read overrides from redis")
+	}
+	return overrides, nil
+}
+
+func (db *RedisConfigDb) AddOverride(config *ConfigPath, override *Override) error {
+	ctx := context.Background()
+	overrideBytes, err := json.Marshal(override)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode override")
+	}
+	keys := []string{db.configsKey(), db.overridesKeyOf(config, override.EntityType), db.expiriesKey()}
+	args := []any{GetConfigPathStr(config), GetOverridePathStr(&override.OverrideKey), overrideBytes}
+	if override.ExpiresAt != nil {
+		member, err := expiryMember(config, &override.OverrideKey)
+		if err != nil {
+			return err
+		}
+		args = append(args, expiryScore(*override.ExpiresAt), member)
+	}
+
+	added, err := addOverrideScript.Run(ctx, db.client, keys, args...).Int()
+	if err != nil {
+		return errors.Wrap(err, "failed to write override to redis")
+	}
+	if added == 0 {
+		return ErrConfigNotFound
+	}
+	return nil
+}
+
+func (db *RedisConfigDb) GetOverride(config *ConfigPath, overrideKey *OverrideKey) (Override, bool, error) {
+	ctx := context.Background()
+	var existsCmd *redis.BoolCmd
+	var overrideCmd *redis.StringCmd
+	_, err := db.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		existsCmd = pipe.HExists(ctx, db.configsKey(), GetConfigPathStr(config))
+		overrideCmd = pipe.HGet(ctx, db.overridesKeyOf(config, overrideKey.EntityType), GetOverridePathStr(overrideKey))
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return Override{}, false, errors.Wrap(err, "failed to read override from redis")
+	}
+	if !existsCmd.Val() {
+		return Override{}, false, ErrConfigNotFound
+	}
+
+	value, err := overrideCmd.Result()
+	if err == redis.Nil {
+		return Override{}, false, nil
+	}
+	if err != nil {
+		return Override{}, false, errors.Wrap(err, "failed to read override from redis")
+	}
+
+	var override Override
+	err = json.Unmarshal([]byte(value), &override)
+	if err != nil {
+		return Override{}, false, errors.Wrap(err, "failed to decode override")
+	}
+	return override, true, nil
+}
+
+func (db *RedisConfigDb) DeleteOverride(config *ConfigPath, overrideKey *OverrideKey) error {
+	ctx := context.Background()
+	err := db.client.HDel(ctx, db.overridesKeyOf(config, overrideKey.EntityType), GetOverridePathStr(overrideKey)).Err()
+	if err != nil {
+		return errors.Wrap(err, "failed to delete override from redis")
+	}
+	return nil
+}
+
+func (db *RedisConfigDb) PopExpiredOverrides(now time.Time) ([]OverrideRef, error) {
+	ctx := context.Background()
+	max := strconv.FormatInt(now.UnixMilli(), 10)
+	var rangeCmd *redis.StringSliceCmd
+	// Reading and removing in one transaction means services sharing the
+	// store never pop the same entry.
+	_, err := db.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		rangeCmd = pipe.ZRangeByScore(ctx, db.expiriesKey(), &redis.ZRangeBy{Min: "-inf", Max: max})
+		pipe.ZRemRangeByScore(ctx, db.expiriesKey(), "-inf", max)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to pop expired overrides from redis")
+	}
+
+	refs := make([]OverrideRef, 0, len(rangeCmd.Val()))
+	for _, value := range rangeCmd.Val() {
+		var ref OverrideRef
+		err = json.Unmarshal([]byte(value), &ref)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode override ref")
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+func (db *RedisConfigDb) RestoreConfig(config *Config, overrides []Override) error {
+	ctx := context.Background()
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode config")
+	}
+	// Field/value pairs to write, by the hash they go to.
+	overrideValues := make(map[string][]any)
+	for _, override := range overrides {
+		overrideBytes, err := json.Marshal(override)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode override")
+		}
+		key := db.overridesKeyOf(&config.ConfigPath, override.EntityType)
+		overrideValues[key] = append(overrideValues[key], GetOverridePathStr(&override.OverrideKey), overrideBytes)
+	}
+
+	_, err = db.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, db.configsKey(), GetConfigPathStr(&config.ConfigPath), configBytes)
+		pipe.Del(ctx, db.overridesKey(&config.ConfigPath), db.segmentOverridesKey(&config.ConfigPath))
+		for key, values := range overrideValues {
+			pipe.HSet(ctx, key, values...)
+		}
+		for _, override := range overrides {
+			err := db.indexExpiry(ctx, pipe, &config.ConfigPath, &override)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to restore config in redis")
+	}
+	return nil
+}
+
+func (db *RedisConfigDb) AddHistory(entry *HistoryEntry) error {
+	ctx := context.Background()
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode history entry")
+	}
+	err = db.client.RPush(ctx, db.historyKey(&entry.ConfigPath), entryBytes).Err()
+	if err != nil {
+		return errors.Wrap(err, "failed to write history to redis")
+	}
+	return nil
+}
+
+func (db *RedisConfigDb) GetHistory(config *ConfigPath) ([]HistoryEntry, error) {
+	ctx := context.Background()
+	values, err := db.client.LRange(ctx, db.historyKey(config), 0, -1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read history from redis")
+	}
+
+	history := make([]HistoryEntry, 0, len(values))
+	for _, value := range values {
+		var entry HistoryEntry
+		err = json.Unmarshal([]byte(value), &entry)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode history entry")
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
+
+func (db *RedisConfigDb) GetSegments() ([]Segment, error) {
+	ctx := context.Background()
+	values, err := db.client.HVals(ctx, db.segmentsKey()).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read segments from redis")
+	}
+
+	segments := make([]Segment, 0, len(values))
+	for _, value := range values {
+		var segment Segment
+		err = json.Unmarshal([]byte(value), &segment)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode segment")
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+func (db *RedisConfigDb) GetSegment(name string) (Segment, error) {
+	ctx := context.Background()
+	value, err := db.client.HGet(ctx, db.segmentsKey(), name).Result()
+	if err == redis.Nil {
+		return Segment{}, ErrSegmentNotFound
+	}
+	if err != nil {
+		return Segment{}, errors.Wrap(err, "failed to read segment from redis")
+	}
+
+	var segment Segment
+	err = json.Unmarshal([]byte(value), &segment)
+	if err != nil {
+		return Segment{}, errors.Wrap(err, "failed to decode segment")
+	}
+	return segment, nil
+}
+
+func (db *RedisConfigDb) AddSegment(segment *Segment) error {
+	ctx := context.Background()
+	segmentBytes, err := json.Marshal(segment)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode segment")
+	}
+	err = db.client.HSet(ctx, db.segmentsKey(), segment.Name, segmentBytes).Err()
+	if err != nil {
+		return errors.Wrap(err, "failed to write segment to redis")
+	}
+	return nil
+}
+
+func (db *RedisConfigDb) DeleteSegment(name string) error {
+	ctx := context.Background()
+	err := db.client.HDel(ctx, db.segmentsKey(), name).Err()
+	if err != nil {
+		return errors.Wrap(err, "failed to delete segment from redis")
+	}
+	return nil
+}
+
+func (db *RedisConfigDb) GetScheduledChanges() ([]ScheduledChange, error) {
+	ctx := context.Background()
+	values, err := db.client.HVals(ctx, db.schedulesKey()).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read scheduled changes from redis")
+	}
+
+	changes := make([]ScheduledChange, 0, len(values))
+	for _, value := range values {
+		var change ScheduledChange
+		err = json.Unmarshal([]byte(value), &change)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode scheduled change")
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func (db *RedisConfigDb) AddScheduledChange(change *ScheduledChange) error {
+	ctx := context.Background()
+	changeBytes, err := json.Marshal(change)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode scheduled change")
+	}
+	err = db.client.HSet(ctx, db.schedulesKey(), change.Id, changeBytes).Err()
+	if err != nil {
+		return errors.Wrap(err, "failed to write scheduled change to redis")
+	}
+	return nil
+}
+
+func (db *RedisConfigDb) DeleteScheduledChange(id string) (bool, error) {
+	ctx := context.Background()
+	deleted, err := db.client.HDel(ctx, db.schedulesKey(), id).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to delete scheduled change from redis")
+	}
+	return deleted > 0, nil
+}
+
+// escapeRedisPattern quotes the glob characters in value so it only
+// matches itself in a SCAN pattern.
+func escapeRedisPattern(value string) string {
+	var escaped strings.Builder
+	for _, r := range value {
+		if strings.ContainsRune(`*?[]\^-`, r) {
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pkg/errors"
+)
+
+func BuildRedisConfigDb(t *testing.T) (*RedisConfigDb, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	server.RequireUserAuth("redis", "redis")
+
+	db := NewRedisConfigDb(ConfigDbConfig{
+		Address:  server.Addr(),
+		User:     "redis",
+		Password: "redis",
+		Database: "configs",
+	})
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db, server
+}
+
+func TestRedisAddAndGetConfig(t *testing.T) {
+	db, server := BuildRedisConfigDb(t)
+
+	configPath := ConfigPath{
+		Service: "service1",
+		Name:    "config1",
+	}
+	err := db.AddConfig(&Config{
+		ConfigPath:   configPath,
+		Type:         "str",
+		DefaultValue: "value1",
+	})
+	if err != nil {
+		t.Fatalf("Failed to add config: %v", err)
+	}
+
+	config, err := db.GetConfig(&configPath)
+	if err != nil {
+		t.Fatalf("Failed to get config: %v", err)
+	}
+	if config.DefaultValue != "value1" || config.Type != "str" {
+		t.Errorf("Expected string config with value1, got %v", config)
+	}
+
+	configs, err := db.GetConfigs()
+	if err != nil {
+		t.Fatalf("Failed to get configs: %v", err)
+	}
+	if len(configs) != 1 {
+		t.Errorf("Expected 1 config, but got %v", configs)
+	}
+
+	if !server.Exists("configs:configs") {
+		t.Errorf("Expected configs to be stored under configs:configs")
+	}
+
+	_, err = db.GetConfig(&ConfigPath{Service: "service1", Name: "missing"})
+	if err == nil {
+		t.Errorf("Expected error when getting missing config, got nil")
+	}
+}
+
+func TestRedisDeleteConfig(t *testing.T) {
+	db, server := BuildRedisConfigDb(t)
+
+	configPath := ConfigPath{
+		Service: "service1",
+		Name:    "config1",
+	}
+	db.AddConfig(&Config{
+		ConfigPath:   configPath,
+		Type:         "str",
+		DefaultValue: "value1",
+	})
+	db.AddOverride(&configPath, &Override{
+		OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"},
+		Value:       "override1",
+	})
+
+	err := db.DeleteConfig(&configPath)
+	if err != nil {
+		t.Fatalf("Failed to delete config: %v", err)
+	}
+	_, err = db.GetConfig(&configPath)
+	if err == nil {
+		t.Errorf("Expected error when getting deleted config, got nil")
+	}
+	if server.Exists("configs:overrides:service1/config1") {
+		t.Errorf("Expected overrides of deleted config to be removed")
+	}
+}
+
+func TestRedisAddOverrideToMissingConfig(t *testing.T) {
+	db, server := BuildRedisConfigDb(t)
+
+	configPath := ConfigPath{
+		Service: "service1",
+		Name:    "config1",
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	err := db.AddOverride(&configPath, &Override{
+		OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"},
+		Value:       "override1",
+		ExpiresAt:   &expiresAt,
+	})
+	if !errors.Is(err, ErrConfigNotFound) {
+		t.Errorf("Expected ErrConfigNotFound, got %v", err)
+	}
+	if server.Exists("configs:overrides:service1/config1") || server.Exists("configs:expiries") {
+		t.Errorf("Expected nothing written for a missing config")
+	}
+
+	db.AddConfig(&Config{
+		ConfigPath:   configPath,
+		Type:         "str",
+		DefaultValue: "value1",
+	})
+	err = db.AddOverride(&configPath, &Override{
+		OverrideKey: OverrideKey{EntityType: "user", EntityId: "123"},
+		Value:       "override1",
+		ExpiresAt:   &expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("Failed to add override: %v", err)
+	}
+	refs, err := db.PopExpiredOverrides(expiresAt.Add(time.Millisecond))
+	if err != nil || len(refs) != 1 || refs[0].ConfigPath != configPath {
+		t.Errorf("Expected the override in the expiry index, got %v, error: %v", refs, err)
+	}
+}
+
+func TestRedisGetOverridesScansLargeSets(t *testing.T) {
+	db, _ := BuildRedisConfigDb(t)
+
+	configPath := ConfigPath{
+		Service: "service1",
+		Name:    "config1",
+	}
+	db.AddConfig(&Config{
+		ConfigPath:   configPath,
+		Type:         "str",
+		DefaultValue: "value1",
+	})
+	count := redisScanCount*2 + 17
+	for i := 0; i < count; i++ {
+		db.AddOverride(&configPath, &Override{
+			OverrideKey: OverrideKey{EntityType: "user", EntityId: strconv.Itoa(i)},
+			Value:       "override",
+		})
+	}
+
+	overrides, err := db.GetOverrides(&configPath)
+	if err != nil {
+		t.Fatalf("Failed to get overrides: %v", err)
+	}
+	if len(overrides) != count {
+		t.Errorf("Expected %d overrides, but got %d", count, len(overrides))
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// ValidateRollouts checks the rollouts of a config of configType.
+func ValidateRollouts(configType string, rollouts []Rollout) error {
+	for i, rollout := range rollouts {
+		if rollout.EntityType == "" {
+			return NewApiError(http.StatusBadRequest, ErrorCodeMissingField, fmt.Sprintf("rollout %d needs an entityType", i))
+		}
+		if math.IsNaN(rollout.Percentage) || rollout.Percentage < 0 || rollout.Percentage > 100 {
+			return NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, fmt.Sprintf("rollout %d percentage must be between 0 and 100", i))
+		}
+		err := ValidateConfigValue(configType, rollout.Value)
+		if err != nil {
+			return NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, fmt.Sprintf("invalid value of rollout %d: %s", i, err.Error()))
+		}
+	}
+	return nil
+}
+
+// DecodeRollouts converts rollouts sent by a client into their stored form.
+func DecodeRollouts(payloads []RolloutPayload) ([]Rollout, error) {
+	var rollouts []Rollout
+	for i, payload := range payloads {
+		rollout := payload.Rollout
+		var err error
+		rollout.Value, err = DecodeConfigValue(payload.Value)
+		if err != nil {
+			return nil, NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, fmt.Sprintf("invalid value of rollout %d: %s", i, err.Error()))
+		}
+		rollouts = append(rollouts, rollout)
+	}
+	return rollouts, nil
+}
+
+func NewRolloutPayloads(rollouts []Rollout, configType string, format string) []RolloutPayload {
+	var payloads []RolloutPayload
+	for _, rollout := range rollouts {
+		payloads = append(payloads, RolloutPayload{
+			Rollout: rollout,
+			Value:   EncodeConfigValue(configType, rollout.Value, format),
+		})
+	}
+	return payloads
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"Service/evaluator"
+)
+
+// ValidateRules checks the rules of a config of configType.
+func ValidateRules(configType string, rules []Rule) error {
+	for i, rule := range rules {
+		err := rule.Condition.Validate()
+		if err != nil {
+			return NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, fmt.Sprintf("invalid condition of rule %d: %s", i, err.Error()))
+		}
+		err = ValidateConfigValue(configType, rule.Value)
+		if err != nil {
+			return NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, fmt.Sprintf("invalid value of rule %d: %s", i, err.Error()))
+		}
+	}
+	return nil
+}
+
+// DecodeRules converts rules sent by a client into their stored form.
+func DecodeRules(payloads []RulePayload) ([]Rule, error) {
+	var rules []Rule
+	for i, payload := range payloads {
+		rule := payload.Rule
+		var err error
+		rule.Value, err = DecodeConfigValue(payload.Value)
+		if err != nil {
+			return nil, NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, fmt.Sprintf("invalid value of rule %d: %s", i, err.Error()))
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func NewRulePayloads(rules []Rule, configType string, format string) []RulePayload {
+	var payloads []RulePayload
+	for _, rule := range rules {
+		payloads = append(payloads, RulePayload{
+			Rule:  rule,
+			Value: EncodeConfigValue(configType, rule.Value, format),
+		})
+	}
+	return payloads
+}
+
+// RulesEqual reports whether two lists of rules are the same.
+func RulesEqual(a []Rule, b []Rule) bool {
+	return evaluator.RulesEqual(ToEvaluatorRules(a), ToEvaluatorRules(b))
+}
+
+func ToEvaluatorRules(rules []Rule) []evaluator.Rule {
+	converted := make([]evaluator.Rule, 0, len(rules))
+	for _, rule := range rules {
+		converted = append(converted, evaluator.Rule(rule))
+	}
+	return converted
+}
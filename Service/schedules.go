@@ -0,0 +1,194 @@
+package main
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const defaultScheduleInterval = time.Second
+
+// Scheduler makes scheduled changes once they are due. Several services
+// sharing a store can each run one, every change is only made once.
+type Scheduler struct {
+	Interval time.Duration
+
+	handlers Handlers
+}
+
+func NewScheduler(interval time.Duration, handlers Handlers) *Scheduler {
+	if interval <= 0 {
+		interval = defaultScheduleInterval
+	}
+	return &Scheduler{
+		Interval: interval,
+		handlers: handlers,
+	}
+}
+
+// Run applies due changes every Interval until stop is closed, or forever
+// when stop is nil.
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		applied, err := s.handlers.ApplyDueChanges(time.Now().UTC())
+		if err != nil {
+			log.Printf("Failed to apply scheduled changes: %v", err)
+		}
+		if applied > 0 {
+			log.Printf("Applied %d scheduled changes", applied)
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// NewScheduledChange starts a change made by actor with the timing of
+// schedule, the caller fills in what it changes.
+func NewScheduledChange(actor string, schedule *Schedule, now time.Time) ScheduledChange {
+	change := ScheduledChange{
+		Id:        NewScheduledChangeId(),
+		ApplyAt:   now,
+		RevertAt:  schedule.RevertAt,
+		Actor:     actor,
+		CreatedAt: now,
+	}
+	if schedule.ApplyAt != nil {
+		change.ApplyAt = schedule.ApplyAt.UTC()
+	}
+	if change.RevertAt != nil {
+		revertAt := change.RevertAt.UTC()
+		change.RevertAt = &revertAt
+	}
+	return change
+}
+
+// scheduleFromRequest schedules change and returns the change left pending
+// in the form r asked for, if any.
+func (h *Handlers) scheduleFromRequest(r *http.Request, change *ScheduledChange) (*ScheduledChangePayload, error) {
+	format, err := h.GetValueFormat(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get value format from request")
+	}
+	pending, err := h.ScheduleChange(change, change.CreatedAt)
+	if err != nil || pending == nil {
+		return nil, err
+	}
+	payload := NewScheduledChangePayload(*pending, format)
+	return &payload, nil
+}
+
+func NewScheduledChangeId() string {
+	return strings.ToLower(rand.Text())
+}
+
+// ValidateScheduledChange checks the timing of a change and its config
+// values. Overrides are checked against their config when scheduled.
+func ValidateScheduledChange(change *ScheduledChange, now time.Time) error {
+	if change.RevertAt != nil {
+		if !change.RevertAt.After(change.ApplyAt) {
+			return NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, "revertAt must be after applyAt")
+		}
+		if !change.RevertAt.After(now) {
+			return NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, "revertAt must be in the future")
+		}
+	}
+	if change.Config != nil {
+		return ValidateConfig(change.Config)
+	}
+	return nil
+}
+
+func sortScheduledChanges(changes []ScheduledChange) {
+	slices.SortFunc(changes, func(a, b ScheduledChange) int {
+		return cmp.Or(a.ApplyAt.Compare(b.ApplyAt), a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.Id, b.Id))
+	})
+}
+
+func NewScheduledChangePayload(change ScheduledChange, format string) ScheduledChangePayload {
+	payload := ScheduledChangePayload{
+		ScheduledChange: change,
+	}
+	if change.Config != nil {
+		config := NewConfigPayload(*change.Config, format)
+		payload.Config = &config
+	}
+	if change.Override != nil {
+		override := NewOverridePayload(*change.Override, change.Type, format)
+		payload.Override = &override
+	}
+	return payload
+}
+
+// ListScheduledChanges lists the pending changes, earliest first. The
+// service query parameter keeps only the changes to that service's configs.
+func (h *Handlers) ListScheduledChanges(r *http.Request) (*HttpResponse, error) {
+	service := r.URL.Query().Get("service")
+	return h.listScheduledChanges(r, func(change *ScheduledChange) bool {
+		return service == "" || change.Service == service
+	})
+}
+
+// ListConfigScheduledChanges lists the pending changes to one config.
+func (h *Handlers) ListConfigScheduledChanges(r *http.Request) (*HttpResponse, error) {
+	configPath := GetConfigPath(mux.Vars(r))
+	return h.listScheduledChanges(r, func(change *ScheduledChange) bool {
+		return change.ConfigPath == *configPath
+	})
+}
+
+func (h *Handlers) listScheduledChanges(r *http.Request, keep func(change *ScheduledChange) bool) (*HttpResponse, error) {
+	format, err := h.GetValueFormat(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get value format from request")
+	}
+	changes, err := h.ConfigDb.GetScheduledChanges()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get scheduled changes from db")
+	}
+	sortScheduledChanges(changes)
+
+	response := ListScheduledChangesResponse{Changes: []ScheduledChangePayload{}}
+	for i := range changes {
+		if keep(&changes[i]) {
+			response.Changes = append(response.Changes, NewScheduledChangePayload(changes[i], format))
+		}
+	}
+	respBytes, err := json.Marshal(response)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal response")
+	}
+	return &HttpResponse{
+		Status: http.StatusOK,
+		Data:   respBytes,
+	}, nil
+}
+
+func (h *Handlers) DeleteScheduledChange(r *http.Request) (*HttpResponse, error) {
+	id := mux.Vars(r)["id"]
+	err := h.CancelScheduledChange(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to cancel scheduled change")
+	}
+
+	respBytes, err := json.Marshal(DeleteScheduledChangeResponse{Message: "Success"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal response")
+	}
+	return &HttpResponse{
+		Status: http.StatusOK,
+		Data:   respBytes,
+	}, nil
+}
@@ -3,7 +3,7 @@ curl -X POST http://localhost:8080/configs -H "Content-Type: application/json" -
         "service": "example-service",
         "name": "example-config1",
         "defaultValue": "example-value1",
-        "type": "string"
+        "type": "str"
    }
  }'
 
@@ -14,7 +14,7 @@ echo ""
         "service": "example-service",
         "name": "example-config2",
         "defaultValue": "example-value2",
-        "type": "string"
+        "type": "str"
    }
  }'
 
@@ -35,7 +35,7 @@ curl -X POST http://localhost:8080/configs -H "Content-Type: application/json" -
         "service": "example-service2",
         "name": "example-config",
         "defaultValue": "example-value",
-        "type": "string"
+        "type": "str"
    }
  }'
 
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"Service/evaluator"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// ValidateSegment checks a segment lists its members, by id or with a
+// condition, before it is saved.
+func ValidateSegment(segment *Segment) error {
+	if segment.Name == "" {
+		return NewApiError(http.StatusBadRequest, ErrorCodeMissingField, "segment name is required")
+	}
+	if strings.Contains(segment.Name, "/") {
+		return NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, "segment name can't contain /")
+	}
+	if len(segment.EntityIds) > 0 && segment.EntityType == "" {
+		return NewApiError(http.StatusBadRequest, ErrorCodeMissingField, "segment entityIds need an entityType")
+	}
+	if len(segment.EntityIds) == 0 && segment.Condition == nil {
+		return NewApiError(http.StatusBadRequest, ErrorCodeMissingField, "segment needs entityIds or a condition")
+	}
+	if segment.Condition != nil {
+		err := segment.Condition.Validate()
+		if err != nil {
+			return NewApiError(http.StatusUnprocessableEntity, ErrorCodeInvalidValue, "invalid segment condition: "+err.Error())
+		}
+	}
+	return nil
+}
+
+// SegmentsEqual reports whether two segments have the same members.
+func SegmentsEqual(a *Segment, b *Segment) bool {
+	if a.EntityType != b.EntityType || !slices.Equal(a.EntityIds, b.EntityIds) || (a.Condition == nil) != (b.Condition == nil) {
+		return false
+	}
+	return a.Condition == nil || a.Condition.Equal(b.Condition)
+}
+
+func ToEvaluatorSegment(segment *Segment) evaluator.Segment {
+	return evaluator.Segment{
+		EntityType: segment.EntityType,
+		EntityIds:  segment.EntityIds,
+		Condition:  segment.Condition,
+	}
+}
+
+// segmentUsages lists the configs with an override targeting the segment
+// named name, sorted by path.
+func (h *Handlers) segmentUsages(name string) ([]ConfigPath, error) {
+	configs, err := h.ConfigDb.GetConfigs()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get configs from db")
+	}
+	paths := []ConfigPath{}
+	for _, config := range configs {
+		_, found, err := h.ConfigDb.GetOverride(&config.ConfigPath, &OverrideKey{EntityType: SegmentEntityType, EntityId: name})
+		if errors.Is(err, ErrConfigNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get override from db")
+		}
+		if found {
+			paths = append(paths, config.ConfigPath)
+		}
+	}
+	slices.SortFunc(paths, func(a, b ConfigPath) int {
+		return cmp.Or(cmp.Compare(a.Service, b.Service), cmp.Compare(a.Name, b.Name))
+	})
+	return paths, nil
+}
+
+func (h *Handlers) ListSegments(r *http.Request) (*HttpResponse, error) {
+	segments, err := h.ConfigDb.GetSegments()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get segments from db")
+	}
+	slices.SortFunc(segments, func(a, b Segment) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	respBytes, err := json.Marshal(ListSegmentsResponse{Segments: segments})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal response")
+	}
+	return &HttpResponse{
+		Status: http.StatusOK,
+		Data:   respBytes,
+	}, nil
+}
+
+func (h *Handlers) PostSegment(r *http.Request) (*HttpResponse, error) {
+	var requestBody PostSegmentRequest
+	err := DecodeRequestBody(r, &requestBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode request body")
+	}
+
+	err = h.SaveSegment(GetActor(r), &requestBody.Segment)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save segment")
+	}
+
+	respBytes, err := json.Marshal(PostSegmentResponse{Message: "Success"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal response")
+	}
+	return &HttpResponse{
+		Status: http.StatusOK,
+		Data:   respBytes,
+	}, nil
+}
+
+func (h *Handlers) GetSegment(r *http.Request) (*HttpResponse, error) {
+	name := mux.Vars(r)["segment"]
+	segment, err := h.ConfigDb.GetSegment(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get segment from db")
+	}
+	usedBy, err := h.segmentUsages(name)
+	if err != nil {
+		return nil, err
+	}
+
+	respBytes, err := json.Marshal(GetSegmentResponse{Segment: segment, UsedBy: usedBy})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal response")
+	}
+	return &HttpResponse{
+		Status: http.StatusOK,
+		Data:   respBytes,
+	}, nil
+}
+
+func (h *Handlers) DeleteSegment(r *http.Request) (*HttpResponse, error) {
+	name := mux.Vars(r)["segment"]
+	err := h.RemoveSegment(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to delete segment")
+	}
+
+	respBytes, err := json.Marshal(DeleteSegmentResponse{Message: "Success"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal response")
+	}
+	return &HttpResponse{
+		Status: http.StatusOK,
+		Data:   respBytes,
+	}, nil
+}
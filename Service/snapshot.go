@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"Service/evaluator"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// GetServiceSnapshot exports every config of a service with its overrides
+// for clients to evaluate locally with the evaluator package.
+func (h *Handlers) GetServiceSnapshot(r *http.Request) (*HttpResponse, error) {
+	service := mux.Vars(r)["service"]
+
+	// Read the revision first, a change landing while the snapshot is built
+	// is seen again by clients polling from it rather than lost.
+	snapshot := evaluator.Snapshot{
+		FormatVersion:   evaluator.FormatVersion,
+		Service:         service,
+		Revision:        FormatEventId(h.Events.Epoch(), h.Events.LastId()),
+		DefaultPriority: h.DefaultPriority,
+		Configs:         make(map[string]evaluator.Config),
+	}
+	now := time.Now()
+	configs, err := h.ConfigDb.GetConfigs()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get configs from db")
+	}
+	for _, config := range configs {
+		if config.Service != service {
+			continue
+		}
+		overrides, err := h.ConfigDb.GetOverrides(&config.ConfigPath)
+		if errors.Is(err, ErrConfigNotFound) {
+			// Deleted since it was listed.
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get overrides from db")
+		}
+		// Overrides expiring later carry their expiry, clients stop applying
+		// them on time without downloading a new snapshot.
+		overrides = slices.DeleteFunc(overrides, func(override Override) bool {
+			return OverrideExpired(&override, now)
+		})
+
+		snapshotConfig := ToEvaluatorConfig(&config)
+		if len(overrides) > 0 {
+			snapshotConfig.Overrides = make(map[string]map[string]evaluator.Override)
+		}
+		for _, override := range overrides {
+			if override.EntityType != SegmentEntityType {
+				continue
+			}
+			if _, found := snapshot.Segments[override.EntityId]; found {
+				continue
+			}
+			segment, err := h.ConfigDb.GetSegment(override.EntityId)
+			if errors.Is(err, ErrSegmentNotFound) {
+				// Only a rollback can bring back an override of a deleted
+				// segment, it matches nobody.
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get segment from db")
+			}
+			if snapshot.Segments == nil {
+				snapshot.Segments = make(map[string]evaluator.Segment)
+			}
+			snapshot.Segments[override.EntityId] = ToEvaluatorSegment(&segment)
+		}
+		for _, override := range overrides {
+			byId, found := snapshotConfig.Overrides[override.EntityType]
+			if !found {
+				byId = make(map[string]evaluator.Override)
+				snapshotConfig.Overrides[override.EntityType] = byId
+			}
+			byId[override.EntityId] = evaluator.Override{Value: override.Value, ExpiresAt: override.ExpiresAt}
+		}
+		snapshot.Configs[config.Name] = snapshotConfig
+	}
+
+	respBytes, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal response")
+	}
+	return &HttpResponse{
+		Status: http.StatusOK,
+		Data:   respBytes,
+	}, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// syncActor is recorded in the history of changes made by a directory sync.
+const syncActor = "sync"
+
+const defaultSyncInterval = 10 * time.Second
+
+// DirectorySync keeps the configs of each service matching a YAML file in
+// Dir named after it, such as service1.yaml. The files use the export
+// document format, configs may leave out their service. A service's file
+// owns it completely: configs and overrides missing from the file are
+// deleted, services without a file are left alone.
+//
+// Every pass re-reads all files and reconciles every service, so changes
+// made through the API to a synced service are reported as drift and
+// reverted. A file that can't be read or fails validation is skipped and
+// its service keeps running on what was last applied.
+type DirectorySync struct {
+	Dir      string
+	Interval time.Duration
+	// DryRun only reports drift without reverting it.
+	DryRun bool
+
+	handlers Handlers
+
+	mu     sync.Mutex
+	status SyncStatus
+}
+
+func NewDirectorySync(dir string, interval time.Duration, dryRun bool, handlers Handlers) *DirectorySync {
+	if interval <= 0 {
+		interval = defaultSyncInterval
+	}
+	return &DirectorySync{
+		Dir:      dir,
+		Interval: interval,
+		DryRun:   dryRun,
+		handlers: handlers,
+		status: SyncStatus{
+			Enabled: true,
+			Dir:     dir,
+			DryRun:  dryRun,
+			Files:   []SyncFileStatus{},
+		},
+	}
+}
+
+// Run syncs every Interval until stop is closed, or forever when stop is
+// nil.
+func (s *DirectorySync) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		s.Sync()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Sync makes one pass over the directory and returns the resulting status.
+func (s *DirectorySync) Sync() SyncStatus {
+	now := time.Now().UTC()
+	status := SyncStatus{
+		Enabled:  true,
+		Dir:      s.Dir,
+		DryRun:   s.DryRun,
+		LastSync: &now,
+		Files:    []SyncFileStatus{},
+	}
+
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		status.Error = err.Error()
+		log.Printf("Failed to read sync directory %s: %v", s.Dir, err)
+		s.setStatus(status)
+		return status
+	}
+
+	owners := make(map[string]string)
+	for _, entry := range entries {
+		extension := filepath.Ext(entry.Name())
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || (extension != ".yaml" && extension != ".yml") {
+			continue
+		}
+		fileStatus := SyncFileStatus{
+			File:    entry.Name(),
+			Service: strings.TrimSuffix(entry.Name(), extension),
+		}
+		if owner, found := owners[fileStatus.Service]; found {
+			fileStatus.Error = fmt.Sprintf("service %s is already synced from %s", fileStatus.Service, owner)
+		} else {
+			owners[fileStatus.Service] = entry.Name()
+			s.syncFile(&fileStatus)
+		}
+		if fileStatus.Error != "" {
+			log.Printf("Rejected sync file %s: %s", fileStatus.File, fileStatus.Error)
+		}
+		status.Files = append(status.Files, fileStatus)
+	}
+
+	s.setStatus(status)
+	return status
+}
+
+func (s *DirectorySync) syncFile(fileStatus *SyncFileStatus) {
+	document, err := ReadSyncFile(filepath.Join(s.Dir, fileStatus.File), fileStatus.Service)
+	if err != nil {
+		fileStatus.Error = err.Error()
+		return
+	}
+	report, err := s.handlers.ImportConfigs(syncActor, document, ImportModeReplace, fileStatus.Service, s.DryRun)
+	if err != nil {
+		fileStatus.Error = err.Error()
+		return
+	}
+
+	fileStatus.InSync = len(report.Created) == 0 && len(report.Updated) == 0 && len(report.Deleted) == 0
+	if !fileStatus.InSync {
+		fileStatus.Drift = &report
+		action := "Reverted"
+		if s.DryRun {
+			action = "Found"
+		}
+		log.Printf("%s drift in service %s: %d created, %d updated, %d deleted",
+			action, fileStatus.Service, len(report.Created), len(report.Updated), len(report.Deleted))
+	}
+}
+
+// ReadSyncFile reads the file syncing service.
+func ReadSyncFile(filename string, service string) (*ExportDocument, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read sync file")
+	}
+	var document ExportDocument
+	err = yaml.Unmarshal(data, &document)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse sync file")
+	}
+	for i := range document.Configs {
+		config := &document.Configs[i]
+		if config.Service == "" {
+			config.Service = service
+		}
+		if config.Service != service {
+			return nil, errors.Errorf("config %s/%s belongs to another service", config.Service, config.Name)
+		}
+	}
+	return &document, nil
+}
+
+func (s *DirectorySync) setStatus(status SyncStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func (s *DirectorySync) Status() SyncStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	status.Files = slices.Clone(status.Files)
+	return status
+}
+
+// GetSyncStatus reports the outcome of the last directory sync.
+func (h *Handlers) GetSyncStatus(r *http.Request) (*HttpResponse, error) {
+	status := SyncStatus{Files: []SyncFileStatus{}}
+	if h.Sync != nil {
+		status = h.Sync.Status()
+	}
+	respBytes, err := json.Marshal(status)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal response")
+	}
+	return &HttpResponse{
+		Status: http.StatusOK,
+		Data:   respBytes,
+	}, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func WriteSyncFile(t *testing.T, dir string, name string, content string) {
+	err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write sync file: %v", err)
+	}
+}
+
+func TestDirectorySync(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CONFIG_SYNC_DIR", dir)
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+	if app.Handlers.Sync == nil {
+		t.Fatalf("Expected CONFIG_SYNC_DIR to enable syncing")
+	}
+
+	err := app.Handlers.SaveConfig("alice", &Config{ConfigPath: ConfigPath{Service: "service2", Name: "config1"}, Type: "bool", DefaultValue: "true"})
+	if err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	WriteSyncFile(t, dir, "service1.yaml", `configs:
+  - name: config1
+    type: long
+    defaultValue: 1
+    overrides:
+      - entityType: user
+        entityId: 123
+        value: 2
+  - name: config2
+    type: str
+    defaultValue: hello
+`)
+	WriteSyncFile(t, dir, "README.md", "not a config file")
+
+	status := app.Handlers.Sync.Sync()
+	if status.Error != "" || len(status.Files) != 1 || status.Files[0].Service != "service1" || status.Files[0].Drift == nil ||
+		len(status.Files[0].Drift.Created) != 3 {
+		t.Fatalf("Expected service1 to be created from its file, but got %+v", status)
+	}
+	configPath := ConfigPath{Service: "service1", Name: "config1"}
+	overrides, err := app.ConfigDb.GetOverrides(&configPath)
+	if err != nil || len(overrides) != 1 || overrides[0].Value != "2" {
+		t.Errorf("Expected the override from the file, but got %v, error: %v", overrides, err)
+	}
+	history, err := app.ConfigDb.GetHistory(&configPath)
+	if err != nil || len(history) != 1 || history[0].Actor != syncActor {
+		t.Errorf("Expected the sync to be recorded in history, but got %v, error: %v", history, err)
+	}
+
+	// Changes made around the files are drift and get reverted, services
+	// without a file are left alone.
+	err = app.Handlers.SaveConfig("alice", &Config{ConfigPath: configPath, Type: "long", DefaultValue: "5"})
+	if err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	err = app.Handlers.SaveConfig("alice", &Config{ConfigPath: ConfigPath{Service: "service1", Name: "config3"}, Type: "bool", DefaultValue: "true"})
+	if err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	status = app.Handlers.Sync.Sync()
+	drift := status.Files[0].Drift
+	if drift == nil || len(drift.Updated) != 1 || len(drift.Deleted) != 1 || drift.Deleted[0].Name != "config3" {
+		t.Errorf("Expected config1 updated and config3 deleted, but got %+v", status)
+	}
+	config, err := app.ConfigDb.GetConfig(&configPath)
+	if err != nil || config.DefaultValue != "1" {
+		t.Errorf("Expected drift to be reverted, but got %v, error: %v", config, err)
+	}
+	_, err = app.ConfigDb.GetConfig(&ConfigPath{Service: "service2", Name: "config1"})
+	if err != nil {
+		t.Errorf("Expected services without a file to be kept, but got %v", err)
+	}
+	status = app.Handlers.Sync.Sync()
+	if !status.Files[0].InSync || status.Files[0].Drift != nil {
+		t.Errorf("Expected service1 to be in sync, but got %+v", status)
+	}
+
+	// An invalid file is rejected without touching its service.
+	WriteSyncFile(t, dir, "service1.yaml", `configs:
+  - name: config1
+    type: long
+    defaultValue: 7
+  - name: config2
+    type: long
+    defaultValue: hello
+`)
+	WriteSyncFile(t, dir, "service3.yml", `configs: [not, valid`)
+	app.Handlers.Sync.Sync()
+	config, err = app.ConfigDb.GetConfig(&configPath)
+	if err != nil || config.DefaultValue != "1" {
+		t.Errorf("Expected an invalid file to change nothing, but got %v, error: %v", config, err)
+	}
+
+	body := MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/sync")
+	})
+	var response SyncStatus
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+	if !response.Enabled || response.LastSync == nil || len(response.Files) != 2 {
+		t.Fatalf("Expected the status of 2 files, but got %s", body)
+	}
+	if !strings.Contains(response.Files[0].Error, "config2") || response.Files[1].Error == "" {
+		t.Errorf("Expected both files to be rejected, but got %s", body)
+	}
+}
+
+func TestDirectorySyncSkipsExpiredOverrides(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CONFIG_SYNC_DIR", dir)
+	app := BuildTestApplication(t)
+
+	WriteSyncFile(t, dir, "service1.yaml", `configs:
+  - name: config1
+    type: long
+    defaultValue: 1
+    overrides:
+      - entityType: user
+        entityId: 1
+        value: 2
+        expiresAt: 2001-01-01T00:00:00Z
+      - entityType: user
+        entityId: 2
+        value: 3
+        expiresAt: 2100-01-01T00:00:00Z
+`)
+	status := app.Handlers.Sync.Sync()
+	if status.Files[0].Error != "" || status.Files[0].Drift == nil || len(status.Files[0].Drift.Created) != 2 {
+		t.Fatalf("Expected config1 created with only its live override, but got %+v", status)
+	}
+	configPath := ConfigPath{Service: "service1", Name: "config1"}
+	overrides, err := app.ConfigDb.GetOverrides(&configPath)
+	if err != nil || len(overrides) != 1 || overrides[0].EntityId != "2" {
+		t.Errorf("Expected only the live override, but got %v, error: %v", overrides, err)
+	}
+
+	// An expired override still stored is deleted once and never written
+	// back, so the file stays in sync.
+	expired := time.Now().UTC().Add(-time.Minute)
+	err = app.Handlers.SaveOverride("alice", &configPath, &Override{OverrideKey: OverrideKey{EntityType: "user", EntityId: "1"}, Value: "2", ExpiresAt: &expired})
+	if err != nil {
+		t.Fatalf("Failed to save override: %v", err)
+	}
+	status = app.Handlers.Sync.Sync()
+	if drift := status.Files[0].Drift; drift == nil || len(drift.Created) != 0 || len(drift.Deleted) != 1 {
+		t.Errorf("Expected the expired override deleted, but got %+v", status)
+	}
+	config, err := app.ConfigDb.GetConfig(&configPath)
+	if err != nil {
+		t.Fatalf("Failed to get config: %v", err)
+	}
+	status = app.Handlers.Sync.Sync()
+	if !status.Files[0].InSync {
+		t.Errorf("Expected service1 to be in sync, but got %+v", status)
+	}
+	synced, err := app.ConfigDb.GetConfig(&configPath)
+	if err != nil || synced.Revision != config.Revision {
+		t.Errorf("Expected revision %d to be kept, but got %v, error: %v", config.Revision, synced, err)
+	}
+}
+
+func TestDirectorySyncDisabled(t *testing.T) {
+	app := BuildTestApplication(t)
+	subject := httptest.NewServer(BuildServer(&app))
+	defer subject.Close()
+
+	body := MakeServerRequest(t, func() (*http.Response, error) {
+		return http.Get(subject.URL + "/sync")
+	})
+	if string(body) != `{"enabled":false,"dryRun":false,"files":[]}` {
+		t.Errorf("Expected sync to be disabled, but got %s", body)
+	}
+}
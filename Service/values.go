@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ConfigTypeBool  = "bool"
+	ConfigTypeStr   = "str"
+	ConfigTypeLong  = "long"
+	ConfigTypeFloat = "float"
+)
+
+const (
+	// ValueFormatTyped encodes values as native JSON booleans and numbers.
+	ValueFormatTyped = "typed"
+	// ValueFormatString encodes every value as a JSON string, the format
+	// used before values were typed.
+	ValueFormatString = "string"
+)
+
+func ValidateConfigType(configType string) error {
+	switch configType {
+	case ConfigTypeBool, ConfigTypeStr, ConfigTypeLong, ConfigTypeFloat:
+		return nil
+	default:
+		return errors.Errorf("unknown config type %q, expected one of bool, str, long or float", configType)
+	}
+}
+
+// ValidateConfigValue checks that value can be read as configType.
+func ValidateConfigValue(configType string, value string) error {
+	err := ValidateConfigType(configType)
+	if err != nil {
+		return err
+	}
+
+	switch configType {
+	case ConfigTypeBool:
+		if value != "true" && value != "false" {
+			return errors.Errorf("value %q is not a valid bool, expected true or false", value)
+		}
+	case ConfigTypeLong:
+		_, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return errors.Errorf("value %q is not a valid long", value)
+		}
+	case ConfigTypeFloat:
+		floatValue, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(floatValue) || math.IsInf(floatValue, 0) {
+			return errors.Errorf("value %q is not a valid float", value)
+		}
+	}
+	return nil
+}
+
+func ValidateValueFormat(format string) error {
+	if format != ValueFormatTyped && format != ValueFormatString {
+		return errors.Errorf("unknown value format %q, expected typed or string", format)
+	}
+	return nil
+}
+
+// EncodeConfigValue converts a stored value into the JSON value sent to
+// clients. Values that can't be read as configType, such as ones written
+// before types were validated, are sent as strings.
+func EncodeConfigValue(configType string, value string, format string) any {
+	if format == ValueFormatString {
+		return value
+	}
+
+	switch configType {
+	case ConfigTypeBool:
+		boolValue, err := strconv.ParseBool(value)
+		if err == nil {
+			return boolValue
+		}
+	case ConfigTypeLong:
+		longValue, err := strconv.ParseInt(value, 10, 64)
+		if err == nil {
+			return longValue
+		}
+	case ConfigTypeFloat:
+		floatValue, err := strconv.ParseFloat(value, 64)
+		if err == nil && !math.IsNaN(floatValue) && !math.IsInf(floatValue, 0) {
+			return floatValue
+		}
+	}
+	return value
+}
+
+// DecodeConfigValue converts a JSON value sent by a client into its stored
+// string form. Requests should be decoded with json.Decoder.UseNumber so
+// longs keep their full precision.
+func DecodeConfigValue(value any) (string, error) {
+	switch typedValue := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return typedValue, nil
+	case bool:
+		return strconv.FormatBool(typedValue), nil
+	case json.Number:
+		return typedValue.String(), nil
+	case float64:
+		return strconv.FormatFloat(typedValue, 'g', -1, 64), nil
+	// YAML documents decode whole numbers as ints.
+	case int:
+		return strconv.Itoa(typedValue), nil
+	case int64:
+		return strconv.FormatInt(typedValue, 10), nil
+	case uint64:
+		return strconv.FormatUint(typedValue, 10), nil
+	default:
+		return "", errors.Errorf("value must be a string, number or bool")
+	}
+}
+
+func NewConfigPayload(config Config, format string) ConfigPayload {
+	return ConfigPayload{
+		Config:       config,
+		DefaultValue: EncodeConfigValue(config.Type, config.DefaultValue, format),
+		Rules:        NewRulePayloads(config.Rules, config.Type, format),
+		Rollouts:     NewRolloutPayloads(config.Rollouts, config.Type, format),
+	}
+}
+
+func NewOverridePayload(override Override, configType string, format string) OverridePayload {
+	return OverridePayload{
+		Override: override,
+		Value:    EncodeConfigValue(configType, override.Value, format),
+	}
+}
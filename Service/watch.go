@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Comments are sent this often on an idle stream so proxies don't close it.
+const watchHeartbeatInterval = 15 * time.Second
+
+const (
+	// WatchEventChange carries a HistoryEntryPayload for every change.
+	WatchEventChange = "change"
+	// WatchEventReset tells a resuming client that events were missed, or
+	// that its last event id is from before a restart, and it should reload
+	// whatever it is watching.
+	WatchEventReset = "reset"
+)
+
+// WatchFilter limits a watch to some services and configs. An empty filter
+// matches every config.
+type WatchFilter struct {
+	Services map[string]bool
+	Configs  map[string]bool
+}
+
+// GetWatchFilter reads the service and config query parameters, both can be
+// repeated. Configs are given as service/name.
+func GetWatchFilter(r *http.Request) (*WatchFilter, error) {
+	query := r.URL.Query()
+	filter := &WatchFilter{
+		Services: make(map[string]bool),
+		Configs:  make(map[string]bool),
+	}
+	for _, service := range query["service"] {
+		filter.Services[service] = true
+	}
+	for _, config := range query["config"] {
+		service, name, found := strings.Cut(config, "/")
+		if !found || service == "" || name == "" {
+			return nil, NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, "config must be given as service/name")
+		}
+		filter.Configs[config] = true
+	}
+	return filter, nil
+}
+
+func (f *WatchFilter) Matches(path *ConfigPath) bool {
+	if len(f.Services) == 0 && len(f.Configs) == 0 {
+		return true
+	}
+	return f.Services[path.Service] || f.Configs[GetConfigPathStr(path)]
+}
+
+// GetLastEventId returns the epoch and id a client wants to resume after,
+// from the Last-Event-ID header browsers send on reconnect or the
+// lastEventId query parameter. The bool is false for a new watch.
+func GetLastEventId(r *http.Request) (string, uint64, bool, error) {
+	idStr := r.Header.Get("Last-Event-ID")
+	if idStr == "" {
+		idStr = r.URL.Query().Get("lastEventId")
+	}
+	if idStr == "" {
+		return "", 0, false, nil
+	}
+	epoch, id, err := ParseEventId(idStr)
+	if err != nil {
+		return "", 0, false, NewApiError(http.StatusBadRequest, ErrorCodeInvalidParameter, "last event id must be the id of an earlier event")
+	}
+	return epoch, id, true, nil
+}
+
+type WatchResetData struct {
+	LastEventId string `json:"lastEventId"`
+}
+
+// Watch streams config and override changes as Server-Sent Events until the
+// client disconnects. It writes the response itself rather than going
+// through CatchErrors, errors can only be reported before the stream starts.
+func (h *Handlers) Watch(w http.ResponseWriter, r *http.Request) {
+	filter, err := GetWatchFilter(r)
+	if err != nil {
+		WriteError(w, GetApiError(err))
+		return
+	}
+	format, err := h.GetValueFormat(r)
+	if err != nil {
+		WriteError(w, GetApiError(err))
+		return
+	}
+	epoch, lastId, resume, err := GetLastEventId(r)
+	if err != nil {
+		WriteError(w, GetApiError(err))
+		return
+	}
+
+	// Subscribe before reading the last id so no change can slip in between.
+	notify, unsubscribe := h.Events.Subscribe()
+	defer unsubscribe()
+	if !resume {
+		epoch = h.Events.Epoch()
+		lastId = h.Events.LastId()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+	_, err = io.WriteString(w, ": watching\n\n")
+	if err == nil {
+		err = controller.Flush()
+	}
+	if err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(watchHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		events, ok := h.Events.Since(epoch, lastId)
+		if !ok {
+			// Nothing is replayed, the client reloads and carries on from
+			// the latest event.
+			epoch = h.Events.Epoch()
+			lastId = h.Events.LastId()
+			eventId := FormatEventId(epoch, lastId)
+			err = writeWatchEvent(w, eventId, WatchEventReset, WatchResetData{LastEventId: eventId})
+		}
+		for _, event := range events {
+			if err != nil {
+				break
+			}
+			lastId = event.Id
+			if filter.Matches(&event.Entry.ConfigPath) {
+				err = writeWatchEvent(w, FormatEventId(epoch, event.Id), WatchEventChange, NewHistoryEntryPayload(event.Entry, format))
+			}
+		}
+		if err == nil {
+			err = controller.Flush()
+		}
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-notify:
+		case <-heartbeat.C:
+			_, err = io.WriteString(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeWatchEvent(w io.Writer, id string, eventType string, data any) error {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal event")
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, eventType, dataBytes)
+	if err != nil {
+		return errors.Wrap(err, "failed to write event")
+	}
+	return nil
+}